@@ -0,0 +1,59 @@
+package api
+
+import (
+	"github.com/ofux/deluge/core/reporting"
+	"sort"
+)
+
+// JobComparison compares the reports of a job with the ones of a baseline job.
+type JobComparison struct {
+	JobID      string               `json:"jobId"`
+	BaselineID string               `json:"baselineId"`
+	Tolerances reporting.Tolerances `json:"tolerances"`
+	// Regression tells whether a metric of a scenario changed more than tolerated
+	Regression bool                                 `json:"regression"`
+	Scenarios  map[string]*reporting.HTTPComparison `json:"scenarios"`
+	// OnlyInBaseline and OnlyInCurrent are the scenarios that ran in one job only
+	OnlyInBaseline []string `json:"onlyInBaseline"`
+	OnlyInCurrent  []string `json:"onlyInCurrent"`
+}
+
+// CompareJobs compares each scenario of the job with the same scenario of the baseline job. Throughputs are the numbers
+// of calls over the duration of the deluges.
+func CompareJobs(baseline, current *Job, tolerances reporting.Tolerances) *JobComparison {
+	comparison := &JobComparison{
+		JobID:          current.ID,
+		BaselineID:     baseline.ID,
+		Tolerances:     tolerances,
+		Scenarios:      make(map[string]*reporting.HTTPComparison),
+		OnlyInBaseline: make([]string, 0),
+		OnlyInCurrent:  make([]string, 0),
+	}
+	for scenarioID, baselineScenario := range baseline.Scenarios {
+		currentScenario, ok := current.Scenarios[scenarioID]
+		if !ok {
+			comparison.OnlyInBaseline = append(comparison.OnlyInBaseline, scenarioID)
+			continue
+		}
+		scenarioComparison := reporting.CompareHTTPReports(
+			reporting.ComparedRun{Report: httpReportOf(baselineScenario), Duration: baseline.GlobalDuration},
+			reporting.ComparedRun{Report: httpReportOf(currentScenario), Duration: current.GlobalDuration},
+			tolerances,
+		)
+		comparison.Scenarios[scenarioID] = scenarioComparison
+		comparison.Regression = comparison.Regression || scenarioComparison.HasRegression()
+	}
+	for scenarioID := range current.Scenarios {
+		if _, ok := baseline.Scenarios[scenarioID]; !ok {
+			comparison.OnlyInCurrent = append(comparison.OnlyInCurrent, scenarioID)
+		}
+	}
+	sort.Strings(comparison.OnlyInBaseline)
+	sort.Strings(comparison.OnlyInCurrent)
+	return comparison
+}
+
+func httpReportOf(scenario *JobScenario) *reporting.HTTPReport {
+	report, _ := scenario.Report.(*reporting.HTTPReport)
+	return report
+}
@@ -1,14 +1,23 @@
 package api
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/ofux/deluge/core"
+	"github.com/ofux/deluge/core/eventlog"
+	"github.com/ofux/deluge/core/reporting"
 	"github.com/ofux/deluge/repov2"
 	"github.com/ofux/deluge/worker"
+	"github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // JobsHandler handles requests for 'jobs' resource
@@ -51,6 +60,41 @@ func NewJobHandler() *JobsHandler {
 		Pattern:     "",
 		HandlerFunc: jobsHandler.GetAllJobs,
 	})
+	// Compare a Job with a baseline Job
+	routes = append(routes, Route{
+		Name:        "Compare a job with a baseline job",
+		Method:      http.MethodGet,
+		Pattern:     "/{id}/compare/{baselineId}",
+		HandlerFunc: jobsHandler.CompareJob,
+	})
+	// Stream the events of a Job
+	routes = append(routes, Route{
+		Name:        "Stream the events of a job",
+		Method:      http.MethodGet,
+		Pattern:     "/{id}/stream",
+		HandlerFunc: jobsHandler.StreamJob,
+	})
+	// Get the event log of a Job
+	routes = append(routes, Route{
+		Name:        "Get the event log of a job",
+		Method:      http.MethodGet,
+		Pattern:     "/{id}/events",
+		HandlerFunc: jobsHandler.GetJobEvents,
+	})
+	// Get webhook deliveries of a Job
+	routes = append(routes, Route{
+		Name:        "Get webhook deliveries of a job",
+		Method:      http.MethodGet,
+		Pattern:     "/{id}/webhook/deliveries",
+		HandlerFunc: jobsHandler.GetWebhookDeliveries,
+	})
+	// Change the concurrency of a scenario of a running Job
+	routes = append(routes, Route{
+		Name:        "Change the concurrency of a scenario of a running job",
+		Method:      http.MethodPut,
+		Pattern:     "/{id}/scenarios/{scenarioId}/concurrency",
+		HandlerFunc: jobsHandler.SetScenarioConcurrency,
+	})
 	// Interrupt a Job
 	routes = append(routes, Route{
 		Name:        "Interrupt a job",
@@ -77,6 +121,35 @@ func (d *JobsHandler) CreateJob(w http.ResponseWriter, r *http.Request) {
 	}
 
 	jobID := uuid.NewV4().String()
+	if job.ID != "" {
+		if strings.ContainsAny(job.ID, `/\`) {
+			SendJSONError(w, fmt.Sprintf("Job ID '%s' must not contain path separators.", job.ID), http.StatusBadRequest)
+			return
+		}
+		if _, exists := repov2.Instance.GetJobShell(job.ID); exists {
+			SendJSONError(w, fmt.Sprintf("Job with ID '%s' already exists.", job.ID), http.StatusConflict)
+			return
+		}
+		jobID = job.ID
+	}
+	if job.Share != nil {
+		if err := job.Share.Validate(); err != nil {
+			SendJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if job.EventLog != "" {
+		if err := eventlog.ValidateFormat(job.EventLog); err != nil {
+			SendJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, sinkConfig := range job.Sinks {
+		if err := sinkConfig.Validate(); err != nil {
+			SendJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	webhook := ""
 	if job.Webhook != "" {
@@ -95,6 +168,7 @@ func (d *JobsHandler) CreateJob(w http.ResponseWriter, r *http.Request) {
 		ID:       jobID,
 		DelugeID: job.DelugeID,
 		Webhook:  webhook,
+		EventLog: job.EventLog,
 	}
 	err := repov2.Instance.SaveJobShell(jobShell)
 	if err != nil {
@@ -102,7 +176,7 @@ func (d *JobsHandler) CreateJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = startJob(jobShell)
+	err = startJob(jobShell, &job)
 	if err != nil {
 		SendJSONError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -112,67 +186,274 @@ func (d *JobsHandler) CreateJob(w http.ResponseWriter, r *http.Request) {
 		ID:       jobShell.ID,
 		DelugeID: jobShell.DelugeID,
 		Webhook:  jobShell.Webhook,
+		EventLog: jobShell.EventLog,
 	}
 
 	SendJSONWithHTTPCode(w, respDTO, http.StatusAccepted)
 }
 
-func startJob(jobShell *repov2.PersistedJobShell) error {
-	err := worker.GetManager().CreateAll(&worker.JobShell{
+func startJob(jobShell *repov2.PersistedJobShell, job *JobCreation) error {
+	workerJobShell := &worker.JobShell{
 		ID:       jobShell.ID,
 		DelugeID: jobShell.DelugeID,
-	})
+		Share:    job.Share,
+		Sinks:    job.Sinks,
+		EventLog: jobShell.EventLog,
+	}
+	err := worker.GetManager().CreateAll(workerJobShell)
 	if err != nil {
 		return err
 	}
 
-	return worker.GetManager().StartAll(&worker.JobShell{
-		ID:       jobShell.ID,
-		DelugeID: jobShell.DelugeID,
-	})
+	return worker.GetManager().StartAll(workerJobShell)
 }
 
+// GetJob sends the report of a job, as JSON or as JUnit XML with the query parameter 'format=junit'.
 func (d *JobsHandler) GetJob(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "junit" {
+		SendJSONError(w, fmt.Sprintf("Unknown format '%s'.", format), http.StatusBadRequest)
+		return
+	}
 	job, ok := repov2.Instance.GetJobShell(id)
 	if !ok {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	partialContent := false
+	jobReport, partialContent, err := getJobReport(repov2.Instance, job)
+	if err != nil {
+		SendJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if jobReport == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	code := http.StatusOK
+	if partialContent {
+		code = http.StatusPartialContent
+	}
+	if format == "junit" {
+		sendJUnit(w, jobReport, code)
+		return
+	}
+	SendJSONWithHTTPCode(w, jobReport, code)
+}
+
+func sendJUnit(w http.ResponseWriter, jobReport *Job, code int) {
+	buf := &bytes.Buffer{}
+	if err := WriteJUnit(buf, jobReport); err != nil {
+		SendJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(HeaderContentTypeKey, HeaderContentTypeXMLUTF8)
+	w.WriteHeader(code)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.WithError(err).WithField("jobId", jobReport.ID).Debug("Failed to write JUnit report of job")
+	}
+}
+
+// CompareJob compares the report of a job with the report of a baseline job. Tolerances can be given as query
+// parameters 'responseTime', 'throughput' and 'errorRate'.
+func (d *JobsHandler) CompareJob(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tolerances, err := parseTolerances(r.URL.Query())
+	if err != nil {
+		SendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var jobReports []*Job
+	for _, id := range []string{vars["baselineId"], vars["id"]} {
+		job, ok := repov2.Instance.GetJobShell(id)
+		if !ok {
+			SendJSONError(w, fmt.Sprintf("Job with ID '%s' does not exist.", id), http.StatusNotFound)
+			return
+		}
+		jobReport, _, err := getJobReport(repov2.Instance, job)
+		if err != nil {
+			SendJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if jobReport == nil {
+			SendJSONError(w, fmt.Sprintf("Job with ID '%s' has no report yet.", id), http.StatusNotFound)
+			return
+		}
+		if !jobReport.Status.IsEnd() {
+			SendJSONError(w, fmt.Sprintf("Job with ID '%s' has not ended yet.", id), http.StatusConflict)
+			return
+		}
+		jobReports = append(jobReports, jobReport)
+	}
+
+	SendJSONWithHTTPCode(w, CompareJobs(jobReports[0], jobReports[1], tolerances), http.StatusOK)
+}
+
+func parseTolerances(query url.Values) (reporting.Tolerances, error) {
+	tolerances := reporting.DefaultTolerances
+	for name, tolerance := range map[string]*float64{
+		"responseTime": &tolerances.ResponseTime,
+		"throughput":   &tolerances.Throughput,
+		"errorRate":    &tolerances.ErrorRate,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 {
+			return tolerances, errors.Errorf("Expected '%s' to be a positive number.", name)
+		}
+		*tolerance = parsed
+	}
+	return tolerances, nil
+}
+
+// getJobReport merges the reports of all the workers of the job. It returns a nil report if no worker has reported yet.
+// The report is partial if the job is still running, or if job's deluge and/or scenarios have been deleted.
+func getJobReport(repository repov2.Repository, job *repov2.PersistedJobShell) (jobReport *Job, partialContent bool, err error) {
 	var scenarios map[string]*repov2.PersistedScenario
-	deluge, ok := repov2.Instance.GetDeluge(job.DelugeID)
+	deluge, ok := repository.GetDeluge(job.DelugeID)
 	if !ok {
 		partialContent = true
 	} else {
-		scenarios = repov2.Instance.GetDelugeScenarios(deluge.ScenarioIDs)
+		scenarios = repository.GetDelugeScenarios(deluge.ScenarioIDs)
 		if len(scenarios) == 0 {
 			partialContent = true
 		}
 	}
 
-	reports := repov2.Instance.GetJobWorkerReports(id)
+	reports := repository.GetJobWorkerReports(job.ID)
 	if len(reports) == 0 {
-		w.WriteHeader(http.StatusNotFound)
+		return nil, false, nil
+	}
+
+	jobReport, err = mapDeluge(job, deluge, scenarios, reports)
+	if err != nil {
+		return nil, false, err
+	}
+	if !jobReport.Status.IsEnd() {
+		partialContent = true
+	}
+	return jobReport, partialContent, nil
+}
+
+// streamKeepAliveFrequency is how often a comment is written on a stream without events, so that proxies and
+// clients do not consider it dead.
+var streamKeepAliveFrequency = 15 * time.Second
+
+// StreamJob sends the events of a job as server-sent events until the job ends or the client goes away.
+func (d *JobsHandler) StreamJob(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if _, ok := repov2.Instance.GetJobShell(id); !ok {
+		SendJSONError(w, fmt.Sprintf("Job with ID '%s' does not exist.", id), http.StatusNotFound)
+		return
+	}
+	if _, ok := w.(http.Flusher); !ok {
+		SendJSONError(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	subscription := worker.EventsInstance.Subscribe(id)
+	defer subscription.Close()
+
+	w.Header().Set(HeaderContentTypeKey, HeaderContentTypeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// A job that has already ended will not publish anything anymore
+	if reports := repov2.Instance.GetJobWorkerReports(id); len(reports) > 0 {
+		if jobStatus := getJobStatus(reports); jobStatus.IsEnd() {
+			SendServerSentEvent(w, string(worker.JobEventStatus), JobStatusEvent{Status: jobStatus})
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(streamKeepAliveFrequency)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-subscription.Events():
+			if !ok {
+				// The client was too slow to receive the events
+				return
+			}
+			if err := SendServerSentEvent(w, string(event.Type), mapJobEvent(event)); err != nil {
+				log.WithError(err).WithField("jobId", id).Debug("Failed to send event of job")
+				return
+			}
+			if event.Type == worker.JobEventStatus && event.Status.IsEnd() {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+// GetJobEvents sends the event log of a job, made of the event logs of all its workers.
+func (d *JobsHandler) GetJobEvents(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	job, ok := repov2.Instance.GetJobShell(id)
+	if !ok {
+		SendJSONError(w, fmt.Sprintf("Job with ID '%s' does not exist.", id), http.StatusNotFound)
+		return
+	}
+	if job.EventLog == "" {
+		SendJSONError(w, fmt.Sprintf("Job with ID '%s' has no event log.", id), http.StatusNotFound)
 		return
 	}
 
-	jobReport, err := mapDeluge(job, deluge, scenarios, reports)
+	readers, err := worker.GetManager().OpenEventLogs(id, job.EventLog)
 	if err != nil {
 		SendJSONError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if !jobReport.Status.IsEnd() {
-		partialContent = true
+	defer eventlog.CloseAll(readers)
+	if len(readers) == 0 {
+		SendJSONError(w, fmt.Sprintf("Event log of job with ID '%s' has not been created yet.", id), http.StatusNotFound)
+		return
 	}
 
-	if partialContent {
-		SendJSONWithHTTPCode(w, jobReport, http.StatusPartialContent)
-	} else {
-		SendJSONWithHTTPCode(w, jobReport, http.StatusOK)
+	contentType := HeaderContentTypeNDJSON
+	if job.EventLog == eventlog.FormatCSV {
+		contentType = HeaderContentTypeCSVUTF8
+	}
+	w.Header().Set(HeaderContentTypeKey, contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, id, job.EventLog))
+	w.WriteHeader(http.StatusOK)
+	if err := eventlog.Concat(w, job.EventLog, readers); err != nil {
+		log.WithError(err).WithField("jobId", id).Debug("Failed to send event log of job")
+	}
+}
+
+func (d *JobsHandler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if _, ok := repov2.Instance.GetJobShell(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	deliveries := repov2.Instance.GetJobWebhookDeliveries(id)
+	deliveriesDTO := make([]WebhookDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		deliveriesDTO = append(deliveriesDTO, mapWebhookDelivery(delivery))
 	}
+
+	SendJSONWithHTTPCode(w, ListOf(deliveriesDTO), http.StatusOK)
 }
 
 func (d *JobsHandler) GetAllJobs(w http.ResponseWriter, r *http.Request) {
@@ -183,6 +464,7 @@ func (d *JobsHandler) GetAllJobs(w http.ResponseWriter, r *http.Request) {
 			ID:       job.ID,
 			DelugeID: job.DelugeID,
 			Webhook:  job.Webhook,
+			EventLog: job.EventLog,
 		})
 	}
 
@@ -193,6 +475,52 @@ func (d *JobsHandler) GetAllJobs(w http.ResponseWriter, r *http.Request) {
 	SendJSONWithHTTPCode(w, ListOf(jobsDTO), http.StatusOK)
 }
 
+func (d *JobsHandler) SetScenarioConcurrency(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	scenarioID := vars["scenarioId"]
+
+	var change ConcurrencyChange
+	if ok := GetJSONBody(w, r, &change); !ok {
+		return
+	}
+	if change.Concurrent == nil || *change.Concurrent < 0 {
+		SendJSONError(w, "Expected 'concurrent' to be a positive integer.", http.StatusBadRequest)
+		return
+	}
+
+	job, ok := repov2.Instance.GetJobShell(id)
+	if !ok {
+		SendJSONError(w, fmt.Sprintf("Job with ID '%s' does not exist.", id), http.StatusNotFound)
+		return
+	}
+	if deluge, ok := repov2.Instance.GetDeluge(job.DelugeID); ok && !containsString(deluge.ScenarioIDs, scenarioID) {
+		SendJSONError(w, fmt.Sprintf("Scenario '%s' is not part of the job.", scenarioID), http.StatusNotFound)
+		return
+	}
+
+	err := worker.GetManager().SetConcurrency(id, scenarioID, *change.Concurrent)
+	switch errors.Cause(err) {
+	case nil:
+		w.WriteHeader(http.StatusAccepted)
+	case worker.ErrUnknownJob, core.ErrUnknownScenario:
+		SendJSONError(w, err.Error(), http.StatusNotFound)
+	case core.ErrScenarioNotRunning, core.ErrConcurrencyNotAdjustable, worker.ErrConcurrencyRejected:
+		SendJSONError(w, err.Error(), http.StatusConflict)
+	default:
+		SendJSONError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *JobsHandler) InterruptJob(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
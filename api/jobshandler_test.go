@@ -1,18 +1,27 @@
 package api
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
+	"github.com/ofux/deluge/core"
+	"github.com/ofux/deluge/core/eventlog"
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/ofux/deluge/core/sinks"
 	"github.com/ofux/deluge/core/status"
+	"github.com/ofux/deluge/dsl/object"
 	"github.com/ofux/deluge/repov2"
 	"github.com/ofux/deluge/worker"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestJobsHandler_Create(t *testing.T) {
@@ -90,6 +99,177 @@ func TestJobsHandler_Create(t *testing.T) {
 		assert.Equal(t, job.Webhook, "")
 	})
 
+	t.Run("Create a job share given by an orchestrator", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		var createdJobShell *worker.JobShell
+		worker.ManagerInstance = &workerManagerMock{
+			CreateAllImpl: func(jobShell *worker.JobShell) error {
+				createdJobShell = jobShell
+				return nil
+			},
+		}
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		w := httptest.NewRecorder()
+
+		var body = `{
+			"id": "remoteJob",
+			"delugeId": "` + delugeKey + `",
+			"share": {"index": 1, "count": 3}
+		}`
+
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/jobs", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusAccepted, w.Code)
+		_, ok := repov2.Instance.GetJobShell("remoteJob")
+		assert.True(t, ok)
+		require.NotNil(t, createdJobShell)
+		assert.Equal(t, "remoteJob", createdJobShell.ID)
+		assert.Equal(t, &core.Share{Index: 1, Count: 3}, createdJobShell.Share)
+
+		// Same ID cannot be used twice
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodPost, "http://example.com/v1/jobs", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("Create a job with a path in its ID", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		worker.ManagerInstance = newWorkerManagerMock()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+
+		for _, id := range []string{"../../etc/x", `..\\x`} {
+			w := httptest.NewRecorder()
+			body := `{"id": "` + id + `", "delugeId": "` + delugeKey + `"}`
+			r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/jobs", strings.NewReader(body))
+			router.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Empty(t, repov2.Instance.GetAllJobShell())
+		}
+	})
+
+	t.Run("Create a job with invalid share", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		worker.ManagerInstance = newWorkerManagerMock()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		w := httptest.NewRecorder()
+
+		var body = `{
+			"delugeId": "` + delugeKey + `",
+			"share": {"index": 3, "count": 3}
+		}`
+
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/jobs", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Create a job with sinks", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		var createdJobShell *worker.JobShell
+		worker.ManagerInstance = &workerManagerMock{
+			CreateAllImpl: func(jobShell *worker.JobShell) error {
+				createdJobShell = jobShell
+				return nil
+			},
+		}
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		w := httptest.NewRecorder()
+
+		var body = `{
+			"delugeId": "` + delugeKey + `",
+			"sinks": [
+				{"type": "statsd", "address": "localhost:8125"},
+				{"type": "influxdb", "address": "http://localhost:8086/write?db=deluge", "headers": {"Authorization": "Token secret"}}
+			]
+		}`
+
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/jobs", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusAccepted, w.Code)
+		require.NotNil(t, createdJobShell)
+		assert.Equal(t, []sinks.Config{
+			{Type: sinks.TypeStatsD, Address: "localhost:8125"},
+			{Type: sinks.TypeInfluxDB, Address: "http://localhost:8086/write?db=deluge", Headers: map[string]string{"Authorization": "Token secret"}},
+		}, createdJobShell.Sinks)
+	})
+
+	t.Run("Create a job with an event log", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		var createdJobShell *worker.JobShell
+		worker.ManagerInstance = &workerManagerMock{
+			CreateAllImpl: func(jobShell *worker.JobShell) error {
+				createdJobShell = jobShell
+				return nil
+			},
+		}
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		w := httptest.NewRecorder()
+
+		var body = `{
+			"delugeId": "` + delugeKey + `",
+			"eventLog": "csv"
+		}`
+
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/jobs", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusAccepted, w.Code)
+		var response JobMetadata
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, eventlog.FormatCSV, response.EventLog)
+		require.NotNil(t, createdJobShell)
+		assert.Equal(t, eventlog.FormatCSV, createdJobShell.EventLog)
+		job, ok := repov2.Instance.GetJobShell(response.ID)
+		require.True(t, ok)
+		assert.Equal(t, eventlog.FormatCSV, job.EventLog)
+	})
+
+	t.Run("Create a job with an event log of unknown format", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		worker.ManagerInstance = newWorkerManagerMock()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		w := httptest.NewRecorder()
+
+		var body = `{
+			"delugeId": "` + delugeKey + `",
+			"eventLog": "xml"
+		}`
+
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/jobs", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Create a job with invalid sink", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		worker.ManagerInstance = newWorkerManagerMock()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		w := httptest.NewRecorder()
+
+		var body = `{
+			"delugeId": "` + delugeKey + `",
+			"sinks": [{"type": "graphite", "address": "localhost:2003"}]
+		}`
+
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/jobs", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
 	t.Run("Create a job with undefined deluge", func(t *testing.T) {
 		repov2.Instance = repov2.NewInMemoryRepository()
 		worker.ManagerInstance = newWorkerManagerMock()
@@ -293,6 +473,262 @@ func TestJobsHandler_GetByID(t *testing.T) {
 		assert.JSONEq(t, `{"id":"myJob","delugeId":"myDeluge","delugeName":"My deluge","status":"inProgress","globalDuration":200000000,"scenarios":{}}`, body)
 	})
 
+	t.Run("Get an existing job with some workers still running", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		worker.ManagerInstance = newWorkerManagerMock()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		w := httptest.NewRecorder()
+
+		createJob(t, jobKey, delugeKey, "")
+		createJobReport(t, "workerId1", jobKey, status.DelugeDoneSuccess)
+		createJobReport(t, "workerId2", jobKey, status.DelugeVirgin)
+
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/"+jobKey, nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		bbody, err := ioutil.ReadAll(w.Body)
+		require.NoError(t, err)
+		body := string(bbody)
+		assert.JSONEq(t, `{"id":"myJob","delugeId":"myDeluge","delugeName":"My deluge","status":"inProgress","globalDuration":200000000,"scenarios":{}}`, body)
+	})
+
+	t.Run("Get an existing job with thresholds", func(t *testing.T) {
+		repo := &repoMock{InMemoryRepository: *repov2.NewInMemoryRepository()}
+		repov2.Instance = repo
+		worker.ManagerInstance = newWorkerManagerMock()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		errorRate, p95 := 0.5, 150.0
+		require.NoError(t, repov2.Instance.SaveJobShell(&repov2.PersistedJobShell{
+			ID:       jobKey,
+			DelugeID: delugeKey,
+			Thresholds: &repov2.PersistedThresholdResults{
+				Passed: false,
+				PerScenario: map[string][]*repov2.PersistedThresholdResult{
+					scenarioKey: {
+						{Request: "*", Metric: "errorRate", Limit: 0.5, Value: &errorRate, Passed: true},
+						{Request: "foo", Metric: "p95", Limit: 100, Value: &p95, Passed: false},
+						{Request: "unknown", Metric: "max", Limit: 1000, Value: nil, Passed: false},
+					},
+				},
+			},
+		}))
+		createJobReport(t, "workerId", jobKey, status.DelugeDoneSuccess)
+		repo.SaveJobShellImpl = func(job *repov2.PersistedJobShell) error {
+			t.Error("reading a job must not save it")
+			return nil
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/"+jobKey, nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		job := &Job{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(job))
+		require.NotNil(t, job.ThresholdsPassed)
+		assert.False(t, *job.ThresholdsPassed)
+		assert.Equal(t, []*ThresholdResult{
+			{Request: "*", Metric: "errorRate", Limit: 0.5, Value: &errorRate, Passed: true},
+			{Request: "foo", Metric: "p95", Limit: 100, Value: &p95, Passed: false},
+			{Request: "unknown", Metric: "max", Limit: 1000, Value: nil, Passed: false},
+		}, job.Scenarios[scenarioKey].Thresholds)
+
+		// The results were saved with the job when it ended, so they do not depend on the deluge anymore
+		require.True(t, repov2.Instance.DeleteDeluge(delugeKey))
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		job = &Job{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(job))
+		require.NotNil(t, job.ThresholdsPassed)
+		assert.False(t, *job.ThresholdsPassed)
+		assert.Len(t, job.Scenarios[scenarioKey].Thresholds, 3)
+	})
+
+	t.Run("Do not show thresholds of a running job", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		worker.ManagerInstance = newWorkerManagerMock()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		require.NoError(t, repov2.Instance.SaveJobShell(&repov2.PersistedJobShell{
+			ID:       jobKey,
+			DelugeID: delugeKey,
+			Thresholds: &repov2.PersistedThresholdResults{
+				Passed: true,
+				PerScenario: map[string][]*repov2.PersistedThresholdResult{
+					scenarioKey: {{Request: "*", Metric: "max", Limit: 1000, Passed: true}},
+				},
+			},
+		}))
+		createJobReport(t, "workerId", jobKey, status.DelugeInProgress)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/"+jobKey, nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		job := &Job{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(job))
+		assert.Nil(t, job.ThresholdsPassed)
+	})
+
+	t.Run("Get an existing job as JUnit XML", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		worker.ManagerInstance = newWorkerManagerMock()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		createJob(t, jobKey, delugeKey, "")
+		createJobReportWithRecords(t, jobKey, scenarioKey, status.DelugeDoneSuccess, 10)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/"+jobKey+"?format=junit", nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, HeaderContentTypeXMLUTF8, w.Header().Get(HeaderContentTypeKey))
+		body := w.Body.String()
+		assert.Contains(t, body, `<testsuites name="My deluge" tests="1" failures="0" time="0.200">`)
+		assert.Contains(t, body, `<testsuite name="myScenario - My scenario" tests="1" failures="0" time="0.200">`)
+		assert.Contains(t, body, `<property name="foo.calls" value="100"></property>`)
+	})
+
+	t.Run("Get an existing job with unknown format", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		worker.ManagerInstance = newWorkerManagerMock()
+		createJob(t, jobKey, delugeKey, "")
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/"+jobKey+"?format=yaml", nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Get a job aborted by a threshold", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		worker.ManagerInstance = newWorkerManagerMock()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		createJob(t, jobKey, delugeKey, "")
+		createJobReport(t, "worker1", jobKey, status.DelugeInterrupted)
+		require.NoError(t, repov2.Instance.SaveWorkerReport(&repov2.PersistedWorkerReport{
+			WorkerID:  "worker2",
+			JobID:     jobKey,
+			Status:    status.DelugeAborted,
+			AbortedBy: &repov2.PersistedAbortCause{ScenarioID: scenarioKey, Request: "foo", Metric: "p95", Limit: 100, Value: 150},
+		}))
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/"+jobKey, nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		job := &Job{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(job))
+		assert.Equal(t, status.DelugeAborted, job.Status)
+		assert.Equal(t, &JobAbortCause{ScenarioID: scenarioKey, Request: "foo", Metric: "p95", Limit: 100, Value: 150}, job.AbortedBy)
+	})
+
+	t.Run("Get a job aborted by an error", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		worker.ManagerInstance = newWorkerManagerMock()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		createJob(t, jobKey, delugeKey, "")
+		assertErr := &object.Error{Message: "Assertion failed"}
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+		recorder.Close()
+		records, err := recorder.GetRecords()
+		require.NoError(t, err)
+		persistedRecords, err := recording.MapHTTPRecords(records)
+		require.NoError(t, err)
+		require.NoError(t, repov2.Instance.SaveWorkerReport(&repov2.PersistedWorkerReport{
+			WorkerID: "worker1",
+			JobID:    jobKey,
+			Status:   status.DelugeAborted,
+			Scenarios: map[string]*repov2.PersistedWorkerScenarioReport{
+				scenarioKey: {
+					Status: status.ScenarioDoneError,
+					// Errors persisted without count occurred once
+					Errors:  []*repov2.PersistedScenarioError{{Error: assertErr, Count: 12}, {Error: &object.Error{Message: "Other error"}}},
+					Records: persistedRecords,
+				},
+			},
+			AbortedBy: &repov2.PersistedAbortCause{ScenarioID: scenarioKey, Error: assertErr},
+		}))
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/"+jobKey, nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		job := &Job{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(job))
+		assert.Equal(t, status.DelugeAborted, job.Status)
+		assert.Equal(t, &JobAbortCause{ScenarioID: scenarioKey, Error: assertErr}, job.AbortedBy)
+		require.Len(t, job.Scenarios[scenarioKey].Errors, 2)
+		assert.Equal(t, uint64(12), job.Scenarios[scenarioKey].Errors[0].Count)
+		assert.Equal(t, uint64(1), job.Scenarios[scenarioKey].Errors[1].Count)
+		assert.Equal(t, uint64(13), job.Scenarios[scenarioKey].ErrorCount)
+	})
+
+	t.Run("Get a job with errors on several workers", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		worker.ManagerInstance = newWorkerManagerMock()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		createJob(t, jobKey, delugeKey, "")
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+		recorder.Close()
+		records, err := recorder.GetRecords()
+		require.NoError(t, err)
+		persistedRecords, err := recording.MapHTTPRecords(records)
+		require.NoError(t, err)
+
+		start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		assertErr := &object.Error{Message: "Assertion failed"}
+		for workerID, errs := range map[string][]*repov2.PersistedScenarioError{
+			"worker1": {
+				{Error: assertErr, Count: 10, FirstAt: start.Add(time.Second), LastAt: start.Add(3 * time.Second), Requests: []string{"foo"}},
+			},
+			"worker2": {
+				{Error: &object.Error{Message: "Other error"}, Count: 1, FirstAt: start.Add(2 * time.Second), LastAt: start.Add(2 * time.Second)},
+				{Error: assertErr, Count: 5, FirstAt: start, LastAt: start.Add(2 * time.Second), Requests: []string{"bar"}},
+			},
+		} {
+			require.NoError(t, repov2.Instance.SaveWorkerReport(&repov2.PersistedWorkerReport{
+				WorkerID: workerID,
+				JobID:    jobKey,
+				Status:   status.DelugeDoneError,
+				Scenarios: map[string]*repov2.PersistedWorkerScenarioReport{
+					scenarioKey: {Status: status.ScenarioDoneError, Errors: errs, Records: persistedRecords},
+				},
+			}))
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/"+jobKey, nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		job := &Job{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(job))
+		scenario := job.Scenarios[scenarioKey]
+		assert.Equal(t, uint64(16), scenario.ErrorCount)
+		require.Len(t, scenario.Errors, 2)
+		assert.Equal(t, assertErr, scenario.Errors[0].Error)
+		assert.Equal(t, uint64(15), scenario.Errors[0].Count)
+		assert.True(t, start.Equal(scenario.Errors[0].FirstAt))
+		assert.True(t, start.Add(3*time.Second).Equal(scenario.Errors[0].LastAt))
+		assert.Equal(t, []string{"bar", "foo"}, scenario.Errors[0].Requests)
+		assert.Equal(t, "Other error", scenario.Errors[1].Message)
+		assert.Equal(t, uint64(1), scenario.Errors[1].Count)
+	})
+
 	t.Run("Get an existing job without scenario definition", func(t *testing.T) {
 		repov2.Instance = repov2.NewInMemoryRepository()
 		worker.ManagerInstance = newWorkerManagerMock()
@@ -386,6 +822,95 @@ func TestJobsHandler_GetAll(t *testing.T) {
 	})
 }
 
+func TestJobsHandler_CompareJob(t *testing.T) {
+	const scenarioKey = "myScenario"
+	const delugeKey = "myDeluge"
+
+	var router = NewRouter(NewJobHandler())
+
+	setup := func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		worker.ManagerInstance = newWorkerManagerMock()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		createJob(t, "baseline", delugeKey, "")
+		createJobReportWithRecords(t, "baseline", scenarioKey, status.DelugeDoneSuccess, 10)
+		createJob(t, "current", delugeKey, "")
+		createJobReportWithRecords(t, "current", scenarioKey, status.DelugeDoneSuccess, 20)
+	}
+
+	t.Run("Compare a job with a baseline", func(t *testing.T) {
+		setup(t)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/current/compare/baseline", nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		comparison := &JobComparison{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(comparison))
+		assert.Equal(t, "current", comparison.JobID)
+		assert.Equal(t, "baseline", comparison.BaselineID)
+		assert.Equal(t, reporting.DefaultTolerances, comparison.Tolerances)
+		assert.True(t, comparison.Regression)
+		require.Contains(t, comparison.Scenarios, scenarioKey)
+		foo := comparison.Scenarios[scenarioKey].PerRequests["foo"]
+		require.NotNil(t, foo)
+		assert.Equal(t, 10.0, foo.Percentiles[95].Baseline)
+		assert.Equal(t, 20.0, foo.Percentiles[95].Current)
+		assert.Contains(t, foo.Regressions, "p95")
+		assert.True(t, foo.Significant)
+	})
+
+	t.Run("Compare a job with a baseline and tolerances", func(t *testing.T) {
+		setup(t)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/current/compare/baseline?responseTime=1.5&errorRate=0.2", nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		comparison := &JobComparison{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(comparison))
+		assert.Equal(t, reporting.Tolerances{ResponseTime: 1.5, Throughput: 0.1, ErrorRate: 0.2}, comparison.Tolerances)
+		assert.False(t, comparison.Regression)
+	})
+
+	t.Run("Compare with bad tolerances", func(t *testing.T) {
+		setup(t)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/current/compare/baseline?throughput=-1", nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Compare with unknown jobs", func(t *testing.T) {
+		setup(t)
+
+		for _, path := range []string{"/v1/jobs/unknown/compare/baseline", "/v1/jobs/current/compare/unknown"} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+			router.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("Compare with a running job", func(t *testing.T) {
+		setup(t)
+		createJob(t, "running", delugeKey, "")
+		createJobReport(t, "workerId", "running", status.DelugeInProgress)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/running/compare/baseline", nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+}
+
 func TestJobsHandler_InterruptJob(t *testing.T) {
 
 	const jobKey = "myJob1"
@@ -423,6 +948,279 @@ func TestJobsHandler_InterruptJob(t *testing.T) {
 	})
 }
 
+func TestJobsHandler_GetJobEvents(t *testing.T) {
+
+	const jobKey = "myJob1"
+
+	var router = NewRouter(NewJobHandler())
+
+	createJobWithEventLog := func(t *testing.T, format string) {
+		t.Helper()
+		require.NoError(t, repov2.Instance.SaveJobShell(&repov2.PersistedJobShell{
+			ID:       jobKey,
+			DelugeID: "myDeluge",
+			EventLog: format,
+		}))
+	}
+	getEvents := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/"+jobKey+"/events", nil)
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Get the event logs of all the workers of a job", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		createJobWithEventLog(t, eventlog.FormatCSV)
+		worker.ManagerInstance = &workerManagerMock{
+			OpenEventLogsImpl: func(jobShellID, format string) ([]io.ReadCloser, error) {
+				assert.Equal(t, jobKey, jobShellID)
+				assert.Equal(t, eventlog.FormatCSV, format)
+				return []io.ReadCloser{
+					ioutil.NopCloser(strings.NewReader("time,request\n1,foo\n")),
+					ioutil.NopCloser(strings.NewReader("time,request\n2,bar\n")),
+				}, nil
+			},
+		}
+
+		w := getEvents()
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, HeaderContentTypeCSVUTF8, w.Header().Get(HeaderContentTypeKey))
+		assert.Equal(t, `attachment; filename="myJob1.csv"`, w.Header().Get("Content-Disposition"))
+		assert.Equal(t, "time,request\n1,foo\n2,bar\n", w.Body.String())
+	})
+
+	t.Run("Get the NDJSON event log of a job", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		createJobWithEventLog(t, eventlog.FormatNDJSON)
+		worker.ManagerInstance = &workerManagerMock{
+			OpenEventLogsImpl: func(jobShellID, format string) ([]io.ReadCloser, error) {
+				return []io.ReadCloser{ioutil.NopCloser(strings.NewReader("{\"request\":\"foo\"}\n"))}, nil
+			},
+		}
+
+		w := getEvents()
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, HeaderContentTypeNDJSON, w.Header().Get(HeaderContentTypeKey))
+		assert.Equal(t, "{\"request\":\"foo\"}\n", w.Body.String())
+	})
+
+	t.Run("Get the event log of a non-existing job", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		worker.ManagerInstance = newWorkerManagerMock()
+
+		assert.Equal(t, http.StatusNotFound, getEvents().Code)
+	})
+
+	t.Run("Get the event log of a job without event log", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		createJobWithEventLog(t, "")
+		worker.ManagerInstance = newWorkerManagerMock()
+
+		assert.Equal(t, http.StatusNotFound, getEvents().Code)
+	})
+
+	t.Run("Get the event log of a job that has not started yet", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		createJobWithEventLog(t, eventlog.FormatNDJSON)
+		worker.ManagerInstance = newWorkerManagerMock()
+
+		assert.Equal(t, http.StatusNotFound, getEvents().Code)
+	})
+
+	t.Run("Fail to open the event logs of a job", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		createJobWithEventLog(t, eventlog.FormatNDJSON)
+		worker.ManagerInstance = &workerManagerMock{
+			OpenEventLogsImpl: func(jobShellID, format string) ([]io.ReadCloser, error) {
+				return nil, errors.New("some error")
+			},
+		}
+
+		assert.Equal(t, http.StatusInternalServerError, getEvents().Code)
+	})
+}
+
+func TestJobsHandler_SetScenarioConcurrency(t *testing.T) {
+	const scenarioKey = "myScenario"
+	const delugeKey = "myDeluge"
+	const jobKey = "myJob1"
+
+	var router = NewRouter(NewJobHandler())
+
+	setup := func(t *testing.T, impl func(jobShellID, scenarioID string, concurrent int) error) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		worker.ManagerInstance = &workerManagerMock{
+			SetConcurrencyImpl: impl,
+		}
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		createJob(t, jobKey, delugeKey, "")
+	}
+	setConcurrency := func(jobID, scenarioID, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPut, "http://example.com/v1/jobs/"+jobID+"/scenarios/"+scenarioID+"/concurrency", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Change concurrency", func(t *testing.T) {
+		var gotJobID, gotScenarioID string
+		var gotConcurrent int
+		setup(t, func(jobShellID, scenarioID string, concurrent int) error {
+			gotJobID, gotScenarioID, gotConcurrent = jobShellID, scenarioID, concurrent
+			return nil
+		})
+
+		w := setConcurrency(jobKey, scenarioKey, `{"concurrent": 42}`)
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, jobKey, gotJobID)
+		assert.Equal(t, scenarioKey, gotScenarioID)
+		assert.Equal(t, 42, gotConcurrent)
+	})
+
+	t.Run("Change concurrency with invalid body", func(t *testing.T) {
+		setup(t, nil)
+		assert.Equal(t, http.StatusBadRequest, setConcurrency(jobKey, scenarioKey, `{"concurrent": -1}`).Code)
+		assert.Equal(t, http.StatusBadRequest, setConcurrency(jobKey, scenarioKey, `{}`).Code)
+		assert.Equal(t, http.StatusBadRequest, setConcurrency(jobKey, scenarioKey, `{"concurrent": "10"}`).Code)
+	})
+
+	t.Run("Change concurrency of unknown job or scenario", func(t *testing.T) {
+		setup(t, nil)
+		assert.Equal(t, http.StatusNotFound, setConcurrency("unknown", scenarioKey, `{"concurrent": 10}`).Code)
+		assert.Equal(t, http.StatusNotFound, setConcurrency(jobKey, "unknown", `{"concurrent": 10}`).Code)
+	})
+
+	t.Run("Change concurrency of a scenario that cannot be changed", func(t *testing.T) {
+		setup(t, func(jobShellID, scenarioID string, concurrent int) error {
+			return core.ErrConcurrencyNotAdjustable
+		})
+		assert.Equal(t, http.StatusConflict, setConcurrency(jobKey, scenarioKey, `{"concurrent": 10}`).Code)
+
+		setup(t, func(jobShellID, scenarioID string, concurrent int) error {
+			return core.ErrScenarioNotRunning
+		})
+		assert.Equal(t, http.StatusConflict, setConcurrency(jobKey, scenarioKey, `{"concurrent": 10}`).Code)
+	})
+
+	t.Run("Change concurrency failing", func(t *testing.T) {
+		setup(t, func(jobShellID, scenarioID string, concurrent int) error {
+			return errors.New("some error")
+		})
+		assert.Equal(t, http.StatusInternalServerError, setConcurrency(jobKey, scenarioKey, `{"concurrent": 10}`).Code)
+	})
+}
+
+func TestJobsHandler_StreamJob(t *testing.T) {
+	const scenarioKey = "myScenario"
+	const delugeKey = "myDeluge"
+	const jobKey = "myJob"
+
+	srv := httptest.NewServer(NewRouter(NewJobHandler()))
+	defer srv.Close()
+
+	setup := func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		worker.ManagerInstance = newWorkerManagerMock()
+		worker.EventsInstance = worker.NewJobEventBroker()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+		createJob(t, jobKey, delugeKey, "")
+	}
+
+	t.Run("Stream the events of a running job", func(t *testing.T) {
+		setup(t)
+		createJobReport(t, "workerId", jobKey, status.DelugeInProgress)
+		worker.EventsInstance.PublishStatus(jobKey, "workerId", status.DelugeInProgress)
+
+		resp, err := http.Get(srv.URL + "/v1/jobs/" + jobKey + "/stream")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		worker.EventsInstance.PublishRecords(jobKey, "workerId", scenarioKey, &reporting.HTTPStatsSnapshot{
+			Global:       &reporting.HTTPStats{Users: 10},
+			PerIteration: map[int]*reporting.HTTPStats{3: {Users: 10}},
+		})
+		worker.EventsInstance.PublishErrors(jobKey, "workerId", scenarioKey, []*object.Error{{Message: "some error"}})
+		worker.EventsInstance.PublishStatus(jobKey, "workerId", status.DelugeDoneError)
+
+		events := readServerSentEvents(t, resp.Body)
+		require.Len(t, events, 4)
+		assert.Equal(t, "status", events[0].Type)
+		assert.JSONEq(t, `{"status":"inProgress"}`, events[0].Data)
+		assert.Equal(t, "records", events[1].Type)
+		var records JobRecordsEvent
+		require.NoError(t, json.Unmarshal([]byte(events[1].Data), &records))
+		assert.Equal(t, "workerId", records.WorkerID)
+		assert.Equal(t, scenarioKey, records.ScenarioID)
+		assert.Equal(t, 10, records.Global.Users)
+		require.Contains(t, records.PerIteration, 3)
+		assert.Equal(t, 10, records.PerIteration[3].Users)
+		assert.Equal(t, "error", events[2].Type)
+		assert.Contains(t, events[2].Data, "some error")
+		assert.Equal(t, "status", events[3].Type)
+		assert.JSONEq(t, `{"status":"doneError"}`, events[3].Data)
+		assert.False(t, worker.EventsInstance.HasSubscribers(jobKey))
+	})
+
+	t.Run("Stream the events of a job that has already ended", func(t *testing.T) {
+		setup(t)
+		createJobReport(t, "workerId", jobKey, status.DelugeDoneSuccess)
+
+		resp, err := http.Get(srv.URL + "/v1/jobs/" + jobKey + "/stream")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		events := readServerSentEvents(t, resp.Body)
+		require.Len(t, events, 1)
+		assert.Equal(t, "status", events[0].Type)
+		assert.JSONEq(t, `{"status":"doneSuccess"}`, events[0].Data)
+	})
+
+	t.Run("Stream the events of a non-existing job", func(t *testing.T) {
+		setup(t)
+
+		resp, err := http.Get(srv.URL + "/v1/jobs/unknown/stream")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+type serverSentEvent struct {
+	Type string
+	Data string
+}
+
+// readServerSentEvents reads events until the server closes the stream.
+func readServerSentEvents(t *testing.T, body io.Reader) []serverSentEvent {
+	t.Helper()
+	var events []serverSentEvent
+	var current serverSentEvent
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current.Type = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.Data = strings.TrimPrefix(line, "data: ")
+		case line == "" && current.Type != "":
+			events = append(events, current)
+			current = serverSentEvent{}
+		}
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
+
 func createJob(t *testing.T, ID, delugeID, webhook string) {
 	t.Helper()
 	err := repov2.Instance.SaveJobShell(&repov2.PersistedJobShell{
@@ -444,6 +1242,28 @@ func createJobReport(t *testing.T, workerID, jobID string, status status.DelugeS
 	require.NoError(t, err)
 }
 
+// createJobReportWithRecords saves the report of a worker that recorded 100 calls of request 'foo' that took the given time
+func createJobReportWithRecords(t *testing.T, jobID, scenarioID string, st status.DelugeStatus, value int64) {
+	t.Helper()
+	recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+	for i := 0; i < 100; i++ {
+		recorder.Record(&recording.HTTPRecordEntry{Iteration: 0, Name: "foo", Value: value, StatusCode: 200})
+	}
+	recorder.Close()
+	records, err := recorder.GetRecords()
+	require.NoError(t, err)
+	persistedRecords, err := recording.MapHTTPRecords(records)
+	require.NoError(t, err)
+	require.NoError(t, repov2.Instance.SaveWorkerReport(&repov2.PersistedWorkerReport{
+		WorkerID: "workerId",
+		JobID:    jobID,
+		Status:   st,
+		Scenarios: map[string]*repov2.PersistedWorkerScenarioReport{
+			scenarioID: {Status: status.ScenarioDoneSuccess, Records: persistedRecords},
+		},
+	}))
+}
+
 func (r *repoMock) SaveJobShell(job *repov2.PersistedJobShell) error {
 	if r.SaveJobShellImpl == nil {
 		return r.InMemoryRepository.SaveJobShell(job)
@@ -456,9 +1276,12 @@ func newWorkerManagerMock() *workerManagerMock {
 }
 
 type workerManagerMock struct {
-	CreateAllImpl    func(jobShell *worker.JobShell) error
-	StartAllImpl     func(jobShell *worker.JobShell) error
-	InterruptAllImpl func(jobShellID string) error
+	CreateAllImpl      func(jobShell *worker.JobShell) error
+	StartAllImpl       func(jobShell *worker.JobShell) error
+	InterruptAllImpl   func(jobShellID string) error
+	SetConcurrencyImpl func(jobShellID, scenarioID string, concurrent int) error
+	OpenEventLogsImpl  func(jobShellID, format string) ([]io.ReadCloser, error)
+	OnJobEndImpl       func(handler worker.JobEndHandler)
 }
 
 func (w *workerManagerMock) CreateAll(jobShell *worker.JobShell) error {
@@ -481,3 +1304,23 @@ func (w *workerManagerMock) InterruptAll(jobShellID string) error {
 	}
 	return nil
 }
+
+func (w *workerManagerMock) SetConcurrency(jobShellID, scenarioID string, concurrent int) error {
+	if w.SetConcurrencyImpl != nil {
+		return w.SetConcurrencyImpl(jobShellID, scenarioID, concurrent)
+	}
+	return nil
+}
+
+func (w *workerManagerMock) OpenEventLogs(jobShellID, format string) ([]io.ReadCloser, error) {
+	if w.OpenEventLogsImpl != nil {
+		return w.OpenEventLogsImpl(jobShellID, format)
+	}
+	return nil, nil
+}
+
+func (w *workerManagerMock) OnJobEnd(handler worker.JobEndHandler) {
+	if w.OnJobEndImpl != nil {
+		w.OnJobEndImpl(handler)
+	}
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/reporting"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Name     string            `xml:"name,attr"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Time     string            `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Time       string           `xml:"time,attr"`
+	Properties []*junitProperty `xml:"properties>property,omitempty"`
+	TestCases  []*junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the job as JUnit XML. Each scenario is a test suite whose failing test cases are its errors, such as
+// failed assertions, and its failed thresholds. The latency summary of each request is given in the properties of the
+// test suite.
+func WriteJUnit(w io.Writer, job *Job) error {
+	suites := &junitTestSuites{
+		Name: job.DelugeName,
+		Time: junitSeconds(job.GlobalDuration.Seconds()),
+	}
+
+	scenarioIDs := make([]string, 0, len(job.Scenarios))
+	for scenarioID := range job.Scenarios {
+		scenarioIDs = append(scenarioIDs, scenarioID)
+	}
+	sort.Strings(scenarioIDs)
+
+	for _, scenarioID := range scenarioIDs {
+		suite := newJUnitTestSuite(job, scenarioID, job.Scenarios[scenarioID])
+		suites.Suites = append(suites.Suites, suite)
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func newJUnitTestSuite(job *Job, scenarioID string, scenario *JobScenario) *junitTestSuite {
+	suite := &junitTestSuite{
+		Name: scenarioID,
+		Time: junitSeconds(job.GlobalDuration.Seconds()),
+	}
+	if scenario.Name != "" {
+		suite.Name += " - " + scenario.Name
+	}
+	className := "deluge." + scenarioID
+
+	suite.Properties = append(suite.Properties, &junitProperty{Name: "status", Value: scenario.Status.String()})
+	if report, ok := scenario.Report.(*reporting.HTTPReport); ok && report.Stats != nil && report.Stats.Global != nil {
+		suite.Properties = append(suite.Properties, junitRequestProperties("*", &report.Stats.Global.HTTPRequestStats)...)
+		names := make([]string, 0, len(report.Stats.Global.PerRequests))
+		for name := range report.Stats.Global.PerRequests {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			suite.Properties = append(suite.Properties, junitRequestProperties(name, report.Stats.Global.PerRequests[name])...)
+		}
+	}
+
+	for _, err := range scenario.Errors {
+		suite.TestCases = append(suite.TestCases, &junitTestCase{
+			Name:      firstLine(err.Message),
+			ClassName: className,
+			Failure: &junitFailure{
+				Message: fmt.Sprintf("%s (%d occurrence(s))", err.Message, err.Count),
+				Type:    "error",
+				Text:    err.Inspect(),
+			},
+		})
+	}
+	if len(scenario.Errors) == 0 {
+		suite.TestCases = append(suite.TestCases, &junitTestCase{Name: "No error", ClassName: className})
+	}
+
+	for _, threshold := range scenario.Thresholds {
+		testCase := &junitTestCase{
+			Name:      fmt.Sprintf("Threshold %s of %s", threshold.Metric, threshold.Request),
+			ClassName: className,
+		}
+		if !threshold.Passed {
+			message := "no call recorded"
+			if threshold.Value != nil {
+				message = fmt.Sprintf("%s exceeds the limit of %s", formatThresholdValue(threshold.Metric, *threshold.Value), formatThresholdValue(threshold.Metric, threshold.Limit))
+			}
+			testCase.Failure = &junitFailure{Message: message, Type: "threshold"}
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+
+	for _, testCase := range suite.TestCases {
+		suite.Tests++
+		if testCase.Failure != nil {
+			suite.Failures++
+		}
+	}
+	return suite
+}
+
+func junitRequestProperties(name string, stats *reporting.HTTPRequestStats) []*junitProperty {
+	if stats.Global == nil {
+		return nil
+	}
+	var koCount int64
+	if koStats := stats.PerOkKo[recording.Ko]; koStats != nil {
+		koCount = koStats.CallCount
+	}
+	return []*junitProperty{
+		{Name: name + ".calls", Value: strconv.FormatInt(stats.Global.CallCount, 10)},
+		{Name: name + ".ko", Value: strconv.FormatInt(koCount, 10)},
+		{Name: name + ".mean", Value: strconv.FormatFloat(stats.Global.Milliseconds(stats.Global.MeanTime), 'f', 2, 64) + "ms"},
+		{Name: name + ".p50", Value: junitMilliseconds(stats.Global, stats.Global.ValueAtQuantiles[50])},
+		{Name: name + ".p95", Value: junitMilliseconds(stats.Global, stats.Global.ValueAtQuantiles[95])},
+		{Name: name + ".p99", Value: junitMilliseconds(stats.Global, stats.Global.ValueAtQuantiles[99])},
+		{Name: name + ".max", Value: junitMilliseconds(stats.Global, stats.Global.MaxTime)},
+	}
+}
+
+// junitMilliseconds formats a response time of the stats in milliseconds, with decimals only below the millisecond.
+func junitMilliseconds(stats *reporting.Stats, value int64) string {
+	return strconv.FormatFloat(stats.Milliseconds(float64(value)), 'f', -1, 64) + "ms"
+}
+
+func formatThresholdValue(metric string, value float64) string {
+	formatted := strconv.FormatFloat(value, 'f', -1, 64)
+	if metric == "errorRate" {
+		return formatted
+	}
+	return formatted + "ms"
+}
+
+func junitSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
+
+func firstLine(s string) string {
+	return strings.TrimSpace(strings.SplitN(s, "\n", 2)[0])
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/ofux/deluge/core/status"
+	"github.com/ofux/deluge/dsl/object"
+	"github.com/ofux/deluge/dsl/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	t.Run("Write a job as JUnit XML", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+		recorder.Record(&recording.HTTPRecordEntry{Iteration: 0, Name: "foo", Value: 10, StatusCode: 200})
+		recorder.Record(&recording.HTTPRecordEntry{Iteration: 0, Name: "foo", Value: 30, StatusCode: 500})
+		recorder.Close()
+		records, err := recorder.GetRecords()
+		require.NoError(t, err)
+
+		assertErr := &object.Error{Message: "Assertion failed", StackToken: []token.Token{{Literal: "assert", Line: 3, Column: 4}}}
+		p95 := 30.0
+		job := &Job{
+			ID:             "job1",
+			DelugeName:     "My deluge",
+			Status:         status.DelugeDoneError,
+			GlobalDuration: 1500 * time.Millisecond,
+			Scenarios: map[string]*JobScenario{
+				"sc1": {
+					Name:   "My scenario",
+					Status: status.ScenarioDoneError,
+					Errors: []*JobError{{Error: assertErr, Count: 2}},
+					Report: (&reporting.HTTPReporter{}).Report(records),
+					Thresholds: []*ThresholdResult{
+						{Request: "foo", Metric: "p95", Limit: 20, Value: &p95, Passed: false},
+						{Request: "*", Metric: "errorRate", Limit: 0.5, Value: new(float64), Passed: true},
+					},
+				},
+				"sc2": {
+					Status: status.ScenarioDoneSuccess,
+				},
+			},
+		}
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, WriteJUnit(buf, job))
+
+		suites := &junitTestSuites{}
+		require.NoError(t, xml.Unmarshal(buf.Bytes(), suites))
+		assert.Equal(t, "My deluge", suites.Name)
+		assert.Equal(t, "1.500", suites.Time)
+		assert.Equal(t, 4, suites.Tests)
+		assert.Equal(t, 2, suites.Failures)
+		require.Len(t, suites.Suites, 2)
+
+		sc1 := suites.Suites[0]
+		assert.Equal(t, "sc1 - My scenario", sc1.Name)
+		assert.Equal(t, 3, sc1.Tests)
+		assert.Equal(t, 2, sc1.Failures)
+		assert.Contains(t, sc1.Properties, &junitProperty{Name: "status", Value: "doneError"})
+		assert.Contains(t, sc1.Properties, &junitProperty{Name: "*.calls", Value: "2"})
+		assert.Contains(t, sc1.Properties, &junitProperty{Name: "foo.ko", Value: "1"})
+		assert.Contains(t, sc1.Properties, &junitProperty{Name: "foo.p95", Value: "30ms"})
+		assert.Equal(t, []*junitTestCase{
+			{Name: "Assertion failed", ClassName: "deluge.sc1", Failure: &junitFailure{
+				Message: "Assertion failed (2 occurrence(s))",
+				Type:    "error",
+				Text:    "RUNTIME ERROR: Assertion failed\n\tat assert (line 3, col 4)",
+			}},
+			{Name: "Threshold p95 of foo", ClassName: "deluge.sc1", Failure: &junitFailure{
+				Message: "30ms exceeds the limit of 20ms",
+				Type:    "threshold",
+			}},
+			{Name: "Threshold errorRate of *", ClassName: "deluge.sc1"},
+		}, sc1.TestCases)
+
+		sc2 := suites.Suites[1]
+		assert.Equal(t, "sc2", sc2.Name)
+		assert.Equal(t, 1, sc2.Tests)
+		assert.Equal(t, 0, sc2.Failures)
+		assert.Equal(t, []*junitTestCase{{Name: "No error", ClassName: "deluge.sc2"}}, sc2.TestCases)
+	})
+}
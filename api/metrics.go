@@ -0,0 +1,185 @@
+package api
+
+import (
+	"bytes"
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/ofux/deluge/worker"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetricsHandler exposes the live metrics of the jobs run by this server in the Prometheus text format.
+// It is only served by workers, as orchestrators do not run jobs themselves.
+type MetricsHandler struct {
+	routes []Route
+}
+
+func (d *MetricsHandler) GetBasePath() string {
+	return "/metrics"
+}
+
+func (d *MetricsHandler) GetRoutes() []Route {
+	return d.routes
+}
+
+// NewMetricsHandler adds a handler to scrape metrics
+func NewMetricsHandler() *MetricsHandler {
+	metricsHandler := &MetricsHandler{}
+
+	// build routes
+	routes := []Route{}
+	// Get metrics
+	routes = append(routes, Route{
+		Name:        "Get metrics",
+		Method:      http.MethodGet,
+		Pattern:     "",
+		HandlerFunc: metricsHandler.GetMetrics,
+	})
+
+	metricsHandler.routes = routes
+	return metricsHandler
+}
+
+func (d *MetricsHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	var jobs []*worker.JobMetrics
+	if collector, ok := worker.GetManager().(worker.MetricsCollector); ok {
+		jobs = collector.CollectMetrics()
+	}
+
+	buf := &bytes.Buffer{}
+	writeMetrics(buf, jobs)
+
+	w.Header().Set(HeaderContentTypeKey, HeaderContentTypePrometheus)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.WithError(err).Error("error while writing metrics")
+	}
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	samples []string
+}
+
+func (f *metricFamily) add(suffix string, labels []string, value string) {
+	f.samples = append(f.samples, f.name+suffix+formatLabels(labels)+" "+value)
+}
+
+// writeMetrics writes the metrics of the jobs in the Prometheus text format. Durations are converted from milliseconds
+// to seconds, as Prometheus expects.
+func writeMetrics(buf *bytes.Buffer, jobs []*worker.JobMetrics) {
+	jobStatus := &metricFamily{name: "deluge_job_status", kind: "gauge", help: "Status of the jobs run by this worker, always 1."}
+	activeUsers := &metricFamily{name: "deluge_scenario_active_users", kind: "gauge", help: "Number of simulated users currently running a scenario."}
+	iterations := &metricFamily{name: "deluge_scenario_iterations_total", kind: "counter", help: "Number of iterations of a scenario executed by simulated users."}
+	scenarioDurations := &metricFamily{name: "deluge_scenario_request_duration_seconds", kind: "summary", help: "Response times of all the HTTP requests of a scenario."}
+	requestDurations := &metricFamily{name: "deluge_request_duration_seconds", kind: "summary", help: "Response times of an HTTP request of a scenario."}
+	requestsPerStatus := &metricFamily{name: "deluge_requests_total", kind: "counter", help: "Number of HTTP requests of a scenario by status code."}
+	requestsPerResult := &metricFamily{name: "deluge_request_results_total", kind: "counter", help: "Number of HTTP requests of a scenario by result, ok or ko."}
+
+	for _, job := range jobs {
+		jobLabels := []string{"job_id", job.JobID, "deluge_id", job.DelugeID, "worker_id", job.WorkerID}
+		jobStatus.add("", append(jobLabels, "status", job.Status.String()), "1")
+
+		scenarioIDs := make([]string, 0, len(job.Scenarios))
+		for scenarioID := range job.Scenarios {
+			scenarioIDs = append(scenarioIDs, scenarioID)
+		}
+		sort.Strings(scenarioIDs)
+
+		for _, scenarioID := range scenarioIDs {
+			scenario := job.Scenarios[scenarioID]
+			scenarioLabels := append(append([]string{}, jobLabels...), "scenario", scenarioID)
+			activeUsers.add("", scenarioLabels, strconv.FormatInt(scenario.ActiveUsers, 10))
+			iterations.add("", scenarioLabels, strconv.FormatUint(scenario.Iterations, 10))
+
+			if scenario.Stats == nil {
+				continue
+			}
+			addSummary(scenarioDurations, scenarioLabels, scenario.Stats.Global)
+
+			names := make([]string, 0, len(scenario.Stats.PerRequests))
+			for name := range scenario.Stats.PerRequests {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				requestStats := scenario.Stats.PerRequests[name]
+				requestLabels := append(append([]string{}, scenarioLabels...), "request", name)
+				addSummary(requestDurations, requestLabels, requestStats.Global)
+
+				statusCodes := make([]int, 0, len(requestStats.PerStatus))
+				for statusCode := range requestStats.PerStatus {
+					statusCodes = append(statusCodes, statusCode)
+				}
+				sort.Ints(statusCodes)
+				for _, statusCode := range statusCodes {
+					requestsPerStatus.add("", append(append([]string{}, requestLabels...), "status_code", strconv.Itoa(statusCode)),
+						strconv.FormatInt(requestStats.PerStatus[statusCode].CallCount, 10))
+				}
+
+				for _, okKo := range []recording.OkKo{recording.Ok, recording.Ko} {
+					var count int64
+					if stats := requestStats.PerOkKo[okKo]; stats != nil {
+						count = stats.CallCount
+					}
+					requestsPerResult.add("", append(append([]string{}, requestLabels...), "result", strings.ToLower(string(okKo))),
+						strconv.FormatInt(count, 10))
+				}
+			}
+		}
+	}
+
+	for _, family := range []*metricFamily{jobStatus, activeUsers, iterations, scenarioDurations, requestDurations, requestsPerStatus, requestsPerResult} {
+		buf.WriteString("# HELP " + family.name + " " + family.help + "\n")
+		buf.WriteString("# TYPE " + family.name + " " + family.kind + "\n")
+		for _, sample := range family.samples {
+			buf.WriteString(sample + "\n")
+		}
+	}
+}
+
+func addSummary(family *metricFamily, labels []string, stats *reporting.Stats) {
+	if stats == nil {
+		return
+	}
+	quantiles := make([]int, 0, len(stats.ValueAtQuantiles))
+	for quantile := range stats.ValueAtQuantiles {
+		quantiles = append(quantiles, quantile)
+	}
+	sort.Ints(quantiles)
+	for _, quantile := range quantiles {
+		family.add("", append(append([]string{}, labels...), "quantile", formatMetricValue(float64(quantile)/100)),
+			formatMetricValue(stats.Milliseconds(float64(stats.ValueAtQuantiles[quantile]))/1000))
+	}
+	family.add("_sum", labels, formatMetricValue(stats.Milliseconds(stats.MeanTime)*float64(stats.CallCount)/1000))
+	family.add("_count", labels, strconv.FormatInt(stats.CallCount, 10))
+}
+
+// formatLabels formats pairs of label names and values.
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+escapeLabelValue(labels[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/ofux/deluge/core/status"
+	"github.com/ofux/deluge/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsHandler_GetMetrics(t *testing.T) {
+	var router = NewRouter(NewMetricsHandler())
+
+	t.Run("Get metrics of running jobs", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+		recorder.Record(&recording.HTTPRecordEntry{Iteration: 0, Name: "foo", Value: 10, StatusCode: 200})
+		recorder.Record(&recording.HTTPRecordEntry{Iteration: 0, Name: "foo", Value: 30, StatusCode: 500})
+		recorder.Record(&recording.HTTPRecordEntry{Iteration: 0, Name: `say "hi"`, Value: 20, StatusCode: 200})
+		recorder.Close()
+		records, err := recorder.GetRecords()
+		require.NoError(t, err)
+
+		worker.ManagerInstance = &metricsCollectorMock{
+			metrics: []*worker.JobMetrics{
+				{
+					JobID:    "job1",
+					DelugeID: "dlg1",
+					WorkerID: "w1",
+					Status:   status.DelugeInProgress,
+					Scenarios: map[string]*worker.ScenarioMetrics{
+						"sc1": {
+							ActiveUsers: 5,
+							Iterations:  42,
+							Stats:       (&reporting.HTTPReporter{}).Report(records).(*reporting.HTTPReport).Stats.Global,
+						},
+					},
+				},
+			},
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/metrics", nil)
+		router.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, HeaderContentTypePrometheus, w.Header().Get(HeaderContentTypeKey))
+		body := w.Body.String()
+		const labels = `job_id="job1",deluge_id="dlg1",worker_id="w1"`
+		assert.Contains(t, body, "# TYPE deluge_job_status gauge\n")
+		assert.Contains(t, body, `deluge_job_status{`+labels+`,status="inProgress"} 1`+"\n")
+		assert.Contains(t, body, `deluge_scenario_active_users{`+labels+`,scenario="sc1"} 5`+"\n")
+		assert.Contains(t, body, "# TYPE deluge_scenario_iterations_total counter\n")
+		assert.Contains(t, body, `deluge_scenario_iterations_total{`+labels+`,scenario="sc1"} 42`+"\n")
+		assert.Contains(t, body, "# TYPE deluge_scenario_request_duration_seconds summary\n")
+		assert.Contains(t, body, `deluge_scenario_request_duration_seconds{`+labels+`,scenario="sc1",quantile="0.99"} 0.03`+"\n")
+		assert.Contains(t, body, `deluge_scenario_request_duration_seconds_sum{`+labels+`,scenario="sc1"} 0.06`+"\n")
+		assert.Contains(t, body, `deluge_scenario_request_duration_seconds_count{`+labels+`,scenario="sc1"} 3`+"\n")
+		assert.Contains(t, body, `deluge_request_duration_seconds{`+labels+`,scenario="sc1",request="foo",quantile="0.5"} 0.01`+"\n")
+		assert.Contains(t, body, `deluge_request_duration_seconds_count{`+labels+`,scenario="sc1",request="foo"} 2`+"\n")
+		assert.Contains(t, body, `deluge_requests_total{`+labels+`,scenario="sc1",request="foo",status_code="200"} 1`+"\n")
+		assert.Contains(t, body, `deluge_requests_total{`+labels+`,scenario="sc1",request="foo",status_code="500"} 1`+"\n")
+		assert.Contains(t, body, `deluge_request_results_total{`+labels+`,scenario="sc1",request="foo",result="ok"} 1`+"\n")
+		assert.Contains(t, body, `deluge_request_results_total{`+labels+`,scenario="sc1",request="foo",result="ko"} 1`+"\n")
+		assert.Contains(t, body, `deluge_request_results_total{`+labels+`,scenario="sc1",request="say \"hi\"",result="ko"} 0`+"\n")
+	})
+
+	t.Run("Get metrics without any job", func(t *testing.T) {
+		worker.ManagerInstance = &metricsCollectorMock{}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/metrics", nil)
+		router.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "# TYPE deluge_job_status gauge\n")
+		assert.NotContains(t, w.Body.String(), "deluge_job_status{")
+	})
+
+	t.Run("Get metrics from a manager that does not run jobs", func(t *testing.T) {
+		worker.ManagerInstance = newWorkerManagerMock()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/metrics", nil)
+		router.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "deluge_job_status{")
+	})
+}
+
+type metricsCollectorMock struct {
+	workerManagerMock
+	metrics []*worker.JobMetrics
+}
+
+func (m *metricsCollectorMock) CollectMetrics() []*worker.JobMetrics {
+	return m.metrics
+}
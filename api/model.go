@@ -1,11 +1,15 @@
 package api
 
 import (
+	"encoding/json"
+	"github.com/ofux/deluge/core"
 	"github.com/ofux/deluge/core/recording"
 	"github.com/ofux/deluge/core/reporting"
+	"github.com/ofux/deluge/core/sinks"
 	"github.com/ofux/deluge/core/status"
 	"github.com/ofux/deluge/dsl/object"
 	"github.com/ofux/deluge/repov2"
+	"github.com/ofux/deluge/worker"
 	"github.com/pkg/errors"
 	"time"
 )
@@ -13,12 +17,35 @@ import (
 type JobCreation struct {
 	DelugeID string `json:"delugeId"`
 	Webhook  string `json:"webhook"`
+	// Sinks are the external systems every request of the job is sent to while it runs
+	Sinks []sinks.Config `json:"sinks,omitempty"`
+	// EventLog is the format (ndjson or csv) of the log every HTTP request of the job is written to. There is no event
+	// log if it is empty.
+	EventLog string `json:"eventLog,omitempty"`
+	// ID and Share are set by an orchestrator when it spreads a job across several workers
+	ID    string      `json:"id,omitempty"`
+	Share *core.Share `json:"share,omitempty"`
+}
+
+// ConcurrencyChange is the new number of concurrent users of a scenario of a running job
+type ConcurrencyChange struct {
+	Concurrent *int `json:"concurrent"`
 }
 
 type JobMetadata struct {
 	ID       string `json:"id"`
 	DelugeID string `json:"delugeId"`
 	Webhook  string `json:"webhook"`
+	EventLog string `json:"eventLog,omitempty"`
+}
+
+type WebhookDelivery struct {
+	Attempt    int           `json:"attempt"`
+	Time       time.Time     `json:"time"`
+	Duration   time.Duration `json:"duration"`
+	StatusCode int           `json:"statusCode,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Success    bool          `json:"success"`
 }
 
 type Job struct {
@@ -28,6 +55,11 @@ type Job struct {
 	Status         status.DelugeStatus     `json:"status"`
 	GlobalDuration time.Duration           `json:"globalDuration"`
 	Scenarios      map[string]*JobScenario `json:"scenarios"`
+	// ThresholdsPassed tells whether all the thresholds of the scenarios passed. It is only set once the job has
+	// ended, if the deluge has thresholds.
+	ThresholdsPassed *bool `json:"thresholdsPassed,omitempty"`
+	// AbortedBy is the threshold that aborted the job, if its status is aborted
+	AbortedBy *JobAbortCause `json:"abortedBy,omitempty"`
 }
 
 type JobScenario struct {
@@ -35,8 +67,108 @@ type JobScenario struct {
 	Name              string                `json:"name"`
 	IterationDuration time.Duration         `json:"iterationDuration"`
 	Status            status.ScenarioStatus `json:"status"`
-	Errors            []*object.Error       `json:"errors"`
+	Errors            []*JobError           `json:"errors"`
+	ErrorCount        uint64                `json:"errorCount"`
 	Report            reporting.Report      `json:"report"`
+	Thresholds        []*ThresholdResult    `json:"thresholds,omitempty"`
+}
+
+// JobError is a group of identical errors (same message at the same location) that made iterations of the users of a
+// scenario fail, on all the workers of the job. Requests are the names of the last requests called before the errors.
+type JobError struct {
+	*object.Error
+	Count    uint64    `json:"count"`
+	FirstAt  time.Time `json:"firstAt"`
+	LastAt   time.Time `json:"lastAt"`
+	Requests []string  `json:"requests,omitempty"`
+}
+
+// ThresholdResult is the outcome of a threshold of a scenario. Limit and Value are in milliseconds for response
+// times, and ratios for the error rate.
+type ThresholdResult struct {
+	Request string   `json:"request"`
+	Metric  string   `json:"metric"`
+	Limit   float64  `json:"limit"`
+	Value   *float64 `json:"value"`
+	Passed  bool     `json:"passed"`
+}
+
+// JobAbortCause is a threshold with abortOnFail that failed while the job was running, or the error of a scenario
+// configured to abort the job on error
+type JobAbortCause struct {
+	ScenarioID string  `json:"scenarioId"`
+	Request    string  `json:"request,omitempty"`
+	Metric     string  `json:"metric,omitempty"`
+	Limit      float64 `json:"limit,omitempty"`
+	Value      float64 `json:"value,omitempty"`
+	// Error is set instead of the threshold if the job was aborted by an error
+	Error *object.Error `json:"error,omitempty"`
+}
+
+// UnmarshalJSON reads the report of the scenario as a *reporting.HTTPReport, so that a job read from JSON has the
+// same report type as a job mapped from the repository.
+func (s *JobScenario) UnmarshalJSON(data []byte) error {
+	type jobScenario JobScenario
+	aux := &struct {
+		*jobScenario
+		Report json.RawMessage `json:"report"`
+	}{
+		jobScenario: (*jobScenario)(s),
+	}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	s.Report = nil
+	if len(aux.Report) == 0 || string(aux.Report) == "null" {
+		return nil
+	}
+	report := &reporting.HTTPReport{}
+	if err := json.Unmarshal(aux.Report, report); err != nil {
+		return errors.Wrapf(err, "failed to read report of scenario %s", s.ID)
+	}
+	s.Report = report
+	return nil
+}
+
+// JobStatusEvent is sent on the stream of a job when its status changes
+type JobStatusEvent struct {
+	Status status.DelugeStatus `json:"status"`
+}
+
+// JobRecordsEvent is sent on the stream of a job when a worker has new records. The statistics of each iteration
+// replace the ones previously received for the same worker, scenario and iteration.
+type JobRecordsEvent struct {
+	WorkerID     string                       `json:"workerId"`
+	ScenarioID   string                       `json:"scenarioId"`
+	Global       *reporting.HTTPStats         `json:"global"`
+	PerIteration map[int]*reporting.HTTPStats `json:"perIteration"`
+}
+
+// JobErrorEvent is sent on the stream of a job when users of a scenario stop because of errors
+type JobErrorEvent struct {
+	WorkerID   string          `json:"workerId"`
+	ScenarioID string          `json:"scenarioId"`
+	Errors     []*object.Error `json:"errors"`
+}
+
+func mapJobEvent(event *worker.JobEvent) interface{} {
+	switch event.Type {
+	case worker.JobEventStatus:
+		return JobStatusEvent{Status: event.Status}
+	case worker.JobEventRecords:
+		return JobRecordsEvent{
+			WorkerID:     event.WorkerID,
+			ScenarioID:   event.ScenarioID,
+			Global:       event.Records.Global,
+			PerIteration: event.Records.PerIteration,
+		}
+	default:
+		return JobErrorEvent{
+			WorkerID:   event.WorkerID,
+			ScenarioID: event.ScenarioID,
+			Errors:     event.Errors,
+		}
+	}
 }
 
 func mapDeluge(job *repov2.PersistedJobShell, deluge *repov2.PersistedDeluge, scenarioDefs map[string]*repov2.PersistedScenario, workerReports []*repov2.PersistedWorkerReport) (*Job, error) {
@@ -52,36 +184,48 @@ func mapDeluge(job *repov2.PersistedJobShell, deluge *repov2.PersistedDeluge, sc
 		dDTO.GlobalDuration = deluge.GlobalDuration
 	}
 
-	delugeStatus := status.DelugeVirgin
 	scenariosStatus := make(map[string]status.ScenarioStatus)
-	scenariosErrors := make(map[string][]*object.Error)
+	scenariosErrors := make(map[string][]*reporting.ErrorGroup)
 	scenariosIterationDurations := make(map[string]time.Duration)
 	scenariosRecords := make(map[string]*recording.HTTPRecordsOverTime)
 	httpReporter := &reporting.HTTPReporter{}
 
 	// Merge records
 	for _, wr := range workerReports {
-		delugeStatus = status.MergeDelugeStatuses(delugeStatus, wr.Status)
+		if wr.AbortedBy != nil && dDTO.AbortedBy == nil {
+			dDTO.AbortedBy = &JobAbortCause{
+				ScenarioID: wr.AbortedBy.ScenarioID,
+				Request:    wr.AbortedBy.Request,
+				Metric:     wr.AbortedBy.Metric,
+				Limit:      wr.AbortedBy.Limit,
+				Value:      wr.AbortedBy.Value,
+				Error:      wr.AbortedBy.Error,
+			}
+		}
 		for scenarioID, scenario := range wr.Scenarios {
 			scenariosStatus[scenarioID] = status.MergeScenarioStatuses(scenariosStatus[scenarioID], scenario.Status)
-			scenariosErrors[scenarioID] = append(scenariosErrors[scenarioID], scenario.Errors...)
+			scenariosErrors[scenarioID] = reporting.MergeErrorGroups(scenariosErrors[scenarioID], mapPersistedErrors(scenario.Errors))
 			scenariosIterationDurations[scenarioID] = scenario.IterationDuration
 			rec, err := recording.MapPersistedHTTPRecords(scenario.Records)
 			if err != nil {
 				return nil, errors.Wrapf(err, "failed to map scenario %s of worker %s of job %s", scenarioID, wr.WorkerID, wr.JobID)
 			}
-			scenariosRecords[scenarioID] = recording.MergeHTTPRecordsOverTime(scenariosRecords[scenarioID], rec)
+			scenariosRecords[scenarioID], err = recording.MergeHTTPRecordsOverTime(scenariosRecords[scenarioID], rec)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to merge scenario %s of worker %s of job %s", scenarioID, wr.WorkerID, wr.JobID)
+			}
 		}
 	}
 
 	jobScenarios := make(map[string]*JobScenario)
 	for scenarioID, scenarioStatus := range scenariosStatus {
 		jobScenario := &JobScenario{
+			ID:                scenarioID,
 			IterationDuration: scenariosIterationDurations[scenarioID],
 			Status:            scenarioStatus,
-			Errors:            scenariosErrors[scenarioID],
 			Report:            httpReporter.Report(scenariosRecords[scenarioID]),
 		}
+		jobScenario.Errors, jobScenario.ErrorCount = mapErrorGroups(scenariosErrors[scenarioID])
 		if scenarioDefs != nil {
 			if scenarioDef, ok := scenarioDefs[scenarioID]; ok {
 				jobScenario.Name = scenarioDef.Name
@@ -90,7 +234,91 @@ func mapDeluge(job *repov2.PersistedJobShell, deluge *repov2.PersistedDeluge, sc
 		jobScenarios[scenarioID] = jobScenario
 	}
 
-	dDTO.Status = delugeStatus
+	dDTO.Status = getJobStatus(workerReports)
 	dDTO.Scenarios = jobScenarios
+	if dDTO.Status.IsEnd() && job.Thresholds != nil {
+		mapThresholdResults(dDTO, job.Thresholds)
+	}
 	return dDTO, nil
 }
+
+// mapThresholdResults sets the results of the thresholds of the job on its scenarios. It leaves ThresholdsPassed
+// unset if the deluge has no thresholds.
+func mapThresholdResults(dDTO *Job, results *repov2.PersistedThresholdResults) {
+	if len(results.PerScenario) == 0 {
+		return
+	}
+	for scenarioID, scenarioResults := range results.PerScenario {
+		jobScenario, ok := dDTO.Scenarios[scenarioID]
+		if !ok {
+			jobScenario = &JobScenario{ID: scenarioID}
+			dDTO.Scenarios[scenarioID] = jobScenario
+		}
+		for _, result := range scenarioResults {
+			jobScenario.Thresholds = append(jobScenario.Thresholds, &ThresholdResult{
+				Request: result.Request,
+				Metric:  result.Metric,
+				Limit:   result.Limit,
+				Value:   result.Value,
+				Passed:  result.Passed,
+			})
+		}
+	}
+	passed := results.Passed
+	dDTO.ThresholdsPassed = &passed
+}
+
+// getJobStatus merges the statuses of the reports of all the workers of a job
+func getJobStatus(workerReports []*repov2.PersistedWorkerReport) status.DelugeStatus {
+	workersStatuses := make([]status.DelugeStatus, 0, len(workerReports))
+	for _, wr := range workerReports {
+		workersStatuses = append(workersStatuses, wr.Status)
+	}
+	return status.MergeJobStatuses(workersStatuses...)
+}
+
+func mapWebhookDelivery(delivery *repov2.PersistedWebhookDelivery) WebhookDelivery {
+	return WebhookDelivery{
+		Attempt:    delivery.Attempt,
+		Time:       delivery.Time,
+		Duration:   delivery.Duration,
+		StatusCode: delivery.StatusCode,
+		Error:      delivery.Error,
+		Success:    isWebhookDeliverySuccess(delivery),
+	}
+}
+
+func mapPersistedErrors(errs []*repov2.PersistedScenarioError) []*reporting.ErrorGroup {
+	groups := make([]*reporting.ErrorGroup, 0, len(errs))
+	for _, err := range errs {
+		count := err.Count
+		if count == 0 {
+			count = 1
+		}
+		groups = append(groups, &reporting.ErrorGroup{
+			Error:    err.Error,
+			Count:    count,
+			FirstAt:  err.FirstAt,
+			LastAt:   err.LastAt,
+			Requests: err.Requests,
+		})
+	}
+	return groups
+}
+
+// mapErrorGroups returns the errors of a scenario, and their total count.
+func mapErrorGroups(groups []*reporting.ErrorGroup) ([]*JobError, uint64) {
+	errs := make([]*JobError, 0, len(groups))
+	var count uint64
+	for _, group := range groups {
+		errs = append(errs, &JobError{
+			Error:    group.Error,
+			Count:    group.Count,
+			FirstAt:  group.FirstAt,
+			LastAt:   group.LastAt,
+			Requests: group.Requests,
+		})
+		count += group.Count
+	}
+	return errs, count
+}
@@ -2,12 +2,22 @@ package api
 
 import (
 	"github.com/meatballhat/negroni-logrus"
+	"github.com/ofux/deluge/worker"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/negroni"
 	"strconv"
 )
 
 func NewServer() *negroni.Negroni {
+	return newServer(NewJobHandler(), NewScenarioHandler(), NewDelugeHandler(), NewMetricsHandler())
+}
+
+// NewOrchestratorServer creates a server that also lets remote workers join the given manager.
+func NewOrchestratorServer(manager *worker.RemoteManager) *negroni.Negroni {
+	return newServer(NewJobHandler(), NewScenarioHandler(), NewDelugeHandler(), NewWorkersHandler(manager))
+}
+
+func newServer(resourcesHandlers ...ResourceHandler) *negroni.Negroni {
 	// web server
 	n := negroni.New()
 
@@ -20,7 +30,7 @@ func NewServer() *negroni.Negroni {
 	n.Use(recovery)
 
 	// route handler goes last
-	n.UseHandler(NewRouter(NewJobHandler(), NewScenarioHandler(), NewDelugeHandler()))
+	n.UseHandler(NewRouter(resourcesHandlers...))
 
 	return n
 }
@@ -28,3 +38,7 @@ func NewServer() *negroni.Negroni {
 func Serve(port int) {
 	NewServer().Run(":" + strconv.Itoa(port))
 }
+
+func ServeOrchestrator(port int, manager *worker.RemoteManager) {
+	NewOrchestratorServer(manager).Run(":" + strconv.Itoa(port))
+}
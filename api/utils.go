@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
@@ -10,8 +11,13 @@ import (
 )
 
 const (
-	HeaderContentTypeKey      = "Content-Type"
-	HeaderContentTypeJsonUTF8 = "application/json; charset=UTF-8"
+	HeaderContentTypeKey         = "Content-Type"
+	HeaderContentTypeJsonUTF8    = "application/json; charset=UTF-8"
+	HeaderContentTypeEventStream = "text/event-stream"
+	HeaderContentTypeXMLUTF8     = "application/xml; charset=UTF-8"
+	HeaderContentTypePrometheus  = "text/plain; version=0.0.4; charset=utf-8"
+	HeaderContentTypeNDJSON      = "application/x-ndjson"
+	HeaderContentTypeCSVUTF8     = "text/csv; charset=UTF-8"
 )
 
 type List struct {
@@ -62,6 +68,21 @@ func SendRawStringHTTPCode(w http.ResponseWriter, str string, code int) {
 	}
 }
 
+// SendServerSentEvent writes an event of the given type with d as JSON data, and flushes it to the client
+func SendServerSentEvent(w http.ResponseWriter, eventType string, d interface{}) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode data of %s event", eventType)
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, data); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
 func GetNonEmptyBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/ofux/deluge/cleanhttp"
+	"github.com/ofux/deluge/repov2"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// WebhookSignatureHeader is the header holding the HMAC-SHA256 of the body of a webhook call, if a secret is set
+	WebhookSignatureHeader = "X-Deluge-Signature"
+	// WebhookAttemptHeader is the header holding the number of the delivery attempt of a webhook call
+	WebhookAttemptHeader = "X-Deluge-Attempt"
+)
+
+// WebhookSender calls the webhook of a job with the final report of the job once the job has ended.
+type WebhookSender struct {
+	// ctx stops the calls in progress once done
+	ctx        context.Context
+	repository repov2.Repository
+	secret     string
+	client     *http.Client
+
+	maxAttempts      int
+	firstRetryDelay  time.Duration
+	retryDelayFactor int
+}
+
+// NewWebhookSender creates a WebhookSender that reads jobs and saves deliveries in the given repository, until ctx is
+// done. If secret is not empty, every call is signed with it.
+func NewWebhookSender(ctx context.Context, repository repov2.Repository, secret string) *WebhookSender {
+	client := cleanhttp.DefaultClient()
+	client.Timeout = 30 * time.Second
+	return &WebhookSender{
+		ctx:        ctx,
+		repository: repository,
+		secret:     secret,
+		client:     client,
+
+		maxAttempts:      5,
+		firstRetryDelay:  5 * time.Second,
+		retryDelayFactor: 3,
+	}
+}
+
+// Sign returns the signature of the given body, as sent in the WebhookSignatureHeader.
+func (s *WebhookSender) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(s.secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NotifyJobEnd calls the webhook of the job in the background, if it has one. It is meant to be the job end handler of
+// the worker manager.
+func (s *WebhookSender) NotifyJobEnd(jobShellID string) {
+	go s.notify(jobShellID)
+}
+
+// ResumePending calls again the webhook of the jobs that have ended without a successful call, because the server was
+// stopped before or while calling it. It returns once all the calls are done.
+func (s *WebhookSender) ResumePending() {
+	var wg sync.WaitGroup
+	for _, jobShell := range s.repository.GetAllJobShell() {
+		if jobShell.Webhook == "" || !s.isDeliveryPending(jobShell.ID) {
+			continue
+		}
+		if !getJobStatus(s.repository.GetJobWorkerReports(jobShell.ID)).IsEnd() {
+			continue
+		}
+		wg.Add(1)
+		go func(jobShellID string) {
+			defer wg.Done()
+			s.notify(jobShellID)
+		}(jobShell.ID)
+	}
+	wg.Wait()
+}
+
+// notify POSTs the final report of the job to the webhook of the job, if it has one.
+func (s *WebhookSender) notify(jobShellID string) {
+	jobShell, ok := s.repository.GetJobShell(jobShellID)
+	if !ok || jobShell.Webhook == "" {
+		return
+	}
+	logger := logrus.WithField("jobId", jobShell.ID).WithField("webhook", jobShell.Webhook)
+
+	jobReport, _, err := getJobReport(s.repository, jobShell)
+	if err != nil {
+		logger.WithError(err).Error("Failed to get report of job for its webhook")
+		return
+	}
+	if jobReport == nil || !jobReport.Status.IsEnd() {
+		logger.Error("Job has not ended, its webhook will not be called")
+		return
+	}
+
+	body, err := json.Marshal(jobReport)
+	if err != nil {
+		logger.WithError(err).Error("Failed to marshal report of job for its webhook")
+		return
+	}
+	if err := s.deliver(jobShell, body); err != nil {
+		logger.WithError(err).Error("Failed to call webhook of job")
+	} else {
+		logger.Info("Called webhook of job")
+	}
+}
+
+// isDeliveryPending tells whether the webhook of the job has neither been called successfully nor given up on.
+func (s *WebhookSender) isDeliveryPending(jobShellID string) bool {
+	deliveries := s.repository.GetJobWebhookDeliveries(jobShellID)
+	for _, delivery := range deliveries {
+		if isWebhookDeliverySuccess(delivery) {
+			return false
+		}
+	}
+	return len(deliveries) < s.maxAttempts
+}
+
+// deliver POSTs the body to the webhook of the job until it succeeds, the maximum number of attempts is reached or the
+// context of the sender is done. Every attempt is saved in the repository, and the attempts made before a restart
+// count.
+func (s *WebhookSender) deliver(jobShell *repov2.PersistedJobShell, body []byte) error {
+	delay := s.firstRetryDelay
+	for attempt := len(s.repository.GetJobWebhookDeliveries(jobShell.ID)) + 1; ; attempt++ {
+		delivery := s.send(jobShell, body, attempt)
+		if s.ctx.Err() != nil {
+			// The call was cut short, so it is left to be made again after a restart
+			return s.ctx.Err()
+		}
+		if err := s.repository.SaveWebhookDelivery(delivery); err != nil {
+			logrus.WithField("jobId", jobShell.ID).WithError(err).Error("Failed to save webhook delivery")
+		}
+		if isWebhookDeliverySuccess(delivery) {
+			return nil
+		}
+		if attempt >= s.maxAttempts {
+			return errors.Errorf("gave up after %d attempts", attempt)
+		}
+		logrus.WithField("jobId", jobShell.ID).Warnf("Webhook call failed (status %d, error '%s'). Retrying in %s", delivery.StatusCode, delivery.Error, delay)
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= time.Duration(s.retryDelayFactor)
+	}
+}
+
+func (s *WebhookSender) send(jobShell *repov2.PersistedJobShell, body []byte, attempt int) *repov2.PersistedWebhookDelivery {
+	delivery := &repov2.PersistedWebhookDelivery{
+		JobID:   jobShell.ID,
+		Attempt: attempt,
+		Time:    time.Now(),
+	}
+	defer func() {
+		delivery.Duration = time.Since(delivery.Time)
+	}()
+
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, jobShell.Webhook, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set(WebhookAttemptHeader, strconv.Itoa(attempt))
+	if s.secret != "" {
+		req.Header.Set(WebhookSignatureHeader, s.Sign(body))
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	delivery.StatusCode = res.StatusCode
+	return delivery
+}
+
+func isWebhookDeliverySuccess(delivery *repov2.PersistedWebhookDelivery) bool {
+	return delivery.Error == "" && delivery.StatusCode >= 200 && delivery.StatusCode < 300
+}
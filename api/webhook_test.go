@@ -0,0 +1,283 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ofux/deluge/core/status"
+	"github.com/ofux/deluge/repov2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookCall struct {
+	attempt   string
+	signature string
+	body      []byte
+}
+
+func newTestWebhookSender(ctx context.Context, secret string) *WebhookSender {
+	sender := NewWebhookSender(ctx, repov2.Instance, secret)
+	sender.maxAttempts = 3
+	sender.firstRetryDelay = 10 * time.Millisecond
+	return sender
+}
+
+func TestWebhookSender_Sign(t *testing.T) {
+	sender := NewWebhookSender(context.Background(), repov2.NewInMemoryRepository(), "my-secret")
+	// echo -n '{"id":"foo"}' | openssl dgst -sha256 -hmac my-secret
+	assert.Equal(t, "sha256=4be9d79399399302fe36d8182f974bd29e6edb8233a3030a46ec836fa3f919c9", sender.Sign([]byte(`{"id":"foo"}`)))
+}
+
+func TestWebhookSender_notify(t *testing.T) {
+	const scenarioKey = "myScenario"
+	const delugeKey = "myDeluge"
+	const jobKey = "myJob"
+
+	t.Run("Call webhook with final report of the job", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+
+		var calls []webhookCall
+		callsMut := &sync.Mutex{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			callsMut.Lock()
+			defer callsMut.Unlock()
+			calls = append(calls, webhookCall{
+				attempt:   r.Header.Get(WebhookAttemptHeader),
+				signature: r.Header.Get(WebhookSignatureHeader),
+				body:      body,
+			})
+			if len(calls) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer srv.Close()
+
+		jobShell := &repov2.PersistedJobShell{ID: jobKey, DelugeID: delugeKey, Webhook: srv.URL + "?job_id=" + jobKey}
+		require.NoError(t, repov2.Instance.SaveJobShell(jobShell))
+		createJobReport(t, "worker1", jobKey, status.DelugeDoneSuccess)
+		createJobReport(t, "worker2", jobKey, status.DelugeDoneSuccess)
+
+		sender := newTestWebhookSender(context.Background(), "my-secret")
+		sender.notify(jobKey)
+
+		callsMut.Lock()
+		defer callsMut.Unlock()
+		require.Len(t, calls, 2)
+		assert.Equal(t, "1", calls[0].attempt)
+		assert.Equal(t, "2", calls[1].attempt)
+		assert.Equal(t, calls[0].body, calls[1].body)
+		assert.Equal(t, sender.Sign(calls[1].body), calls[1].signature)
+		var job Job
+		require.NoError(t, json.Unmarshal(calls[1].body, &job))
+		assert.Equal(t, jobKey, job.ID)
+		assert.Equal(t, status.DelugeDoneSuccess, job.Status)
+
+		deliveries := repov2.Instance.GetJobWebhookDeliveries(jobKey)
+		require.Len(t, deliveries, 2)
+		assert.Equal(t, 1, deliveries[0].Attempt)
+		assert.Equal(t, http.StatusServiceUnavailable, deliveries[0].StatusCode)
+		assert.Equal(t, 2, deliveries[1].Attempt)
+		assert.Equal(t, http.StatusOK, deliveries[1].StatusCode)
+	})
+
+	t.Run("Do not call webhook of a job that has not ended", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("webhook of a running job must not be called")
+		}))
+		defer srv.Close()
+
+		jobShell := &repov2.PersistedJobShell{ID: jobKey, DelugeID: delugeKey, Webhook: srv.URL}
+		require.NoError(t, repov2.Instance.SaveJobShell(jobShell))
+		createJobReport(t, "worker1", jobKey, status.DelugeDoneSuccess)
+		createJobReport(t, "worker2", jobKey, status.DelugeInProgress)
+
+		newTestWebhookSender(context.Background(), "").notify(jobKey)
+
+		assert.Empty(t, repov2.Instance.GetJobWebhookDeliveries(jobKey))
+	})
+
+	t.Run("Do nothing for a job without webhook", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+
+		require.NoError(t, repov2.Instance.SaveJobShell(&repov2.PersistedJobShell{ID: jobKey, DelugeID: delugeKey}))
+		createJobReport(t, "worker1", jobKey, status.DelugeDoneSuccess)
+
+		newTestWebhookSender(context.Background(), "").notify(jobKey)
+
+		assert.Empty(t, repov2.Instance.GetJobWebhookDeliveries(jobKey))
+	})
+
+	t.Run("Give up after max attempts", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Empty(t, r.Header.Get(WebhookSignatureHeader))
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer srv.Close()
+
+		jobShell := &repov2.PersistedJobShell{ID: jobKey, DelugeID: delugeKey, Webhook: srv.URL}
+		require.NoError(t, repov2.Instance.SaveJobShell(jobShell))
+		createJobReport(t, "worker1", jobKey, status.DelugeInterrupted)
+
+		newTestWebhookSender(context.Background(), "").notify(jobKey)
+
+		deliveries := repov2.Instance.GetJobWebhookDeliveries(jobKey)
+		require.Len(t, deliveries, 3)
+		for _, delivery := range deliveries {
+			assert.Equal(t, http.StatusInternalServerError, delivery.StatusCode)
+		}
+	})
+
+	t.Run("Record unreachable webhook", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		srv.Close()
+
+		jobShell := &repov2.PersistedJobShell{ID: jobKey, DelugeID: delugeKey, Webhook: srv.URL}
+		require.NoError(t, repov2.Instance.SaveJobShell(jobShell))
+		createJobReport(t, "worker1", jobKey, status.DelugeDoneError)
+
+		newTestWebhookSender(context.Background(), "").notify(jobKey)
+
+		deliveries := repov2.Instance.GetJobWebhookDeliveries(jobKey)
+		require.Len(t, deliveries, 3)
+		assert.Equal(t, 0, deliveries[0].StatusCode)
+		assert.NotEmpty(t, deliveries[0].Error)
+	})
+
+	t.Run("Stop retrying once the sender is stopped", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		createScenario(t, scenarioKey, "My scenario")
+		createDeluge(t, delugeKey, "My deluge", scenarioKey)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer srv.Close()
+
+		jobShell := &repov2.PersistedJobShell{ID: jobKey, DelugeID: delugeKey, Webhook: srv.URL}
+		require.NoError(t, repov2.Instance.SaveJobShell(jobShell))
+		createJobReport(t, "worker1", jobKey, status.DelugeDoneSuccess)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		sender := newTestWebhookSender(ctx, "")
+		sender.firstRetryDelay = time.Hour
+		done := make(chan struct{})
+		go func() {
+			sender.notify(jobKey)
+			close(done)
+		}()
+
+		for i := 0; i < 100 && len(repov2.Instance.GetJobWebhookDeliveries(jobKey)) == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("webhook sender did not stop")
+		}
+		assert.Len(t, repov2.Instance.GetJobWebhookDeliveries(jobKey), 1)
+	})
+}
+
+func TestWebhookSender_ResumePending(t *testing.T) {
+	const scenarioKey = "myScenario"
+	const delugeKey = "myDeluge"
+
+	repov2.Instance = repov2.NewInMemoryRepository()
+	createScenario(t, scenarioKey, "My scenario")
+	createDeluge(t, delugeKey, "My deluge", scenarioKey)
+
+	var calls []string
+	callsMut := &sync.Mutex{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callsMut.Lock()
+		defer callsMut.Unlock()
+		calls = append(calls, r.URL.Query().Get("job_id")+"#"+r.Header.Get(WebhookAttemptHeader))
+	}))
+	defer srv.Close()
+
+	createWebhookJob := func(jobKey string, reportStatus status.DelugeStatus, previousStatusCodes ...int) {
+		jobShell := &repov2.PersistedJobShell{ID: jobKey, DelugeID: delugeKey, Webhook: srv.URL + "?job_id=" + jobKey}
+		require.NoError(t, repov2.Instance.SaveJobShell(jobShell))
+		createJobReport(t, "worker1", jobKey, reportStatus)
+		for i, statusCode := range previousStatusCodes {
+			require.NoError(t, repov2.Instance.SaveWebhookDelivery(&repov2.PersistedWebhookDelivery{
+				JobID: jobKey, Attempt: i + 1, Time: time.Now(), StatusCode: statusCode,
+			}))
+		}
+	}
+	createWebhookJob("neverCalled", status.DelugeDoneSuccess)
+	createWebhookJob("failedOnce", status.DelugeDoneError, http.StatusBadGateway)
+	createWebhookJob("succeeded", status.DelugeDoneSuccess, http.StatusBadGateway, http.StatusOK)
+	createWebhookJob("gaveUp", status.DelugeDoneSuccess, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	createWebhookJob("running", status.DelugeInProgress)
+
+	newTestWebhookSender(context.Background(), "").ResumePending()
+
+	callsMut.Lock()
+	defer callsMut.Unlock()
+	assert.ElementsMatch(t, []string{"neverCalled#1", "failedOnce#2"}, calls)
+}
+
+func TestJobsHandler_GetWebhookDeliveries(t *testing.T) {
+	const jobKey = "myJob"
+	var router = NewRouter(NewJobHandler())
+
+	t.Run("Get deliveries of an existing job", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		createJob(t, jobKey, "myDeluge", "http://someurl.com")
+		deliveryTime := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+		require.NoError(t, repov2.Instance.SaveWebhookDelivery(&repov2.PersistedWebhookDelivery{
+			JobID: jobKey, Attempt: 1, Time: deliveryTime, Duration: time.Second, StatusCode: 502,
+		}))
+		require.NoError(t, repov2.Instance.SaveWebhookDelivery(&repov2.PersistedWebhookDelivery{
+			JobID: jobKey, Attempt: 2, Time: deliveryTime, Duration: time.Second, StatusCode: 200,
+		}))
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/"+jobKey+"/webhook/deliveries", nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		bbody, err := ioutil.ReadAll(w.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"elements":[
+			{"attempt":1,"time":"2019-01-02T03:04:05Z","duration":1000000000,"statusCode":502,"success":false},
+			{"attempt":2,"time":"2019-01-02T03:04:05Z","duration":1000000000,"statusCode":200,"success":true}
+		]}`, string(bbody))
+	})
+
+	t.Run("Get deliveries of a non-existing job", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/"+jobKey+"/webhook/deliveries", nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
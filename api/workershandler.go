@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/ofux/deluge/repov2"
+	"github.com/ofux/deluge/worker"
+	"net/http"
+	"net/url"
+)
+
+// WorkersHandler handles requests for 'workers' resource. It is only served by orchestrators.
+type WorkersHandler struct {
+	routes  []Route
+	manager *worker.RemoteManager
+}
+
+func (d *WorkersHandler) GetBasePath() string {
+	return "/v1/workers"
+}
+
+func (d *WorkersHandler) GetRoutes() []Route {
+	return d.routes
+}
+
+// NewWorkersHandler adds handlers for workers joining the given orchestrator's manager
+func NewWorkersHandler(manager *worker.RemoteManager) *WorkersHandler {
+	handler := &WorkersHandler{
+		manager: manager,
+	}
+
+	// build routes
+	var routes []Route
+	// Register a Worker
+	routes = append(routes, Route{
+		Name:        "Registers a worker",
+		Method:      http.MethodPost,
+		Pattern:     "",
+		HandlerFunc: handler.Register,
+	})
+	// Get all Workers
+	routes = append(routes, Route{
+		Name:        "Get all workers",
+		Method:      http.MethodGet,
+		Pattern:     "",
+		HandlerFunc: handler.GetAll,
+	})
+	// Receive a report from a Worker
+	routes = append(routes, Route{
+		Name:        "Receives a worker report",
+		Method:      http.MethodPost,
+		Pattern:     "/{id}/reports",
+		HandlerFunc: handler.SaveReport,
+	})
+	// Receive a heartbeat from a Worker
+	routes = append(routes, Route{
+		Name:        "Receives a worker heartbeat",
+		Method:      http.MethodPost,
+		Pattern:     "/{id}/heartbeats",
+		HandlerFunc: handler.Heartbeat,
+	})
+
+	handler.routes = routes
+
+	return handler
+}
+
+func (d *WorkersHandler) Register(w http.ResponseWriter, r *http.Request) {
+	var registration worker.Registration
+	if ok := GetJSONBody(w, r, &registration); !ok {
+		return
+	}
+
+	if registration.ID == "" {
+		SendJSONError(w, "Missing worker ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := url.ParseRequestURI(registration.URL); err != nil {
+		SendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	SendJSONWithHTTPCode(w, d.manager.Register(registration), http.StatusCreated)
+}
+
+func (d *WorkersHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	SendJSONWithHTTPCode(w, ListOf(d.manager.GetWorkers()), http.StatusOK)
+}
+
+func (d *WorkersHandler) SaveReport(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	body, ok := GetNonEmptyBody(w, r)
+	if !ok {
+		return
+	}
+	report := &repov2.PersistedWorkerReport{}
+	if err := json.Unmarshal(body, report); err != nil {
+		SendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sendWorkerResult(w, d.manager.SaveReport(id, report))
+}
+
+func (d *WorkersHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var heartbeat worker.Heartbeat
+	if ok := GetJSONBody(w, r, &heartbeat); !ok {
+		return
+	}
+
+	sendWorkerResult(w, d.manager.Heartbeat(id, heartbeat))
+}
+
+// sendWorkerResult maps the result of a call from a worker to the status code of the response.
+func sendWorkerResult(w http.ResponseWriter, err error) {
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusOK)
+	case worker.ErrUnknownAssignment, worker.ErrUnknownWorker:
+		SendJSONError(w, err.Error(), http.StatusNotFound)
+	case worker.ErrLostAssignment:
+		SendJSONError(w, err.Error(), http.StatusGone)
+	default:
+		SendJSONError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
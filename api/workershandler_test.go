@@ -0,0 +1,221 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/ofux/deluge/core/status"
+	"github.com/ofux/deluge/repov2"
+	"github.com/ofux/deluge/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkersHandler_Register(t *testing.T) {
+
+	t.Run("Register a worker", func(t *testing.T) {
+		manager := worker.NewRemoteManager(30*time.Second, false)
+		router := NewRouter(NewWorkersHandler(manager))
+		w := httptest.NewRecorder()
+
+		body := `{"id": "w1", "url": "http://localhost:33033"}`
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/workers", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		require.Len(t, manager.GetWorkers(), 1)
+		assert.Equal(t, "w1", manager.GetWorkers()[0].ID)
+		assert.Equal(t, "http://localhost:33033", manager.GetWorkers()[0].URL)
+	})
+
+	t.Run("Register a worker without ID", func(t *testing.T) {
+		manager := worker.NewRemoteManager(30*time.Second, false)
+		router := NewRouter(NewWorkersHandler(manager))
+		w := httptest.NewRecorder()
+
+		body := `{"url": "http://localhost:33033"}`
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/workers", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Len(t, manager.GetWorkers(), 0)
+	})
+
+	t.Run("Register a worker with bad URL", func(t *testing.T) {
+		manager := worker.NewRemoteManager(30*time.Second, false)
+		router := NewRouter(NewWorkersHandler(manager))
+		w := httptest.NewRecorder()
+
+		body := `{"id": "w1", "url": "badurl"}`
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/workers", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Len(t, manager.GetWorkers(), 0)
+	})
+}
+
+func TestWorkersHandler_GetAll(t *testing.T) {
+	manager := worker.NewRemoteManager(30*time.Second, false)
+	manager.Register(worker.Registration{ID: "w2", URL: "http://worker2"})
+	manager.Register(worker.Registration{ID: "w1", URL: "http://worker1"})
+	router := NewRouter(NewWorkersHandler(manager))
+	w := httptest.NewRecorder()
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/v1/workers", nil)
+	router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Elements []*worker.RemoteWorker `json:"elements"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.Len(t, response.Elements, 2)
+	assert.Equal(t, "w1", response.Elements[0].ID)
+	assert.Equal(t, "w2", response.Elements[1].ID)
+}
+
+func TestWorkersHandler_SaveReport(t *testing.T) {
+	const scenarioKey = "myScenario"
+	const delugeKey = "myDeluge"
+	const jobKey = "myJob"
+
+	repov2.Instance = repov2.NewInMemoryRepository()
+	createScenario(t, scenarioKey, "My scenario")
+	createDeluge(t, delugeKey, "My deluge", scenarioKey)
+
+	// Fake remote worker accepting everything
+	var remoteJobID string
+	remoteWorker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/jobs":
+			var job JobCreation
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&job))
+			remoteJobID = job.ID
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer remoteWorker.Close()
+
+	manager := worker.NewRemoteManager(30*time.Second, false)
+	manager.Register(worker.Registration{ID: "w1", URL: remoteWorker.URL})
+	jobShell := &worker.JobShell{ID: jobKey, DelugeID: delugeKey}
+	require.NoError(t, manager.CreateAll(jobShell))
+	require.NoError(t, manager.StartAll(jobShell))
+	require.NotEmpty(t, remoteJobID)
+
+	router := NewRouter(NewWorkersHandler(manager), NewJobHandler())
+
+	t.Run("Save report of an assigned job", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		body := `{"WorkerID": "local", "JobID": "` + remoteJobID + `", "Status": "doneSuccess"}`
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/workers/w1/reports", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		createJob(t, jobKey, delugeKey, "")
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, "http://example.com/v1/jobs/"+jobKey, nil)
+		router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		bbody, err := ioutil.ReadAll(w.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"id":"myJob","delugeId":"myDeluge","delugeName":"My deluge","status":"doneSuccess","globalDuration":200000000,"scenarios":{}}`, string(bbody))
+	})
+
+	t.Run("Save report of an unknown job", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		body := `{"WorkerID": "local", "JobID": "unknown", "Status": "doneSuccess"}`
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/workers/w1/reports", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Save report from another worker", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		body := `{"WorkerID": "local", "JobID": "` + remoteJobID + `", "Status": "doneSuccess"}`
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/workers/w2/reports", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Save malformed report", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		body := `{"WorkerID": "local", "JobID": "` + remoteJobID + `", "Status": "foo"}`
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/workers/w1/reports", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, status.DelugeDoneSuccess, repov2.Instance.GetJobWorkerReports(jobKey)[0].Status)
+	})
+}
+
+func TestWorkersHandler_Heartbeat(t *testing.T) {
+	const scenarioKey = "myScenario"
+	const delugeKey = "myDeluge"
+	const jobKey = "myJob"
+
+	repov2.Instance = repov2.NewInMemoryRepository()
+	createScenario(t, scenarioKey, "My scenario")
+	createDeluge(t, delugeKey, "My deluge", scenarioKey)
+
+	// Fake remote worker accepting everything
+	var remoteJobID string
+	remoteWorker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/jobs":
+			var job JobCreation
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&job))
+			remoteJobID = job.ID
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer remoteWorker.Close()
+
+	manager := worker.NewRemoteManager(100*time.Millisecond, false)
+	manager.Register(worker.Registration{ID: "w1", URL: remoteWorker.URL})
+	jobShell := &worker.JobShell{ID: jobKey, DelugeID: delugeKey}
+	require.NoError(t, manager.CreateAll(jobShell))
+	require.NoError(t, manager.StartAll(jobShell))
+	require.NotEmpty(t, remoteJobID)
+
+	router := NewRouter(NewWorkersHandler(manager))
+	sendHeartbeat := func(workerID, jobID string) int {
+		w := httptest.NewRecorder()
+		body := `{"jobId": "` + jobID + `", "status": "inProgress"}`
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/workers/"+workerID+"/heartbeats", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	t.Run("Heartbeat of an assigned job", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, sendHeartbeat("w1", remoteJobID))
+	})
+
+	t.Run("Heartbeat of an unknown job", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, sendHeartbeat("w1", "unknown"))
+	})
+
+	t.Run("Heartbeat from an unknown worker", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, sendHeartbeat("w2", remoteJobID))
+	})
+
+	t.Run("Heartbeat of a lost job", func(t *testing.T) {
+		time.Sleep(300 * time.Millisecond)
+		assert.Equal(t, http.StatusGone, sendHeartbeat("w1", remoteJobID))
+
+		w := httptest.NewRecorder()
+		body := `{"WorkerID": "local", "JobID": "` + remoteJobID + `", "Status": "doneSuccess"}`
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/v1/workers/w1/reports", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusGone, w.Code)
+		assert.Equal(t, status.DelugeWorkerLost, repov2.Instance.GetJobWorkerReports(jobKey)[0].Status)
+	})
+}
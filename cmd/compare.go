@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ofux/deluge/api"
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	compareFormat     string
+	compareTolerances = reporting.DefaultTolerances
+)
+
+// compareCmd represents the compare command
+var compareCmd = &cobra.Command{
+	Use:   "compare <baseline job file> <job file>",
+	Short: "Compares the result of a job with the result of a baseline job.",
+	Long: `Compares the result of a job with the result of a baseline job, such as the output files of 'deluge run'.
+
+Each scenario and each request is compared: percentiles and mean of response times, throughput and error rate.
+A change is flagged as a regression when it exceeds the tolerances. Changes of response times that are not
+statistically significant (two-sample Kolmogorov-Smirnov test, 95% confidence) are likely noise.
+
+The command exits with code 4 if a regression was found.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		if compareFormat != "text" && compareFormat != "json" {
+			die(fmt.Errorf("Unknown comparison format '%s'", compareFormat), 1)
+		}
+		baseline, err := readJobFile(args[0])
+		if err != nil {
+			die(err, 1)
+		}
+		current, err := readJobFile(args[1])
+		if err != nil {
+			die(err, 1)
+		}
+
+		comparison := api.CompareJobs(baseline, current, compareTolerances)
+		if compareFormat == "json" {
+			err = json.NewEncoder(os.Stdout).Encode(comparison)
+		} else {
+			err = writeComparison(os.Stdout, comparison)
+		}
+		if err != nil {
+			die(err, 1)
+		}
+		if comparison.Regression {
+			os.Exit(4)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(compareCmd)
+
+	compareCmd.Flags().StringVarP(&compareFormat, "format", "f", "text", "The format of the comparison (text or json)")
+	compareCmd.Flags().Float64Var(&compareTolerances.ResponseTime, "response-time-tolerance", reporting.DefaultTolerances.ResponseTime, "The tolerated relative increase of response times (0.1 for +10%)")
+	compareCmd.Flags().Float64Var(&compareTolerances.Throughput, "throughput-tolerance", reporting.DefaultTolerances.Throughput, "The tolerated relative decrease of throughput (0.1 for -10%)")
+	compareCmd.Flags().Float64Var(&compareTolerances.ErrorRate, "error-rate-tolerance", reporting.DefaultTolerances.ErrorRate, "The tolerated absolute increase of error rate (0.01 for +1 point)")
+}
+
+// writeComparison writes the comparison as a table per scenario.
+func writeComparison(w io.Writer, comparison *api.JobComparison) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Job %s compared with baseline %s\n", comparison.JobID, comparison.BaselineID)
+
+	scenarioIDs := make([]string, 0, len(comparison.Scenarios))
+	for scenarioID := range comparison.Scenarios {
+		scenarioIDs = append(scenarioIDs, scenarioID)
+	}
+	sort.Strings(scenarioIDs)
+
+	for _, scenarioID := range scenarioIDs {
+		scenario := comparison.Scenarios[scenarioID]
+		fmt.Fprintf(tw, "\nScenario %s\n", scenarioID)
+		fmt.Fprintln(tw, "Request\tMetric\tBaseline\tCurrent\tChange\t")
+		writeRequestComparison(tw, "All requests", &scenario.RequestComparison)
+
+		names := make([]string, 0, len(scenario.PerRequests))
+		for name := range scenario.PerRequests {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			writeRequestComparison(tw, name, scenario.PerRequests[name])
+		}
+		for _, name := range scenario.OnlyInBaseline {
+			fmt.Fprintf(tw, "Request %s was only called in the baseline\n", name)
+		}
+		for _, name := range scenario.OnlyInCurrent {
+			fmt.Fprintf(tw, "Request %s was only called in the job\n", name)
+		}
+	}
+	for _, scenarioID := range comparison.OnlyInBaseline {
+		fmt.Fprintf(tw, "\nScenario %s only ran in the baseline\n", scenarioID)
+	}
+	for _, scenarioID := range comparison.OnlyInCurrent {
+		fmt.Fprintf(tw, "\nScenario %s only ran in the job\n", scenarioID)
+	}
+
+	if comparison.Regression {
+		fmt.Fprintln(tw, "\nRegression found")
+	} else {
+		fmt.Fprintln(tw, "\nNo regression")
+	}
+	return tw.Flush()
+}
+
+func writeRequestComparison(w io.Writer, name string, comparison *reporting.RequestComparison) {
+	regressions := make(map[string]bool)
+	for _, metric := range comparison.Regressions {
+		regressions[metric] = true
+	}
+	significance := "not significant"
+	if comparison.Significant {
+		significance = "significant"
+	}
+
+	writeDelta := func(metric, unit string, delta *reporting.Delta) {
+		if delta == nil {
+			return
+		}
+		line := fmt.Sprintf("%s\t%s\t%s%s\t%s%s\t%s\t", name, metric, formatFloat(delta.Baseline), unit, formatFloat(delta.Current), unit, formatChange(delta))
+		if regressions[metric] {
+			line += "REGRESSION"
+		}
+		fmt.Fprintln(w, line)
+	}
+	for _, quantile := range []int{50, 90, 95, 99} {
+		writeDelta(fmt.Sprintf("p%d", quantile), " ms", comparison.Percentiles[quantile])
+	}
+	writeDelta(reporting.MetricMean, " ms", comparison.Mean)
+	writeDelta(reporting.MetricThroughput, "/s", comparison.Throughput)
+	writeDelta(reporting.MetricErrorRate, "", comparison.ErrorRate)
+	fmt.Fprintf(w, "%s\tresponse times\t\t\t%s\t\n", name, significance)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
+
+func formatChange(delta *reporting.Delta) string {
+	change := formatFloat(delta.Change)
+	if delta.Change >= 0 {
+		change = "+" + change
+	}
+	if delta.RelativeChange != nil {
+		change += fmt.Sprintf(" (%+.1f%%)", *delta.RelativeChange*100)
+	}
+	return strings.TrimSpace(change)
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/ofux/deluge/api"
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"testing"
+)
+
+func TestWriteComparison(t *testing.T) {
+	t.Run("Write a comparison with a regression", func(t *testing.T) {
+		relativeChange := 1.0
+		comparison := &api.JobComparison{
+			JobID:      "job2",
+			BaselineID: "job1",
+			Regression: true,
+			Scenarios: map[string]*reporting.HTTPComparison{
+				"sc1": {
+					RequestComparison: reporting.RequestComparison{
+						Percentiles: map[int]*reporting.Delta{
+							95: {Baseline: 10, Current: 20, Change: 10, RelativeChange: &relativeChange},
+						},
+						ErrorRate:   &reporting.Delta{Baseline: 0, Current: 0.1, Change: 0.1},
+						Significant: true,
+						Regressions: []string{"p95", reporting.MetricErrorRate},
+					},
+					OnlyInCurrent: []string{"new request"},
+				},
+			},
+			OnlyInBaseline: []string{"sc0"},
+		}
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, writeComparison(buf, comparison))
+		output := buf.String()
+		assert.Contains(t, output, "Job job2 compared with baseline job1")
+		assert.Regexp(t, regexp.MustCompile(`All requests +p95 +10.00 ms +20.00 ms +\+10.00 \(\+100.0%\) +REGRESSION`), output)
+		assert.Regexp(t, regexp.MustCompile(`All requests +errorRate +0.00 +0.10 +\+0.10 +REGRESSION`), output)
+		assert.Regexp(t, regexp.MustCompile(`All requests +response times +significant`), output)
+		assert.Contains(t, output, "Request new request was only called in the job")
+		assert.Contains(t, output, "Scenario sc0 only ran in the baseline")
+		assert.Contains(t, output, "Regression found")
+	})
+}
@@ -0,0 +1,419 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ofux/deluge/api"
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/ofux/deluge/core/status"
+	"github.com/ofux/deluge/dsl/object"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// dashboardRefreshFrequency is how often the dashboard is drawn again
+	dashboardRefreshFrequency = 500 * time.Millisecond
+	// dashboardRateWindow is the period over which request rates are computed
+	dashboardRateWindow = time.Second
+	// dashboardErrorCount is the number of recent errors shown by the dashboard
+	dashboardErrorCount = 5
+	// dashboardAllRequests is the name under which the statistics of all the requests of a scenario are kept
+	dashboardAllRequests = ""
+
+	clearScreen = "\033[H\033[2J"
+	hideCursor  = "\033[?25l"
+	showCursor  = "\033[?25h"
+)
+
+// dashboard shows the live statistics of a job in the terminal. It follows the events of the job stream.
+type dashboard struct {
+	jobID        string
+	out          io.Writer
+	start        time.Time
+	status       status.DelugeStatus
+	ended        bool
+	interrupting bool
+	message      string
+	scenarios    map[string]*dashboardScenario
+	errors       []*dashboardError
+	mut          *sync.Mutex
+}
+
+type dashboardScenario struct {
+	// workers are the latest statistics received from each worker
+	workers map[string]*dashboardWorkerStats
+	// rates are the request rates by request name
+	rates map[string]*rateMeter
+}
+
+type dashboardWorkerStats struct {
+	global        *reporting.HTTPStats
+	lastIteration int
+	users         int
+}
+
+type dashboardError struct {
+	scenarioID string
+	err        *object.Error
+}
+
+// rateMeter computes a rate from a count that is sampled regularly.
+type rateMeter struct {
+	count int64
+	time  time.Time
+	rate  float64
+}
+
+// dashboardRow holds the statistics of a request, merged across all the workers.
+type dashboardRow struct {
+	callCount int64
+	okCount   int64
+	koCount   int64
+	// quantiles are the highest values of each worker in milliseconds, as quantiles of several workers cannot be merged
+	quantiles map[int]float64
+}
+
+func newDashboard(jobID string, out io.Writer) *dashboard {
+	return &dashboard{
+		jobID:     jobID,
+		out:       out,
+		start:     time.Now(),
+		scenarios: make(map[string]*dashboardScenario),
+		mut:       &sync.Mutex{},
+	}
+}
+
+// run shows the dashboard until the job ends. Pressing 'q' or sending an interrupt signal interrupts the job.
+// Doing it a second time stops the dashboard without waiting for the end of the job.
+func (d *dashboard) run() {
+	if restore, err := enableCbreakMode(os.Stdin.Fd()); err == nil {
+		defer restore()
+	}
+	fmt.Fprint(d.out, hideCursor)
+	defer fmt.Fprint(d.out, showCursor)
+
+	ended := make(chan struct{})
+	go d.followJob(ended)
+
+	keys := make(chan byte)
+	go readKeys(os.Stdin, keys)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(dashboardRefreshFrequency)
+	defer ticker.Stop()
+
+	for {
+		d.draw(time.Now())
+		select {
+		case <-ended:
+			d.draw(time.Now())
+			return
+		case <-ticker.C:
+		case key := <-keys:
+			if key != 'q' && key != 'Q' {
+				continue
+			}
+			if !d.interrupt() {
+				return
+			}
+		case <-signals:
+			if !d.interrupt() {
+				return
+			}
+		}
+	}
+}
+
+// interrupt asks for the interruption of the job. It returns false if it was already asked.
+func (d *dashboard) interrupt() bool {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if d.interrupting {
+		return false
+	}
+	d.interrupting = true
+	d.message = "Interrupting the job..."
+	if err := interruptJob(d.jobID); err != nil {
+		d.message = "Failed to interrupt the job: " + err.Error()
+		d.interrupting = false
+	}
+	return true
+}
+
+// followJob reads the events of the job stream until the job ends. The stream is opened again if it is closed before.
+func (d *dashboard) followJob(ended chan<- struct{}) {
+	defer close(ended)
+	for {
+		resp, err := http.Get(runRemoteAddr + "/v1/jobs/" + d.jobID + "/stream")
+		if err != nil {
+			d.setMessage("Failed to follow the job: " + err.Error())
+		} else if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			d.setMessage(fmt.Sprintf("Failed to follow the job. Received code %d from worker.", resp.StatusCode))
+		} else {
+			err = readServerSentEvents(resp.Body, d.handleEvent)
+			resp.Body.Close()
+			if d.hasEnded() {
+				return
+			}
+			if err != nil {
+				d.setMessage("Lost the stream of the job: " + err.Error())
+			}
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (d *dashboard) handleEvent(eventType string, data []byte) error {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	switch eventType {
+	case "status":
+		event := &api.JobStatusEvent{}
+		if err := json.Unmarshal(data, event); err != nil {
+			return err
+		}
+		d.status = event.Status
+		d.ended = event.Status.IsEnd()
+
+	case "records":
+		event := &api.JobRecordsEvent{}
+		if err := json.Unmarshal(data, event); err != nil {
+			return err
+		}
+		scenario := d.getScenario(event.ScenarioID)
+		stats, ok := scenario.workers[event.WorkerID]
+		if !ok {
+			stats = &dashboardWorkerStats{lastIteration: -1}
+			scenario.workers[event.WorkerID] = stats
+		}
+		if event.Global != nil {
+			stats.global = event.Global
+		}
+		for iteration, iterationStats := range event.PerIteration {
+			if iteration >= stats.lastIteration {
+				stats.lastIteration = iteration
+				stats.users = iterationStats.Users
+			}
+		}
+
+	case "error":
+		event := &api.JobErrorEvent{}
+		if err := json.Unmarshal(data, event); err != nil {
+			return err
+		}
+		d.getScenario(event.ScenarioID)
+		for _, err := range event.Errors {
+			d.errors = append(d.errors, &dashboardError{scenarioID: event.ScenarioID, err: err})
+		}
+		if len(d.errors) > dashboardErrorCount {
+			d.errors = d.errors[len(d.errors)-dashboardErrorCount:]
+		}
+	}
+	return nil
+}
+
+func (d *dashboard) getScenario(scenarioID string) *dashboardScenario {
+	scenario, ok := d.scenarios[scenarioID]
+	if !ok {
+		scenario = &dashboardScenario{
+			workers: make(map[string]*dashboardWorkerStats),
+			rates:   make(map[string]*rateMeter),
+		}
+		d.scenarios[scenarioID] = scenario
+	}
+	return scenario
+}
+
+func (d *dashboard) hasEnded() bool {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	return d.ended
+}
+
+func (d *dashboard) setMessage(message string) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	d.message = message
+}
+
+func (d *dashboard) draw(now time.Time) {
+	buf := &bytes.Buffer{}
+	buf.WriteString(clearScreen)
+	d.render(buf, now)
+	d.out.Write(buf.Bytes())
+}
+
+// render writes the dashboard as it is at the given time.
+func (d *dashboard) render(w io.Writer, now time.Time) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	fmt.Fprintf(w, "Job %s - %s - %s\n", d.jobID, d.status, now.Sub(d.start).Truncate(time.Second))
+	switch {
+	case d.message != "":
+		fmt.Fprintln(w, d.message)
+	case !d.ended:
+		fmt.Fprintln(w, "Press q to interrupt the job")
+	}
+
+	scenarioIDs := make([]string, 0, len(d.scenarios))
+	for scenarioID := range d.scenarios {
+		scenarioIDs = append(scenarioIDs, scenarioID)
+	}
+	sort.Strings(scenarioIDs)
+
+	for _, scenarioID := range scenarioIDs {
+		scenario := d.scenarios[scenarioID]
+		rows := scenario.rows()
+		users := 0
+		for _, stats := range scenario.workers {
+			users += stats.users
+		}
+
+		fmt.Fprintf(w, "\nScenario %s - %d users\n", scenarioID, users)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "REQUEST\tRPS\tOK\tKO\tP50\tP95\tP99\t")
+		names := make([]string, 0, len(rows))
+		for name := range rows {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			row := rows[name]
+			rate := scenario.getRateMeter(name)
+			rate.sample(row.callCount, now)
+			label := name
+			if name == dashboardAllRequests {
+				label = "(all)"
+			}
+			fmt.Fprintf(tw, "%s\t%.1f\t%d\t%d\t%sms\t%sms\t%sms\t\n", label, rate.rate, row.okCount, row.koCount,
+				formatMilliseconds(row.quantiles[50]), formatMilliseconds(row.quantiles[95]), formatMilliseconds(row.quantiles[99]))
+		}
+		tw.Flush()
+	}
+
+	if len(d.errors) > 0 {
+		fmt.Fprintln(w, "\nRecent errors")
+		for _, e := range d.errors {
+			fmt.Fprintf(w, "[%s] %s\n", e.scenarioID, e.err.Inspect())
+		}
+	}
+}
+
+// formatMilliseconds formats a response time with up to 3 decimals, which is the resolution of reports in microseconds.
+func formatMilliseconds(value float64) string {
+	return strconv.FormatFloat(math.Round(value*1000)/1000, 'f', -1, 64)
+}
+
+// rows merges the statistics of all the workers, by request name.
+func (s *dashboardScenario) rows() map[string]*dashboardRow {
+	rows := make(map[string]*dashboardRow)
+	addStats := func(name string, stats *reporting.HTTPRequestStats) {
+		row, ok := rows[name]
+		if !ok {
+			row = &dashboardRow{quantiles: make(map[int]float64)}
+			rows[name] = row
+		}
+		if stats.Global != nil {
+			row.callCount += stats.Global.CallCount
+			for quantile, value := range stats.Global.ValueAtQuantiles {
+				if value := stats.Global.Milliseconds(float64(value)); value > row.quantiles[quantile] {
+					row.quantiles[quantile] = value
+				}
+			}
+		}
+		if okStats := stats.PerOkKo[recording.Ok]; okStats != nil {
+			row.okCount += okStats.CallCount
+		}
+		if koStats := stats.PerOkKo[recording.Ko]; koStats != nil {
+			row.koCount += koStats.CallCount
+		}
+	}
+	for _, worker := range s.workers {
+		if worker.global == nil {
+			continue
+		}
+		addStats(dashboardAllRequests, &worker.global.HTTPRequestStats)
+		for name, requestStats := range worker.global.PerRequests {
+			addStats(name, requestStats)
+		}
+	}
+	return rows
+}
+
+func (s *dashboardScenario) getRateMeter(name string) *rateMeter {
+	rate, ok := s.rates[name]
+	if !ok {
+		rate = &rateMeter{}
+		s.rates[name] = rate
+	}
+	return rate
+}
+
+// sample takes the count into account if the previous sample is older than dashboardRateWindow.
+func (m *rateMeter) sample(count int64, now time.Time) {
+	if m.time.IsZero() {
+		m.count, m.time = count, now
+		return
+	}
+	elapsed := now.Sub(m.time)
+	if elapsed < dashboardRateWindow {
+		return
+	}
+	m.rate = float64(count-m.count) / elapsed.Seconds()
+	m.count, m.time = count, now
+}
+
+// readServerSentEvents calls handle for each event read from r, until r ends or handle fails.
+func readServerSentEvents(r io.Reader, handle func(eventType string, data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	// Records events can be larger than the default buffer
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	eventType := ""
+	var data []byte
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimSpace(strings.TrimPrefix(line, "data:"))...)
+		case line == "":
+			if eventType != "" {
+				if err := handle(eventType, data); err != nil {
+					return err
+				}
+			}
+			eventType, data = "", nil
+		}
+	}
+	return scanner.Err()
+}
+
+func readKeys(r io.Reader, keys chan<- byte) {
+	buf := make([]byte, 1)
+	for {
+		if _, err := r.Read(buf); err != nil {
+			return
+		}
+		keys <- buf[0]
+	}
+}
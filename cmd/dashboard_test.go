@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboard(t *testing.T) {
+	t.Run("Render the events of a job", func(t *testing.T) {
+		d := newDashboard("job1", &bytes.Buffer{})
+		d.start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		stream := `event: status
+data: {"status":"inProgress"}
+
+event: records
+data: {"workerId":"w1","scenarioId":"sc1","global":{"Global":{"CallCount":10,"ValueAtQuantiles":{"50":10,"95":20,"99":30}},"PerOkKo":{"Ok":{"CallCount":8},"Ko":{"CallCount":2}},"PerRequests":{"req1":{"Global":{"CallCount":10,"ValueAtQuantiles":{"50":10,"95":20,"99":30}},"PerOkKo":{"Ok":{"CallCount":8},"Ko":{"CallCount":2}}}}},"perIteration":{"0":{"Users":3},"1":{"Users":4}}}
+
+event: records
+data: {"workerId":"w2","scenarioId":"sc1","global":{"Global":{"Unit":"us","CallCount":5,"ValueAtQuantiles":{"50":15000,"95":25000,"99":28000}},"PerOkKo":{"Ok":{"CallCount":5}}},"perIteration":{"0":{"Users":2}}}
+
+event: error
+data: {"workerId":"w1","scenarioId":"sc1","errors":[{"message":"something went wrong"}]}
+
+`
+		require.NoError(t, readServerSentEvents(strings.NewReader(stream), d.handleEvent))
+
+		out := &bytes.Buffer{}
+		d.render(out, d.start.Add(2*time.Second))
+		rendered := out.String()
+		assert.Contains(t, rendered, "Job job1 - inProgress - 2s\nPress q to interrupt the job\n")
+		assert.Contains(t, rendered, "Scenario sc1 - 6 users\n")
+		assert.Regexp(t, `\(all\) +0\.0 +13 +2 +15ms +25ms +30ms`, rendered)
+		assert.Regexp(t, `req1 +0\.0 +8 +2 +10ms +20ms +30ms`, rendered)
+		assert.Contains(t, rendered, "Recent errors\n[sc1] RUNTIME ERROR: something went wrong")
+
+		require.NoError(t, d.handleEvent("status", []byte(`{"status":"doneError"}`)))
+		assert.True(t, d.hasEnded())
+		out.Reset()
+		d.render(out, d.start.Add(3*time.Second))
+		assert.NotContains(t, out.String(), "Press q")
+	})
+
+	t.Run("Keep the most recent errors", func(t *testing.T) {
+		d := newDashboard("job1", &bytes.Buffer{})
+		for i := 0; i <= dashboardErrorCount; i++ {
+			require.NoError(t, d.handleEvent("error", []byte(fmt.Sprintf(`{"scenarioId":"sc1","errors":[{"message":"error %d"}]}`, i))))
+		}
+		require.Len(t, d.errors, dashboardErrorCount)
+		assert.Equal(t, "error 1", d.errors[0].err.Message)
+	})
+
+	t.Run("Fail on a bad event", func(t *testing.T) {
+		d := newDashboard("job1", &bytes.Buffer{})
+		err := readServerSentEvents(strings.NewReader("event: status\ndata: {\n\n"), d.handleEvent)
+		assert.Error(t, err)
+	})
+}
+
+func TestRateMeter(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := &rateMeter{}
+	m.sample(10, start)
+	assert.Equal(t, 0.0, m.rate)
+	m.sample(15, start.Add(500*time.Millisecond))
+	assert.Equal(t, 0.0, m.rate, "the window is not elapsed yet")
+	m.sample(30, start.Add(2*time.Second))
+	assert.Equal(t, 10.0, m.rate)
+}
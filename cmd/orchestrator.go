@@ -1,24 +1,43 @@
 package cmd
 
 import (
-	"fmt"
+	"context"
+	"github.com/ofux/deluge/api"
+	"github.com/ofux/deluge/repov2"
+	"github.com/ofux/deluge/worker"
 	"github.com/spf13/cobra"
+	"time"
 )
 
 var (
-	orchestratorPort int32
+	orchestratorPort             int32
+	orchestratorHeartbeatTimeout time.Duration
+	orchestratorRebalance        bool
+	orchestratorWebhookSecret    string
+	orchestratorDataDir          string
 )
 
 // serveCmd represents the serve command
 var orchestratorCmd = &cobra.Command{
 	Use:   "orchestrator",
-	Short: "(NOT YET IMPLEMENTED) Starts a server that will spread jobs across multiple workers.",
-	Long: `(NOT YET IMPLEMENTED) An orchestrator is a Deluge server instance that is meant to spread jobs across multiple workers.
+	Short: "Starts a server that will spread jobs across multiple workers.",
+	Long: `An orchestrator is a Deluge server instance that is meant to spread jobs across multiple workers.
 
 First, start the orchestrator.
-Then, start each worker with the --orchestrator flag so that they will join the orchestrator and will act as slaves for it.`,
+Then, start each worker with the --orchestrator flag so that they will join the orchestrator and will act as slaves for it.
+
+The concurrent users of each scenario are split across all the workers that joined the orchestrator when the job is started.
+
+While running a job, workers send heartbeats to the orchestrator. A worker that does not send any heartbeat for longer than
+the heartbeat timeout is considered lost. With --rebalance, its share of the job is restarted on another worker.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("This feature is not yet implemented.")
+		useDataDir(orchestratorDataDir)
+		manager := worker.NewRemoteManager(orchestratorHeartbeatTimeout, orchestratorRebalance)
+		worker.ManagerInstance = manager
+		webhookSender := api.NewWebhookSender(context.Background(), repov2.Instance, orchestratorWebhookSecret)
+		manager.OnJobEnd(webhookSender.NotifyJobEnd)
+		go webhookSender.ResumePending()
+		api.ServeOrchestrator(int(orchestratorPort), manager)
 	},
 }
 
@@ -26,5 +45,9 @@ func init() {
 	startCmd.AddCommand(orchestratorCmd)
 
 	orchestratorCmd.Flags().Int32VarP(&orchestratorPort, "port", "p", 33044, "The port on which deluge orchestrator will be listening")
+	orchestratorCmd.Flags().DurationVar(&orchestratorHeartbeatTimeout, "heartbeat-timeout", 30*time.Second, "The time after which a silent worker is considered lost")
+	orchestratorCmd.Flags().BoolVar(&orchestratorRebalance, "rebalance", false, "Restart the share of a lost worker on another worker")
+	orchestratorCmd.Flags().StringVar(&orchestratorWebhookSecret, "webhook-secret", "", "The secret used to sign the calls to job webhooks (header "+api.WebhookSignatureHeader+")")
+	orchestratorCmd.Flags().StringVar(&orchestratorDataDir, "data-dir", "", "The directory in which deluges, scenarios, jobs and reports are kept across restarts (in memory only if not set)")
 
 }
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ofux/deluge/api"
+	"github.com/ofux/deluge/report"
+	"github.com/spf13/cobra"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var (
+	reportFormat string
+	reportOutput string
+)
+
+// reportCmd represents the report command
+var reportCmd = &cobra.Command{
+	Use:   "report <file containing the result of a job>",
+	Short: "Generates a readable report from the result of a job.",
+	Long: `Generates a readable report from the result of a job, such as the output file of 'deluge run'.
+
+The html format produces a single static file that can be read offline: response times over time, response time
+distributions, requests and status codes tables and a summary of the errors of each scenario.
+
+Without --output, the report is written to <file without its extension>.<format>.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		if reportFormat != "html" {
+			die(fmt.Errorf("Unknown report format '%s'", reportFormat), 1)
+		}
+		job, err := readJobFile(args[0])
+		if err != nil {
+			die(err, 1)
+		}
+
+		output := reportOutput
+		if output == "" {
+			output = strings.TrimSuffix(args[0], ".json") + "." + reportFormat
+		}
+		fo, err := os.Create(output)
+		if err != nil {
+			die(err, 1)
+		}
+		if err := writeReport(fo, job); err != nil {
+			fo.Close()
+			die(err, 1)
+		}
+		if err := fo.Close(); err != nil {
+			die(err, 1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(reportCmd)
+
+	reportCmd.Flags().StringVarP(&reportFormat, "format", "f", "html", "The format of the report (html)")
+	reportCmd.Flags().StringVarP(&reportOutput, "output", "o", "", "The file the report is written to")
+}
+
+// readJobFile reads the result of a job written as JSON.
+func readJobFile(filename string) (*api.Job, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	job := &api.Job{}
+	if err := json.Unmarshal(content, job); err != nil {
+		return nil, fmt.Errorf("%s is not the result of a job: %s", filename, err.Error())
+	}
+	return job, nil
+}
+
+func writeReport(w io.Writer, job *api.Job) error {
+	return report.WriteHTML(w, job)
+}
@@ -5,18 +5,31 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/ofux/deluge/api"
+	"github.com/ofux/deluge/core"
+	"github.com/ofux/deluge/core/eventlog"
+	"github.com/ofux/deluge/core/sinks"
 	"github.com/ofux/deluge/core/status"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 )
 
 var (
-	runRemoteAddr string
+	runRemoteAddr    string
+	runScenarioFiles []string
+	runTUI           bool
+	runJUnitFile     string
+	runSinks         []string
+	runEventsFile    string
 )
 
 // serveCmd represents the serve command
@@ -25,18 +38,49 @@ var runCmd = &cobra.Command{
 	Short: "Runs deluge script from the given file.",
 	Long: `Runs deluge script from the given file.
 
+The scenarios of the deluge are read from the files given with --scenario. They are created, or updated if they
+already exist, before the deluge is run.
+
 If a worker/orchestrator address is given (see --remote flag) the script will be executed by this worker/orchestrator.
-Otherwise, a local worker will be silently started on a random port to run the script and will be shutdown right after.`,
+Otherwise, a local worker will be silently started on a random port to run the script and will be shutdown right after.
+
+With --tui, a live dashboard of the job is shown while it runs. Press 'q' to interrupt the job.
+
+With --junit, the result of the job is also written as JUnit XML for CI systems. Each scenario is a test suite whose
+failing test cases are its errors and its failed thresholds.
+
+With --sink, every request of the job is also sent to an external time-series system while the job runs. The flag
+takes the type of the sink and its address, separated by '=', and can be repeated:
+  --sink statsd=localhost:8125
+  --sink influxdb=http://localhost:8086/write?db=deluge
+  --sink otlp=http://localhost:4318/v1/metrics
+
+With --events, every HTTP request of the job is written to an event log, downloaded to the given file once the job
+is over. The event log is written as CSV if the file name ends with .csv, and as NDJSON otherwise.
+
+The command exits with code 4 if some thresholds of the deluge failed, or if the job was aborted by a threshold.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) != 2 {
 			cmd.Usage()
 			os.Exit(1)
 		}
-		// read input file
+		jobSinks, err := parseSinks(runSinks)
+		if err != nil {
+			die(err, 1)
+		}
+		// read input files
 		fileContent, err := ioutil.ReadFile(args[0])
 		if err != nil {
 			die(err, 1)
 		}
+		scenarioContents := make([][]byte, 0, len(runScenarioFiles))
+		for _, scenarioFile := range runScenarioFiles {
+			scenarioContent, err := ioutil.ReadFile(scenarioFile)
+			if err != nil {
+				die(err, 1)
+			}
+			scenarioContents = append(scenarioContents, scenarioContent)
+		}
 		// prepare output file
 		fo, err := os.Create(args[1])
 		if err != nil {
@@ -57,15 +101,29 @@ Otherwise, a local worker will be silently started on a random port to run the s
 			l.Close()
 			randomPort := l.Addr().(*net.TCPAddr).Port
 			runRemoteAddr = "http://localhost:" + strconv.Itoa(randomPort)
+			if runTUI {
+				// Logs of the local worker would mess the dashboard up
+				log.SetOutput(ioutil.Discard)
+			}
 			go api.Serve(randomPort)
+			waitForServer(5 * time.Second)
+		}
+
+		for _, scenarioContent := range scenarioContents {
+			postScenario(scenarioContent)
 		}
+		delugeID := postDeluge(fileContent)
+		jobMetadata := postJob(&api.JobCreation{DelugeID: delugeID, Sinks: jobSinks, EventLog: eventLogFormat(runEventsFile)})
 
-		dlg := postDeluge(fileContent)
+		if runTUI {
+			newDashboard(jobMetadata.ID, os.Stdout).run()
+		}
 
 		// polling
-		for dlg.Status == status.DelugeVirgin || dlg.Status == status.DelugeInProgress {
-			dlg = getDeluge(dlg.DelugeID)
+		dlg := getJob(jobMetadata.ID)
+		for dlg == nil || !dlg.Status.IsEnd() {
 			time.Sleep(500 * time.Millisecond)
+			dlg = getJob(jobMetadata.ID)
 		}
 
 		result, err := json.Marshal(dlg)
@@ -75,6 +133,31 @@ Otherwise, a local worker will be silently started on a random port to run the s
 		if _, err := fo.Write(result); err != nil {
 			die(err, 1)
 		}
+		if runJUnitFile != "" {
+			writeJUnitFile(runJUnitFile, dlg)
+		}
+		if runEventsFile != "" {
+			downloadEvents(jobMetadata.ID, runEventsFile)
+		}
+
+		if err := writeErrors(os.Stdout, dlg); err != nil {
+			die(err, 1)
+		}
+
+		aborted := dlg.Status == status.DelugeAborted
+		if aborted && dlg.AbortedBy != nil && dlg.AbortedBy.Error != nil {
+			fmt.Printf("Job aborted: error in scenario %s: %s\n", dlg.AbortedBy.ScenarioID, dlg.AbortedBy.Error.Inspect())
+		} else if aborted && dlg.AbortedBy != nil {
+			fmt.Printf("Job aborted: scenario %s, request %s, %s: %s (limit: %s)\n", dlg.AbortedBy.ScenarioID, dlg.AbortedBy.Request,
+				dlg.AbortedBy.Metric, strconv.FormatFloat(dlg.AbortedBy.Value, 'f', -1, 64), strconv.FormatFloat(dlg.AbortedBy.Limit, 'f', -1, 64))
+		}
+		if dlg.ThresholdsPassed != nil && !*dlg.ThresholdsPassed {
+			printFailedThresholds(dlg)
+		}
+		if aborted || (dlg.ThresholdsPassed != nil && !*dlg.ThresholdsPassed) {
+			fo.Close()
+			os.Exit(4)
+		}
 	},
 }
 
@@ -82,7 +165,78 @@ func init() {
 	RootCmd.AddCommand(runCmd)
 
 	runCmd.Flags().StringVarP(&runRemoteAddr, "remote", "r", "", "The worker/orchestrator address on which the deluge script will be executed")
+	runCmd.Flags().StringSliceVarP(&runScenarioFiles, "scenario", "s", nil, "A file containing a scenario of the deluge (can be repeated)")
+	runCmd.Flags().BoolVar(&runTUI, "tui", false, "Shows a live dashboard of the job while it runs")
+	runCmd.Flags().StringVar(&runJUnitFile, "junit", "", "A file the result of the job is written to as JUnit XML")
+	runCmd.Flags().StringVar(&runEventsFile, "events", "", "A file the event log of the job is written to, as CSV if it ends with .csv or as NDJSON otherwise")
+	runCmd.Flags().StringArrayVar(&runSinks, "sink", nil, "A sink requests are sent to, as <statsd|influxdb|otlp>=<address> (can be repeated)")
+
+}
+
+// parseSinks parses the values of the --sink flag.
+func parseSinks(values []string) ([]sinks.Config, error) {
+	configs := make([]sinks.Config, 0, len(values))
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid sink '%s', expected <type>=<address>", value)
+		}
+		config := sinks.Config{Type: parts[0], Address: parts[1]}
+		if err := config.Validate(); err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
 
+// eventLogFormat returns the format of the event log written to the given file, or an empty format if there is no file.
+func eventLogFormat(filename string) string {
+	switch {
+	case filename == "":
+		return ""
+	case strings.HasSuffix(strings.ToLower(filename), ".csv"):
+		return eventlog.FormatCSV
+	default:
+		return eventlog.FormatNDJSON
+	}
+}
+
+func downloadEvents(jobID, filename string) {
+	resp, err := http.Get(runRemoteAddr + "/v1/jobs/" + jobID + "/events")
+	if err != nil {
+		die(err, 2)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		readResponse(resp, nil)
+		return
+	}
+	fo, err := os.Create(filename)
+	if err != nil {
+		die(err, 1)
+	}
+	if _, err := io.Copy(fo, resp.Body); err != nil {
+		fo.Close()
+		die(err, 2)
+	}
+	if err := fo.Close(); err != nil {
+		die(err, 1)
+	}
+}
+
+func writeJUnitFile(filename string, dlg *api.Job) {
+	fo, err := os.Create(filename)
+	if err != nil {
+		die(err, 1)
+	}
+	if err := api.WriteJUnit(fo, dlg); err != nil {
+		fo.Close()
+		die(err, 1)
+	}
+	if err := fo.Close(); err != nil {
+		die(err, 1)
+	}
 }
 
 func die(err error, code int) {
@@ -90,52 +244,190 @@ func die(err error, code int) {
 	os.Exit(code)
 }
 
-func postDeluge(fileContent []byte) *api.Job {
-	resp, err := http.Post(runRemoteAddr+"/v1/jobs", "text/plain", bytes.NewReader(fileContent))
+// waitForServer waits for the local worker to accept requests.
+func waitForServer(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := http.Get(runRemoteAddr + "/v1/jobs")
+		if err == nil {
+			resp.Body.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			die(err, 2)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func postScenario(fileContent []byte) {
+	compiled, err := core.CompileScenario(string(fileContent))
 	if err != nil {
-		die(err, 2)
+		die(err, 1)
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	sendScript("/v1/scenarios", compiled.GetScenarioDefinition().ID, fileContent)
+}
+
+func postDeluge(fileContent []byte) string {
+	compiled, err := core.CompileDeluge(string(fileContent))
 	if err != nil {
 		die(err, 1)
 	}
-	if resp.StatusCode >= 300 {
-		dtoErr := &api.Error{}
-		if err = json.Unmarshal(body, dtoErr); err != nil {
-			die(fmt.Errorf("Something went wrong. Received code %d from worker.", resp.StatusCode), 3)
+	delugeID := compiled.GetDelugeDefinition().ID
+	sendScript("/v1/deluges", delugeID, fileContent)
+	return delugeID
+}
+
+// sendScript creates the resource with the given script, or updates it if it already exists.
+func sendScript(resourcePath, id string, script []byte) {
+	resp, err := http.Post(runRemoteAddr+resourcePath, "text/plain", bytes.NewReader(script))
+	if err != nil {
+		die(err, 2)
+	}
+	if resp.StatusCode == http.StatusConflict {
+		resp.Body.Close()
+		req, err := http.NewRequest(http.MethodPut, runRemoteAddr+resourcePath+"/"+id, bytes.NewReader(script))
+		if err != nil {
+			die(err, 1)
+		}
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			die(err, 2)
 		}
-		die(fmt.Errorf("Something went wrong. Received code %d from worker with error: %s", resp.StatusCode, dtoErr.Error), 3)
 	}
+	readResponse(resp, nil)
+}
 
-	dlg := &api.Job{}
-	if err = json.Unmarshal(body, dlg); err != nil {
+func postJob(job *api.JobCreation) *api.JobMetadata {
+	body, err := json.Marshal(job)
+	if err != nil {
 		die(err, 1)
 	}
-	return dlg
+	resp, err := http.Post(runRemoteAddr+"/v1/jobs", api.HeaderContentTypeJsonUTF8, bytes.NewReader(body))
+	if err != nil {
+		die(err, 2)
+	}
+	jobMetadata := &api.JobMetadata{}
+	readResponse(resp, jobMetadata)
+	return jobMetadata
 }
 
-func getDeluge(id string) *api.Job {
+// getJob returns the report of the job, or nil if no worker has reported yet.
+func getJob(id string) *api.Job {
 	resp, err := http.Get(runRemoteAddr + "/v1/jobs/" + id)
 	if err != nil {
 		die(err, 2)
 	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil
+	}
+	dlg := &api.Job{}
+	readResponse(resp, dlg)
+	return dlg
+}
+
+func interruptJob(id string) error {
+	req, err := http.NewRequest(http.MethodPut, runRemoteAddr+"/v1/jobs/interrupt/"+id, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Something went wrong. Received code %d from worker.", resp.StatusCode)
+	}
+	return nil
+}
+
+// readResponse dies if the response is an error. Otherwise, it reads the JSON body of the response into out, if not nil.
+func readResponse(resp *http.Response, out interface{}) {
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		die(err, 1)
 	}
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode >= 300 {
 		dtoErr := &api.Error{}
 		if err = json.Unmarshal(body, dtoErr); err != nil {
 			die(fmt.Errorf("Something went wrong. Received code %d from worker.", resp.StatusCode), 3)
 		}
 		die(fmt.Errorf("Something went wrong. Received code %d from worker with error: %s", resp.StatusCode, dtoErr.Error), 3)
 	}
+	if out != nil {
+		if err = json.Unmarshal(body, out); err != nil {
+			die(err, 1)
+		}
+	}
+}
 
-	dlg := &api.Job{}
-	if err = json.Unmarshal(body, dlg); err != nil {
-		die(err, 1)
+func printFailedThresholds(dlg *api.Job) {
+	scenarioIDs := make([]string, 0, len(dlg.Scenarios))
+	for scenarioID := range dlg.Scenarios {
+		scenarioIDs = append(scenarioIDs, scenarioID)
 	}
-	return dlg
+	sort.Strings(scenarioIDs)
+
+	for _, scenarioID := range scenarioIDs {
+		for _, threshold := range dlg.Scenarios[scenarioID].Thresholds {
+			if threshold.Passed {
+				continue
+			}
+			value := "no call recorded"
+			if threshold.Value != nil {
+				value = strconv.FormatFloat(*threshold.Value, 'f', -1, 64)
+			}
+			fmt.Printf("Threshold failed: scenario %s, request %s, %s: %s (limit: %s)\n", scenarioID, threshold.Request,
+				threshold.Metric, value, strconv.FormatFloat(threshold.Limit, 'f', -1, 64))
+		}
+	}
+}
+
+// writeErrors writes a summary of the groups of errors of each scenario of the job, the most frequent first.
+func writeErrors(w io.Writer, dlg *api.Job) error {
+	scenarioIDs := make([]string, 0, len(dlg.Scenarios))
+	for scenarioID, scenario := range dlg.Scenarios {
+		if len(scenario.Errors) > 0 {
+			scenarioIDs = append(scenarioIDs, scenarioID)
+		}
+	}
+	sort.Strings(scenarioIDs)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, scenarioID := range scenarioIDs {
+		scenario := dlg.Scenarios[scenarioID]
+		errs := make([]*api.JobError, len(scenario.Errors))
+		copy(errs, scenario.Errors)
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].Count > errs[j].Count
+		})
+
+		fmt.Fprintf(tw, "Errors of scenario %s: %d\n", scenarioID, scenario.ErrorCount)
+		fmt.Fprintln(tw, "  Count\tRequests\tFirst\tLast\tError")
+		for _, err := range errs {
+			fmt.Fprintf(tw, "  %d\t%s\t%s\t%s\t%s\n", err.Count, strings.Join(err.Requests, ", "),
+				formatErrorTime(err.FirstAt), formatErrorTime(err.LastAt), errorSummary(err))
+		}
+	}
+	return tw.Flush()
+}
+
+func formatErrorTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("15:04:05")
+}
+
+// errorSummary returns the first line of the message of an error, and where it occurred.
+func errorSummary(err *api.JobError) string {
+	summary := strings.TrimSpace(strings.SplitN(err.Message, "\n", 2)[0])
+	if len(err.StackToken) > 0 {
+		tok := err.StackToken[0]
+		summary += fmt.Sprintf(" (line %d, col %d)", tok.Line, tok.Column)
+	}
+	return summary
 }
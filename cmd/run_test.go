@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/ofux/deluge/api"
+	"github.com/ofux/deluge/dsl/object"
+	"github.com/ofux/deluge/dsl/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestWriteErrors(t *testing.T) {
+	t.Run("Write the errors of a job", func(t *testing.T) {
+		first := time.Date(2020, 1, 1, 10, 0, 0, 0, time.Local)
+		job := &api.Job{
+			Scenarios: map[string]*api.JobScenario{
+				"sc1": {
+					ErrorCount: 12,
+					Errors: []*api.JobError{
+						{
+							Error:   &object.Error{Message: "Other error"},
+							Count:   2,
+							FirstAt: first.Add(time.Minute),
+							LastAt:  first.Add(time.Minute),
+						},
+						{
+							Error: &object.Error{
+								Message:    "Assertion failed",
+								StackToken: []token.Token{{Type: token.IDENT, Literal: "assert", Line: 3, Column: 10}},
+							},
+							Count:    10,
+							FirstAt:  first,
+							LastAt:   first.Add(2 * time.Minute),
+							Requests: []string{"bar", "foo"},
+						},
+					},
+				},
+				"sc2": {},
+			},
+		}
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, writeErrors(buf, job))
+		output := buf.String()
+		assert.Contains(t, output, "Errors of scenario sc1: 12")
+		assert.NotContains(t, output, "sc2")
+		assert.Regexp(t, regexp.MustCompile(`(?s)10 +bar, foo +10:00:00 +10:02:00 +Assertion failed \(line 3, col 10\).*2 +10:01:00 +10:01:00 +Other error`), output)
+	})
+
+	t.Run("Write nothing for a job without error", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, writeErrors(buf, &api.Job{Scenarios: map[string]*api.JobScenario{"sc1": {}}}))
+		assert.Empty(t, buf.String())
+	})
+}
@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"github.com/ofux/deluge/core/eventlog"
+	"github.com/ofux/deluge/repov2"
 	"github.com/spf13/cobra"
+	"path/filepath"
 )
 
 // startCmd represents the serve command
@@ -14,3 +17,17 @@ var startCmd = &cobra.Command{
 func init() {
 	RootCmd.AddCommand(startCmd)
 }
+
+// useDataDir makes the server keep its deluges, scenarios, jobs, reports and event logs in the given directory, so that
+// they survive restarts. Nothing but event logs is kept on disk if dataDir is empty.
+func useDataDir(dataDir string) {
+	if dataDir == "" {
+		return
+	}
+	repo, err := repov2.NewFileRepository(dataDir)
+	if err != nil {
+		die(err, 1)
+	}
+	repov2.Instance = repo
+	eventlog.Dir = filepath.Join(dataDir, "events")
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package cmd
+
+import "errors"
+
+// enableCbreakMode is not supported on this platform, so key presses are only received after Enter is pressed.
+func enableCbreakMode(fd uintptr) (restore func(), err error) {
+	return nil, errors.New("cbreak mode is not supported on this platform")
+}
@@ -0,0 +1,35 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package cmd
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// enableCbreakMode makes the terminal send each key press right away, without echoing it. The returned function
+// restores the previous mode of the terminal.
+func enableCbreakMode(fd uintptr) (restore func(), err error) {
+	var previous syscall.Termios
+	if err := ioctlTermios(fd, ioctlGetTermios, &previous); err != nil {
+		return nil, err
+	}
+	cbreak := previous
+	cbreak.Lflag &^= syscall.ICANON | syscall.ECHO
+	cbreak.Cc[syscall.VMIN] = 1
+	cbreak.Cc[syscall.VTIME] = 0
+	if err := ioctlTermios(fd, ioctlSetTermios, &cbreak); err != nil {
+		return nil, err
+	}
+	return func() {
+		ioctlTermios(fd, ioctlSetTermios, &previous)
+	}, nil
+}
+
+func ioctlTermios(fd, request uintptr, termios *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(termios))); errno != 0 {
+		return errno
+	}
+	return nil
+}
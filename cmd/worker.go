@@ -1,12 +1,23 @@
 package cmd
 
 import (
+	"context"
 	"github.com/ofux/deluge/api"
+	"github.com/ofux/deluge/repov2"
+	"github.com/ofux/deluge/worker"
+	uuid "github.com/satori/go.uuid"
 	"github.com/spf13/cobra"
+	"os"
+	"strconv"
+	"time"
 )
 
 var (
-	workerPort int32
+	workerPort          int32
+	workerOrchestrator  string
+	workerURL           string
+	workerWebhookSecret string
+	workerDataDir       string
 )
 
 // serveCmd represents the serve command
@@ -16,6 +27,24 @@ var workerCmd = &cobra.Command{
 	Long: `A worker is a Deluge server instance that is meant to execute some jobs like running scenarios and generating reports.
 It can be used together with an orchestrator.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		useDataDir(workerDataDir)
+		if workerOrchestrator != "" {
+			if workerURL == "" {
+				hostname, err := os.Hostname()
+				if err != nil {
+					die(err, 1)
+				}
+				workerURL = "http://" + hostname + ":" + strconv.Itoa(int(workerPort))
+			}
+			worker.OrchestratorInstance = worker.NewOrchestratorClient(workerOrchestrator, worker.Registration{
+				ID:  uuid.NewV4().String(),
+				URL: workerURL,
+			})
+			go worker.OrchestratorInstance.Join(5 * time.Second)
+		}
+		webhookSender := api.NewWebhookSender(context.Background(), repov2.Instance, workerWebhookSecret)
+		worker.ManagerInstance.OnJobEnd(webhookSender.NotifyJobEnd)
+		go webhookSender.ResumePending()
 		api.Serve(int(workerPort))
 	},
 }
@@ -24,5 +53,9 @@ func init() {
 	startCmd.AddCommand(workerCmd)
 
 	workerCmd.Flags().Int32VarP(&workerPort, "port", "p", 33033, "The port on which deluge worker will be listening")
+	workerCmd.Flags().StringVarP(&workerOrchestrator, "orchestrator", "o", "", "The address of the orchestrator to join (ex: http://187.32.87.353:9090)")
+	workerCmd.Flags().StringVar(&workerURL, "url", "", "The address on which the orchestrator can reach this worker (defaults to http://<hostname>:<port>)")
+	workerCmd.Flags().StringVar(&workerWebhookSecret, "webhook-secret", "", "The secret used to sign the calls to job webhooks (header "+api.WebhookSignatureHeader+")")
+	workerCmd.Flags().StringVar(&workerDataDir, "data-dir", "", "The directory in which deluges, scenarios, jobs and reports are kept across restarts (in memory only if not set)")
 
 }
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"github.com/ofux/deluge/core/recording"
 	"github.com/ofux/deluge/dsl/ast"
 	"github.com/ofux/deluge/dsl/evaluator"
 	"github.com/ofux/deluge/dsl/lexer"
@@ -22,6 +23,8 @@ type DelugeDefinition struct {
 type CompiledDeluge struct {
 	definition      *DelugeDefinition
 	scenarioConfigs map[string]*scenarioConfig
+	// histogram is the resolution and range of the response times recorded by the scenarios
+	histogram recording.HistogramConfig
 }
 
 func (c *CompiledDeluge) GetDelugeDefinition() *DelugeDefinition {
@@ -52,6 +55,7 @@ func CompileDeluge(script string) (*CompiledDeluge, error) {
 
 	builder := &delugeBuilder{
 		scenarioConfigs: make(map[string]*scenarioConfig),
+		histogram:       recording.DefaultHistogramConfig,
 	}
 	ev := evaluator.NewEvaluator()
 	if err := ev.AddBuiltin("deluge", builder.dslCreateDeluge); err != nil {
@@ -71,6 +75,7 @@ func CompileDeluge(script string) (*CompiledDeluge, error) {
 			GlobalDuration: builder.globalDuration,
 		},
 		scenarioConfigs: builder.scenarioConfigs,
+		histogram:       builder.histogram,
 	}, nil
 }
 
@@ -80,12 +85,31 @@ type delugeBuilder struct {
 	name            string
 	globalDuration  time.Duration
 	scenarioConfigs map[string]*scenarioConfig
+	histogram       recording.HistogramConfig
 }
 
+// scenarioConfig is the configuration of a scenario in a deluge. A scenario is either run with a fixed number of
+// concurrent users (closed model: 'concurrent' and 'delay'), or with a fixed number of new iterations per second
+// (open model: 'arrivalRate' and optionally 'maxUsers').
+// With 'stages', the number of users (or the arrival rate) starts at 'concurrent' (or 'arrivalRate') and then
+// follows the stages.
 type scenarioConfig struct {
 	concurrent        int
 	iterationDuration time.Duration
+	openModel         bool
+	arrivalRate       int
+	maxUsers          int
+	profile           *loadProfile
 	args              *object.Hash
+	thresholds        []*Threshold
+	// httpClient is nil if the scenario uses the default HTTP client
+	httpClient *httpClientConfig
+	// onError is what the scenario does when an iteration fails because of an error
+	onError errorPolicy
+}
+
+func (c *scenarioConfig) isOpenModel() bool {
+	return c.openModel
 }
 
 func (d *delugeBuilder) dslCreateDeluge(node ast.Node, args ...object.Object) object.Object {
@@ -94,8 +118,8 @@ func (d *delugeBuilder) dslCreateDeluge(node ast.Node, args ...object.Object) ob
 	}
 	d.visited = true
 
-	if len(args) != 4 {
-		return evaluator.NewError(node, "Expected %d arguments at %s\n", 4, ast.PrintLocation(node))
+	if len(args) != 4 && len(args) != 5 {
+		return evaluator.NewError(node, "Expected %d or %d arguments at %s\n", 4, 5, ast.PrintLocation(node))
 	}
 
 	delugeId, ok := args[0].(*object.String)
@@ -131,26 +155,50 @@ func (d *delugeBuilder) dslCreateDeluge(node ast.Node, args ...object.Object) ob
 			return evaluator.NewError(node, "Expected scenario configuration to be an object at %s\n", ast.PrintLocation(node))
 		}
 
-		concurrentClientsHashValue, ok := scenarioConf.Get("concurrent")
-		if !ok {
-			return evaluator.NewError(node, "Expected 'concurrent' value in configuration at %s\n", ast.PrintLocation(node))
+		sConf := &scenarioConfig{}
+		if stagesHashValue, ok := scenarioConf.Get("stages"); ok {
+			stages, errObj := parseStages(node, stagesHashValue)
+			if errObj != nil {
+				return errObj
+			}
+			sConf.profile = &loadProfile{stages: stages}
 		}
-		concurrentClients, ok := concurrentClientsHashValue.(*object.Integer)
-		if !ok {
-			return evaluator.NewError(node, "Expected 'concurrent' value to be an integer in configuration at %s\n", ast.PrintLocation(node))
+		if _, ok := scenarioConf.Get("arrivalRate"); ok {
+			if errObj := parseOpenModelConfig(node, scenarioConf, sConf); errObj != nil {
+				return errObj
+			}
+		} else if errObj := parseClosedModelConfig(node, scenarioConf, sConf); errObj != nil {
+			return errObj
+		}
+		if sConf.profile != nil {
+			sConf.profile.start = sConf.concurrent
+			if sConf.isOpenModel() {
+				sConf.profile.start = sConf.arrivalRate
+			}
 		}
 
-		delayHashValue, ok := scenarioConf.Get("delay")
-		if !ok {
-			return evaluator.NewError(node, "Expected 'delay' value in configuration at %s\n", ast.PrintLocation(node))
+		if thresholdsHashValue, ok := scenarioConf.Get("thresholds"); ok {
+			thresholds, errObj := parseThresholds(node, thresholdsHashValue)
+			if errObj != nil {
+				return errObj
+			}
+			sConf.thresholds = thresholds
 		}
-		delayHashStr, ok := delayHashValue.(*object.String)
-		if !ok {
-			return evaluator.NewError(node, "Expected 'delay' value to be a valid duration in configuration at %s\n", ast.PrintLocation(node))
+
+		if httpHashValue, ok := scenarioConf.Get("http"); ok {
+			httpClient, errObj := parseHTTPClientConfig(node, httpHashValue)
+			if errObj != nil {
+				return errObj
+			}
+			sConf.httpClient = httpClient
 		}
-		delayHash, err := time.ParseDuration(delayHashStr.Value)
-		if err != nil {
-			return evaluator.NewError(node, "Expected 'delay' value to be a valid duration in configuration at %s\n", ast.PrintLocation(node))
+
+		if onErrorValue, ok := scenarioConf.Get("onError"); ok {
+			policy, errObj := parseErrorPolicy(node, onErrorValue)
+			if errObj != nil {
+				return errObj
+			}
+			sConf.onError = policy
 		}
 
 		var argsHash *object.Hash
@@ -172,12 +220,170 @@ func (d *delugeBuilder) dslCreateDeluge(node ast.Node, args ...object.Object) ob
 			return evaluator.NewError(node, "Scenario '%v' is already configured", scenarioId)
 		}
 
-		d.scenarioConfigs[string(scenarioId)] = &scenarioConfig{
-			concurrent:        int(concurrentClients.Value),
-			iterationDuration: delayHash,
-			args:              argsHash,
+		sConf.args = argsHash
+		d.scenarioConfigs[string(scenarioId)] = sConf
+	}
+
+	if len(args) == 5 {
+		options, ok := args[4].(*object.Hash)
+		if !ok {
+			return evaluator.NewError(node, "Expected 5th argument to be an object at %s\n", ast.PrintLocation(node))
+		}
+		if errObj := d.parseOptions(node, options); errObj != nil {
+			return errObj
 		}
 	}
 
 	return evaluator.NULL
 }
+
+// parseOptions parses the options of the deluge: the 'resolution' (ms, us or ns) of the recorded response times and
+// the highest response time that can be recorded ('maxResponseTime').
+func (d *delugeBuilder) parseOptions(node ast.Node, options *object.Hash) *object.Error {
+	if resolutionValue, ok := options.Get("resolution"); ok {
+		resolution, ok := resolutionValue.(*object.String)
+		if !ok {
+			return evaluator.NewError(node, "Expected 'resolution' to be a string in options at %s\n", ast.PrintLocation(node))
+		}
+		unit, err := recording.ParseTimeUnit(resolution.Value)
+		if err != nil {
+			return evaluator.NewError(node, "Invalid 'resolution' in options at %s: %s\n", ast.PrintLocation(node), err.Error())
+		}
+		d.histogram.Unit = unit
+	}
+	if maxValue, ok := options.Get("maxResponseTime"); ok {
+		maxStr, ok := maxValue.(*object.String)
+		if !ok {
+			return evaluator.NewError(node, "Expected 'maxResponseTime' to be a valid duration in options at %s\n", ast.PrintLocation(node))
+		}
+		duration, err := time.ParseDuration(maxStr.Value)
+		if err != nil {
+			return evaluator.NewError(node, "Expected 'maxResponseTime' to be a valid duration in options at %s\n", ast.PrintLocation(node))
+		}
+		d.histogram.MaxValue = duration
+	}
+	if err := d.histogram.Validate(); err != nil {
+		return evaluator.NewError(node, "Invalid options at %s: %s\n", ast.PrintLocation(node), err.Error())
+	}
+	return nil
+}
+
+func parseClosedModelConfig(node ast.Node, scenarioConf *object.Hash, sConf *scenarioConfig) *object.Error {
+	concurrentClients := &object.Integer{Value: 0}
+	concurrentClientsHashValue, ok := scenarioConf.Get("concurrent")
+	if ok {
+		concurrentClients, ok = concurrentClientsHashValue.(*object.Integer)
+		if !ok {
+			return evaluator.NewError(node, "Expected 'concurrent' value to be an integer in configuration at %s\n", ast.PrintLocation(node))
+		}
+	} else if sConf.profile == nil {
+		// Without stages, the number of users is fixed and must be given
+		return evaluator.NewError(node, "Expected 'concurrent' value in configuration at %s\n", ast.PrintLocation(node))
+	}
+
+	delayHashValue, ok := scenarioConf.Get("delay")
+	if !ok {
+		return evaluator.NewError(node, "Expected 'delay' value in configuration at %s\n", ast.PrintLocation(node))
+	}
+	delayHashStr, ok := delayHashValue.(*object.String)
+	if !ok {
+		return evaluator.NewError(node, "Expected 'delay' value to be a valid duration in configuration at %s\n", ast.PrintLocation(node))
+	}
+	delayHash, err := time.ParseDuration(delayHashStr.Value)
+	if err != nil {
+		return evaluator.NewError(node, "Expected 'delay' value to be a valid duration in configuration at %s\n", ast.PrintLocation(node))
+	}
+
+	sConf.concurrent = int(concurrentClients.Value)
+	sConf.iterationDuration = delayHash
+	return nil
+}
+
+// maxArrivalRate is the highest number of iterations per second an open model scenario can start. Each iteration
+// is scheduled with its own timer, which cannot keep up with intervals shorter than about ten microseconds.
+const maxArrivalRate = 100000
+
+func parseOpenModelConfig(node ast.Node, scenarioConf *object.Hash, sConf *scenarioConfig) *object.Error {
+	if _, ok := scenarioConf.Get("concurrent"); ok {
+		return evaluator.NewError(node, "Expected either 'concurrent' or 'arrivalRate' value in configuration at %s\n", ast.PrintLocation(node))
+	}
+	if _, ok := scenarioConf.Get("delay"); ok {
+		return evaluator.NewError(node, "Unexpected 'delay' value with 'arrivalRate' in configuration at %s\n", ast.PrintLocation(node))
+	}
+
+	arrivalRateHashValue, _ := scenarioConf.Get("arrivalRate")
+	arrivalRate, ok := arrivalRateHashValue.(*object.Integer)
+	// With stages, the arrival rate may start at 0
+	if !ok || arrivalRate.Value < 0 || (arrivalRate.Value == 0 && sConf.profile == nil) {
+		return evaluator.NewError(node, "Expected 'arrivalRate' value to be a positive integer in configuration at %s\n", ast.PrintLocation(node))
+	}
+	if arrivalRate.Value > int64(maxArrivalRate) {
+		return evaluator.NewError(node, "Expected 'arrivalRate' value to be at most %d in configuration at %s\n", maxArrivalRate, ast.PrintLocation(node))
+	}
+	if sConf.profile != nil {
+		for i, st := range sConf.profile.stages {
+			if st.target > maxArrivalRate {
+				return evaluator.NewError(node, "Expected 'target' of stage %d to be at most %d with 'arrivalRate' in configuration at %s\n", i+1, maxArrivalRate, ast.PrintLocation(node))
+			}
+		}
+	}
+	sConf.openModel = true
+	sConf.arrivalRate = int(arrivalRate.Value)
+
+	if maxUsersHashValue, ok := scenarioConf.Get("maxUsers"); ok {
+		maxUsers, ok := maxUsersHashValue.(*object.Integer)
+		if !ok || maxUsers.Value <= 0 {
+			return evaluator.NewError(node, "Expected 'maxUsers' value to be a positive integer in configuration at %s\n", ast.PrintLocation(node))
+		}
+		sConf.maxUsers = int(maxUsers.Value)
+	}
+	return nil
+}
+
+func parseStages(node ast.Node, stagesHashValue object.Object) ([]stage, *object.Error) {
+	stagesArray, ok := stagesHashValue.(*object.Array)
+	if !ok || len(stagesArray.Elements) == 0 {
+		return nil, evaluator.NewError(node, "Expected 'stages' value to be a non-empty array in configuration at %s\n", ast.PrintLocation(node))
+	}
+
+	stages := make([]stage, 0, len(stagesArray.Elements))
+	for i, stageValue := range stagesArray.Elements {
+		stageHash, ok := stageValue.(*object.Hash)
+		if !ok {
+			return nil, evaluator.NewError(node, "Expected stage %d to be an object in configuration at %s\n", i+1, ast.PrintLocation(node))
+		}
+
+		durationStr, ok, err := stageHash.GetAsString("duration")
+		if !ok || err != nil {
+			return nil, evaluator.NewError(node, "Expected 'duration' of stage %d to be a valid duration in configuration at %s\n", i+1, ast.PrintLocation(node))
+		}
+		duration, err := time.ParseDuration(durationStr.Value)
+		if err != nil || duration < 0 {
+			return nil, evaluator.NewError(node, "Expected 'duration' of stage %d to be a valid duration in configuration at %s\n", i+1, ast.PrintLocation(node))
+		}
+
+		targetValue, ok := stageHash.Get("target")
+		if !ok {
+			return nil, evaluator.NewError(node, "Expected 'target' value in stage %d in configuration at %s\n", i+1, ast.PrintLocation(node))
+		}
+		target, ok := targetValue.(*object.Integer)
+		if !ok || target.Value < 0 {
+			return nil, evaluator.NewError(node, "Expected 'target' of stage %d to be a positive integer in configuration at %s\n", i+1, ast.PrintLocation(node))
+		}
+
+		step := false
+		if rampStr, ok, err := stageHash.GetAsString("ramp"); ok {
+			if err != nil || (rampStr.Value != "linear" && rampStr.Value != "step") {
+				return nil, evaluator.NewError(node, "Expected 'ramp' of stage %d to be either \"linear\" or \"step\" in configuration at %s\n", i+1, ast.PrintLocation(node))
+			}
+			step = rampStr.Value == "step"
+		}
+
+		stages = append(stages, stage{
+			duration: duration,
+			target:   int(target.Value),
+			step:     step,
+		})
+	}
+	return stages, nil
+}
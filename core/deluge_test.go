@@ -1,9 +1,11 @@
 package core
 
 import (
+	"github.com/ofux/deluge/core/recording"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 func TestCompileDeluge(t *testing.T) {
@@ -18,7 +20,7 @@ func TestCompileDeluge(t *testing.T) {
 					"delay": "100ms"
 				}
 			});`,
-			"RUNTIME ERROR: Expected 4 arguments at",
+			"RUNTIME ERROR: Expected 4 or 5 arguments at",
 		},
 		{
 			`deluge(1, "Some name", "200ms", {
@@ -119,10 +121,321 @@ func TestCompileDeluge(t *testing.T) {
 			});`,
 			"RUNTIME ERROR: Expected 'args' to be an object at",
 		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"arrivalRate": "50"
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'arrivalRate' value to be a positive integer in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"arrivalRate": 0
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'arrivalRate' value to be a positive integer in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"arrivalRate": 100001
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'arrivalRate' value to be at most 100000 in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"arrivalRate": 0,
+					"stages": [{"duration": "1m", "target": 10}, {"duration": "1m", "target": 100001}]
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'target' of stage 2 to be at most 100000 with 'arrivalRate' in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"arrivalRate": 50,
+					"maxUsers": -1
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'maxUsers' value to be a positive integer in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"arrivalRate": 50,
+					"concurrent": 100
+				}
+			});`,
+			"RUNTIME ERROR: Expected either 'concurrent' or 'arrivalRate' value in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"arrivalRate": 50,
+					"delay": "100ms"
+				}
+			});`,
+			"RUNTIME ERROR: Unexpected 'delay' value with 'arrivalRate' in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"delay": "100ms",
+					"stages": []
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'stages' value to be a non-empty array in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"delay": "100ms",
+					"stages": [{"duration": "1m"}]
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'target' value in stage 1 in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"delay": "100ms",
+					"stages": [{"duration": "1m", "target": 10}, {"duration": "foo", "target": 10}]
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'duration' of stage 2 to be a valid duration in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"delay": "100ms",
+					"stages": [{"duration": "1m", "target": -1}]
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'target' of stage 1 to be a positive integer in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"delay": "100ms",
+					"stages": [{"duration": "1m", "target": 10, "ramp": "exponential"}]
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'ramp' of stage 1 to be either \"linear\" or \"step\" in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"thresholds": "bad"
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'thresholds' value to be an object in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"thresholds": {"My request": "bad"}
+				}
+			});`,
+			"RUNTIME ERROR: Expected thresholds of 'My request' to be an object in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"thresholds": {"My request": {"p42": "1s"}}
+				}
+			});`,
+			"RUNTIME ERROR: Unknown threshold 'p42' of 'My request' in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"thresholds": {"My request": {"p95": 300}}
+				}
+			});`,
+			"RUNTIME ERROR: Expected threshold 'p95' of 'My request' to be a valid duration in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"thresholds": {"*": {"errorRate": 1.5}}
+				}
+			});`,
+			"RUNTIME ERROR: Expected threshold 'errorRate' of '*' to be a number between 0 and 1 in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"thresholds": {"*": {"errorRate": 0.1, "abortOnFail": "yes"}}
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'abortOnFail' of '*' to be a boolean in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"thresholds": {"*": {"errorRate": 0.1, "abortOnFail": true, "delayAbortEval": "soon"}}
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'delayAbortEval' of '*' to be a valid duration in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"http": true
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'http' value to be an object in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"http": {"keepalive": true}
+				}
+			});`,
+			"RUNTIME ERROR: Unknown setting 'keepalive' in 'http' in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"http": {"keepAlive": "yes"}
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'http.keepAlive' to be a boolean in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"http": {"reuseConnections": true}
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'http.keepAlive' to be true to reuse connections in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"http": {"maxConnsPerHost": -1}
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'http.maxConnsPerHost' to be a positive integer in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"http": {"timeout": "long"}
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'http.timeout' to be a valid duration in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"http": {"proxy": "localhost"}
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'http.proxy' to be a valid URL in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"http": {"tls": {"ca": "not a certificate"}}
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'http.tls.ca' to be PEM-encoded certificates in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"http": {"tls": {"cert": "not a certificate"}}
+				}
+			});`,
+			"RUNTIME ERROR: Expected both 'http.tls.cert' and 'http.tls.key' for a client certificate in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"http": {"tls": {"cert": "not a certificate", "key": "not a key"}}
+				}
+			});`,
+			"RUNTIME ERROR: Invalid client certificate in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"http": {"tls": {"verify": false}}
+				}
+			});`,
+			"RUNTIME ERROR: Unknown setting 'verify' in 'http.tls' in configuration at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {
+				"myScenario": {
+					"concurrent": 100,
+					"delay": "100ms",
+					"onError": "ignore"
+				}
+			});`,
+			"RUNTIME ERROR: Expected 'onError' value to be either \"continue\", \"stopUser\", \"stopScenario\" or \"abortDeluge\" in configuration at",
+		},
 		{
 			`deluge("myID", "Some name", "200ms", {}); deluge("Some other name", "200ms", {});`,
 			"RUNTIME ERROR: Expected only one deluge definition at",
 		},
+		{
+			`deluge("myID", "Some name", "200ms", {}, "us");`,
+			"RUNTIME ERROR: Expected 5th argument to be an object at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {}, {"resolution": "s"});`,
+			"RUNTIME ERROR: Invalid 'resolution' in options at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {}, {"maxResponseTime": 10});`,
+			"RUNTIME ERROR: Expected 'maxResponseTime' to be a valid duration in options at",
+		},
+		{
+			`deluge("myID", "Some name", "200ms", {}, {"maxResponseTime": "1ms"});`,
+			"RUNTIME ERROR: Invalid options at",
+		},
 	}
 
 	for _, tt := range tests {
@@ -140,3 +453,188 @@ scenario("myScenario", "My scenario", function () {
 		assert.Contains(t, err.Error(), tt.expected)
 	}
 }
+
+func TestCompileDeluge_ArrivalRate(t *testing.T) {
+	clearRepo()
+	compiled, err := CompileDeluge(`
+	deluge("myID", "Some name", "200ms", {
+		"closed": {
+			"concurrent": 10,
+			"delay": "100ms"
+		},
+		"open": {
+			"arrivalRate": 50
+		},
+		"openWithMaxUsers": {
+			"arrivalRate": 20,
+			"maxUsers": 5
+		}
+	});`)
+	require.NoError(t, err)
+
+	assert.False(t, compiled.scenarioConfigs["closed"].isOpenModel())
+	assert.Equal(t, 10, compiled.scenarioConfigs["closed"].concurrent)
+
+	assert.True(t, compiled.scenarioConfigs["open"].isOpenModel())
+	assert.Equal(t, 50, compiled.scenarioConfigs["open"].arrivalRate)
+	assert.Equal(t, 0, compiled.scenarioConfigs["open"].maxUsers)
+
+	assert.True(t, compiled.scenarioConfigs["openWithMaxUsers"].isOpenModel())
+	assert.Equal(t, 20, compiled.scenarioConfigs["openWithMaxUsers"].arrivalRate)
+	assert.Equal(t, 5, compiled.scenarioConfigs["openWithMaxUsers"].maxUsers)
+}
+
+func TestCompileDeluge_Options(t *testing.T) {
+	clearRepo()
+	compiled, err := CompileDeluge(`deluge("myID", "Some name", "200ms", {});`)
+	require.NoError(t, err)
+	assert.Equal(t, recording.DefaultHistogramConfig, compiled.histogram)
+
+	compiled, err = CompileDeluge(`deluge("myID", "Some name", "200ms", {}, {"resolution": "us", "maxResponseTime": "10s"});`)
+	require.NoError(t, err)
+	assert.Equal(t, recording.HistogramConfig{Unit: recording.Microsecond, MaxValue: 10 * time.Second}, compiled.histogram)
+
+	compiled, err = CompileDeluge(`deluge("myID", "Some name", "200ms", {}, {"resolution": "ns"});`)
+	require.NoError(t, err)
+	assert.Equal(t, recording.HistogramConfig{Unit: recording.Nanosecond, MaxValue: time.Hour}, compiled.histogram)
+}
+
+func TestCompileDeluge_HTTPClient(t *testing.T) {
+	clearRepo()
+	compiled, err := CompileDeluge(`
+	deluge("myID", "Some name", "200ms", {
+		"default": {
+			"concurrent": 1,
+			"delay": "100ms"
+		},
+		"browser": {
+			"concurrent": 1,
+			"delay": "100ms",
+			"http": {
+				"keepAlive": true,
+				"reuseConnections": true,
+				"maxConnsPerHost": 6,
+				"timeout": "30s",
+				"connectTimeout": "5s",
+				"tlsHandshakeTimeout": "2s",
+				"responseHeaderTimeout": "10s",
+				"idleConnTimeout": "1m",
+				"maxRedirects": 0,
+				"proxy": "http://proxy:3128",
+				"http2": false,
+				"tls": {"insecureSkipVerify": true, "serverName": "example.com"}
+			}
+		}
+	});`)
+	require.NoError(t, err)
+
+	assert.Nil(t, compiled.scenarioConfigs["default"].httpClient)
+
+	conf := compiled.scenarioConfigs["browser"].httpClient
+	require.NotNil(t, conf)
+	assert.True(t, conf.keepAlive)
+	assert.True(t, conf.reuseConnections)
+	assert.Equal(t, 6, conf.maxConnsPerHost)
+	assert.Equal(t, 30*time.Second, conf.timeout)
+	assert.Equal(t, 5*time.Second, conf.connectTimeout)
+	assert.Equal(t, 2*time.Second, conf.tlsHandshakeTimeout)
+	assert.Equal(t, 10*time.Second, conf.responseHeaderTimeout)
+	assert.Equal(t, time.Minute, conf.idleConnTimeout)
+	assert.Equal(t, 0, conf.maxRedirects)
+	assert.Equal(t, "http://proxy:3128", conf.proxy.String())
+	require.NotNil(t, conf.http2)
+	assert.False(t, *conf.http2)
+	require.NotNil(t, conf.tlsConfig)
+	assert.True(t, conf.tlsConfig.InsecureSkipVerify)
+	assert.Equal(t, "example.com", conf.tlsConfig.ServerName)
+}
+
+func TestCompileDeluge_OnError(t *testing.T) {
+	clearRepo()
+	compiled, err := CompileDeluge(`
+	deluge("myID", "Some name", "200ms", {
+		"default": {"concurrent": 1, "delay": "100ms"},
+		"continue": {"concurrent": 1, "delay": "100ms", "onError": "continue"},
+		"stopUser": {"concurrent": 1, "delay": "100ms", "onError": "stopUser"},
+		"stopScenario": {"concurrent": 1, "delay": "100ms", "onError": "stopScenario"},
+		"abortDeluge": {"concurrent": 1, "delay": "100ms", "onError": "abortDeluge"}
+	});`)
+	require.NoError(t, err)
+
+	assert.Equal(t, stopUserOnError, compiled.scenarioConfigs["default"].onError)
+	assert.Equal(t, continueOnError, compiled.scenarioConfigs["continue"].onError)
+	assert.Equal(t, stopUserOnError, compiled.scenarioConfigs["stopUser"].onError)
+	assert.Equal(t, stopScenarioOnError, compiled.scenarioConfigs["stopScenario"].onError)
+	assert.Equal(t, abortDelugeOnError, compiled.scenarioConfigs["abortDeluge"].onError)
+}
+
+func TestCompileDeluge_Stages(t *testing.T) {
+	clearRepo()
+	compiled, err := CompileDeluge(`
+	deluge("myID", "Some name", "200ms", {
+		"closed": {
+			"delay": "100ms",
+			"stages": [
+				{"duration": "1m", "target": 100},
+				{"duration": "5m", "target": 100},
+				{"duration": "30s", "target": 200, "ramp": "step"},
+				{"duration": "1m", "target": 0, "ramp": "linear"}
+			]
+		},
+		"open": {
+			"arrivalRate": 0,
+			"stages": [
+				{"duration": "1m", "target": 50}
+			]
+		}
+	});`)
+	require.NoError(t, err)
+
+	closed := compiled.scenarioConfigs["closed"]
+	assert.False(t, closed.isOpenModel())
+	assert.Equal(t, &loadProfile{
+		start: 0,
+		stages: []stage{
+			{duration: time.Minute, target: 100},
+			{duration: 5 * time.Minute, target: 100},
+			{duration: 30 * time.Second, target: 200, step: true},
+			{duration: time.Minute, target: 0},
+		},
+	}, closed.profile)
+
+	open := compiled.scenarioConfigs["open"]
+	assert.True(t, open.isOpenModel())
+	assert.Equal(t, &loadProfile{
+		start:  0,
+		stages: []stage{{duration: time.Minute, target: 50}},
+	}, open.profile)
+}
+
+func TestCompileDeluge_Thresholds(t *testing.T) {
+	clearRepo()
+	compiled, err := CompileDeluge(`
+	deluge("myID", "Some name", "200ms", {
+		"withThresholds": {
+			"concurrent": 10,
+			"delay": "100ms",
+			"thresholds": {
+				"Get all products": {"p95": "300ms", "errorRate": 0.01},
+				"*": {"mean": "1s", "errorRate": 0, "abortOnFail": true, "delayAbortEval": "30s"}
+			}
+		},
+		"withoutThresholds": {
+			"concurrent": 10,
+			"delay": "100ms"
+		}
+	});`)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string][]*Threshold{
+		"withThresholds": {
+			{Request: AllRequests, Metric: ThresholdErrorRate, Limit: 0, AbortOnFail: true, DelayAbortEval: 30 * time.Second},
+			{Request: AllRequests, Metric: ThresholdMean, Limit: 1000, AbortOnFail: true, DelayAbortEval: 30 * time.Second},
+			{Request: "Get all products", Metric: ThresholdErrorRate, Limit: 0.01},
+			{Request: "Get all products", Metric: ThresholdP95, Limit: 300},
+		},
+	}, compiled.GetThresholds())
+}
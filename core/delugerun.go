@@ -2,7 +2,9 @@ package core
 
 import (
 	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/reporting"
 	"github.com/ofux/deluge/core/status"
+	"github.com/ofux/deluge/dsl/object"
 	"github.com/ofux/deluge/repov2"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -18,6 +20,18 @@ type RunnableDeluge struct {
 	runStatusMutex *sync.Mutex
 	interrupt      chan struct{}
 	statusChange   chan status.DelugeStatus
+
+	// abortEvalFrequency is how often the thresholds with abortOnFail are evaluated while the deluge runs
+	abortEvalFrequency time.Duration
+	abortedBy          *AbortCause
+}
+
+// AbortCause is what aborted the deluge while it was running: either a threshold that failed, or an error of a
+// scenario configured to abort the deluge on error. ThresholdResult is nil if the deluge was aborted by an error.
+type AbortCause struct {
+	ScenarioID string
+	*ThresholdResult
+	Error *object.Error
 }
 
 // GetDelugeDefinition returns a copy of the deluge definition
@@ -39,19 +53,44 @@ func (d *RunnableDeluge) GetStatus() status.DelugeStatus {
 	return d.runStatus
 }
 
+// GetAbortCause returns what aborted the deluge, or nil if it was not aborted.
+func (d *RunnableDeluge) GetAbortCause() *AbortCause {
+	d.runStatusMutex.Lock()
+	defer d.runStatusMutex.Unlock()
+	return d.abortedBy
+}
+
+// GetRecordsSnapshot returns a snapshot of the records of each scenario. Scenarios that already ended have a snapshot
+// with an error. It fails only if all the scenarios ended.
 func (d *RunnableDeluge) GetRecordsSnapshot() (map[string]*recording.RecordSnapshot, error) {
 	res := make(map[string]*recording.RecordSnapshot)
+	var lastErr error
+	endedCount := 0
 	for scenarioID, scenario := range d.Scenarios {
 		snap, err := scenario.GetRecordsSnapshot()
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to get snapshot of records of scenario %s", scenarioID)
+			lastErr = errors.Wrapf(err, "failed to get snapshot of records of scenario %s", scenarioID)
+			snap = &recording.RecordSnapshot{Err: lastErr}
+			endedCount++
 		}
 		res[scenarioID] = snap
 	}
+	if endedCount > 0 && endedCount == len(d.Scenarios) {
+		return nil, lastErr
+	}
 	return res, nil
 }
 
 func NewRunnableDeluge(delugeID string) (*RunnableDeluge, error) {
+	return NewRunnableDelugeShare(delugeID, FullShare)
+}
+
+// NewRunnableDelugeShare creates a runnable deluge that only simulates the given share of the concurrent users
+// of each scenario.
+func NewRunnableDelugeShare(delugeID string, share Share) (*RunnableDeluge, error) {
+	if err := share.Validate(); err != nil {
+		return nil, err
+	}
 	persistedDeluge, ok := repov2.Instance.GetDeluge(delugeID)
 	if !ok {
 		return nil, errors.Errorf("deluge with ID '%s' does not exist", delugeID)
@@ -68,6 +107,8 @@ func NewRunnableDeluge(delugeID string) (*RunnableDeluge, error) {
 		runStatusMutex: &sync.Mutex{},
 		interrupt:      make(chan struct{}),
 		statusChange:   make(chan status.DelugeStatus, 5), // Status cannot change more than 5 times
+
+		abortEvalFrequency: time.Second,
 	}
 	dlg.statusChange <- dlg.runStatus
 	for id, sConf := range compiledDeluge.scenarioConfigs {
@@ -76,14 +117,54 @@ func NewRunnableDeluge(delugeID string) (*RunnableDeluge, error) {
 			if err != nil {
 				return nil, errors.Wrapf(err, "failed to recompile scenario %s", id)
 			}
-			dlg.Scenarios[id] = newRunnableScenario(
-				compiledScenario,
-				sConf.concurrent,
-				dlg.GetGlobalDuration(),
-				sConf.iterationDuration,
-				sConf.args,
-				log.New().WithField("deluge", dlg.GetDelugeDefinition().Name),
-			)
+			logEntry := log.WithField("deluge", dlg.GetDelugeDefinition().Name)
+			var profile *loadProfile
+			if sConf.profile != nil {
+				profile = sConf.profile.split(share)
+			}
+			if sConf.isOpenModel() {
+				maxUsers := share.Split(sConf.maxUsers)
+				if sConf.maxUsers > 0 && maxUsers == 0 {
+					maxUsers = 1
+				}
+				arrivalRate := share.Split(sConf.arrivalRate)
+				if profile != nil {
+					// Iterations are started at the highest rate of the profile, which paces the scenario
+					arrivalRate = profile.max()
+				}
+				scenario := newRunnableOpenScenario(
+					compiledScenario,
+					arrivalRate,
+					maxUsers,
+					dlg.GetGlobalDuration(),
+					sConf.args,
+					compiledDeluge.histogram,
+					sConf.httpClient,
+					logEntry,
+				)
+				if arrivalRate > 0 {
+					scenario.profile = profile
+				}
+				dlg.addScenario(id, scenario, sConf)
+			} else {
+				concurrent := share.Split(sConf.concurrent)
+				if profile != nil {
+					// Users are started by the load profile
+					concurrent = 0
+				}
+				scenario := newRunnableScenario(
+					compiledScenario,
+					concurrent,
+					dlg.GetGlobalDuration(),
+					sConf.iterationDuration,
+					sConf.args,
+					compiledDeluge.histogram,
+					sConf.httpClient,
+					logEntry,
+				)
+				scenario.profile = profile
+				dlg.addScenario(id, scenario, sConf)
+			}
 		} else {
 			return nil, errors.Errorf("scenario '%s' is configured but not defined", id)
 		}
@@ -91,6 +172,16 @@ func NewRunnableDeluge(delugeID string) (*RunnableDeluge, error) {
 	return dlg, nil
 }
 
+// addScenario adds a scenario to the deluge, with the error policy of its configuration.
+func (d *RunnableDeluge) addScenario(scenarioID string, scenario *RunnableScenario, sConf *scenarioConfig) {
+	scenario.errorPolicy = sConf.onError
+	scenario.abortDeluge = func(err *object.Error) {
+		log.Warnf("Aborting deluge: error in scenario %s: %s", scenarioID, err.Message)
+		d.abort(&AbortCause{ScenarioID: scenarioID, Error: err})
+	}
+	d.Scenarios[scenarioID] = scenario
+}
+
 // Run runs the deluge asynchronously. It returns a channel that will be closed once the execution is finished.
 func (d *RunnableDeluge) Run() <-chan struct{} {
 	done := make(chan struct{})
@@ -115,6 +206,11 @@ func (d *RunnableDeluge) run() {
 	d.statusChange <- d.runStatus
 	d.runStatusMutex.Unlock()
 
+	scenariosDone := make(chan struct{})
+	if thresholds := d.getAbortThresholds(); len(thresholds) > 0 {
+		go d.watchAbortThresholds(thresholds, start, scenariosDone)
+	}
+
 	var waitg sync.WaitGroup
 	for _, scenario := range d.Scenarios {
 		waitg.Add(1)
@@ -124,6 +220,7 @@ func (d *RunnableDeluge) run() {
 		}(scenario)
 	}
 	waitg.Wait()
+	close(scenariosDone)
 
 	d.end()
 
@@ -143,15 +240,121 @@ func (d *RunnableDeluge) end() {
 			}
 		}
 		d.statusChange <- d.runStatus
+	} else if d.runStatus == status.DelugeAborted || d.runStatus == status.DelugeInterrupted {
+		// The status is published once scenarios have stopped, so that their records are complete
+		d.statusChange <- d.runStatus
 	}
 	close(d.statusChange)
 }
 
+// GetThresholds returns the thresholds of each scenario of the deluge.
+func (d *RunnableDeluge) GetThresholds() map[string][]*Threshold {
+	return d.compiledDeluge.GetThresholds()
+}
+
+// getAbortThresholds returns the thresholds with abortOnFail of each scenario.
+func (d *RunnableDeluge) getAbortThresholds() map[string][]*Threshold {
+	abortThresholds := make(map[string][]*Threshold)
+	for scenarioID, thresholds := range d.compiledDeluge.GetThresholds() {
+		for _, threshold := range thresholds {
+			if threshold.AbortOnFail {
+				abortThresholds[scenarioID] = append(abortThresholds[scenarioID], threshold)
+			}
+		}
+	}
+	return abortThresholds
+}
+
+// watchAbortThresholds regularly evaluates the given thresholds against the records of the scenarios, and aborts the
+// deluge as soon as one of them fails. It returns when the deluge is aborted or interrupted, or when done is closed.
+func (d *RunnableDeluge) watchAbortThresholds(thresholds map[string][]*Threshold, start time.Time, done <-chan struct{}) {
+	ticker := time.NewTicker(d.abortEvalFrequency)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-d.interrupt:
+			return
+		case <-ticker.C:
+			if cause := d.evaluateAbortThresholds(thresholds, time.Since(start)); cause != nil {
+				log.Warnf("Aborting deluge: threshold %s of request '%s' of scenario %s failed", cause.Metric, cause.Request, cause.ScenarioID)
+				d.abort(cause)
+				return
+			}
+		}
+	}
+}
+
+// evaluateAbortThresholds returns the first threshold that fails, if any. Thresholds are evaluated only once their
+// delay has elapsed, and only on requests that have been called, so that a deluge is not aborted before it warmed up.
+func (d *RunnableDeluge) evaluateAbortThresholds(thresholds map[string][]*Threshold, elapsed time.Duration) *AbortCause {
+	for scenarioID, scenarioThresholds := range thresholds {
+		var stats *reporting.HTTPStats
+		for _, threshold := range scenarioThresholds {
+			if elapsed < threshold.DelayAbortEval {
+				continue
+			}
+			if stats == nil {
+				var err error
+				stats, err = d.Scenarios[scenarioID].getGlobalStats()
+				if err != nil {
+					// The scenario ended, its thresholds will be evaluated with its report
+					break
+				}
+			}
+			if result := evaluateThreshold(threshold, stats); result.Value != nil && !result.Passed {
+				return &AbortCause{ScenarioID: scenarioID, ThresholdResult: result}
+			}
+		}
+	}
+	return nil
+}
+
+// SetConcurrency changes the number of users of the given scenario while the deluge is running.
+func (d *RunnableDeluge) SetConcurrency(scenarioID string, concurrent int) error {
+	scenario, ok := d.Scenarios[scenarioID]
+	if !ok {
+		return errors.Wrapf(ErrUnknownScenario, "scenario '%s' is not part of deluge '%s'", scenarioID, d.GetDelugeDefinition().ID)
+	}
+	if d.GetStatus() != status.DelugeInProgress {
+		return ErrScenarioNotRunning
+	}
+	return scenario.SetConcurrency(concurrent)
+}
+
+// OnError sets a function that is called the first time each distinct error occurs in a scenario, while the deluge
+// runs. It must be called before Run.
+func (d *RunnableDeluge) OnError(listener func(scenarioID string, err *object.Error)) {
+	for scenarioID, scenario := range d.Scenarios {
+		scenarioID := scenarioID
+		scenario.onError = func(err *object.Error) {
+			listener(scenarioID, err)
+		}
+	}
+}
+
 func (d *RunnableDeluge) Interrupt() {
 	d.runStatusMutex.Lock()
 	if d.runStatus == status.DelugeVirgin || d.runStatus == status.DelugeInProgress {
+		if d.runStatus == status.DelugeVirgin {
+			// No scenario runs, otherwise the status is published once they have stopped
+			d.statusChange <- status.DelugeInterrupted
+		}
 		d.runStatus = status.DelugeInterrupted
-		d.statusChange <- d.runStatus
+		d.runStatusMutex.Unlock()
+		close(d.interrupt)
+	} else {
+		d.runStatusMutex.Unlock()
+	}
+}
+
+// abort interrupts the deluge because of the given failed threshold or error.
+func (d *RunnableDeluge) abort(cause *AbortCause) {
+	d.runStatusMutex.Lock()
+	if d.runStatus == status.DelugeInProgress {
+		d.runStatus = status.DelugeAborted
+		d.abortedBy = cause
 		d.runStatusMutex.Unlock()
 		close(d.interrupt)
 	} else {
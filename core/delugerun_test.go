@@ -4,10 +4,13 @@ import (
 	"github.com/ofux/deluge/core/recording"
 	"github.com/ofux/deluge/core/recording/recordingtest"
 	"github.com/ofux/deluge/core/status"
+	"github.com/ofux/deluge/dsl/object"
 	"github.com/ofux/deluge/repov2"
 	"github.com/ofux/docilemonkey/docilemonkey"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"sync"
 	"testing"
 	"time"
 )
@@ -118,6 +121,118 @@ func TestDeluge_NewRunnableDeluge(t *testing.T) {
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "failed to recompile deluge with ID 'foo'")
 	})
+
+	t.Run("Create runnable deluge share", func(t *testing.T) {
+		clearRepo()
+
+		compileScenario(t, `
+		scenario("myScenario1", "My scenario with args", function (args) {
+		});`)
+
+		compileDeluge(t, `
+		deluge("foo", "Some name", "100ms", {
+			"myScenario1": {
+				"concurrent": 10,
+				"delay": "1000ms"
+			}
+		});`)
+
+		dlg, err := NewRunnableDelugeShare("foo", Share{Index: 0, Count: 3})
+		require.NoError(t, err)
+		assert.Len(t, dlg.Scenarios["myScenario1"].simUsers, 4)
+
+		dlg, err = NewRunnableDelugeShare("foo", Share{Index: 2, Count: 3})
+		require.NoError(t, err)
+		assert.Len(t, dlg.Scenarios["myScenario1"].simUsers, 3)
+
+		_, err = NewRunnableDelugeShare("foo", Share{Index: 3, Count: 3})
+		assert.Error(t, err)
+	})
+
+	t.Run("Create runnable deluge share with arrival rate", func(t *testing.T) {
+		clearRepo()
+
+		compileScenario(t, `
+		scenario("myScenario1", "My scenario with args", function (args) {
+		});`)
+
+		compileDeluge(t, `
+		deluge("foo", "Some name", "100ms", {
+			"myScenario1": {
+				"arrivalRate": 100,
+				"maxUsers": 2
+			}
+		});`)
+
+		dlg, err := NewRunnableDelugeShare("foo", Share{Index: 0, Count: 3})
+		require.NoError(t, err)
+		assert.Equal(t, 34, dlg.Scenarios["myScenario1"].arrivalRate)
+		assert.Equal(t, 1, dlg.Scenarios["myScenario1"].maxUsers)
+		assert.Empty(t, dlg.Scenarios["myScenario1"].simUsers)
+
+		dlg, err = NewRunnableDelugeShare("foo", Share{Index: 2, Count: 3})
+		require.NoError(t, err)
+		assert.Equal(t, 33, dlg.Scenarios["myScenario1"].arrivalRate)
+		assert.Equal(t, 1, dlg.Scenarios["myScenario1"].maxUsers)
+	})
+
+	t.Run("Create runnable deluge with the highest arrival rate", func(t *testing.T) {
+		clearRepo()
+
+		compileScenario(t, `
+		scenario("myScenario1", "My scenario", function () {
+		});`)
+
+		compileDeluge(t, `
+		deluge("foo", "Some name", "1h", {
+			"myScenario1": {
+				"arrivalRate": 100000
+			}
+		});`)
+
+		dlg, err := NewRunnableDeluge("foo")
+		require.NoError(t, err)
+		assert.Equal(t, 100000, dlg.Scenarios["myScenario1"].arrivalRate)
+		assert.Equal(t, 10*time.Microsecond, dlg.Scenarios["myScenario1"].IterationDuration)
+	})
+
+	t.Run("Create runnable deluge share with stages", func(t *testing.T) {
+		clearRepo()
+
+		compileScenario(t, `
+		scenario("myScenario1", "My scenario", function () {
+		});`)
+		compileScenario(t, `
+		scenario("myScenario2", "My other scenario", function () {
+		});`)
+
+		compileDeluge(t, `
+		deluge("foo", "Some name", "100ms", {
+			"myScenario1": {
+				"delay": "10ms",
+				"stages": [{"duration": "1m", "target": 10}]
+			},
+			"myScenario2": {
+				"arrivalRate": 0,
+				"stages": [{"duration": "1m", "target": 50}, {"duration": "1m", "target": 100}]
+			}
+		});`)
+
+		dlg, err := NewRunnableDelugeShare("foo", Share{Index: 0, Count: 2})
+		require.NoError(t, err)
+
+		// Users are started by the load profile
+		assert.Empty(t, dlg.Scenarios["myScenario1"].simUsers)
+		assert.Equal(t, &loadProfile{
+			start:  0,
+			stages: []stage{{duration: time.Minute, target: 5}},
+		}, dlg.Scenarios["myScenario1"].profile)
+
+		// Iterations are started at the highest rate of the profile
+		assert.Equal(t, 50, dlg.Scenarios["myScenario2"].arrivalRate)
+		assert.Equal(t, 20*time.Millisecond, dlg.Scenarios["myScenario2"].IterationDuration)
+		assert.Equal(t, 25, dlg.Scenarios["myScenario2"].profile.stages[0].target)
+	})
 }
 
 func TestDeluge_Run(t *testing.T) {
@@ -163,6 +278,46 @@ func TestDeluge_Run(t *testing.T) {
 		recordingtest.CheckHTTPRecord(t, records.Global, reqName, int64(dlg.Scenarios["myScenario"].EffectiveExecCount), 201, recording.Ok)
 	})
 
+	t.Run("Change concurrency of a running deluge", func(t *testing.T) {
+		clearRepo()
+
+		compileScenario(t, `
+		scenario("myScenario", "My scenario", function () {
+		});`)
+		compileScenario(t, `
+		scenario("myOpenScenario", "My open scenario", function () {
+		});`)
+
+		compileDeluge(t, `
+		deluge("foo", "Some name", "300ms", {
+			"myScenario": {
+				"concurrent": 2,
+				"delay": "10ms"
+			},
+			"myOpenScenario": {
+				"arrivalRate": 10
+			}
+		});`)
+
+		dlg, err := NewRunnableDeluge("foo")
+		require.NoError(t, err)
+		assert.Equal(t, ErrScenarioNotRunning, dlg.SetConcurrency("myScenario", 5))
+
+		done := dlg.Run()
+		for dlg.GetStatus() != status.DelugeInProgress {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		assert.NoError(t, dlg.SetConcurrency("myScenario", 5))
+		assert.Equal(t, ErrConcurrencyNotAdjustable, dlg.SetConcurrency("myOpenScenario", 5))
+		assert.Equal(t, ErrUnknownScenario, errors.Cause(dlg.SetConcurrency("unknown", 5)))
+		<-done
+
+		assertStatuses(t, dlg, status.DelugeVirgin, status.DelugeInProgress, status.DelugeDoneSuccess)
+		assert.Equal(t, uint64(5), dlg.Scenarios["myScenario"].EffectiveUserCount)
+		assert.Equal(t, ErrScenarioNotRunning, dlg.SetConcurrency("myScenario", 1))
+	})
+
 	t.Run("Run and interrupt a deluge", func(t *testing.T) {
 		srv := docilemonkey.NewTestServer()
 		defer srv.Close()
@@ -211,6 +366,94 @@ func TestDeluge_Run(t *testing.T) {
 		assert.Equal(t, status.DelugeInterrupted, dlg.runStatus)
 
 		assertStatuses(t, dlg, status.DelugeVirgin, status.DelugeInProgress, status.DelugeInterrupted)
+
+		// Records are complete once the status has changed
+		assert.NotNil(t, dlg.Scenarios["myScenario"].Records)
+	})
+
+	t.Run("Run and abort a deluge when a threshold fails", func(t *testing.T) {
+		srv := docilemonkey.NewTestServer()
+		defer srv.Close()
+		clearRepo()
+
+		compileScenario(t, `
+		scenario("myScenario", "My scenario", function () {
+
+			http("My request", {
+				"url": "`+srv.URL+`/hello/toto?s=500"
+			});
+
+		});`)
+
+		compileDeluge(t, `
+		deluge("foo", "Some name", "20s", {
+			"myScenario": {
+				"concurrent": 10,
+				"delay": "100ms",
+				"thresholds": {
+					"My request": {"p99": "10s", "errorRate": 0.1, "abortOnFail": true}
+				}
+			}
+		});`)
+
+		dlg, err := NewRunnableDeluge("foo")
+		require.NoError(t, err)
+		dlg.abortEvalFrequency = 10 * time.Millisecond
+
+		start := time.Now()
+		<-dlg.Run()
+		if time.Now().Sub(start).Seconds() > 10 {
+			t.Errorf("Looks like deluge was not aborted")
+		}
+
+		assertStatuses(t, dlg, status.DelugeVirgin, status.DelugeInProgress, status.DelugeAborted)
+		cause := dlg.GetAbortCause()
+		require.NotNil(t, cause)
+		assert.Equal(t, "myScenario", cause.ScenarioID)
+		assert.Equal(t, "My request", cause.Request)
+		assert.Equal(t, ThresholdErrorRate, cause.Metric)
+		assert.Equal(t, 1.0, *cause.Value)
+		assert.False(t, cause.Passed)
+
+		// Records are complete once the status has changed
+		assert.NotNil(t, dlg.Scenarios["myScenario"].Records)
+
+		dlg.Interrupt()
+		assert.Equal(t, status.DelugeAborted, dlg.GetStatus())
+	})
+
+	t.Run("Run a deluge with a threshold that fails before its abort delay", func(t *testing.T) {
+		srv := docilemonkey.NewTestServer()
+		defer srv.Close()
+		clearRepo()
+
+		compileScenario(t, `
+		scenario("myScenario", "My scenario", function () {
+
+			http("My request", {
+				"url": "`+srv.URL+`/hello/toto?s=500"
+			});
+
+		});`)
+
+		compileDeluge(t, `
+		deluge("foo", "Some name", "300ms", {
+			"myScenario": {
+				"concurrent": 10,
+				"delay": "100ms",
+				"thresholds": {
+					"*": {"errorRate": 0.1, "abortOnFail": true, "delayAbortEval": "10s"}
+				}
+			}
+		});`)
+
+		dlg, err := NewRunnableDeluge("foo")
+		require.NoError(t, err)
+		dlg.abortEvalFrequency = 10 * time.Millisecond
+		<-dlg.Run()
+
+		assertStatuses(t, dlg, status.DelugeVirgin, status.DelugeInProgress, status.DelugeDoneSuccess)
+		assert.Nil(t, dlg.GetAbortCause())
 	})
 
 	t.Run("Run deluge with args", func(t *testing.T) {
@@ -282,8 +525,9 @@ func TestDeluge_Run(t *testing.T) {
 		assertStatuses(t, dlg, status.DelugeVirgin, status.DelugeInProgress, status.DelugeDoneError)
 
 		assert.Equal(t, status.DelugeDoneError, dlg.runStatus)
-		assert.Len(t, dlg.Scenarios["myScenario"].Errors, 5)
+		require.Len(t, dlg.Scenarios["myScenario"].Errors, 1)
 		assert.Equal(t, "Assertion failed", dlg.Scenarios["myScenario"].Errors[0].Message)
+		assert.Equal(t, uint64(5), dlg.Scenarios["myScenario"].Errors[0].Count)
 		assert.Equal(t, uint64(5), dlg.Scenarios["myScenario"].EffectiveUserCount)
 		assert.Equal(t, uint64(15), dlg.Scenarios["myScenario"].EffectiveExecCount)
 	})
@@ -363,6 +607,91 @@ func TestDeluge_Run_With_Errors(t *testing.T) {
 		assert.Equal(t, uint64(10), dlg.Scenarios["myScenario"].EffectiveUserCount)
 	})
 
+	t.Run("Errors are notified while the deluge runs", func(t *testing.T) {
+		clearRepo()
+		compileScenario(t, `
+		scenario("myScenario", "My scenario", function () {
+			assert(false);
+		});`)
+
+		compileDeluge(t, `
+		deluge("foo", "Some name", "100ms", {
+			"myScenario": {
+				"concurrent": 10,
+				"delay": "10ms"
+			}
+		});`)
+
+		dlg, err := NewRunnableDeluge("foo")
+		assert.NoError(t, err)
+
+		var notified []*object.Error
+		notifiedMut := &sync.Mutex{}
+		dlg.OnError(func(scenarioID string, err *object.Error) {
+			assert.Equal(t, "myScenario", scenarioID)
+			notifiedMut.Lock()
+			defer notifiedMut.Unlock()
+			notified = append(notified, err)
+		})
+
+		<-dlg.Run()
+
+		notifiedMut.Lock()
+		defer notifiedMut.Unlock()
+		// Identical errors are only notified once
+		require.Len(t, notified, 1)
+		require.Len(t, dlg.Scenarios["myScenario"].Errors, 1)
+		assert.Equal(t, dlg.Scenarios["myScenario"].Errors[0].Error, notified[0])
+	})
+
+	t.Run("Get records snapshot while some scenarios ended", func(t *testing.T) {
+		clearRepo()
+		compileScenario(t, `
+		scenario("failingScenario", "My failing scenario", function () {
+			assert(false);
+		});`)
+		compileScenario(t, `
+		scenario("myScenario", "My scenario", function () {
+		});`)
+
+		compileDeluge(t, `
+		deluge("foo", "Some name", "300ms", {
+			"failingScenario": {
+				"concurrent": 2,
+				"delay": "10ms"
+			},
+			"myScenario": {
+				"concurrent": 2,
+				"delay": "10ms"
+			}
+		});`)
+
+		dlg, err := NewRunnableDeluge("foo")
+		require.NoError(t, err)
+		done := dlg.Run()
+		for {
+			dlg.Scenarios["failingScenario"].Mutex.Lock()
+			ended := dlg.Scenarios["failingScenario"].Status.IsEnd()
+			dlg.Scenarios["failingScenario"].Mutex.Unlock()
+			if ended {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		// Let the recorder of the failing scenario close
+		time.Sleep(20 * time.Millisecond)
+
+		snapshot, err := dlg.GetRecordsSnapshot()
+		require.NoError(t, err)
+		assert.Error(t, snapshot["failingScenario"].Err)
+		assert.NoError(t, snapshot["myScenario"].Err)
+		assert.NotNil(t, snapshot["myScenario"].HTTPRecordsOverTimeSnapshot)
+
+		<-done
+		_, err = dlg.GetRecordsSnapshot()
+		assert.Error(t, err)
+	})
+
 	t.Run("Error trying to modify args hash", func(t *testing.T) {
 		clearRepo()
 		compileScenario(t, `
@@ -402,18 +731,140 @@ func TestDeluge_Run_With_Errors(t *testing.T) {
 		assert.Equal(t, status.DelugeDoneError, dlg.runStatus)
 
 		assert.Equal(t, status.ScenarioDoneError, dlg.Scenarios["sc1"].Status)
-		require.Len(t, dlg.Scenarios["sc1"].Errors, 10)
+		require.Len(t, dlg.Scenarios["sc1"].Errors, 1)
 		assert.Equal(t, "hash is immutable, you cannot modify it", dlg.Scenarios["sc1"].Errors[0].Message)
+		assert.Equal(t, uint64(10), dlg.Scenarios["sc1"].Errors[0].Count)
 		assert.Equal(t, uint64(10), dlg.Scenarios["sc1"].EffectiveExecCount)
 		assert.Equal(t, uint64(10), dlg.Scenarios["sc1"].EffectiveUserCount)
 
 		assert.Equal(t, status.ScenarioDoneError, dlg.Scenarios["sc2"].Status)
-		require.Len(t, dlg.Scenarios["sc2"].Errors, 10)
+		require.Len(t, dlg.Scenarios["sc2"].Errors, 1)
 		assert.Equal(t, "hash is immutable, you cannot modify it", dlg.Scenarios["sc2"].Errors[0].Message)
+		assert.Equal(t, uint64(10), dlg.Scenarios["sc2"].Errors[0].Count)
 		assert.Equal(t, uint64(10), dlg.Scenarios["sc2"].EffectiveExecCount)
 		assert.Equal(t, uint64(10), dlg.Scenarios["sc2"].EffectiveUserCount)
 	})
 
+	t.Run("Continue on error", func(t *testing.T) {
+		clearRepo()
+		compileScenario(t, `
+		scenario("myScenario", "My scenario", function () {
+			assert(false);
+		});`)
+
+		compileDeluge(t, `
+		deluge("foo", "Some name", "100ms", {
+			"myScenario": {
+				"concurrent": 5,
+				"delay": "10ms",
+				"onError": "continue"
+			}
+		});`)
+
+		dlg, err := NewRunnableDeluge("foo")
+		require.NoError(t, err)
+
+		<-dlg.Run()
+
+		assertStatuses(t, dlg, status.DelugeVirgin, status.DelugeInProgress, status.DelugeDoneError)
+		scenario := dlg.Scenarios["myScenario"]
+		assert.Equal(t, uint64(5), scenario.EffectiveUserCount)
+		// Users went on with their next iterations
+		assert.True(t, scenario.EffectiveExecCount > 5, "%d executions", scenario.EffectiveExecCount)
+		require.Len(t, scenario.Errors, 1)
+		assert.Equal(t, "Assertion failed", scenario.Errors[0].Message)
+		assert.Equal(t, scenario.EffectiveExecCount, scenario.Errors[0].Count)
+	})
+
+	t.Run("Stop scenario on error", func(t *testing.T) {
+		clearRepo()
+		compileScenario(t, `
+		scenario("failingScenario", "My failing scenario", function (args, session) {
+			if (session["count"] == null) {
+				session["count"] = 0;
+			}
+			session["count"]++;
+			assert(session["count"] < 3);
+		});`)
+		compileScenario(t, `
+		scenario("otherScenario", "My other scenario", function () {
+		});`)
+
+		compileDeluge(t, `
+		deluge("foo", "Some name", "500ms", {
+			"failingScenario": {
+				"concurrent": 5,
+				"delay": "10ms",
+				"onError": "stopScenario"
+			},
+			"otherScenario": {
+				"concurrent": 1,
+				"delay": "100ms"
+			}
+		});`)
+
+		dlg, err := NewRunnableDeluge("foo")
+		require.NoError(t, err)
+
+		<-dlg.Run()
+
+		assertStatuses(t, dlg, status.DelugeVirgin, status.DelugeInProgress, status.DelugeDoneError)
+		failing := dlg.Scenarios["failingScenario"]
+		assert.Equal(t, status.ScenarioDoneError, failing.Status)
+		// Users stop once the first error occurred, at the latest at the end of their current iteration
+		assert.True(t, failing.EffectiveExecCount >= 11 && failing.EffectiveExecCount <= 15, "%d executions", failing.EffectiveExecCount)
+		require.Len(t, failing.Errors, 1)
+		assert.Equal(t, "Assertion failed", failing.Errors[0].Message)
+
+		other := dlg.Scenarios["otherScenario"]
+		assert.Equal(t, status.ScenarioDoneSuccess, other.Status)
+		assert.Equal(t, uint64(5), other.EffectiveExecCount)
+	})
+
+	t.Run("Abort deluge on error", func(t *testing.T) {
+		clearRepo()
+		compileScenario(t, `
+		scenario("failingScenario", "My failing scenario", function () {
+			assert(false);
+		});`)
+		compileScenario(t, `
+		scenario("otherScenario", "My other scenario", function () {
+		});`)
+
+		compileDeluge(t, `
+		deluge("foo", "Some name", "20s", {
+			"failingScenario": {
+				"concurrent": 1,
+				"delay": "10ms",
+				"onError": "abortDeluge"
+			},
+			"otherScenario": {
+				"concurrent": 1,
+				"delay": "10ms"
+			}
+		});`)
+
+		dlg, err := NewRunnableDeluge("foo")
+		require.NoError(t, err)
+
+		start := time.Now()
+		<-dlg.Run()
+		if time.Now().Sub(start).Seconds() > 10 {
+			t.Errorf("Looks like deluge was not aborted")
+		}
+
+		assertStatuses(t, dlg, status.DelugeVirgin, status.DelugeInProgress, status.DelugeAborted)
+		cause := dlg.GetAbortCause()
+		require.NotNil(t, cause)
+		assert.Equal(t, "failingScenario", cause.ScenarioID)
+		assert.Nil(t, cause.ThresholdResult)
+		require.NotNil(t, cause.Error)
+		assert.Equal(t, "Assertion failed", cause.Error.Message)
+
+		assert.Equal(t, status.ScenarioDoneError, dlg.Scenarios["failingScenario"].Status)
+		assert.Equal(t, status.ScenarioInterrupted, dlg.Scenarios["otherScenario"].Status)
+	})
+
 }
 
 func BenchmarkNewDeluge(b *testing.B) {
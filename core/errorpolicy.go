@@ -0,0 +1,88 @@
+package core
+
+import (
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/ofux/deluge/dsl/ast"
+	"github.com/ofux/deluge/dsl/evaluator"
+	"github.com/ofux/deluge/dsl/object"
+	"sync"
+	"time"
+)
+
+// maxScenarioErrors is the number of distinct errors kept by a scenario. Further distinct errors are only counted.
+const maxScenarioErrors = 100
+
+// errorPolicy is what a scenario does when an iteration of one of its users fails because of a script error, such as a
+// failed assertion. It is set by the 'onError' value of the configuration of the scenario.
+type errorPolicy int
+
+const (
+	// stopUserOnError stops the user, while the other users of the scenario go on. It is the default policy.
+	stopUserOnError errorPolicy = iota
+	// continueOnError starts the next iteration of the user as if nothing happened
+	continueOnError
+	// stopScenarioOnError stops all the users of the scenario once their current iteration ends
+	stopScenarioOnError
+	// abortDelugeOnError aborts the whole deluge
+	abortDelugeOnError
+)
+
+var errorPolicies = map[string]errorPolicy{
+	"continue":     continueOnError,
+	"stopUser":     stopUserOnError,
+	"stopScenario": stopScenarioOnError,
+	"abortDeluge":  abortDelugeOnError,
+}
+
+func parseErrorPolicy(node ast.Node, onErrorValue object.Object) (errorPolicy, *object.Error) {
+	onError, ok := onErrorValue.(*object.String)
+	if ok {
+		if policy, ok := errorPolicies[onError.Value]; ok {
+			return policy, nil
+		}
+	}
+	return stopUserOnError, evaluator.NewError(node, "Expected 'onError' value to be either \"continue\", \"stopUser\", \"stopScenario\" or \"abortDeluge\" in configuration at %s\n", ast.PrintLocation(node))
+}
+
+// errorAggregator groups the errors of the users of a scenario by message and location. It keeps at most
+// maxScenarioErrors groups. Its zero value is ready to use.
+type errorAggregator struct {
+	mut    sync.Mutex
+	groups map[string]*reporting.ErrorGroup
+	errors []*reporting.ErrorGroup
+	// dropped is the number of errors that were not kept because there were already too many groups
+	dropped uint64
+}
+
+// add records an occurrence of the given error, during or after the given request (empty if none). It returns true if
+// the error was not known yet.
+func (a *errorAggregator) add(err *object.Error, request string, at time.Time) bool {
+	key := reporting.ErrorKey(err)
+
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	if group, ok := a.groups[key]; ok {
+		group.Add(request, at)
+		return false
+	}
+	if len(a.errors) >= maxScenarioErrors {
+		a.dropped++
+		return false
+	}
+	if a.groups == nil {
+		a.groups = make(map[string]*reporting.ErrorGroup)
+	}
+	group := reporting.NewErrorGroup(err, request, at)
+	a.groups[key] = group
+	a.errors = append(a.errors, group)
+	return true
+}
+
+// get returns the groups of errors in the order they first occurred, and the number of errors that were not kept.
+func (a *errorAggregator) get() ([]*reporting.ErrorGroup, uint64) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	errs := make([]*reporting.ErrorGroup, len(a.errors))
+	copy(errs, a.errors)
+	return errs, a.dropped
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"fmt"
+	"github.com/ofux/deluge/dsl/object"
+	"github.com/ofux/deluge/dsl/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestErrorAggregator(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Group errors by message and location", func(t *testing.T) {
+		aggregator := &errorAggregator{}
+		atLine := func(message string, line int) *object.Error {
+			return &object.Error{Message: message, StackToken: []token.Token{{Type: token.IDENT, Literal: "assert", Line: line}}}
+		}
+
+		assert.True(t, aggregator.add(atLine("Assertion failed", 1), "foo", start))
+		assert.False(t, aggregator.add(atLine("Assertion failed", 1), "bar", start.Add(2*time.Second)))
+		assert.True(t, aggregator.add(atLine("Assertion failed", 2), "foo", start.Add(time.Second)))
+		assert.True(t, aggregator.add(atLine("Other error", 1), "", start.Add(time.Second)))
+		assert.False(t, aggregator.add(atLine("Assertion failed", 1), "foo", start.Add(time.Second)))
+
+		errs, dropped := aggregator.get()
+		assert.Equal(t, uint64(0), dropped)
+		require.Len(t, errs, 3)
+		assert.Equal(t, atLine("Assertion failed", 1), errs[0].Error)
+		assert.Equal(t, uint64(3), errs[0].Count)
+		assert.Equal(t, start, errs[0].FirstAt)
+		assert.Equal(t, start.Add(2*time.Second), errs[0].LastAt)
+		assert.Equal(t, []string{"bar", "foo"}, errs[0].Requests)
+		assert.Equal(t, atLine("Assertion failed", 2), errs[1].Error)
+		assert.Equal(t, uint64(1), errs[1].Count)
+		assert.Equal(t, "Other error", errs[2].Message)
+		assert.Equal(t, uint64(1), errs[2].Count)
+		assert.Empty(t, errs[2].Requests)
+	})
+
+	t.Run("Keep a limited number of errors", func(t *testing.T) {
+		aggregator := &errorAggregator{}
+		for i := 0; i < maxScenarioErrors+10; i++ {
+			aggregator.add(&object.Error{Message: fmt.Sprintf("error %d", i)}, "foo", start)
+		}
+		// Known errors are still counted
+		assert.False(t, aggregator.add(&object.Error{Message: "error 0"}, "foo", start))
+
+		errs, dropped := aggregator.get()
+		assert.Len(t, errs, maxScenarioErrors)
+		assert.Equal(t, uint64(10), dropped)
+		assert.Equal(t, uint64(2), errs[0].Count)
+	})
+}
@@ -0,0 +1,252 @@
+// Package eventlog writes every HTTP request of simulated users to a file, for post-mortems that need more than the
+// histograms of the reports.
+package eventlog
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+var (
+	// Dir is the directory event logs are written to, in a sub-directory per job
+	Dir = filepath.Join(os.TempDir(), "deluge-events")
+	// flushInterval is how often buffered events are written to the file, so that an event log can be read while the
+	// job runs
+	flushInterval = time.Second
+)
+
+// csvHeader is the first line of CSV event logs
+var csvHeader = []string{"time", "scenario", "user", "iteration", "request", "method", "url", "status", "latencyMs", "bytesSent", "bytesReceived", "error"}
+
+// Event is an HTTP request sent by a simulated user.
+type Event struct {
+	// Time is when the request was sent
+	Time      time.Time `json:"time"`
+	Scenario  string    `json:"scenario"`
+	User      string    `json:"user"`
+	Iteration int       `json:"iteration"`
+	Request   string    `json:"request"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	// Status is 0 if no response was received
+	Status        int     `json:"status"`
+	LatencyMs     float64 `json:"latencyMs"`
+	BytesSent     int64   `json:"bytesSent"`
+	BytesReceived int64   `json:"bytesReceived"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// ValidateFormat tells whether event logs can be written in the given format.
+func ValidateFormat(format string) error {
+	if format != FormatNDJSON && format != FormatCSV {
+		return errors.Errorf("unknown event log format '%s', expected %s or %s", format, FormatNDJSON, FormatCSV)
+	}
+	return nil
+}
+
+// Writer writes events to a file from a single goroutine.
+type Writer struct {
+	format string
+	file   *os.File
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	events chan *Event
+	done   chan struct{}
+	closed bool
+	mut    *sync.RWMutex
+	// err is the first error that occurred while writing
+	err error
+}
+
+// Create creates the event log of the share of a job run by a worker.
+func Create(jobID, workerID, format string) (*Writer, error) {
+	if err := ValidateFormat(format); err != nil {
+		return nil, err
+	}
+	jobDir := filepath.Join(Dir, encodeFileName(jobID))
+	if err := os.MkdirAll(jobDir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create event log directory of job %s", jobID)
+	}
+	file, err := os.Create(filepath.Join(jobDir, encodeFileName(workerID)+"."+format))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create event log of job %s", jobID)
+	}
+
+	w := &Writer{
+		format: format,
+		file:   file,
+		buf:    bufio.NewWriter(file),
+		events: make(chan *Event, 10000),
+		done:   make(chan struct{}),
+		mut:    &sync.RWMutex{},
+	}
+	if format == FormatCSV {
+		w.csv = csv.NewWriter(w.buf)
+		w.setErr(w.csv.Write(csvHeader))
+	}
+	go w.run()
+	return w, nil
+}
+
+// Log queues the event to be written. It blocks if the file cannot keep up, as events are never dropped.
+// Events logged once the writer is closed are ignored.
+func (w *Writer) Log(event *Event) {
+	w.mut.RLock()
+	defer w.mut.RUnlock()
+	if w.closed {
+		return
+	}
+	w.events <- event
+}
+
+// Close writes the queued events and closes the file.
+func (w *Writer) Close() error {
+	w.mut.Lock()
+	if w.closed {
+		w.mut.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.events)
+	w.mut.Unlock()
+
+	<-w.done
+	w.setErr(w.file.Close())
+	return w.err
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.events:
+			if !ok {
+				w.flush()
+				return
+			}
+			w.write(event)
+		case <-ticker.C:
+			w.flush()
+		}
+	}
+}
+
+func (w *Writer) write(event *Event) {
+	if w.format == FormatCSV {
+		w.setErr(w.csv.Write([]string{
+			event.Time.Format(time.RFC3339Nano),
+			event.Scenario,
+			event.User,
+			strconv.Itoa(event.Iteration),
+			event.Request,
+			event.Method,
+			event.URL,
+			strconv.Itoa(event.Status),
+			strconv.FormatFloat(event.LatencyMs, 'f', -1, 64),
+			strconv.FormatInt(event.BytesSent, 10),
+			strconv.FormatInt(event.BytesReceived, 10),
+			event.Error,
+		}))
+		return
+	}
+	// Encode adds the line break
+	w.setErr(json.NewEncoder(w.buf).Encode(event))
+}
+
+func (w *Writer) flush() {
+	if w.csv != nil {
+		w.csv.Flush()
+		w.setErr(w.csv.Error())
+	}
+	w.setErr(w.buf.Flush())
+}
+
+func (w *Writer) setErr(err error) {
+	if w.err == nil && err != nil {
+		w.err = errors.Wrap(err, "failed to write event log")
+	}
+}
+
+// OpenAll opens the event logs written by the workers of a job on this host, in the given format.
+// Readers must be closed.
+func OpenAll(jobID, format string) ([]io.ReadCloser, error) {
+	jobDir := filepath.Join(Dir, encodeFileName(jobID))
+	infos, err := ioutil.ReadDir(jobDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to read event logs of job %s", jobID)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	readers := make([]io.ReadCloser, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), "."+format) {
+			continue
+		}
+		file, err := os.Open(filepath.Join(jobDir, info.Name()))
+		if err != nil {
+			CloseAll(readers)
+			return nil, errors.Wrapf(err, "failed to open event log of job %s", jobID)
+		}
+		readers = append(readers, file)
+	}
+	return readers, nil
+}
+
+// encodeFileName escapes a job or worker ID so that it can safely be used as a file name, whatever the characters it
+// contains.
+func encodeFileName(name string) string {
+	encoded := url.PathEscape(name)
+	if strings.HasPrefix(encoded, ".") {
+		// Prevents "." and ".."
+		encoded = "%2E" + encoded[1:]
+	}
+	return encoded
+}
+
+// CloseAll closes the readers of event logs.
+func CloseAll(readers []io.ReadCloser) {
+	for _, r := range readers {
+		r.Close()
+	}
+}
+
+// Concat writes the event logs one after the other. The header of CSV event logs is only written once.
+func Concat(w io.Writer, format string, readers []io.ReadCloser) error {
+	for i, r := range readers {
+		br := bufio.NewReader(r)
+		if format == FormatCSV && i > 0 {
+			if _, err := br.ReadString('\n'); err != nil && err != io.EOF {
+				return err
+			}
+		}
+		if _, err := io.Copy(w, br); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package eventlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	at := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+	events := []*Event{
+		{Time: at, Scenario: "sc1", User: "0", Iteration: 1, Request: "get, home", Method: "GET", URL: "http://localhost/", Status: 200, LatencyMs: 12.5, BytesReceived: 42},
+		{Time: at, Scenario: "sc1", User: "1", Iteration: 1, Request: "post", Method: "POST", URL: "http://localhost/foo", LatencyMs: 3, BytesSent: 7, Error: "connection refused"},
+	}
+
+	t.Run("Write events as NDJSON", func(t *testing.T) {
+		defer useTempDir(t)()
+
+		w, err := Create("job1", "worker1", FormatNDJSON)
+		require.NoError(t, err)
+		for _, event := range events {
+			w.Log(event)
+		}
+		require.NoError(t, w.Close())
+		// Events logged once the writer is closed are ignored
+		w.Log(events[0])
+
+		content, err := ioutil.ReadFile(filepath.Join(Dir, "job1", "worker1.ndjson"))
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		require.Len(t, lines, 2)
+		for i, line := range lines {
+			event := &Event{}
+			require.NoError(t, json.Unmarshal([]byte(line), event))
+			assert.True(t, at.Equal(event.Time))
+			event.Time = events[i].Time
+			assert.Equal(t, events[i], event)
+		}
+		assert.Contains(t, lines[0], `"latencyMs":12.5`)
+		assert.NotContains(t, lines[0], `"error"`)
+	})
+
+	t.Run("Write events as CSV", func(t *testing.T) {
+		defer useTempDir(t)()
+
+		w, err := Create("job1", "worker1", FormatCSV)
+		require.NoError(t, err)
+		for _, event := range events {
+			w.Log(event)
+		}
+		require.NoError(t, w.Close())
+
+		content, err := ioutil.ReadFile(filepath.Join(Dir, "job1", "worker1.csv"))
+		require.NoError(t, err)
+		assert.Equal(t, "time,scenario,user,iteration,request,method,url,status,latencyMs,bytesSent,bytesReceived,error\n"+
+			"2020-01-02T03:04:05.000006Z,sc1,0,1,\"get, home\",GET,http://localhost/,200,12.5,0,42,\n"+
+			"2020-01-02T03:04:05.000006Z,sc1,1,1,post,POST,http://localhost/foo,0,3,7,0,connection refused\n", string(content))
+	})
+
+	t.Run("Flush events regularly", func(t *testing.T) {
+		defer useTempDir(t)()
+		previous := flushInterval
+		flushInterval = 10 * time.Millisecond
+		defer func() {
+			flushInterval = previous
+		}()
+
+		w, err := Create("job1", "worker1", FormatNDJSON)
+		require.NoError(t, err)
+		defer w.Close()
+		w.Log(events[0])
+
+		path := filepath.Join(Dir, "job1", "worker1.ndjson")
+		var content []byte
+		for wait := 0 * time.Millisecond; wait < time.Second && len(content) == 0; wait += 10 * time.Millisecond {
+			time.Sleep(10 * time.Millisecond)
+			content, err = ioutil.ReadFile(path)
+			require.NoError(t, err)
+		}
+		assert.NotEmpty(t, content)
+	})
+
+	t.Run("Fail to create an event log with unknown format", func(t *testing.T) {
+		defer useTempDir(t)()
+
+		_, err := Create("job1", "worker1", "xml")
+		assert.EqualError(t, err, "unknown event log format 'xml', expected ndjson or csv")
+	})
+}
+
+func TestOpenAll(t *testing.T) {
+	t.Run("Concat the CSV event logs of a job", func(t *testing.T) {
+		defer useTempDir(t)()
+		event := &Event{Time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), Scenario: "sc1", User: "0", Request: "foo", Method: "GET", URL: "http://localhost/", Status: 200, LatencyMs: 1}
+		for _, workerID := range []string{"worker1", "worker2"} {
+			w, err := Create("job1", workerID, FormatCSV)
+			require.NoError(t, err)
+			w.Log(event)
+			require.NoError(t, w.Close())
+		}
+		w, err := Create("job1", "worker3", FormatNDJSON)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		readers, err := OpenAll("job1", FormatCSV)
+		require.NoError(t, err)
+		defer CloseAll(readers)
+		require.Len(t, readers, 2)
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, Concat(buf, FormatCSV, readers))
+		const line = "2020-01-02T03:04:05Z,sc1,0,0,foo,GET,http://localhost/,200,1,0,0,\n"
+		assert.Equal(t, "time,scenario,user,iteration,request,method,url,status,latencyMs,bytesSent,bytesReceived,error\n"+line+line, buf.String())
+	})
+
+	t.Run("Keep the event logs of a job with a path in its ID in the event log directory", func(t *testing.T) {
+		defer useTempDir(t)()
+
+		w, err := Create("../job1", "../worker1", FormatNDJSON)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		_, err = os.Stat(filepath.Join(Dir, "%2E.%2Fjob1", "%2E.%2Fworker1.ndjson"))
+		assert.NoError(t, err)
+		_, err = os.Stat(filepath.Join(filepath.Dir(Dir), "job1"))
+		assert.True(t, os.IsNotExist(err))
+
+		readers, err := OpenAll("../job1", FormatNDJSON)
+		require.NoError(t, err)
+		defer CloseAll(readers)
+		assert.Len(t, readers, 1)
+
+		readers, err = OpenAll("..", FormatNDJSON)
+		require.NoError(t, err)
+		assert.Empty(t, readers)
+	})
+
+	t.Run("Open the event logs of a job without any", func(t *testing.T) {
+		defer useTempDir(t)()
+
+		readers, err := OpenAll("unknown", FormatCSV)
+		require.NoError(t, err)
+		assert.Empty(t, readers)
+	})
+}
+
+// useTempDir makes event logs be written in a temporary directory, until the returned function is called
+func useTempDir(t *testing.T) func() {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "deluge-events")
+	require.NoError(t, err)
+	previous := Dir
+	Dir = dir
+	return func() {
+		Dir = previous
+		os.RemoveAll(dir)
+	}
+}
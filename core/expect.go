@@ -0,0 +1,128 @@
+package core
+
+import (
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/dsl/ast"
+	"github.com/ofux/deluge/dsl/evaluator"
+	"github.com/ofux/deluge/dsl/object"
+	"strings"
+)
+
+// httpExpectation decides whether the response of a request is a success, instead of its status code being below 400.
+// It is given by the 'expect' field of a request: either an object of criteria that must all be met, or a function
+// that is given the response and returns a boolean.
+type httpExpectation struct {
+	// statuses are the expected status codes. Status codes below 400 are expected if it is empty.
+	statuses     []int64
+	bodyContains *string
+	callback     object.Object
+}
+
+// parseHTTPExpectation returns nil if the request has no 'expect' field.
+func parseHTTPExpectation(node ast.Node, reqObj *object.Hash) (*httpExpectation, *object.Error) {
+	expectValue, ok := reqObj.Get("expect")
+	if !ok {
+		return nil, nil
+	}
+
+	switch expect := expectValue.(type) {
+	case *object.Function, *object.Builtin:
+		return &httpExpectation{callback: expect}, nil
+	case *object.Hash:
+		expectation := &httpExpectation{}
+		for key := range expect.Pairs {
+			if key != "status" && key != "bodyContains" {
+				return nil, evaluator.NewError(node, "invalid HTTP request: unknown expectation '%s'", key)
+			}
+		}
+		if statusValue, ok := expect.Get("status"); ok {
+			statuses, ok := parseExpectedStatuses(statusValue)
+			if !ok {
+				return nil, evaluator.NewError(node, "invalid HTTP request: expected status should be an integer or an array of integers")
+			}
+			expectation.statuses = statuses
+		}
+		if bodyContains, ok, err := expect.GetAsString("bodyContains"); ok {
+			if err != nil {
+				return nil, evaluator.NewError(node, "invalid HTTP request: %s", err.Error())
+			}
+			expectation.bodyContains = &bodyContains.Value
+		}
+		return expectation, nil
+	default:
+		return nil, evaluator.NewError(node, "invalid HTTP request: 'expect' should be of type %s or %s but was %s", object.HASH_OBJ, object.FUNCTION_OBJ, expectValue.Type())
+	}
+}
+
+func parseExpectedStatuses(statusValue object.Object) ([]int64, bool) {
+	switch status := statusValue.(type) {
+	case *object.Integer:
+		return []int64{status.Value}, true
+	case *object.Array:
+		statuses := make([]int64, 0, len(status.Elements))
+		for _, element := range status.Elements {
+			integer, ok := element.(*object.Integer)
+			if !ok {
+				return nil, false
+			}
+			statuses = append(statuses, integer.Value)
+		}
+		return statuses, true
+	}
+	return nil, false
+}
+
+// evalExpectation tells whether the response meets the expectation. The result is empty if there is no expectation,
+// and KO if the response could not be read. An error is returned if the callback of the expectation fails.
+func (su *simUser) evalExpectation(node ast.Node, expectation *httpExpectation, statusCode int, resObj object.Object) (recording.OkKo, *object.Error) {
+	if expectation == nil {
+		return "", nil
+	}
+	res, ok := resObj.(*object.Hash)
+	if !ok {
+		return recording.Ko, nil
+	}
+
+	if expectation.callback != nil {
+		evaluated := su.evaluator.ApplyFunction(node, expectation.callback, res)
+		if errObj, ok := evaluated.(*object.Error); ok {
+			return recording.Ko, errObj
+		}
+		success, ok := evaluated.(*object.Boolean)
+		if !ok {
+			returnedType := evaluator.NULL.Type()
+			if evaluated != nil {
+				returnedType = evaluated.Type()
+			}
+			return recording.Ko, evaluator.NewError(node, "the 'expect' function of an HTTP request should return a %s but returned %s", object.BOOLEAN_OBJ, returnedType)
+		}
+		if success.Value {
+			return recording.Ok, nil
+		}
+		return recording.Ko, nil
+	}
+
+	if len(expectation.statuses) > 0 {
+		if !containsStatus(expectation.statuses, statusCode) {
+			return recording.Ko, nil
+		}
+	} else if statusCode >= 400 {
+		return recording.Ko, nil
+	}
+	if expectation.bodyContains != nil {
+		body, _, err := res.GetAsString("body")
+		if err != nil || !strings.Contains(body.Value, *expectation.bodyContains) {
+			return recording.Ko, nil
+		}
+	}
+	return recording.Ok, nil
+}
+
+func containsStatus(statuses []int64, statusCode int) bool {
+	for _, status := range statuses {
+		if status == int64(statusCode) {
+			return true
+		}
+	}
+	return false
+}
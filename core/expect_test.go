@@ -0,0 +1,126 @@
+package core
+
+import (
+	"github.com/ofux/deluge/core/recording"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// checkOkKo checks the number of OK and KO calls recorded by the user.
+func checkOkKo(t *testing.T, su *simUser, okCount, koCount int64) {
+	su.httpRecorder.Close()
+	records, err := su.httpRecorder.GetRecords()
+	require.NoError(t, err)
+	var actualOk, actualKo int64
+	if h, ok := records.Global.PerOkKo[recording.Ok]; ok {
+		actualOk = h.TotalCount()
+	}
+	if h, ok := records.Global.PerOkKo[recording.Ko]; ok {
+		actualKo = h.TotalCount()
+	}
+	assert.Equal(t, okCount, actualOk, "OK calls")
+	assert.Equal(t, koCount, actualKo, "KO calls")
+}
+
+func TestSimUser_HTTPExpectations(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/failure":
+			_, _ = w.Write([]byte(`{"error": "out of stock"}`))
+		case "/crash":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"id": 42}`))
+		default:
+			_, _ = w.Write([]byte(`{"id": 42}`))
+		}
+	}))
+	defer ts.Close()
+
+	t.Run("Status code below 400 without expectation", func(t *testing.T) {
+		su := NewSimUserTest(t, `
+		http("foo", {"url": "`+ts.URL+`/missing"});
+		http("foo", {"url": "`+ts.URL+`/failure"});
+		`)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+		checkOkKo(t, su, 1, 1)
+	})
+
+	t.Run("Expected status codes", func(t *testing.T) {
+		su := NewSimUserTest(t, `
+		http("foo", {"url": "`+ts.URL+`/missing", "expect": {"status": [200, 404]}});
+		http("foo", {"url": "`+ts.URL+`/missing", "expect": {"status": 404}});
+		http("foo", {"url": "`+ts.URL+`/ok", "expect": {"status": 201}});
+		`)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+		checkOkKo(t, su, 2, 1)
+	})
+
+	t.Run("Expected body", func(t *testing.T) {
+		su := NewSimUserTest(t, `
+		http("foo", {"url": "`+ts.URL+`/ok", "expect": {"status": 200, "bodyContains": "\"id\""}});
+		http("foo", {"url": "`+ts.URL+`/failure", "expect": {"status": 200, "bodyContains": "\"id\""}});
+		`)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+		checkOkKo(t, su, 1, 1)
+	})
+
+	t.Run("Expected body without expected status codes", func(t *testing.T) {
+		su := NewSimUserTest(t, `
+		http("foo", {"url": "`+ts.URL+`/ok", "expect": {"bodyContains": "\"id\""}});
+		http("foo", {"url": "`+ts.URL+`/crash", "expect": {"bodyContains": "\"id\""}});
+		`)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+		checkOkKo(t, su, 1, 1)
+	})
+
+	t.Run("Expectation function", func(t *testing.T) {
+		su := NewSimUserTest(t, `
+		let noError = function (res) {
+			return (res["status"] == 200) && (parseJson(res["body"])["error"] == null);
+		};
+		let res = http("foo", {"url": "`+ts.URL+`/failure", "expect": noError});
+		assert(res["status"] == 200);
+		http("foo", {"url": "`+ts.URL+`/ok", "expect": noError});
+		`)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+		checkOkKo(t, su, 1, 1)
+	})
+
+	t.Run("Expectation function not returning a boolean", func(t *testing.T) {
+		su := NewSimUserTest(t, `
+		http("foo", {"url": "`+ts.URL+`/ok", "expect": function (res) { return res["status"]; }});
+		`)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneError)
+		checkSimUserError(t, su, "the 'expect' function of an HTTP request should return a BOOLEAN but returned INTEGER")
+		checkOkKo(t, su, 0, 1)
+	})
+
+	t.Run("Bad expectations", func(t *testing.T) {
+		for expect, expectedErr := range map[string]string{
+			`200`:                    "invalid HTTP request: 'expect' should be of type HASH or FUNCTION but was INTEGER",
+			`{"statusCode": 200}`:    "invalid HTTP request: unknown expectation 'statusCode'",
+			`{"status": "200"}`:      "invalid HTTP request: expected status should be an integer or an array of integers",
+			`{"status": [200, "x"]}`: "invalid HTTP request: expected status should be an integer or an array of integers",
+			`{"bodyContains": 42}`:   "invalid HTTP request: 'bodyContains' should be of type STRING but was INTEGER",
+		} {
+			su := NewSimUserTest(t, `
+			http("foo", {"url": "`+ts.URL+`/ok", "expect": `+expect+`});
+			`)
+			su.run(0)
+			checkSimUserStatus(t, su, UserDoneError)
+			checkSimUserError(t, su, expectedErr)
+			checkOkKo(t, su, 0, 0)
+		}
+	})
+}
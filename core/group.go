@@ -0,0 +1,63 @@
+package core
+
+import (
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/dsl/ast"
+	"github.com/ofux/deluge/dsl/evaluator"
+	"github.com/ofux/deluge/dsl/object"
+	"sync/atomic"
+	"time"
+)
+
+// execGroup is the implementation of the built-in function 'group' that calls a function of the script and records
+// its duration under the given name, with and without the pauses it made. It returns what the function returns.
+// The group is KO if the function fails, or if one of the requests it performed is KO.
+func (su *simUser) execGroup(node ast.Node, args ...object.Object) object.Object {
+	if oErr := evaluator.AssertArgsType(node, args, object.STRING_OBJ, object.FUNCTION_OBJ); oErr != nil {
+		return oErr
+	}
+	groupName := args[0].(*object.String).Value
+
+	result := recording.Ko
+	koRequestCount := su.koRequestCount
+	pauseDuration := su.pauseDuration
+	start := time.Now()
+	// The group is recorded even if the function is interrupted, by a failed assertion for instance
+	defer func() {
+		duration := time.Since(start)
+		if su.koRequestCount > koRequestCount {
+			result = recording.Ko
+		}
+		unit := su.httpRecorder.Unit()
+		su.httpRecorder.Record(&recording.GroupRecordEntry{
+			Iteration:          su.iteration,
+			Stage:              su.stage,
+			Users:              int(atomic.LoadInt64(&su.scenario.activeUserCount)),
+			Name:               groupName,
+			Value:              unit.FromDuration(duration),
+			ValueWithoutPauses: unit.FromDuration(duration - (su.pauseDuration - pauseDuration)),
+			Result:             result,
+		})
+	}()
+
+	evaluated := su.evaluator.ApplyFunction(node, args[1])
+	if evaluator.IsError(evaluated) {
+		return evaluated
+	}
+	result = recording.Ok
+	if evaluated == nil {
+		return evaluator.NULL
+	}
+	return evaluated
+}
+
+// execPause is the built-in function 'pause' of the users. It keeps track of the time spent in pauses, so that groups
+// can be timed without them.
+func (su *simUser) execPause(node ast.Node, args ...object.Object) object.Object {
+	pause, _ := evaluator.GlobalBuiltin("pause")
+	start := time.Now()
+	defer func() {
+		su.pauseDuration += time.Since(start)
+	}()
+	return pause.Fn(node, args...)
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"github.com/ofux/deluge/core/recording"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSimUser_Group(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ko" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	getGroups := func(t *testing.T, su *simUser) map[string]*recording.GroupRecord {
+		su.httpRecorder.Close()
+		records, err := su.httpRecorder.GetRecords()
+		require.NoError(t, err)
+		// Groups are recorded apart from requests
+		assert.Len(t, records.Global.PerGroups, len(records.OverTime[0].PerGroups))
+		return records.Global.PerGroups
+	}
+
+	t.Run("Record the duration of a group with and without pauses", func(t *testing.T) {
+		su := NewSimUserTest(t, `
+		let res = group("Checkout", function () {
+			http("cart", {"url": "`+ts.URL+`/cart"});
+			pause("50ms");
+			let res = http("pay", {"url": "`+ts.URL+`/pay"});
+			return res["status"];
+		});
+		assert(res == 200);
+		`)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+
+		groups := getGroups(t, su)
+		require.Len(t, groups, 1)
+		checkout := groups["Checkout"]
+		require.NotNil(t, checkout)
+		assert.Equal(t, int64(1), checkout.PerOkKo[recording.Ok].TotalCount())
+		assert.True(t, checkout.Global.Max() >= 50, "the group should last at least its pause, got %dms", checkout.Global.Max())
+		assert.True(t, checkout.WithoutPauses.Max() < 50, "the group without pauses should last less than its pause, got %dms", checkout.WithoutPauses.Max())
+	})
+
+	t.Run("A group with a KO request is KO", func(t *testing.T) {
+		su := NewSimUserTest(t, `
+		group("Checkout", function () {
+			http("cart", {"url": "`+ts.URL+`/ko"});
+		});
+		group("Browse", function () {
+			http("home", {"url": "`+ts.URL+`/home"});
+		});
+		`)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+
+		groups := getGroups(t, su)
+		require.Len(t, groups, 2)
+		assert.Equal(t, int64(1), groups["Checkout"].PerOkKo[recording.Ko].TotalCount())
+		assert.Nil(t, groups["Checkout"].PerOkKo[recording.Ok])
+		assert.Equal(t, int64(1), groups["Browse"].PerOkKo[recording.Ok].TotalCount())
+	})
+
+	t.Run("A group that fails is KO", func(t *testing.T) {
+		su := NewSimUserTest(t, `
+		group("Checkout", function () {
+			http("cart", {"url": "`+ts.URL+`/cart"});
+			assert(false);
+		});
+		`)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneError)
+		checkSimUserError(t, su, "Assertion failed")
+
+		groups := getGroups(t, su)
+		require.Len(t, groups, 1)
+		assert.Equal(t, int64(1), groups["Checkout"].PerOkKo[recording.Ko].TotalCount())
+	})
+
+	t.Run("Bad group arguments", func(t *testing.T) {
+		su := NewSimUserTest(t, `
+		group("Checkout", "not a function");
+		`)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneError)
+		checkSimUserError(t, su, "wrong type of argument n°2. got=STRING, want=FUNCTION")
+	})
+}
@@ -1,14 +1,22 @@
 package core
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/ofux/deluge/core/eventlog"
 	"github.com/ofux/deluge/core/recording"
 	"github.com/ofux/deluge/dsl/ast"
 	"github.com/ofux/deluge/dsl/evaluator"
 	"github.com/ofux/deluge/dsl/object"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -20,11 +28,19 @@ func (su *simUser) execHTTPRequest(node ast.Node, args ...object.Object) object.
 	}
 	reqName := args[0].(*object.String).Value
 	reqObj := args[1].(*object.Hash)
+	su.lastRequest = reqName
 
 	req, errObj := createRequest(node, reqObj)
 	if evaluator.IsError(errObj) {
 		return errObj
 	}
+	expectation, errObj := parseHTTPExpectation(node, reqObj)
+	if errObj != nil {
+		return errObj
+	}
+
+	timings := newHTTPTimings()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), timings.trace()))
 
 	su.log.Debugf("Performing HTTP request: %s %s", req.Method, req.URL.String())
 	start := time.Now()
@@ -33,20 +49,104 @@ func (su *simUser) execHTTPRequest(node ast.Node, args ...object.Object) object.
 
 	if err != nil {
 		su.log.Debugf("Request error: %s", err.Error())
-		return evaluator.NewError(node, err.Error())
+		su.logHTTPEvent(reqName, req, start, end.Sub(start), 0, 0, err.Error())
+		category, ok := classifyTransportError(err)
+		if !ok {
+			return evaluator.NewError(node, err.Error())
+		}
+		// The request is a KO sample, and the user goes on with a response that has no status
+		unit := su.httpRecorder.Unit()
+		su.recordHTTPRequest(&recording.HTTPRecordEntry{
+			Iteration: su.iteration,
+			Stage:     su.stage,
+			Users:     int(atomic.LoadInt64(&su.scenario.activeUserCount)),
+			Name:      reqName,
+			Value:     unit.FromDuration(end.Sub(start)),
+			Phases:    timings.phases(unit),
+			Error:     category,
+		})
+		return getFailureObject(category, err, timings)
 	}
 	defer res.Body.Close()
 
 	duration := end.Sub(start)
 	su.log.Debugf("Response status: %s in %s", "res.Status", duration.String())
-	su.httpRecorder.Record(&recording.HTTPRecordEntry{
+
+	// The body is read before recording, so that its transfer is part of the timings
+	body := &countingReadCloser{ReadCloser: res.Body}
+	res.Body = body
+	resObj := getResponseObject(node, res, timings)
+	result, expectErr := su.evalExpectation(node, expectation, res.StatusCode, resObj)
+
+	unit := su.httpRecorder.Unit()
+	su.recordHTTPRequest(&recording.HTTPRecordEntry{
 		Iteration:  su.iteration,
+		Stage:      su.stage,
+		Users:      int(atomic.LoadInt64(&su.scenario.activeUserCount)),
 		Name:       reqName,
-		Value:      recording.NanosecondToHistogramTime(duration.Nanoseconds()),
+		Value:      unit.FromDuration(duration),
 		StatusCode: res.StatusCode,
+		Phases:     timings.phases(unit),
+		Result:     result,
 	})
+	errMessage := ""
+	if errObj, ok := resObj.(*object.Error); ok {
+		errMessage = errObj.Message
+	}
+	if expectErr != nil && errMessage == "" {
+		errMessage = expectErr.Message
+	}
+	su.logHTTPEvent(reqName, req, start, duration, res.StatusCode, body.count, errMessage)
 
-	return getResponseObject(node, res)
+	if expectErr != nil {
+		return expectErr
+	}
+	return resObj
+}
+
+// recordHTTPRequest records the request and counts it if it is KO, so that the groups it belongs to are KO too.
+func (su *simUser) recordHTTPRequest(rec *recording.HTTPRecordEntry) {
+	if rec.OkKo() == recording.Ko {
+		su.koRequestCount++
+	}
+	su.httpRecorder.Record(rec)
+}
+
+// logHTTPEvent writes the request to the event log of the scenario, if it has one.
+func (su *simUser) logHTTPEvent(reqName string, req *http.Request, start time.Time, duration time.Duration, statusCode int, bytesReceived int64, errMessage string) {
+	if su.scenario.eventLog == nil {
+		return
+	}
+	var bytesSent int64
+	if req.ContentLength > 0 {
+		bytesSent = req.ContentLength
+	}
+	su.scenario.eventLog.Log(&eventlog.Event{
+		Time:          start,
+		Scenario:      su.scenario.compiledScenario.scenario.ID,
+		User:          su.name,
+		Iteration:     su.iteration,
+		Request:       reqName,
+		Method:        req.Method,
+		URL:           req.URL.String(),
+		Status:        statusCode,
+		LatencyMs:     float64(duration.Nanoseconds()) / float64(time.Millisecond),
+		BytesSent:     bytesSent,
+		BytesReceived: bytesReceived,
+		Error:         errMessage,
+	})
+}
+
+// countingReadCloser counts the bytes read from the underlying reader
+type countingReadCloser struct {
+	io.ReadCloser
+	count int64
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.count += int64(n)
+	return n, err
 }
 
 func createRequest(node ast.Node, reqObj *object.Hash) (*http.Request, *object.Error) {
@@ -97,13 +197,14 @@ func createRequest(node ast.Node, reqObj *object.Hash) (*http.Request, *object.E
 	return req, nil
 }
 
-func getResponseObject(node ast.Node, res *http.Response) object.Object {
+func getResponseObject(node ast.Node, res *http.Response, timings *httpTimings) object.Object {
 	resHeaders := getResponseHeaders(res)
 	if evaluator.IsError(resHeaders) {
 		return resHeaders
 	}
 
 	resBody := getResponseBody(node, res)
+	timings.bodyRead(time.Now())
 	if evaluator.IsError(resBody) {
 		return resBody
 	}
@@ -113,11 +214,72 @@ func getResponseObject(node ast.Node, res *http.Response) object.Object {
 			object.HashKey("status"):  &object.Integer{Value: int64(res.StatusCode)},
 			object.HashKey("headers"): resHeaders,
 			object.HashKey("body"):    resBody,
+			object.HashKey("timings"): timings.toObject(),
+		},
+		IsImmutable: true,
+	}
+}
+
+// getFailureObject returns the response of a request that failed without response. Its status is 0 and its 'error'
+// gives the category and the message of the failure.
+func getFailureObject(category recording.ErrorCategory, err error, timings *httpTimings) object.Object {
+	return &object.Hash{
+		Pairs: map[object.HashKey]object.Object{
+			object.HashKey("status"): &object.Integer{Value: 0},
+			object.HashKey("headers"): &object.Hash{
+				Pairs:       map[object.HashKey]object.Object{},
+				IsImmutable: true,
+			},
+			object.HashKey("body"):    &object.String{Value: ""},
+			object.HashKey("timings"): timings.toObject(),
+			object.HashKey("error"): &object.Hash{
+				Pairs: map[object.HashKey]object.Object{
+					object.HashKey("category"): &object.String{Value: string(category)},
+					object.HashKey("message"):  &object.String{Value: err.Error()},
+				},
+				IsImmutable: true,
+			},
 		},
 		IsImmutable: true,
 	}
 }
 
+// classifyTransportError tells the category of an error of the HTTP client. It returns false for errors that are
+// not network failures, such as an invalid URL or too many redirects: those are errors of the script.
+func classifyTransportError(err error) (recording.ErrorCategory, bool) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return recording.ErrorTimeout, true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return recording.ErrorDNS, true
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return recording.ErrorRefused, true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return recording.ErrorReset, true
+	}
+	var (
+		unknownAuthorityErr x509.UnknownAuthorityError
+		certificateErr      x509.CertificateInvalidError
+		hostnameErr         x509.HostnameError
+		recordHeaderErr     tls.RecordHeaderError
+	)
+	if errors.As(err, &unknownAuthorityErr) || errors.As(err, &certificateErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &recordHeaderErr) ||
+		strings.Contains(err.Error(), "tls: ") {
+		return recording.ErrorTLS, true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return recording.ErrorOther, true
+	}
+	return "", false
+}
+
 func getResponseHeaders(res *http.Response) object.Object {
 	resHeaders := make(map[object.HashKey]object.Object)
 	for k := range res.Header {
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"github.com/ofux/deluge/core/recording"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
@@ -46,6 +47,42 @@ func TestSimUser_ExecHTTPRequest(t *testing.T) {
 		assert.Equal(t, 1, callCount)
 	})
 
+	t.Run("HTTP request timings", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := w.Write([]byte("response body"))
+			require.NoError(t, err)
+		}))
+		defer ts.Close()
+
+		const reqName = "Some request"
+
+		su := NewSimUserTest(t, `
+		let res = http("`+reqName+`", {
+			"url": "`+ts.URL+`"
+		});
+
+		let timings = res["timings"];
+		assert(timings["dns"] == 0.0);
+		assert(timings["connect"] > 0.0);
+		assert(timings["tls"] == 0.0);
+		assert(timings["ttfb"] > 0.0);
+		assert(timings["transfer"] >= 0.0);
+		`)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+		checkRecords(t, su.httpRecorder, reqName, 1)
+
+		// The host is an IP address reached over plain HTTP, so there is neither DNS lookup nor TLS handshake to record
+		records, err := su.httpRecorder.GetRecords()
+		require.NoError(t, err)
+		perPhase := records.OverTime[0].PerRequests[reqName].PerPhase
+		require.Len(t, perPhase, 3)
+		for _, phase := range []recording.HTTPPhase{recording.PhaseConnect, recording.PhaseTTFB, recording.PhaseTransfer} {
+			assert.Equal(t, int64(1), perPhase[phase].TotalCount(), "phase %s", phase)
+		}
+		assert.Len(t, records.Global.PerPhase, 3)
+	})
+
 	t.Run("HTTP DELETE request", func(t *testing.T) {
 		callCount := 0
 		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -251,3 +288,69 @@ func TestSimUser_ExecHTTPRequest(t *testing.T) {
 		checkSimUserError(t, su, "invalid HTTP header 'foo': should be of type STRING but was INTEGER")
 	})
 }
+
+func TestSimUser_TransportFailures(t *testing.T) {
+	// closedURL is the URL of a server that is not listening anymore
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closedURL := ts.URL
+	ts.Close()
+
+	t.Run("Connection refused", func(t *testing.T) {
+		su := NewSimUserTest(t, `
+		let res = http("foo", {"url": "`+closedURL+`"});
+		assert(res["status"] == 0);
+		assert(res["body"] == "");
+		assert(res["error"]["category"] == "refused");
+		assert(len(res["error"]["message"]) > 0);
+		http("foo", {"url": "`+closedURL+`"});
+		`)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+
+		su.httpRecorder.Close()
+		records, err := su.httpRecorder.GetRecords()
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), records.Global.Global.TotalCount())
+		assert.Equal(t, int64(2), records.Global.PerOkKo[recording.Ko].TotalCount())
+		assert.Empty(t, records.Global.PerStatus)
+		assert.Equal(t, int64(2), records.Global.PerError[recording.ErrorRefused].TotalCount())
+		assert.Equal(t, int64(2), records.Global.PerRequests["foo"].PerError[recording.ErrorRefused].TotalCount())
+	})
+
+	t.Run("Connection reset", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			conn.Close()
+		}))
+		defer ts.Close()
+
+		su := NewSimUserTest(t, `
+		let res = http("foo", {"url": "`+ts.URL+`"});
+		assert(res["error"]["category"] == "reset");
+		`)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+	})
+
+	t.Run("DNS failure", func(t *testing.T) {
+		su := NewSimUserTest(t, `
+		let res = http("foo", {"url": "http://deluge.invalid"});
+		assert(res["error"]["category"] == "dns");
+		`)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+	})
+
+	t.Run("Successful responses have no error", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer ts.Close()
+
+		su := NewSimUserTest(t, `
+		let res = http("foo", {"url": "`+ts.URL+`"});
+		assert(res["error"] == null);
+		`)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+	})
+}
@@ -0,0 +1,288 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/ofux/deluge/cleanhttp"
+	"github.com/ofux/deluge/dsl/ast"
+	"github.com/ofux/deluge/dsl/evaluator"
+	"github.com/ofux/deluge/dsl/object"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// httpClientConfig configures the HTTP client of each user of a scenario. The zero value gives the default client:
+// no keep-alive, no connection reused across iterations, and the redirect, proxy and TLS settings of Go.
+type httpClientConfig struct {
+	keepAlive bool
+	// reuseConnections keeps the idle connections of a user from one iteration to the next, like a browser does.
+	// It requires keepAlive.
+	reuseConnections bool
+	// maxConnsPerHost limits the connections of a user to a host (0 means no limit)
+	maxConnsPerHost int
+	// timeout is the time limit of a request, including redirects and the reading of the response body
+	timeout               time.Duration
+	connectTimeout        time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	idleConnTimeout       time.Duration
+	// maxRedirects is the number of redirects followed before giving up. Redirects are not followed if it is 0, and
+	// Go's default (10) is used if it is negative.
+	maxRedirects int
+	// proxy is the proxy of every request. The proxy of the environment is used if it is nil.
+	proxy *url.URL
+	// tlsConfig is nil if the scenario has no TLS setting
+	tlsConfig *tls.Config
+	// http2 is nil to keep Go's default, which only uses HTTP/2 without TLS setting
+	http2 *bool
+}
+
+var httpClientConfigKeys = map[string]bool{
+	"keepAlive":             true,
+	"reuseConnections":      true,
+	"maxConnsPerHost":       true,
+	"timeout":               true,
+	"connectTimeout":        true,
+	"tlsHandshakeTimeout":   true,
+	"responseHeaderTimeout": true,
+	"idleConnTimeout":       true,
+	"maxRedirects":          true,
+	"proxy":                 true,
+	"tls":                   true,
+	"http2":                 true,
+}
+
+var tlsConfigKeys = map[string]bool{
+	"insecureSkipVerify": true,
+	"serverName":         true,
+	"ca":                 true,
+	"cert":               true,
+	"key":                true,
+}
+
+// newClient creates the HTTP client of a user. A nil config gives the default client.
+func (c *httpClientConfig) newClient() *http.Client {
+	if c == nil {
+		return cleanhttp.DefaultClient()
+	}
+
+	var transport *http.Transport
+	if c.keepAlive {
+		transport = cleanhttp.DefaultPooledTransport()
+	} else {
+		transport = cleanhttp.DefaultTransport()
+	}
+	transport.MaxConnsPerHost = c.maxConnsPerHost
+	if c.maxConnsPerHost > 0 && c.keepAlive {
+		transport.MaxIdleConnsPerHost = c.maxConnsPerHost
+	}
+	if c.connectTimeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   c.connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	if c.tlsHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = c.tlsHandshakeTimeout
+	}
+	if c.responseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = c.responseHeaderTimeout
+	}
+	if c.idleConnTimeout > 0 {
+		transport.IdleConnTimeout = c.idleConnTimeout
+	}
+	if c.proxy != nil {
+		transport.Proxy = http.ProxyURL(c.proxy)
+	}
+	if c.tlsConfig != nil {
+		transport.TLSClientConfig = c.tlsConfig.Clone()
+	}
+	if c.http2 != nil {
+		if *c.http2 {
+			transport.ForceAttemptHTTP2 = true
+		} else {
+			// A non-nil empty map disables HTTP/2
+			transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		}
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   c.timeout,
+	}
+	if c.maxRedirects >= 0 {
+		maxRedirects := c.maxRedirects
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if maxRedirects == 0 {
+				return http.ErrUseLastResponse
+			}
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		}
+	}
+	return client
+}
+
+// closesIdleConnections tells whether users close their idle connections at the end of each iteration, so that
+// every iteration opens new connections.
+func (c *httpClientConfig) closesIdleConnections() bool {
+	return c == nil || !c.reuseConnections
+}
+
+func parseHTTPClientConfig(node ast.Node, httpHashValue object.Object) (*httpClientConfig, *object.Error) {
+	httpHash, ok := httpHashValue.(*object.Hash)
+	if !ok {
+		return nil, evaluator.NewError(node, "Expected 'http' value to be an object in configuration at %s\n", ast.PrintLocation(node))
+	}
+	if errObj := checkKeys(node, "http", httpHash, httpClientConfigKeys); errObj != nil {
+		return nil, errObj
+	}
+
+	conf := &httpClientConfig{maxRedirects: -1}
+	for key, dest := range map[string]*bool{
+		"keepAlive":        &conf.keepAlive,
+		"reuseConnections": &conf.reuseConnections,
+	} {
+		if value, ok, err := httpHash.GetAsBool(key); ok {
+			if err != nil {
+				return nil, evaluator.NewError(node, "Expected 'http.%s' to be a boolean in configuration at %s\n", key, ast.PrintLocation(node))
+			}
+			*dest = value.Value
+		}
+	}
+	if conf.reuseConnections && !conf.keepAlive {
+		return nil, evaluator.NewError(node, "Expected 'http.keepAlive' to be true to reuse connections in configuration at %s\n", ast.PrintLocation(node))
+	}
+
+	for key, dest := range map[string]*int{
+		"maxConnsPerHost": &conf.maxConnsPerHost,
+		"maxRedirects":    &conf.maxRedirects,
+	} {
+		if value, ok, err := httpHash.GetAsInt(key); ok {
+			if err != nil || value.Value < 0 {
+				return nil, evaluator.NewError(node, "Expected 'http.%s' to be a positive integer in configuration at %s\n", key, ast.PrintLocation(node))
+			}
+			*dest = int(value.Value)
+		}
+	}
+
+	for key, dest := range map[string]*time.Duration{
+		"timeout":               &conf.timeout,
+		"connectTimeout":        &conf.connectTimeout,
+		"tlsHandshakeTimeout":   &conf.tlsHandshakeTimeout,
+		"responseHeaderTimeout": &conf.responseHeaderTimeout,
+		"idleConnTimeout":       &conf.idleConnTimeout,
+	} {
+		if value, ok, err := httpHash.GetAsString(key); ok {
+			var duration time.Duration
+			if err == nil {
+				duration, err = time.ParseDuration(value.Value)
+			}
+			if err != nil || duration < 0 {
+				return nil, evaluator.NewError(node, "Expected 'http.%s' to be a valid duration in configuration at %s\n", key, ast.PrintLocation(node))
+			}
+			*dest = duration
+		}
+	}
+
+	if value, ok, err := httpHash.GetAsString("proxy"); ok {
+		var proxy *url.URL
+		if err == nil {
+			proxy, err = url.Parse(value.Value)
+		}
+		if err != nil || proxy.Scheme == "" || proxy.Host == "" {
+			return nil, evaluator.NewError(node, "Expected 'http.proxy' to be a valid URL in configuration at %s\n", ast.PrintLocation(node))
+		}
+		conf.proxy = proxy
+	}
+
+	if value, ok, err := httpHash.GetAsBool("http2"); ok {
+		if err != nil {
+			return nil, evaluator.NewError(node, "Expected 'http.http2' to be a boolean in configuration at %s\n", ast.PrintLocation(node))
+		}
+		conf.http2 = &value.Value
+	}
+
+	if tlsHashValue, ok := httpHash.Get("tls"); ok {
+		tlsConfig, errObj := parseTLSConfig(node, tlsHashValue)
+		if errObj != nil {
+			return nil, errObj
+		}
+		conf.tlsConfig = tlsConfig
+	}
+	return conf, nil
+}
+
+// parseTLSConfig parses the TLS settings of a scenario. Certificates and keys are PEM-encoded strings rather than
+// files, so that they reach the workers with the deluge.
+func parseTLSConfig(node ast.Node, tlsHashValue object.Object) (*tls.Config, *object.Error) {
+	tlsHash, ok := tlsHashValue.(*object.Hash)
+	if !ok {
+		return nil, evaluator.NewError(node, "Expected 'http.tls' value to be an object in configuration at %s\n", ast.PrintLocation(node))
+	}
+	if errObj := checkKeys(node, "http.tls", tlsHash, tlsConfigKeys); errObj != nil {
+		return nil, errObj
+	}
+
+	tlsConfig := &tls.Config{}
+	if value, ok, err := tlsHash.GetAsBool("insecureSkipVerify"); ok {
+		if err != nil {
+			return nil, evaluator.NewError(node, "Expected 'http.tls.insecureSkipVerify' to be a boolean in configuration at %s\n", ast.PrintLocation(node))
+		}
+		tlsConfig.InsecureSkipVerify = value.Value
+	}
+
+	pems := make(map[string]string)
+	for _, key := range []string{"serverName", "ca", "cert", "key"} {
+		if value, ok, err := tlsHash.GetAsString(key); ok {
+			if err != nil {
+				return nil, evaluator.NewError(node, "Expected 'http.tls.%s' to be a string in configuration at %s\n", key, ast.PrintLocation(node))
+			}
+			pems[key] = value.Value
+		}
+	}
+	tlsConfig.ServerName = pems["serverName"]
+
+	if ca, ok := pems["ca"]; ok {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(ca)) {
+			return nil, evaluator.NewError(node, "Expected 'http.tls.ca' to be PEM-encoded certificates in configuration at %s\n", ast.PrintLocation(node))
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	cert, hasCert := pems["cert"]
+	key, hasKey := pems["key"]
+	if hasCert != hasKey {
+		return nil, evaluator.NewError(node, "Expected both 'http.tls.cert' and 'http.tls.key' for a client certificate in configuration at %s\n", ast.PrintLocation(node))
+	}
+	if hasCert {
+		certificate, err := tls.X509KeyPair([]byte(cert), []byte(key))
+		if err != nil {
+			return nil, evaluator.NewError(node, "Invalid client certificate in configuration at %s: %s\n", ast.PrintLocation(node), err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// checkKeys returns an error if the hash has a key that is not allowed, as it is most likely a typo.
+func checkKeys(node ast.Node, name string, hash *object.Hash, allowed map[string]bool) *object.Error {
+	unknown := make([]string, 0)
+	for key := range hash.Pairs {
+		if !allowed[string(key)] {
+			unknown = append(unknown, string(key))
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return evaluator.NewError(node, "Unknown setting '%s' in '%s' in configuration at %s\n", unknown[0], name, ast.PrintLocation(node))
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"encoding/pem"
+	"github.com/ofux/deluge/dsl/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newSimUserWithHTTPClient(t *testing.T, js string, conf *httpClientConfig) *simUser {
+	su := NewSimUserTest(t, js)
+	su.scenario.httpClient = conf
+	su.client = conf.newClient()
+	return su
+}
+
+// newConnCountingServer returns a server that counts the connections opened by its clients.
+func newConnCountingServer(handler http.HandlerFunc) (*httptest.Server, *int64) {
+	var connCount int64
+	ts := httptest.NewUnstartedServer(handler)
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&connCount, 1)
+		}
+	}
+	ts.Start()
+	return ts, &connCount
+}
+
+func TestHTTPClientConfig(t *testing.T) {
+	okHandler := func(w http.ResponseWriter, r *http.Request) {}
+
+	t.Run("Default client opens a connection per request", func(t *testing.T) {
+		ts, connCount := newConnCountingServer(okHandler)
+		defer ts.Close()
+
+		su := newSimUserWithHTTPClient(t, `
+		http("foo", {"url": "`+ts.URL+`"});
+		http("foo", {"url": "`+ts.URL+`"});
+		`, nil)
+		su.run(0)
+		su.run(1)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+		assert.Equal(t, int64(4), atomic.LoadInt64(connCount))
+	})
+
+	t.Run("Keep-alive reuses connections within an iteration", func(t *testing.T) {
+		ts, connCount := newConnCountingServer(okHandler)
+		defer ts.Close()
+
+		su := newSimUserWithHTTPClient(t, `
+		http("foo", {"url": "`+ts.URL+`"});
+		http("foo", {"url": "`+ts.URL+`"});
+		`, &httpClientConfig{keepAlive: true, maxRedirects: -1})
+		su.run(0)
+		su.run(1)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+		assert.Equal(t, int64(2), atomic.LoadInt64(connCount))
+	})
+
+	t.Run("Connections are reused across iterations", func(t *testing.T) {
+		ts, connCount := newConnCountingServer(okHandler)
+		defer ts.Close()
+
+		su := newSimUserWithHTTPClient(t, `
+		http("foo", {"url": "`+ts.URL+`"});
+		http("foo", {"url": "`+ts.URL+`"});
+		`, &httpClientConfig{keepAlive: true, reuseConnections: true, maxRedirects: -1})
+		su.run(0)
+		su.run(1)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+		assert.Equal(t, int64(1), atomic.LoadInt64(connCount))
+	})
+
+	t.Run("Redirects are not followed", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/redirect" {
+				http.Redirect(w, r, "/target", http.StatusFound)
+			}
+		}))
+		defer ts.Close()
+
+		su := newSimUserWithHTTPClient(t, `
+		let res = http("foo", {"url": "`+ts.URL+`/redirect"});
+		assert(res["status"] == 302);
+		assert(res["headers"]["Location"] == "/target");
+		`, &httpClientConfig{maxRedirects: 0})
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+	})
+
+	t.Run("Too many redirects", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/again", http.StatusFound)
+		}))
+		defer ts.Close()
+
+		su := newSimUserWithHTTPClient(t, `
+		http("foo", {"url": "`+ts.URL+`"});
+		`, &httpClientConfig{maxRedirects: 2})
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneError)
+		assert.Contains(t, su.execError.Message, "stopped after 2 redirects")
+	})
+
+	t.Run("Requests time out", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}))
+		defer ts.Close()
+
+		su := newSimUserWithHTTPClient(t, `
+		let res = http("foo", {"url": "`+ts.URL+`"});
+		assert(res["status"] == 0);
+		assert(res["error"]["category"] == "timeout");
+		`, &httpClientConfig{timeout: 20 * time.Millisecond, maxRedirects: -1})
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+		checkOkKo(t, su, 0, 1)
+	})
+
+	t.Run("TLS with a custom CA", func(t *testing.T) {
+		ts := httptest.NewTLSServer(http.HandlerFunc(okHandler))
+		defer ts.Close()
+		ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+
+		tlsConfig, errObj := parseTLSConfig(nil, &object.Hash{Pairs: map[object.HashKey]object.Object{
+			"ca": &object.String{Value: string(ca)},
+		}})
+		require.Nil(t, errObj)
+		su := newSimUserWithHTTPClient(t, `
+		let res = http("foo", {"url": "`+ts.URL+`"});
+		assert(res["status"] == 200);
+		assert(res["timings"]["tls"] > 0.0);
+		`, &httpClientConfig{tlsConfig: tlsConfig, maxRedirects: -1})
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+
+		// The certificate of the server is unknown to the default client
+		su = newSimUserWithHTTPClient(t, `
+		let res = http("foo", {"url": "`+ts.URL+`"});
+		assert(res["error"]["category"] == "tls");
+		`, nil)
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+	})
+
+	t.Run("TLS without verification", func(t *testing.T) {
+		ts := httptest.NewTLSServer(http.HandlerFunc(okHandler))
+		defer ts.Close()
+
+		tlsConfig, errObj := parseTLSConfig(nil, &object.Hash{Pairs: map[object.HashKey]object.Object{
+			"insecureSkipVerify": &object.Boolean{Value: true},
+		}})
+		require.Nil(t, errObj)
+		su := newSimUserWithHTTPClient(t, `
+		http("foo", {"url": "`+ts.URL+`"});
+		`, &httpClientConfig{tlsConfig: tlsConfig, maxRedirects: -1})
+		su.run(0)
+		checkSimUserStatus(t, su, UserDoneSuccess)
+	})
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"crypto/tls"
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/dsl/object"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// httpTimings measures the phases of an HTTP request with an httptrace.ClientTrace.
+// When redirects are followed, each phase is the one of the last request that went through it.
+type httpTimings struct {
+	mut          sync.Mutex
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	durations    map[recording.HTTPPhase]time.Duration
+}
+
+func newHTTPTimings() *httpTimings {
+	return &httpTimings{
+		durations: make(map[recording.HTTPPhase]time.Duration, len(recording.HTTPPhases)),
+	}
+}
+
+// trace creates the ClientTrace that fills the timings. Its hooks may be called concurrently, for instance when
+// dialing several addresses of a host.
+func (t *httpTimings) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.start(&t.dnsStart)
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.done(recording.PhaseDNS, &t.dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			t.start(&t.connectStart)
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				t.done(recording.PhaseConnect, &t.connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.start(&t.tlsStart)
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				t.done(recording.PhaseTLS, &t.tlsStart)
+			}
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.start(&t.wroteRequest)
+		},
+		GotFirstResponseByte: func() {
+			t.mut.Lock()
+			defer t.mut.Unlock()
+			t.firstByte = time.Now()
+			if !t.wroteRequest.IsZero() {
+				t.durations[recording.PhaseTTFB] = t.firstByte.Sub(t.wroteRequest)
+			}
+		},
+	}
+}
+
+func (t *httpTimings) start(at *time.Time) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	*at = time.Now()
+}
+
+// done ends the phase that started at the given time. The start time is read under the lock, as the hook that set it
+// may run concurrently.
+func (t *httpTimings) done(phase recording.HTTPPhase, start *time.Time) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if !start.IsZero() {
+		t.durations[phase] = time.Since(*start)
+	}
+}
+
+// bodyRead ends the transfer of the response, which started with its first byte.
+func (t *httpTimings) bodyRead(end time.Time) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if !t.firstByte.IsZero() {
+		t.durations[recording.PhaseTransfer] = end.Sub(t.firstByte)
+	}
+}
+
+// phases returns the duration of every phase that happened, in the given unit. Phases that did not happen, such as
+// the DNS lookup on a reused connection, are left out so that they are not recorded.
+func (t *httpTimings) phases(unit recording.TimeUnit) map[recording.HTTPPhase]int64 {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	phases := make(map[recording.HTTPPhase]int64, len(t.durations))
+	for phase, duration := range t.durations {
+		phases[phase] = unit.FromDuration(duration)
+	}
+	return phases
+}
+
+// toObject returns the duration of every phase in milliseconds, as exposed by res["timings"].
+func (t *httpTimings) toObject() *object.Hash {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	pairs := make(map[object.HashKey]object.Object, len(recording.HTTPPhases))
+	for _, phase := range recording.HTTPPhases {
+		pairs[object.HashKey(phase)] = &object.Float{Value: float64(t.durations[phase]) / float64(time.Millisecond)}
+	}
+	return &object.Hash{
+		Pairs:       pairs,
+		IsImmutable: true,
+	}
+}
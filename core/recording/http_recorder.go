@@ -15,9 +15,12 @@ type HTTPRecorder struct {
 	affectedTimeIndexesSinceLastSnapshot map[int]struct{}
 	overTimeCount                        int
 	iterationCount                       int
+	histogram                            HistogramConfig
 }
 
 type HTTPRecordsOverTime struct {
+	// Unit is the unit of the values of all the histograms
+	Unit     TimeUnit
 	Global   *HTTPRecord
 	OverTime []*HTTPRecord
 }
@@ -25,35 +28,142 @@ type HTTPRecordsOverTime struct {
 type HTTPRecord struct {
 	HTTPRequestRecord
 	PerRequests map[string]*HTTPRequestRecord
+	// PerGroups holds the durations of the groups of requests of the script, such as business flows. It is nil if no
+	// group was recorded.
+	PerGroups map[string]*GroupRecord
+	// Stage is the number (starting at 1) of the load stage that was running when the requests were recorded.
+	// It is 0 if the scenario has no stages, and always 0 for global records.
+	Stage int
+	// Users is the highest number of users that were running when the requests were recorded.
+	// It is always 0 for global records.
+	Users int
 }
 
 type HTTPRequestRecord struct {
 	Global    *hdr.Histogram
 	PerStatus map[int]*hdr.Histogram
 	PerOkKo   map[OkKo]*hdr.Histogram
+	// PerPhase holds the durations of each phase of the requests. It is nil if no phase was recorded.
+	PerPhase map[HTTPPhase]*hdr.Histogram
+	// PerError holds the durations of the requests that failed without response, per category of error. They are not
+	// part of PerStatus. It is nil if no request failed this way.
+	PerError map[ErrorCategory]*hdr.Histogram
 }
 
+// ErrorCategory is the kind of failure of a request that got no response.
+type ErrorCategory string
+
+const (
+	// ErrorTimeout is a request that timed out
+	ErrorTimeout ErrorCategory = "timeout"
+	// ErrorRefused is a connection refused by the server
+	ErrorRefused ErrorCategory = "refused"
+	// ErrorReset is a connection closed by the server before the response
+	ErrorReset ErrorCategory = "reset"
+	// ErrorDNS is a failed DNS lookup
+	ErrorDNS ErrorCategory = "dns"
+	// ErrorTLS is a failed TLS handshake, such as an invalid certificate
+	ErrorTLS ErrorCategory = "tls"
+	// ErrorOther is any other network error
+	ErrorOther ErrorCategory = "other"
+)
+
+// HTTPPhase is a phase of an HTTP request.
+type HTTPPhase string
+
+const (
+	// PhaseDNS is the DNS lookup
+	PhaseDNS HTTPPhase = "dns"
+	// PhaseConnect is the establishment of the TCP connection
+	PhaseConnect HTTPPhase = "connect"
+	// PhaseTLS is the TLS handshake
+	PhaseTLS HTTPPhase = "tls"
+	// PhaseTTFB is the time to first byte, from the request being written to the first byte of the response
+	PhaseTTFB HTTPPhase = "ttfb"
+	// PhaseTransfer is the reading of the response, from its first byte to the end of its body
+	PhaseTransfer HTTPPhase = "transfer"
+)
+
+// HTTPPhases are the phases of an HTTP request, in order.
+var HTTPPhases = []HTTPPhase{PhaseDNS, PhaseConnect, PhaseTLS, PhaseTTFB, PhaseTransfer}
+
 type HTTPRecordEntry struct {
-	Iteration  int
-	Name       string
+	Iteration int
+	Stage     int
+	Users     int
+	Name      string
+	// Value is the response time, in the unit of the recorder
 	Value      int64
 	StatusCode int
+	// Phases are the durations of the phases of the request, in the unit of the recorder. Phases that did not happen,
+	// such as the DNS lookup on a reused connection, are left out.
+	Phases map[HTTPPhase]int64
+	// Result tells whether the request succeeded. If it is empty, requests succeed if their status code is below 400.
+	Result OkKo
+	// Error is the category of the failure of a request that got no response, in which case StatusCode is 0 and the
+	// request is KO. It is empty if a response was received.
+	Error ErrorCategory
+}
+
+// GroupRecord holds the durations of the executions of a group of requests.
+type GroupRecord struct {
+	// Global holds the durations of the executions, pauses included
+	Global *hdr.Histogram
+	// WithoutPauses holds the durations of the executions, minus the time spent in pauses
+	WithoutPauses *hdr.Histogram
+	PerOkKo       map[OkKo]*hdr.Histogram
+}
+
+// GroupRecordEntry is an execution of a group of requests, such as a business flow made of several requests and
+// pauses. Groups are recorded apart from requests, so they do not change the number of requests nor their response
+// times.
+type GroupRecordEntry struct {
+	Iteration int
+	Stage     int
+	Users     int
+	Name      string
+	// Value is the duration of the execution, pauses included, in the unit of the recorder
+	Value int64
+	// ValueWithoutPauses is the duration of the execution minus the time spent in pauses, in the unit of the recorder
+	ValueWithoutPauses int64
+	// Result is KO if the group failed because of an error, or if one of its requests was KO
+	Result OkKo
 }
 
 type HTTPRecordsOverTimeSnapshot struct {
+	Unit     TimeUnit
 	Global   *HTTPRecord
 	OverTime map[int]*HTTPRecord
 }
 
-func NewHTTPRecorder(iterationCount, concurrent int) *HTTPRecorder {
+// NewHTTPRecordsOverTimeSnapshot creates a snapshot that contains all the iterations of the given records.
+func NewHTTPRecordsOverTimeSnapshot(records *HTTPRecordsOverTime) *HTTPRecordsOverTimeSnapshot {
+	if records == nil {
+		return nil
+	}
+	snap := &HTTPRecordsOverTimeSnapshot{
+		Unit:     records.Unit,
+		Global:   records.Global,
+		OverTime: make(map[int]*HTTPRecord, len(records.OverTime)),
+	}
+	for index, rec := range records.OverTime {
+		snap.OverTime[index] = rec
+	}
+	return snap
+}
+
+// NewHTTPRecorder creates a recorder whose histograms have the given configuration. Values of entries must be in the
+// unit of the configuration.
+func NewHTTPRecorder(iterationCount, concurrent int, histogram HistogramConfig) *HTTPRecorder {
 	overTimeCount := Min(iterationCount, MaxOverTimeCount)
 
 	recorder := &HTTPRecorder{
 		Recorder: NewRecorder(concurrent),
 		records: &HTTPRecordsOverTime{
+			Unit: histogram.Unit,
 			Global: &HTTPRecord{
 				HTTPRequestRecord: HTTPRequestRecord{
-					Global:    createHistogram(),
+					Global:    histogram.createHistogram(),
 					PerStatus: make(map[int]*hdr.Histogram),
 					PerOkKo:   make(map[OkKo]*hdr.Histogram),
 				},
@@ -64,14 +174,20 @@ func NewHTTPRecorder(iterationCount, concurrent int) *HTTPRecorder {
 		affectedTimeIndexesSinceLastSnapshot: make(map[int]struct{}),
 		iterationCount:                       iterationCount,
 		overTimeCount:                        overTimeCount,
+		histogram:                            histogram,
 	}
 	recorder.processRecords(recorder.processHTTPEntry, recorder.processRecordsSnapshotRequest)
 	return recorder
 }
 
+// Unit returns the unit in which the values of entries must be recorded.
+func (r *HTTPRecorder) Unit() TimeUnit {
+	return r.histogram.Unit
+}
+
 // GetRecords returns the full records and can be called only once recording has ended.
 func (r *HTTPRecorder) GetRecords() (*HTTPRecordsOverTime, error) {
-	if r.recording != TERMINATED {
+	if r.getRecordingState() != TERMINATED {
 		return nil, errors.New("GetRecords can only be called after recording ended properly and after the 'Close()' method has been called")
 	}
 	return r.records, nil
@@ -79,86 +195,109 @@ func (r *HTTPRecorder) GetRecords() (*HTTPRecordsOverTime, error) {
 
 // GetRecordsSnapshot returns a channel where a copy of current records will be sent.
 func (r *HTTPRecorder) GetRecordsSnapshot() (<-chan RecordSnapshot, error) {
+	return r.askForSnapshot(false)
+}
+
+// GetGlobalRecordsSnapshot returns a channel where a copy of current global records will be sent. Records over time
+// are left out, so that the next call to GetRecordsSnapshot still gets all the ones that changed.
+func (r *HTTPRecorder) GetGlobalRecordsSnapshot() (<-chan RecordSnapshot, error) {
+	return r.askForSnapshot(true)
+}
+
+func (r *HTTPRecorder) askForSnapshot(globalOnly bool) (<-chan RecordSnapshot, error) {
+	// The state can't change until the request is received, so that a recorder being closed never misses it
+	r.recordingMutex.RLock()
+	defer r.recordingMutex.RUnlock()
 	if r.recording != RECORDING {
 		return nil, errors.New("GetRecordsSnapshot can only be called while recording. Use GetRecords instead")
 	}
 	// We set a buffer of size 1 so 'processRecordsSnapshotRequest' can never stay blocked (waiting for a listener)
 	newChan := make(chan RecordSnapshot, 1)
-	r.askForRecordsSnapshot <- newChan
+	r.askForRecordsSnapshot <- snapshotRequest{snapshotChan: newChan, globalOnly: globalOnly}
 	return newChan, nil
 }
 
-func (r *HTTPRecorder) processRecordsSnapshotRequest(snapshotChan chan<- RecordSnapshot) {
+func (r *HTTPRecorder) processRecordsSnapshotRequest(request snapshotRequest) {
 	snap := &HTTPRecordsOverTimeSnapshot{
+		Unit:     r.records.Unit,
 		Global:   copyHTTPRecord(r.records.Global),
 		OverTime: make(map[int]*HTTPRecord),
 	}
-	for index := range r.affectedTimeIndexesSinceLastSnapshot {
-		snap.OverTime[index] = copyHTTPRecord(r.records.OverTime[index])
-	}
+	if !request.globalOnly {
+		for index := range r.affectedTimeIndexesSinceLastSnapshot {
+			snap.OverTime[index] = copyHTTPRecord(r.records.OverTime[index])
+		}
 
-	// Clear affectedTimeIndexesSinceLastSnapshot map
-	r.affectedTimeIndexesSinceLastSnapshot = make(map[int]struct{})
+		// Clear affectedTimeIndexesSinceLastSnapshot map
+		r.affectedTimeIndexesSinceLastSnapshot = make(map[int]struct{})
+	}
 
-	snapshotChan <- RecordSnapshot{
+	request.snapshotChan <- RecordSnapshot{
 		HTTPRecordsOverTimeSnapshot: snap,
 		Err:                         nil,
 	}
 }
 
 func (r *HTTPRecorder) processHTTPEntry(record RecordEntry) {
-	rec := record.(*HTTPRecordEntry)
-
-	// Global record for all iterations
-	processEntryToHTTPRecord(rec, r.records.Global)
+	switch rec := record.(type) {
+	case *HTTPRecordEntry:
+		// Global record for all iterations
+		processEntryToHTTPRecord(rec, r.records.Global, r.histogram)
+		processEntryToHTTPRecord(rec, r.overTimeRecord(rec.Iteration, rec.Stage, rec.Users), r.histogram)
+	case *GroupRecordEntry:
+		processEntryToGroupRecord(rec, r.records.Global, r.histogram)
+		processEntryToGroupRecord(rec, r.overTimeRecord(rec.Iteration, rec.Stage, rec.Users), r.histogram)
+	}
+}
 
-	overTimeIndex := r.iterationToTimeIndex(rec.Iteration)
+// overTimeRecord returns the record of the given iteration, and updates its stage and users.
+func (r *HTTPRecorder) overTimeRecord(iteration, stage, users int) *HTTPRecord {
+	overTimeIndex := r.iterationToTimeIndex(iteration)
 	if len(r.records.OverTime) <= overTimeIndex {
 		diff := overTimeIndex + 1 - len(r.records.OverTime)
-		r.records.OverTime = append(r.records.OverTime, createHTTPRecords(diff)...)
+		r.records.OverTime = append(r.records.OverTime, createHTTPRecords(diff, r.histogram)...)
+	}
+	out := r.records.OverTime[overTimeIndex]
+	if stage > out.Stage {
+		out.Stage = stage
+	}
+	if users > out.Users {
+		out.Users = users
 	}
-	processEntryToHTTPRecord(rec, r.records.OverTime[overTimeIndex])
 	r.affectedTimeIndexesSinceLastSnapshot[overTimeIndex] = struct{}{}
+	return out
 }
 
 func (r *HTTPRecorder) iterationToTimeIndex(iteration int) int {
 	return iteration * r.overTimeCount / r.iterationCount
 }
 
-func processEntryToHTTPRecord(rec *HTTPRecordEntry, out *HTTPRecord) {
+func processEntryToHTTPRecord(rec *HTTPRecordEntry, out *HTTPRecord, config HistogramConfig) {
 
-	val := rec.Value
-	if val < out.Global.LowestTrackableValue() {
-		val = out.Global.LowestTrackableValue()
-	}
-	if val > out.Global.HighestTrackableValue() {
-		val = out.Global.HighestTrackableValue()
-	}
+	val := trackableValue(rec.Value, out.Global)
 
 	// Global. We explicitly ignore the error as we already made sure 'val' is trackable
 	_ = out.Global.RecordValue(val)
 
-	// Global per status
-	histogram, ok := out.PerStatus[rec.StatusCode]
-	if !ok {
-		histogram = createHistogram()
-		out.PerStatus[rec.StatusCode] = histogram
-	}
-	_ = histogram.RecordValue(val)
+	// Global per status, or per error
+	processStatusOrError(rec, &out.HTTPRequestRecord, val, config)
 
 	// Global per result OK/KO
-	histogram, ok = out.PerOkKo[httpOkKo(rec)]
+	histogram, ok := out.PerOkKo[httpOkKo(rec)]
 	if !ok {
-		histogram = createHistogram()
+		histogram = config.createHistogram()
 		out.PerOkKo[httpOkKo(rec)] = histogram
 	}
 	_ = histogram.RecordValue(val)
 
+	// Global per phase
+	processPhases(rec, &out.HTTPRequestRecord, config)
+
 	// Request's records
 	requestRecords, ok := out.PerRequests[rec.Name]
 	if !ok {
 		requestRecords = &HTTPRequestRecord{
-			Global:    createHistogram(),
+			Global:    config.createHistogram(),
 			PerStatus: make(map[int]*hdr.Histogram),
 			PerOkKo:   make(map[OkKo]*hdr.Histogram),
 		}
@@ -168,29 +307,103 @@ func processEntryToHTTPRecord(rec *HTTPRecordEntry, out *HTTPRecord) {
 	// Request's global
 	_ = requestRecords.Global.RecordValue(val)
 
-	// Global per status
-	histogram, ok = requestRecords.PerStatus[rec.StatusCode]
-	if !ok {
-		histogram = createHistogram()
-		requestRecords.PerStatus[rec.StatusCode] = histogram
-	}
-	_ = histogram.RecordValue(val)
+	// Request's per status, or per error
+	processStatusOrError(rec, requestRecords, val, config)
 
 	// Global per result OK/KO
 	histogram, ok = requestRecords.PerOkKo[httpOkKo(rec)]
 	if !ok {
-		histogram = createHistogram()
+		histogram = config.createHistogram()
 		requestRecords.PerOkKo[httpOkKo(rec)] = histogram
 	}
 	_ = histogram.RecordValue(val)
+
+	// Request's phases
+	processPhases(rec, requestRecords, config)
+}
+
+func processEntryToGroupRecord(rec *GroupRecordEntry, out *HTTPRecord, config HistogramConfig) {
+	if out.PerGroups == nil {
+		out.PerGroups = make(map[string]*GroupRecord)
+	}
+	groupRecord, ok := out.PerGroups[rec.Name]
+	if !ok {
+		groupRecord = &GroupRecord{
+			Global:        config.createHistogram(),
+			WithoutPauses: config.createHistogram(),
+			PerOkKo:       make(map[OkKo]*hdr.Histogram),
+		}
+		out.PerGroups[rec.Name] = groupRecord
+	}
+
+	val := trackableValue(rec.Value, groupRecord.Global)
+	_ = groupRecord.Global.RecordValue(val)
+	_ = groupRecord.WithoutPauses.RecordValue(trackableValue(rec.ValueWithoutPauses, groupRecord.WithoutPauses))
+
+	histogram, ok := groupRecord.PerOkKo[rec.OkKo()]
+	if !ok {
+		histogram = config.createHistogram()
+		groupRecord.PerOkKo[rec.OkKo()] = histogram
+	}
+	_ = histogram.RecordValue(val)
+}
+
+func processStatusOrError(rec *HTTPRecordEntry, out *HTTPRequestRecord, val int64, config HistogramConfig) {
+	if rec.Error != "" {
+		if out.PerError == nil {
+			out.PerError = make(map[ErrorCategory]*hdr.Histogram)
+		}
+		histogram, ok := out.PerError[rec.Error]
+		if !ok {
+			histogram = config.createHistogram()
+			out.PerError[rec.Error] = histogram
+		}
+		_ = histogram.RecordValue(val)
+		return
+	}
+
+	histogram, ok := out.PerStatus[rec.StatusCode]
+	if !ok {
+		histogram = config.createHistogram()
+		out.PerStatus[rec.StatusCode] = histogram
+	}
+	_ = histogram.RecordValue(val)
 }
 
-func createHTTPRecords(count int) []*HTTPRecord {
+func processPhases(rec *HTTPRecordEntry, out *HTTPRequestRecord, config HistogramConfig) {
+	if len(rec.Phases) == 0 {
+		return
+	}
+	if out.PerPhase == nil {
+		out.PerPhase = make(map[HTTPPhase]*hdr.Histogram, len(HTTPPhases))
+	}
+	for phase, value := range rec.Phases {
+		histogram, ok := out.PerPhase[phase]
+		if !ok {
+			histogram = config.createHistogram()
+			out.PerPhase[phase] = histogram
+		}
+		_ = histogram.RecordValue(trackableValue(value, histogram))
+	}
+}
+
+// trackableValue bounds the value to the range of the histogram.
+func trackableValue(value int64, histogram *hdr.Histogram) int64 {
+	if value < histogram.LowestTrackableValue() {
+		return histogram.LowestTrackableValue()
+	}
+	if value > histogram.HighestTrackableValue() {
+		return histogram.HighestTrackableValue()
+	}
+	return value
+}
+
+func createHTTPRecords(count int, config HistogramConfig) []*HTTPRecord {
 	httpRecords := make([]*HTTPRecord, count)
 	for i := 0; i < count; i++ {
 		httpRecords[i] = &HTTPRecord{
 			HTTPRequestRecord: HTTPRequestRecord{
-				Global:    createHistogram(),
+				Global:    config.createHistogram(),
 				PerStatus: make(map[int]*hdr.Histogram),
 				PerOkKo:   make(map[OkKo]*hdr.Histogram),
 			},
@@ -200,13 +413,32 @@ func createHTTPRecords(count int) []*HTTPRecord {
 	return httpRecords
 }
 
+// OkKo tells whether the request succeeded.
+func (rec *HTTPRecordEntry) OkKo() OkKo {
+	return httpOkKo(rec)
+}
+
 func httpOkKo(httpRec *HTTPRecordEntry) OkKo {
+	if httpRec.Error != "" {
+		return Ko
+	}
+	if httpRec.Result != "" {
+		return httpRec.Result
+	}
 	if httpRec.StatusCode < 400 {
 		return Ok
 	}
 	return Ko
 }
 
+// OkKo tells whether the group succeeded. Groups without result succeed.
+func (rec *GroupRecordEntry) OkKo() OkKo {
+	if rec.Result == "" {
+		return Ok
+	}
+	return rec.Result
+}
+
 func Min(a, b int) int {
 	if a < b {
 		return a
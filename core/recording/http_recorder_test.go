@@ -13,7 +13,7 @@ import (
 func TestHTTPRecorder(t *testing.T) {
 
 	t.Run("Records 1 Value", func(t *testing.T) {
-		recorder := recording.NewHTTPRecorder(1, 1)
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
 
 		recorder.Record(&recording.HTTPRecordEntry{
 			Iteration:  0,
@@ -34,7 +34,7 @@ func TestHTTPRecorder(t *testing.T) {
 	})
 
 	t.Run("Records 1 Value code 500", func(t *testing.T) {
-		recorder := recording.NewHTTPRecorder(1, 1)
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
 
 		recorder.Record(&recording.HTTPRecordEntry{
 			Iteration:  0,
@@ -54,9 +54,29 @@ func TestHTTPRecorder(t *testing.T) {
 		recordingtest.CheckHTTPRecord(t, result, "foo", 1, 500, recording.Ko)
 	})
 
+	t.Run("Records 1 Value code 404 with an OK result", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+
+		recorder.Record(&recording.HTTPRecordEntry{
+			Iteration:  0,
+			Name:       "foo",
+			Value:      1000,
+			StatusCode: 404,
+			Result:     recording.Ok,
+		})
+
+		recorder.Close()
+
+		results, err := recorder.GetRecords()
+		require.NoError(t, err)
+
+		result := results.OverTime[0]
+		recordingtest.CheckHTTPRecord(t, result, "foo", 1, 404, recording.Ok)
+	})
+
 	t.Run("Records 100 values simultaneously on the same Iteration", func(t *testing.T) {
 		const concurrent = 100
-		recorder := recording.NewHTTPRecorder(1, concurrent)
+		recorder := recording.NewHTTPRecorder(1, concurrent, recording.DefaultHistogramConfig)
 
 		var waitg sync.WaitGroup
 		for i := 0; i < concurrent; i++ {
@@ -85,7 +105,7 @@ func TestHTTPRecorder(t *testing.T) {
 	})
 
 	t.Run("Records 1 Value at a given Iteration", func(t *testing.T) {
-		recorder := recording.NewHTTPRecorder(1, 1)
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
 
 		recorder.Record(&recording.HTTPRecordEntry{
 			Iteration:  42,
@@ -108,10 +128,40 @@ func TestHTTPRecorder(t *testing.T) {
 		recordingtest.CheckHTTPRecord(t, result, "foo", 1, 200, recording.Ok)
 	})
 
+	t.Run("Records the stage of iterations", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(2, 1, recording.DefaultHistogramConfig)
+
+		recorder.Record(&recording.HTTPRecordEntry{
+			Iteration:  0,
+			Stage:      1,
+			Name:       "foo",
+			Value:      1000,
+			StatusCode: 200,
+		})
+		recorder.Record(&recording.HTTPRecordEntry{
+			Iteration:  1,
+			Stage:      2,
+			Name:       "foo",
+			Value:      1000,
+			StatusCode: 200,
+		})
+
+		recorder.Close()
+
+		results, err := recorder.GetRecords()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if results.OverTime[0].Stage != 1 || results.OverTime[1].Stage != 2 {
+			t.Fatalf("Expected stages 1 and 2, got %d and %d", results.OverTime[0].Stage, results.OverTime[1].Stage)
+		}
+	})
+
 	t.Run("Records 100 values simultaneously on multiple iterations", func(t *testing.T) {
 		const concurrent = 100
 		const iterCount = 100
-		recorder := recording.NewHTTPRecorder(iterCount, concurrent)
+		recorder := recording.NewHTTPRecorder(iterCount, concurrent, recording.DefaultHistogramConfig)
 
 		var waitg sync.WaitGroup
 		for i := 0; i < concurrent; i++ {
@@ -151,7 +201,7 @@ func TestHTTPRecorder(t *testing.T) {
 		const iterCountSnapshotReaders = 5
 		var sleepDurationPerIteration = 10 * time.Millisecond
 		var sleepDurationPerIterationForSnapshotReaders = sleepDurationPerIteration * iterCount / iterCountSnapshotReaders
-		recorder := recording.NewHTTPRecorder(iterCount, concurrent)
+		recorder := recording.NewHTTPRecorder(iterCount, concurrent, recording.DefaultHistogramConfig)
 
 		var waitg sync.WaitGroup
 		for i := 0; i < concurrent; i++ {
@@ -199,7 +249,7 @@ func TestHTTPRecorder(t *testing.T) {
 	t.Run("Records 100 values simultaneously on more than MaxOverTimeCount iterations", func(t *testing.T) {
 		const concurrent = 10
 		const iterCount = recording.MaxOverTimeCount * 3
-		recorder := recording.NewHTTPRecorder(iterCount, concurrent)
+		recorder := recording.NewHTTPRecorder(iterCount, concurrent, recording.DefaultHistogramConfig)
 
 		var waitg sync.WaitGroup
 		for i := 0; i < concurrent; i++ {
@@ -232,12 +282,164 @@ func TestHTTPRecorder(t *testing.T) {
 			recordingtest.CheckHTTPRecord(t, results.OverTime[j], "foo", concurrent*3, 200, recording.Ok)
 		}
 	})
+
+	t.Run("Records values and sends them to sinks", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+		sink1 := &sinkMock{}
+		sink2 := &sinkMock{}
+		recorder.AddSink(sink1)
+		recorder.AddSink(sink2)
+
+		entry := &recording.HTTPRecordEntry{
+			Iteration:  0,
+			Name:       "foo",
+			Value:      1000,
+			StatusCode: 200,
+		}
+		recorder.Record(entry)
+		recorder.Close()
+
+		results, err := recorder.GetRecords()
+		require.NoError(t, err)
+		recordingtest.CheckHTTPRecord(t, results.Global, "foo", 1, 200, recording.Ok)
+		assert.Equal(t, []recording.RecordEntry{entry}, sink1.entries)
+		assert.Equal(t, []recording.RecordEntry{entry}, sink2.entries)
+	})
+
+	t.Run("Records values in microseconds up to the max value", func(t *testing.T) {
+		config := recording.HistogramConfig{Unit: recording.Microsecond, MaxValue: time.Second}
+		recorder := recording.NewHTTPRecorder(1, 1, config)
+
+		recorder.Record(&recording.HTTPRecordEntry{
+			Name:       "foo",
+			Value:      config.Unit.FromDuration(250 * time.Microsecond),
+			StatusCode: 200,
+		})
+		recorder.Record(&recording.HTTPRecordEntry{
+			Name:       "foo",
+			Value:      config.Unit.FromDuration(time.Minute),
+			StatusCode: 200,
+		})
+		recorder.Close()
+
+		results, err := recorder.GetRecords()
+		require.NoError(t, err)
+		assert.Equal(t, recording.Microsecond, results.Unit)
+		assert.Equal(t, int64(2), results.Global.Global.TotalCount())
+		assert.Equal(t, int64(250), results.Global.Global.Min())
+		// Longer values are recorded as the max value
+		assert.InDelta(t, 1000000, results.Global.Global.Max(), 1000)
+	})
+
+	t.Run("Records requests that got no response", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+
+		recorder.Record(&recording.HTTPRecordEntry{Name: "foo", Value: 30, StatusCode: 200})
+		recorder.Record(&recording.HTTPRecordEntry{Name: "foo", Value: 1000, Error: recording.ErrorTimeout})
+		recorder.Record(&recording.HTTPRecordEntry{Name: "foo", Value: 2, Error: recording.ErrorRefused, Result: recording.Ok})
+		recorder.Close()
+
+		results, err := recorder.GetRecords()
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), results.Global.Global.TotalCount())
+		assert.Len(t, results.Global.PerStatus, 1)
+		assert.Equal(t, int64(1), results.Global.PerStatus[200].TotalCount())
+		// Requests without response are always KO
+		assert.Equal(t, int64(2), results.Global.PerOkKo[recording.Ko].TotalCount())
+		assert.Len(t, results.Global.PerError, 2)
+		assert.Equal(t, int64(1000), results.Global.PerError[recording.ErrorTimeout].Max())
+		assert.Equal(t, int64(1), results.OverTime[0].PerRequests["foo"].PerError[recording.ErrorRefused].TotalCount())
+	})
+
+	t.Run("Records the phases of requests", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+
+		recorder.Record(&recording.HTTPRecordEntry{
+			Name:       "foo",
+			Value:      50,
+			StatusCode: 200,
+			Phases: map[recording.HTTPPhase]int64{
+				recording.PhaseDNS:      2,
+				recording.PhaseConnect:  3,
+				recording.PhaseTLS:      10,
+				recording.PhaseTTFB:     30,
+				recording.PhaseTransfer: 5,
+			},
+		})
+		recorder.Record(&recording.HTTPRecordEntry{
+			Name:       "bar",
+			Value:      20,
+			StatusCode: 200,
+		})
+		recorder.Close()
+
+		results, err := recorder.GetRecords()
+		require.NoError(t, err)
+		assert.Len(t, results.Global.PerPhase, len(recording.HTTPPhases))
+		assert.Equal(t, int64(1), results.Global.PerPhase[recording.PhaseTTFB].TotalCount())
+		assert.Equal(t, int64(30), results.Global.PerPhase[recording.PhaseTTFB].Max())
+		assert.Equal(t, int64(10), results.OverTime[0].PerRequests["foo"].PerPhase[recording.PhaseTLS].Max())
+		// Requests without phases have none
+		assert.Nil(t, results.OverTime[0].PerRequests["bar"].PerPhase)
+	})
+
+	t.Run("Records groups apart from requests", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(2, 1, recording.DefaultHistogramConfig)
+
+		recorder.Record(&recording.HTTPRecordEntry{Iteration: 0, Name: "foo", Value: 30, StatusCode: 200})
+		recorder.Record(&recording.GroupRecordEntry{Iteration: 0, Name: "checkout", Value: 1030, ValueWithoutPauses: 30})
+		recorder.Record(&recording.GroupRecordEntry{Iteration: 1, Stage: 2, Users: 3, Name: "checkout", Value: 50, ValueWithoutPauses: 50, Result: recording.Ko})
+		recorder.Close()
+
+		results, err := recorder.GetRecords()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), results.Global.Global.TotalCount())
+		require.Len(t, results.Global.PerGroups, 1)
+		checkout := results.Global.PerGroups["checkout"]
+		assert.Equal(t, int64(2), checkout.Global.TotalCount())
+		assert.Equal(t, int64(1030), checkout.Global.Max())
+		assert.Equal(t, int64(50), checkout.WithoutPauses.Max())
+		// Groups without result are OK
+		assert.Equal(t, int64(1), checkout.PerOkKo[recording.Ok].TotalCount())
+		assert.Equal(t, int64(1), checkout.PerOkKo[recording.Ko].TotalCount())
+
+		require.Len(t, results.OverTime, 2)
+		assert.Equal(t, int64(1030), results.OverTime[0].PerGroups["checkout"].Global.Max())
+		assert.Equal(t, 2, results.OverTime[1].Stage)
+		assert.Equal(t, 3, results.OverTime[1].Users)
+		assert.Empty(t, results.OverTime[1].PerRequests)
+	})
+}
+
+func TestHistogramConfig_Validate(t *testing.T) {
+	assert.NoError(t, recording.DefaultHistogramConfig.Validate())
+	assert.NoError(t, recording.HistogramConfig{Unit: recording.Nanosecond, MaxValue: time.Second}.Validate())
+	assert.EqualError(t, recording.HistogramConfig{Unit: "s", MaxValue: time.Hour}.Validate(), "unknown time unit 's', expected ms, us or ns")
+	assert.EqualError(t, recording.HistogramConfig{Unit: recording.Millisecond, MaxValue: time.Millisecond}.Validate(), "max value 1ms is too low for unit ms")
+}
+
+func TestTimeUnit(t *testing.T) {
+	assert.Equal(t, int64(1), recording.Millisecond.FromDuration(1999*time.Microsecond))
+	assert.Equal(t, int64(1999), recording.Microsecond.FromDuration(1999*time.Microsecond))
+	assert.Equal(t, int64(1999000), recording.Nanosecond.FromDuration(1999*time.Microsecond))
+	assert.Equal(t, 1.999, recording.Microsecond.ToMilliseconds(1999))
+	assert.Equal(t, 0.5, recording.Nanosecond.ToMilliseconds(500000))
+	// Records persisted before units existed are in milliseconds
+	assert.Equal(t, 3.0, recording.TimeUnit("").ToMilliseconds(3))
+}
+
+type sinkMock struct {
+	entries []recording.RecordEntry
+}
+
+func (s *sinkMock) Send(rec recording.RecordEntry) {
+	s.entries = append(s.entries, rec)
 }
 
 func TestHTTPRecorderErrors(t *testing.T) {
 
 	t.Run("Get records on a running httpRecorder", func(t *testing.T) {
-		recorder := recording.NewHTTPRecorder(1, 1)
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
 
 		recorder.Record(&recording.HTTPRecordEntry{
 			Iteration:  0,
@@ -251,8 +453,38 @@ func TestHTTPRecorderErrors(t *testing.T) {
 		assert.Equal(t, "GetRecords can only be called after recording ended properly and after the 'Close()' method has been called", err.Error())
 	})
 
+	t.Run("Get global records snapshot without affecting the next snapshot", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(2, 1, recording.DefaultHistogramConfig)
+		recorder.Record(&recording.HTTPRecordEntry{
+			Iteration:  1,
+			Name:       "foo",
+			Value:      1000,
+			StatusCode: 200,
+		})
+
+		var globalSnapshot recording.RecordSnapshot
+		for globalSnapshot.HTTPRecordsOverTimeSnapshot == nil || globalSnapshot.HTTPRecordsOverTimeSnapshot.Global.Global.TotalCount() == 0 {
+			snapshotChan, err := recorder.GetGlobalRecordsSnapshot()
+			require.NoError(t, err)
+			globalSnapshot = <-snapshotChan
+			require.NoError(t, globalSnapshot.Err)
+		}
+		assert.Len(t, globalSnapshot.HTTPRecordsOverTimeSnapshot.OverTime, 0)
+
+		snapshotChan, err := recorder.GetRecordsSnapshot()
+		require.NoError(t, err)
+		snapshot := <-snapshotChan
+		require.NoError(t, snapshot.Err)
+		require.Len(t, snapshot.HTTPRecordsOverTimeSnapshot.OverTime, 1)
+		recordingtest.CheckHTTPRecord(t, snapshot.HTTPRecordsOverTimeSnapshot.OverTime[1], "foo", 1, 200, recording.Ok)
+
+		recorder.Close()
+		_, err = recorder.GetGlobalRecordsSnapshot()
+		assert.Error(t, err)
+	})
+
 	t.Run("Get records snapshot on a finished httpRecorder", func(t *testing.T) {
-		recorder := recording.NewHTTPRecorder(1, 1)
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
 
 		recorder.Record(&recording.HTTPRecordEntry{
 			Iteration:  0,
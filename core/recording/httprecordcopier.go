@@ -8,10 +8,30 @@ func copyHTTPRecord(rec *HTTPRecord) *HTTPRecord {
 	st := &HTTPRecord{
 		HTTPRequestRecord: *copyHTTPRequestRecord(&(rec.HTTPRequestRecord)),
 		PerRequests:       make(map[string]*HTTPRequestRecord),
+		Stage:             rec.Stage,
+		Users:             rec.Users,
 	}
 	for k, v := range rec.PerRequests {
 		st.PerRequests[k] = copyHTTPRequestRecord(v)
 	}
+	if rec.PerGroups != nil {
+		st.PerGroups = make(map[string]*GroupRecord, len(rec.PerGroups))
+		for k, v := range rec.PerGroups {
+			st.PerGroups[k] = copyGroupRecord(v)
+		}
+	}
+	return st
+}
+
+func copyGroupRecord(rec *GroupRecord) *GroupRecord {
+	st := &GroupRecord{
+		Global:        rec.Global.Copy(),
+		WithoutPauses: rec.WithoutPauses.Copy(),
+		PerOkKo:       make(map[OkKo]*hdr.Histogram),
+	}
+	for k, v := range rec.PerOkKo {
+		st.PerOkKo[k] = v.Copy()
+	}
 	return st
 }
 
@@ -27,5 +47,17 @@ func copyHTTPRequestRecord(rec *HTTPRequestRecord) *HTTPRequestRecord {
 	for k, v := range rec.PerOkKo {
 		st.PerOkKo[k] = v.Copy()
 	}
+	if rec.PerPhase != nil {
+		st.PerPhase = make(map[HTTPPhase]*hdr.Histogram, len(rec.PerPhase))
+		for k, v := range rec.PerPhase {
+			st.PerPhase[k] = v.Copy()
+		}
+	}
+	if rec.PerError != nil {
+		st.PerError = make(map[ErrorCategory]*hdr.Histogram, len(rec.PerError))
+		for k, v := range rec.PerError {
+			st.PerError[k] = v.Copy()
+		}
+	}
 	return st
 }
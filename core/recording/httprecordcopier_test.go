@@ -4,6 +4,7 @@ import (
 	hdr "github.com/ofux/hdrhistogram"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_copyHTTPRecord(t *testing.T) {
@@ -25,7 +26,7 @@ func Benchmark_copyHTTPRecord(b *testing.B) {
 func buildHTTPRecordsForTests(concurrent int) *HTTPRecord {
 	records := &HTTPRecord{
 		HTTPRequestRecord: HTTPRequestRecord{
-			Global:    createHistogram(),
+			Global:    DefaultHistogramConfig.createHistogram(),
 			PerStatus: make(map[int]*hdr.Histogram),
 			PerOkKo:   make(map[OkKo]*hdr.Histogram),
 		},
@@ -36,24 +37,46 @@ func buildHTTPRecordsForTests(concurrent int) *HTTPRecord {
 		rec := &HTTPRecordEntry{
 			Iteration:  42,
 			Name:       "This is my awesome HTTP request",
-			Value:      NanosecondToHistogramTime(int64(1000 * 1000 * i)),
+			Value:      Millisecond.FromDuration(time.Duration(int64(1000 * 1000 * i))),
 			StatusCode: 200,
+			Phases: map[HTTPPhase]int64{
+				PhaseConnect: int64(i),
+				PhaseTTFB:    int64(i * 2),
+			},
 		}
-		processEntryToHTTPRecord(rec, records)
+		processEntryToHTTPRecord(rec, records, DefaultHistogramConfig)
 		rec = &HTTPRecordEntry{
 			Iteration:  42,
 			Name:       "This is my other awesome HTTP request",
-			Value:      NanosecondToHistogramTime(int64(1000 * 1000 * i * 3)),
+			Value:      Millisecond.FromDuration(time.Duration(int64(1000 * 1000 * i * 3))),
 			StatusCode: 401,
 		}
-		processEntryToHTTPRecord(rec, records)
+		processEntryToHTTPRecord(rec, records, DefaultHistogramConfig)
 		rec = &HTTPRecordEntry{
 			Iteration:  41,
 			Name:       "This is my other other awesome HTTP request",
-			Value:      NanosecondToHistogramTime(int64(1000 * 1000 * i * 2)),
+			Value:      Millisecond.FromDuration(time.Duration(int64(1000 * 1000 * i * 2))),
 			StatusCode: 500,
 		}
-		processEntryToHTTPRecord(rec, records)
+		processEntryToHTTPRecord(rec, records, DefaultHistogramConfig)
+		rec = &HTTPRecordEntry{
+			Iteration: 41,
+			Name:      "This is my other other awesome HTTP request",
+			Value:     Millisecond.FromDuration(time.Duration(int64(1000 * 1000 * i * 5))),
+			Error:     ErrorTimeout,
+		}
+		processEntryToHTTPRecord(rec, records, DefaultHistogramConfig)
+		result := Ok
+		if i%2 == 1 {
+			result = Ko
+		}
+		processEntryToGroupRecord(&GroupRecordEntry{
+			Iteration:          42,
+			Name:               "This is my awesome business flow",
+			Value:              Millisecond.FromDuration(time.Duration(int64(1000 * 1000 * i * 6))),
+			ValueWithoutPauses: Millisecond.FromDuration(time.Duration(int64(1000 * 1000 * i * 4))),
+			Result:             result,
+		}, records, DefaultHistogramConfig)
 	}
 	return records
 }
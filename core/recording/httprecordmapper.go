@@ -11,6 +11,7 @@ func MapHTTPRecords(records *HTTPRecordsOverTime) (*repov2.PersistedHTTPRecordsO
 		return nil, err
 	}
 	report := &repov2.PersistedHTTPRecordsOverTime{
+		Unit:     string(records.Unit),
 		Global:   p,
 		OverTime: make([]*repov2.PersistedHTTPRecord, 0, len(records.OverTime)),
 	}
@@ -33,6 +34,8 @@ func mapHTTPRecord(rec *HTTPRecord) (*repov2.PersistedHTTPRecord, error) {
 	st := &repov2.PersistedHTTPRecord{
 		PersistedHTTPRequestRecord: *p,
 		PerRequests:                make(map[string]*repov2.PersistedHTTPRequestRecord),
+		Stage:                      rec.Stage,
+		Users:                      rec.Users,
 	}
 	for k, v := range rec.PerRequests {
 		p, err := mapHTTPRequestRecord(v)
@@ -41,6 +44,44 @@ func mapHTTPRecord(rec *HTTPRecord) (*repov2.PersistedHTTPRecord, error) {
 		}
 		st.PerRequests[k] = p
 	}
+	if rec.PerGroups != nil {
+		st.PerGroups = make(map[string]*repov2.PersistedGroupRecord, len(rec.PerGroups))
+		for k, v := range rec.PerGroups {
+			p, err := mapGroupRecord(v)
+			if err != nil {
+				return nil, err
+			}
+			st.PerGroups[k] = p
+		}
+	}
+	return st, nil
+}
+
+func mapGroupRecord(rec *GroupRecord) (*repov2.PersistedGroupRecord, error) {
+	global, err := rec.Global.Export()
+	if err != nil {
+		return nil, err
+	}
+	withoutPauses, err := rec.WithoutPauses.Export()
+	if err != nil {
+		return nil, err
+	}
+	st := &repov2.PersistedGroupRecord{
+		Global:        global,
+		WithoutPauses: withoutPauses,
+		PerOkKo:       make(map[repov2.OkKo]*hdr.Snapshot),
+	}
+	for k, v := range rec.PerOkKo {
+		key := repov2.Ok
+		if k == Ko {
+			key = repov2.Ko
+		}
+		snap, err := v.Export()
+		if err != nil {
+			return nil, err
+		}
+		st.PerOkKo[key] = snap
+	}
 	return st, nil
 }
 
@@ -72,6 +113,26 @@ func mapHTTPRequestRecord(rec *HTTPRequestRecord) (*repov2.PersistedHTTPRequestR
 		}
 		st.PerOkKo[key] = snap
 	}
+	if rec.PerPhase != nil {
+		st.PerPhase = make(map[string]*hdr.Snapshot, len(rec.PerPhase))
+		for k, v := range rec.PerPhase {
+			snap, err := v.Export()
+			if err != nil {
+				return nil, err
+			}
+			st.PerPhase[string(k)] = snap
+		}
+	}
+	if rec.PerError != nil {
+		st.PerError = make(map[string]*hdr.Snapshot, len(rec.PerError))
+		for k, v := range rec.PerError {
+			snap, err := v.Export()
+			if err != nil {
+				return nil, err
+			}
+			st.PerError[string(k)] = snap
+		}
+	}
 	return st, nil
 }
 
@@ -84,6 +145,7 @@ func MapPersistedHTTPRecords(records *repov2.PersistedHTTPRecordsOverTime) (*HTT
 		return nil, err
 	}
 	report := &HTTPRecordsOverTime{
+		Unit:     TimeUnit(records.Unit).OrDefault(),
 		Global:   p,
 		OverTime: make([]*HTTPRecord, 0, len(records.OverTime)),
 	}
@@ -106,6 +168,8 @@ func mapPersistedHTTPRecord(rec *repov2.PersistedHTTPRecord) (*HTTPRecord, error
 	st := &HTTPRecord{
 		HTTPRequestRecord: *p,
 		PerRequests:       make(map[string]*HTTPRequestRecord),
+		Stage:             rec.Stage,
+		Users:             rec.Users,
 	}
 	for k, v := range rec.PerRequests {
 		p, err := mapPersistedHTTPRequestRecord(v)
@@ -114,6 +178,44 @@ func mapPersistedHTTPRecord(rec *repov2.PersistedHTTPRecord) (*HTTPRecord, error
 		}
 		st.PerRequests[k] = p
 	}
+	if rec.PerGroups != nil {
+		st.PerGroups = make(map[string]*GroupRecord, len(rec.PerGroups))
+		for k, v := range rec.PerGroups {
+			p, err := mapPersistedGroupRecord(v)
+			if err != nil {
+				return nil, err
+			}
+			st.PerGroups[k] = p
+		}
+	}
+	return st, nil
+}
+
+func mapPersistedGroupRecord(rec *repov2.PersistedGroupRecord) (*GroupRecord, error) {
+	global, err := hdr.Import(rec.Global)
+	if err != nil {
+		return nil, err
+	}
+	withoutPauses, err := hdr.Import(rec.WithoutPauses)
+	if err != nil {
+		return nil, err
+	}
+	st := &GroupRecord{
+		Global:        global,
+		WithoutPauses: withoutPauses,
+		PerOkKo:       make(map[OkKo]*hdr.Histogram),
+	}
+	for k, v := range rec.PerOkKo {
+		key := Ok
+		if k == repov2.Ko {
+			key = Ko
+		}
+		h, err := hdr.Import(v)
+		if err != nil {
+			return nil, err
+		}
+		st.PerOkKo[key] = h
+	}
 	return st, nil
 }
 
@@ -145,5 +247,25 @@ func mapPersistedHTTPRequestRecord(rec *repov2.PersistedHTTPRequestRecord) (*HTT
 		}
 		st.PerOkKo[key] = h
 	}
+	if rec.PerPhase != nil {
+		st.PerPhase = make(map[HTTPPhase]*hdr.Histogram, len(rec.PerPhase))
+		for k, v := range rec.PerPhase {
+			h, err := hdr.Import(v)
+			if err != nil {
+				return nil, err
+			}
+			st.PerPhase[HTTPPhase(k)] = h
+		}
+	}
+	if rec.PerError != nil {
+		st.PerError = make(map[ErrorCategory]*hdr.Histogram, len(rec.PerError))
+		for k, v := range rec.PerError {
+			h, err := hdr.Import(v)
+			if err != nil {
+				return nil, err
+			}
+			st.PerError[ErrorCategory(k)] = h
+		}
+	}
 	return st, nil
 }
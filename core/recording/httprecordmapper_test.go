@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"math/rand"
 	"testing"
+	"time"
 )
 
 func TestMapHTTPRecords(t *testing.T) {
@@ -17,10 +18,18 @@ func TestMapHTTPRecords(t *testing.T) {
 	require.NoError(t, err)
 
 	assert.Equal(t, rec, reMappedRec)
+
+	t.Run("Records persisted without unit are in milliseconds", func(t *testing.T) {
+		mappedRec.Unit = ""
+		reMappedRec, err := MapPersistedHTTPRecords(mappedRec)
+		require.NoError(t, err)
+		assert.Equal(t, Millisecond, reMappedRec.Unit)
+	})
 }
 
 func buildHTTPRecordsOverTimeForTests(concurrent, iterationCount int) *HTTPRecordsOverTime {
 	records := &HTTPRecordsOverTime{
+		Unit:     Microsecond,
 		Global:   buildHTTPRecordsForTests(concurrent),
 		OverTime: make([]*HTTPRecord, 0, iterationCount),
 	}
@@ -47,7 +56,7 @@ func buildHTTPRecordsOverTimeForBenchmarks(concurrent, iterationCount int) *HTTP
 	records := &HTTPRecordsOverTime{
 		Global: &HTTPRecord{
 			HTTPRequestRecord: HTTPRequestRecord{
-				Global:    createHistogram(),
+				Global:    DefaultHistogramConfig.createHistogram(),
 				PerStatus: make(map[int]*hdr.Histogram),
 				PerOkKo:   make(map[OkKo]*hdr.Histogram),
 			},
@@ -57,17 +66,17 @@ func buildHTTPRecordsOverTimeForBenchmarks(concurrent, iterationCount int) *HTTP
 	}
 
 	for iter := 0; iter < iterationCount; iter++ {
-		records.OverTime = append(records.OverTime, createHTTPRecords(1)...)
+		records.OverTime = append(records.OverTime, createHTTPRecords(1, DefaultHistogramConfig)...)
 		for i := 0; i < concurrent; i++ {
 			rec := &HTTPRecordEntry{
 				Iteration:  iter,
 				Name:       "This is my awesome HTTP request",
-				Value:      NanosecondToHistogramTime(1000*1000 + rand.Int63n(600*1000*1000*1000)),
+				Value:      Millisecond.FromDuration(time.Duration(1000*1000 + rand.Int63n(600*1000*1000*1000))),
 				StatusCode: 200,
 			}
 			// Global record for all iterations
-			processEntryToHTTPRecord(rec, records.Global)
-			processEntryToHTTPRecord(rec, records.OverTime[iter])
+			processEntryToHTTPRecord(rec, records.Global, DefaultHistogramConfig)
+			processEntryToHTTPRecord(rec, records.OverTime[iter], DefaultHistogramConfig)
 		}
 	}
 	return records
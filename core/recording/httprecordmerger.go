@@ -2,20 +2,27 @@ package recording
 
 import (
 	hdr "github.com/ofux/hdrhistogram"
+	"github.com/pkg/errors"
 )
 
-func MergeHTTPRecordsOverTime(rec1, rec2 *HTTPRecordsOverTime) *HTTPRecordsOverTime {
+// MergeHTTPRecordsOverTime merges the records of two runs of a scenario. It fails if their values are not in the same
+// unit.
+func MergeHTTPRecordsOverTime(rec1, rec2 *HTTPRecordsOverTime) (*HTTPRecordsOverTime, error) {
 	if rec1 == nil {
-		return rec2
+		return rec2, nil
 	}
 	if rec2 == nil {
-		return rec1
+		return rec1, nil
+	}
+	if rec1.Unit.OrDefault() != rec2.Unit.OrDefault() {
+		return nil, errors.Errorf("cannot merge records in %s with records in %s", rec1.Unit.OrDefault(), rec2.Unit.OrDefault())
 	}
 
 	if len(rec1.OverTime) < len(rec2.OverTime) {
 		rec1, rec2 = rec2, rec1
 	}
 	merged := &HTTPRecordsOverTime{
+		Unit:   rec1.Unit,
 		Global: mergeHTTPRecords(rec1.Global, rec2.Global),
 	}
 	for i, v1 := range rec1.OverTime {
@@ -25,7 +32,7 @@ func MergeHTTPRecordsOverTime(rec1, rec2 *HTTPRecordsOverTime) *HTTPRecordsOverT
 			merged.OverTime = append(merged.OverTime, v1)
 		}
 	}
-	return merged
+	return merged, nil
 }
 
 func mergeHTTPRecords(rec1, rec2 *HTTPRecord) *HTTPRecord {
@@ -39,6 +46,12 @@ func mergeHTTPRecords(rec1, rec2 *HTTPRecord) *HTTPRecord {
 	merged := &HTTPRecord{
 		HTTPRequestRecord: *mergeHTTPRequestRecords(&rec1.HTTPRequestRecord, &rec2.HTTPRequestRecord),
 		PerRequests:       make(map[string]*HTTPRequestRecord),
+		Stage:             rec1.Stage,
+		// Records being merged come from different workers, so their users add up
+		Users: rec1.Users + rec2.Users,
+	}
+	if rec2.Stage > merged.Stage {
+		merged.Stage = rec2.Stage
 	}
 
 	for k, v1 := range rec1.PerRequests {
@@ -54,6 +67,43 @@ func mergeHTTPRecords(rec1, rec2 *HTTPRecord) *HTTPRecord {
 		}
 	}
 
+	if rec1.PerGroups != nil || rec2.PerGroups != nil {
+		merged.PerGroups = make(map[string]*GroupRecord)
+		for k, v1 := range rec1.PerGroups {
+			if v2, ok := rec2.PerGroups[k]; ok {
+				merged.PerGroups[k] = mergeGroupRecords(v1, v2)
+			} else {
+				merged.PerGroups[k] = v1
+			}
+		}
+		for k, v2 := range rec2.PerGroups {
+			if _, ok := merged.PerGroups[k]; !ok {
+				merged.PerGroups[k] = v2
+			}
+		}
+	}
+
+	return merged
+}
+
+func mergeGroupRecords(rec1, rec2 *GroupRecord) *GroupRecord {
+	merged := &GroupRecord{
+		Global:        mergeHistograms(rec1.Global, rec2.Global),
+		WithoutPauses: mergeHistograms(rec1.WithoutPauses, rec2.WithoutPauses),
+		PerOkKo:       make(map[OkKo]*hdr.Histogram),
+	}
+	for k, h1 := range rec1.PerOkKo {
+		if h2, ok := rec2.PerOkKo[k]; ok {
+			merged.PerOkKo[k] = mergeHistograms(h1, h2)
+		} else {
+			merged.PerOkKo[k] = h1.Copy()
+		}
+	}
+	for k, h2 := range rec2.PerOkKo {
+		if _, ok := merged.PerOkKo[k]; !ok {
+			merged.PerOkKo[k] = h2.Copy()
+		}
+	}
 	return merged
 }
 
@@ -90,5 +140,37 @@ func mergeHTTPRequestRecords(rec1, rec2 *HTTPRequestRecord) *HTTPRequestRecord {
 		}
 	}
 
+	if rec1.PerPhase != nil || rec2.PerPhase != nil {
+		merged.PerPhase = make(map[HTTPPhase]*hdr.Histogram)
+		for k, h1 := range rec1.PerPhase {
+			if h2, ok := rec2.PerPhase[k]; ok {
+				merged.PerPhase[k] = mergeHistograms(h1, h2)
+			} else {
+				merged.PerPhase[k] = h1.Copy()
+			}
+		}
+		for k, h2 := range rec2.PerPhase {
+			if _, ok := merged.PerPhase[k]; !ok {
+				merged.PerPhase[k] = h2.Copy()
+			}
+		}
+	}
+
+	if rec1.PerError != nil || rec2.PerError != nil {
+		merged.PerError = make(map[ErrorCategory]*hdr.Histogram)
+		for k, h1 := range rec1.PerError {
+			if h2, ok := rec2.PerError[k]; ok {
+				merged.PerError[k] = mergeHistograms(h1, h2)
+			} else {
+				merged.PerError[k] = h1.Copy()
+			}
+		}
+		for k, h2 := range rec2.PerError {
+			if _, ok := merged.PerError[k]; !ok {
+				merged.PerError[k] = h2.Copy()
+			}
+		}
+	}
+
 	return merged
 }
@@ -2,6 +2,7 @@ package recording
 
 import (
 	hdr "github.com/ofux/hdrhistogram"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"reflect"
 	"testing"
@@ -749,13 +750,123 @@ func TestMergeHTTPRecordsOverTime(t *testing.T) {
 			},
 		},
 	}
+	tests = append(tests, struct {
+		name string
+		args args
+		want *HTTPRecordsOverTime
+	}{
+		name: "Stages and users over time",
+		args: args{
+			rec1: &HTTPRecordsOverTime{
+				OverTime: []*HTTPRecord{
+					newFakeStagedRecord(t, 1, 3, 200),
+					newFakeStagedRecord(t, 2, 3, 200),
+				},
+			},
+			rec2: &HTTPRecordsOverTime{
+				OverTime: []*HTTPRecord{
+					newFakeStagedRecord(t, 1, 4, 300),
+					newFakeStagedRecord(t, 1, 2, 300),
+				},
+			},
+		},
+		want: &HTTPRecordsOverTime{
+			OverTime: []*HTTPRecord{
+				newFakeStagedRecord(t, 1, 7, 200, 300),
+				newFakeStagedRecord(t, 2, 5, 200, 300),
+			},
+		},
+	})
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := MergeHTTPRecordsOverTime(tt.args.rec1, tt.args.rec2); !reflect.DeepEqual(got, tt.want) {
+			got, err := MergeHTTPRecordsOverTime(tt.args.rec1, tt.args.rec2)
+			require.NoError(t, err)
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("MergeHTTPRecordsOverTime() = %v, want %v", got, tt.want)
 			}
 		})
 	}
+
+	t.Run("Records in different units", func(t *testing.T) {
+		rec1 := &HTTPRecordsOverTime{Unit: Millisecond, Global: newFakeStagedRecord(t, 0, 0, 200)}
+		rec2 := &HTTPRecordsOverTime{Unit: Microsecond, Global: newFakeStagedRecord(t, 0, 0, 300)}
+		_, err := MergeHTTPRecordsOverTime(rec1, rec2)
+		require.EqualError(t, err, "cannot merge records in ms with records in us")
+	})
+
+	t.Run("Records without unit are in milliseconds", func(t *testing.T) {
+		rec1 := &HTTPRecordsOverTime{Global: newFakeStagedRecord(t, 0, 0, 200)}
+		rec2 := &HTTPRecordsOverTime{Unit: Millisecond, Global: newFakeStagedRecord(t, 0, 0, 300)}
+		got, err := MergeHTTPRecordsOverTime(rec1, rec2)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), got.Global.Global.TotalCount())
+	})
+
+	t.Run("Records with phases", func(t *testing.T) {
+		rec1 := &HTTPRecordsOverTime{Global: newFakeStagedRecord(t, 0, 0, 200)}
+		rec1.Global.PerPhase = map[HTTPPhase]*hdr.Histogram{
+			PhaseTTFB: newFakeHistogram(t, 100),
+			PhaseDNS:  newFakeHistogram(t, 10),
+		}
+		rec2 := &HTTPRecordsOverTime{Global: newFakeStagedRecord(t, 0, 0, 300)}
+		rec2.Global.PerPhase = map[HTTPPhase]*hdr.Histogram{
+			PhaseTTFB: newFakeHistogram(t, 150),
+		}
+		got, err := MergeHTTPRecordsOverTime(rec1, rec2)
+		require.NoError(t, err)
+		require.Len(t, got.Global.PerPhase, 2)
+		assert.Equal(t, int64(2), got.Global.PerPhase[PhaseTTFB].TotalCount())
+		assert.Equal(t, int64(1), got.Global.PerPhase[PhaseDNS].TotalCount())
+	})
+
+	t.Run("Records with errors", func(t *testing.T) {
+		rec1 := &HTTPRecordsOverTime{Global: newFakeStagedRecord(t, 0, 0, 200)}
+		rec1.Global.PerError = map[ErrorCategory]*hdr.Histogram{ErrorTimeout: newFakeHistogram(t, 900)}
+		rec2 := &HTTPRecordsOverTime{Global: newFakeStagedRecord(t, 0, 0, 300)}
+		rec2.Global.PerError = map[ErrorCategory]*hdr.Histogram{
+			ErrorTimeout: newFakeHistogram(t, 800),
+			ErrorRefused: newFakeHistogram(t, 1),
+		}
+		got, err := MergeHTTPRecordsOverTime(rec1, rec2)
+		require.NoError(t, err)
+		require.Len(t, got.Global.PerError, 2)
+		assert.Equal(t, int64(2), got.Global.PerError[ErrorTimeout].TotalCount())
+		assert.Equal(t, int64(1), got.Global.PerError[ErrorRefused].TotalCount())
+		assert.Nil(t, got.Global.PerPhase)
+	})
+
+	t.Run("Records with groups", func(t *testing.T) {
+		rec1 := &HTTPRecordsOverTime{Global: newFakeStagedRecord(t, 0, 0, 200)}
+		rec1.Global.PerGroups = map[string]*GroupRecord{
+			"checkout": {
+				Global:        newFakeHistogram(t, 900),
+				WithoutPauses: newFakeHistogram(t, 400),
+				PerOkKo:       map[OkKo]*hdr.Histogram{Ok: newFakeHistogram(t, 900)},
+			},
+		}
+		rec2 := &HTTPRecordsOverTime{Global: newFakeStagedRecord(t, 0, 0, 300)}
+		rec2.Global.PerGroups = map[string]*GroupRecord{
+			"checkout": {
+				Global:        newFakeHistogram(t, 800),
+				WithoutPauses: newFakeHistogram(t, 300),
+				PerOkKo:       map[OkKo]*hdr.Histogram{Ko: newFakeHistogram(t, 800)},
+			},
+			"browse": {
+				Global:        newFakeHistogram(t, 10),
+				WithoutPauses: newFakeHistogram(t, 10),
+				PerOkKo:       map[OkKo]*hdr.Histogram{Ok: newFakeHistogram(t, 10)},
+			},
+		}
+		got, err := MergeHTTPRecordsOverTime(rec1, rec2)
+		require.NoError(t, err)
+		require.Len(t, got.Global.PerGroups, 2)
+		checkout := got.Global.PerGroups["checkout"]
+		assert.Equal(t, int64(2), checkout.Global.TotalCount())
+		assert.Equal(t, int64(2), checkout.WithoutPauses.TotalCount())
+		assert.Equal(t, int64(1), checkout.PerOkKo[Ok].TotalCount())
+		assert.Equal(t, int64(1), checkout.PerOkKo[Ko].TotalCount())
+		assert.Equal(t, int64(1), got.Global.PerGroups["browse"].Global.TotalCount())
+	})
 }
 
 func newFakeHistogram(t *testing.T, values ...int64) *hdr.Histogram {
@@ -766,3 +877,16 @@ func newFakeHistogram(t *testing.T, values ...int64) *hdr.Histogram {
 	}
 	return histo
 }
+
+func newFakeStagedRecord(t *testing.T, stage, users int, values ...int64) *HTTPRecord {
+	return &HTTPRecord{
+		HTTPRequestRecord: HTTPRequestRecord{
+			Global:    newFakeHistogram(t, values...),
+			PerStatus: map[int]*hdr.Histogram{},
+			PerOkKo:   map[OkKo]*hdr.Histogram{},
+		},
+		PerRequests: map[string]*HTTPRequestRecord{},
+		Stage:       stage,
+		Users:       users,
+	}
+}
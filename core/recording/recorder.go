@@ -2,7 +2,9 @@ package recording
 
 import (
 	hdr "github.com/ofux/hdrhistogram"
+	"github.com/pkg/errors"
 	"sync"
+	"time"
 )
 
 type RecordingState int
@@ -23,10 +25,18 @@ const (
 
 type RecordEntry interface{}
 
+// Sink receives every entry recorded by a Recorder, to forward it to an external system.
+// Send is called by the goroutine that records the entry, so it must not block.
+type Sink interface {
+	Send(rec RecordEntry)
+}
+
 type Recorder struct {
+	sinks                 []Sink
 	recording             RecordingState
+	recordingMutex        *sync.RWMutex
 	recordsQueue          chan RecordEntry
-	askForRecordsSnapshot chan chan<- RecordSnapshot
+	askForRecordsSnapshot chan snapshotRequest
 	recordingWaitGroup    *sync.WaitGroup
 	processingWaitGroup   *sync.WaitGroup
 }
@@ -36,20 +46,36 @@ type RecordSnapshot struct {
 	Err                         error
 }
 
+type snapshotRequest struct {
+	snapshotChan chan<- RecordSnapshot
+	// globalOnly asks for the global records only, which leaves the next snapshot of records over time unchanged
+	globalOnly bool
+}
+
 func NewRecorder(concurrent int) *Recorder {
 	return &Recorder{
 		recording:             READY,
+		recordingMutex:        new(sync.RWMutex),
 		recordsQueue:          make(chan RecordEntry, concurrent),
-		askForRecordsSnapshot: make(chan chan<- RecordSnapshot),
+		askForRecordsSnapshot: make(chan snapshotRequest),
 		recordingWaitGroup:    new(sync.WaitGroup),
 		processingWaitGroup:   new(sync.WaitGroup),
 	}
 }
 
-// HTTPRecordEntry records a new Value in the underlying appropriate HDRHistogram.
+// AddSink adds a sink that will receive all the entries recorded from now on.
+// It must be called before recording starts.
+func (r *Recorder) AddSink(sink Sink) {
+	r.sinks = append(r.sinks, sink)
+}
+
+// HTTPRecordEntry records a new Value in the underlying appropriate HDRHistogram, and sends it to the sinks.
 // This is safe to call this method from different goroutines.
 // Calling this method on a closed Recorder will cause a panic.
 func (r *Recorder) Record(rec RecordEntry) {
+	for _, sink := range r.sinks {
+		sink.Send(rec)
+	}
 	r.recordingWaitGroup.Add(1)
 	go func() {
 		defer r.recordingWaitGroup.Done()
@@ -60,20 +86,37 @@ func (r *Recorder) Record(rec RecordEntry) {
 // Close closes the Recorder, making the results available for read.
 // Trying to record some values on a closed Recorder will cause a panic.
 func (r *Recorder) Close() {
-	if r.recording == RECORDING {
-		r.recording = TERMINATING
-		// wait for all records to be taken
-		r.recordingWaitGroup.Wait()
-		// ensure listener won't stay blocked
-		close(r.recordsQueue)
-		// wait for the end of recording
-		r.processingWaitGroup.Wait()
-		r.recording = TERMINATED
+	r.recordingMutex.Lock()
+	if r.recording != RECORDING {
+		r.recordingMutex.Unlock()
+		return
 	}
+	r.recording = TERMINATING
+	r.recordingMutex.Unlock()
+
+	// wait for all records to be taken
+	r.recordingWaitGroup.Wait()
+	// ensure listener won't stay blocked
+	close(r.recordsQueue)
+	// wait for the end of recording
+	r.processingWaitGroup.Wait()
+	r.setRecordingState(TERMINATED)
+}
+
+func (r *Recorder) getRecordingState() RecordingState {
+	r.recordingMutex.RLock()
+	defer r.recordingMutex.RUnlock()
+	return r.recording
 }
 
-func (r *Recorder) processRecords(processRecord func(RecordEntry), processSnapshotRequest func(chan<- RecordSnapshot)) {
-	r.recording = RECORDING
+func (r *Recorder) setRecordingState(state RecordingState) {
+	r.recordingMutex.Lock()
+	defer r.recordingMutex.Unlock()
+	r.recording = state
+}
+
+func (r *Recorder) processRecords(processRecord func(RecordEntry), processSnapshotRequest func(snapshotRequest)) {
+	r.setRecordingState(RECORDING)
 	r.processingWaitGroup.Add(1)
 
 	go func() {
@@ -86,9 +129,9 @@ func (r *Recorder) processRecords(processRecord func(RecordEntry), processSnapsh
 					return // exit for loop and goroutine when recordsQueue is closed
 				}
 				processRecord(rec)
-			case snapshotChan, ok := <-r.askForRecordsSnapshot:
+			case request, ok := <-r.askForRecordsSnapshot:
 				if ok {
-					processSnapshotRequest(snapshotChan)
+					processSnapshotRequest(request)
 				}
 			}
 		}
@@ -96,14 +139,80 @@ func (r *Recorder) processRecords(processRecord func(RecordEntry), processSnapsh
 
 }
 
-func createHistogram() *hdr.Histogram {
-	// Max value represents one hour. Min value represents 1ms.
-	return hdr.New(0, 3600*1000, 3)
+// TimeUnit is the unit of the values recorded in histograms.
+type TimeUnit string
+
+const (
+	Millisecond TimeUnit = "ms"
+	Microsecond TimeUnit = "us"
+	Nanosecond  TimeUnit = "ns"
+)
+
+// Duration returns the duration of one unit. Records persisted before the unit was introduced have no unit, and
+// are in milliseconds.
+func (u TimeUnit) Duration() time.Duration {
+	switch u {
+	case Microsecond:
+		return time.Microsecond
+	case Nanosecond:
+		return time.Nanosecond
+	default:
+		return time.Millisecond
+	}
+}
+
+// FromDuration converts a duration to a value of this unit, truncated.
+func (u TimeUnit) FromDuration(d time.Duration) int64 {
+	return int64(d / u.Duration())
+}
+
+// ToMilliseconds converts a value of this unit to milliseconds.
+func (u TimeUnit) ToMilliseconds(value float64) float64 {
+	return value * float64(u.Duration()) / float64(time.Millisecond)
+}
+
+// OrDefault returns the unit, or milliseconds if it is not set.
+func (u TimeUnit) OrDefault() TimeUnit {
+	if u == "" {
+		return Millisecond
+	}
+	return u
+}
+
+// ParseTimeUnit parses ms, us or ns.
+func ParseTimeUnit(unit string) (TimeUnit, error) {
+	switch u := TimeUnit(unit); u {
+	case Millisecond, Microsecond, Nanosecond:
+		return u, nil
+	}
+	return "", errors.Errorf("unknown time unit '%s', expected %s, %s or %s", unit, Millisecond, Microsecond, Nanosecond)
+}
+
+// HistogramConfig sets the resolution and the range of the histograms of response times.
+type HistogramConfig struct {
+	// Unit is the resolution of the histograms
+	Unit TimeUnit
+	// MaxValue is the highest response time that can be tracked. Longer ones are recorded as MaxValue.
+	MaxValue time.Duration
+}
+
+// DefaultHistogramConfig tracks response times from 0 to 1 hour at millisecond resolution.
+var DefaultHistogramConfig = HistogramConfig{Unit: Millisecond, MaxValue: time.Hour}
+
+// Validate tells whether histograms can be created with this configuration.
+func (c HistogramConfig) Validate() error {
+	if _, err := ParseTimeUnit(string(c.Unit)); err != nil {
+		return err
+	}
+	// The highest trackable value must be at least twice the lowest discernible one (1)
+	if c.Unit.FromDuration(c.MaxValue) < 2 {
+		return errors.Errorf("max value %s is too low for unit %s", c.MaxValue, c.Unit)
+	}
+	return nil
 }
 
-func NanosecondToHistogramTime(nano int64) int64 {
-	const nanoToHisto = 1000 * 1000 // Converts nanoseconds to milliseconds
-	return nano / nanoToHisto
+func (c HistogramConfig) createHistogram() *hdr.Histogram {
+	return hdr.New(0, c.Unit.FromDuration(c.MaxValue), 3)
 }
 
 func mergeHistograms(h1, h2 *hdr.Histogram) *hdr.Histogram {
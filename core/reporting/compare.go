@@ -0,0 +1,281 @@
+package reporting
+
+import (
+	"fmt"
+	"github.com/ofux/deluge/core/recording"
+	hdr "github.com/ofux/hdrhistogram"
+	"math"
+	"sort"
+	"time"
+)
+
+// Tolerances are the changes from a baseline that are not considered as regressions.
+type Tolerances struct {
+	// ResponseTime is the tolerated relative increase of the mean and percentiles of response times, such as 0.1 for +10%
+	ResponseTime float64 `json:"responseTime"`
+	// Throughput is the tolerated relative decrease of the number of calls per second, such as 0.1 for -10%
+	Throughput float64 `json:"throughput"`
+	// ErrorRate is the tolerated absolute increase of the ratio of KO calls, such as 0.01 for +1 point
+	ErrorRate float64 `json:"errorRate"`
+}
+
+// DefaultTolerances are the tolerances used when none are given.
+var DefaultTolerances = Tolerances{ResponseTime: 0.1, Throughput: 0.1, ErrorRate: 0.01}
+
+// Names of the metrics that can regress, along with the percentiles ("p50", "p95"...)
+const (
+	MetricMean       = "mean"
+	MetricThroughput = "throughput"
+	MetricErrorRate  = "errorRate"
+)
+
+// comparedQuantiles are the percentiles of response times that are compared
+var comparedQuantiles = []int{50, 75, 90, 95, 99}
+
+// ksCriticalCoefficient gives the critical value of the two-sample Kolmogorov-Smirnov test for a significance level of 0.05
+const ksCriticalCoefficient = 1.358
+
+// Delta is the change of a metric from a baseline. Response times are in milliseconds, so that runs recorded at
+// different resolutions can be compared.
+type Delta struct {
+	Baseline float64
+	Current  float64
+	// Change is Current - Baseline
+	Change float64
+	// RelativeChange is Change / Baseline, or nil if Baseline is 0
+	RelativeChange *float64
+}
+
+// RequestComparison compares the statistics of a request with the ones of a baseline.
+type RequestComparison struct {
+	Percentiles map[int]*Delta
+	Mean        *Delta
+	// Throughput is in calls per second. It is nil if the duration of a run is unknown.
+	Throughput *Delta
+	ErrorRate  *Delta
+	// Significant tells whether the response time distributions differ with a confidence of 95%, according to a
+	// two-sample Kolmogorov-Smirnov test. Deltas that are not significant are likely noise.
+	Significant bool
+	// Regressions are the metrics that changed more than tolerated
+	Regressions []string
+}
+
+// HTTPComparison compares the report of a scenario with the one of a baseline.
+type HTTPComparison struct {
+	RequestComparison
+	PerRequests map[string]*RequestComparison
+	// OnlyInBaseline and OnlyInCurrent are the requests that were called in one run only
+	OnlyInBaseline []string
+	OnlyInCurrent  []string
+}
+
+// ComparedRun is the report of a scenario and how long the scenario ran.
+type ComparedRun struct {
+	Report   *HTTPReport
+	Duration time.Duration
+}
+
+// HasRegression tells whether the scenario or one of its requests regressed.
+func (c *HTTPComparison) HasRegression() bool {
+	if len(c.Regressions) > 0 {
+		return true
+	}
+	for _, request := range c.PerRequests {
+		if len(request.Regressions) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// CompareHTTPReports compares the report of a scenario with the report of a baseline, for all the requests and for each
+// request that was called in both runs.
+func CompareHTTPReports(baseline, current ComparedRun, tolerances Tolerances) *HTTPComparison {
+	baselineStats := globalStats(baseline.Report)
+	currentStats := globalStats(current.Report)
+
+	comparison := &HTTPComparison{
+		RequestComparison: *compareRequests(&baselineStats.HTTPRequestStats, &currentStats.HTTPRequestStats, baseline.Duration, current.Duration, tolerances),
+		PerRequests:       make(map[string]*RequestComparison),
+		OnlyInBaseline:    make([]string, 0),
+		OnlyInCurrent:     make([]string, 0),
+	}
+	for name, baselineRequest := range baselineStats.PerRequests {
+		if currentRequest, ok := currentStats.PerRequests[name]; ok {
+			comparison.PerRequests[name] = compareRequests(baselineRequest, currentRequest, baseline.Duration, current.Duration, tolerances)
+		} else {
+			comparison.OnlyInBaseline = append(comparison.OnlyInBaseline, name)
+		}
+	}
+	for name := range currentStats.PerRequests {
+		if _, ok := baselineStats.PerRequests[name]; !ok {
+			comparison.OnlyInCurrent = append(comparison.OnlyInCurrent, name)
+		}
+	}
+	sort.Strings(comparison.OnlyInBaseline)
+	sort.Strings(comparison.OnlyInCurrent)
+	return comparison
+}
+
+func globalStats(report *HTTPReport) *HTTPStats {
+	if report == nil || report.Stats == nil || report.Stats.Global == nil {
+		return &HTTPStats{HTTPRequestStats: HTTPRequestStats{Global: &Stats{}}}
+	}
+	return report.Stats.Global
+}
+
+func compareRequests(baseline, current *HTTPRequestStats, baselineDuration, currentDuration time.Duration, tolerances Tolerances) *RequestComparison {
+	baselineGlobal, currentGlobal := baseline.Global, current.Global
+	if baselineGlobal == nil {
+		baselineGlobal = &Stats{}
+	}
+	if currentGlobal == nil {
+		currentGlobal = &Stats{}
+	}
+
+	comparison := &RequestComparison{
+		Percentiles: make(map[int]*Delta),
+		Mean:        newDelta(baselineGlobal.Milliseconds(baselineGlobal.MeanTime), currentGlobal.Milliseconds(currentGlobal.MeanTime)),
+		ErrorRate:   newDelta(errorRate(baseline), errorRate(current)),
+		Significant: distributionsDiffer(baselineGlobal, currentGlobal),
+		Regressions: make([]string, 0),
+	}
+	for _, quantile := range comparedQuantiles {
+		delta := newDelta(
+			baselineGlobal.Milliseconds(float64(baselineGlobal.ValueAtQuantiles[quantile])),
+			currentGlobal.Milliseconds(float64(currentGlobal.ValueAtQuantiles[quantile])),
+		)
+		comparison.Percentiles[quantile] = delta
+		if delta.increasedMoreThan(tolerances.ResponseTime) {
+			comparison.Regressions = append(comparison.Regressions, fmt.Sprintf("p%d", quantile))
+		}
+	}
+	if comparison.Mean.increasedMoreThan(tolerances.ResponseTime) {
+		comparison.Regressions = append(comparison.Regressions, MetricMean)
+	}
+	if baselineDuration > 0 && currentDuration > 0 {
+		comparison.Throughput = newDelta(
+			float64(baselineGlobal.CallCount)/baselineDuration.Seconds(),
+			float64(currentGlobal.CallCount)/currentDuration.Seconds(),
+		)
+		if comparison.Throughput.RelativeChange != nil && *comparison.Throughput.RelativeChange < -tolerances.Throughput {
+			comparison.Regressions = append(comparison.Regressions, MetricThroughput)
+		}
+	}
+	if comparison.ErrorRate.Change > tolerances.ErrorRate {
+		comparison.Regressions = append(comparison.Regressions, MetricErrorRate)
+	}
+	return comparison
+}
+
+func newDelta(baseline, current float64) *Delta {
+	delta := &Delta{
+		Baseline: baseline,
+		Current:  current,
+		Change:   current - baseline,
+	}
+	if baseline != 0 {
+		relativeChange := delta.Change / baseline
+		delta.RelativeChange = &relativeChange
+	}
+	return delta
+}
+
+func (d *Delta) increasedMoreThan(tolerance float64) bool {
+	return d.RelativeChange != nil && *d.RelativeChange > tolerance
+}
+
+func errorRate(stats *HTTPRequestStats) float64 {
+	if stats.Global == nil || stats.Global.CallCount == 0 {
+		return 0
+	}
+	koStats := stats.PerOkKo[recording.Ko]
+	if koStats == nil {
+		return 0
+	}
+	return float64(koStats.CallCount) / float64(stats.Global.CallCount)
+}
+
+// distributionsDiffer runs a two-sample Kolmogorov-Smirnov test on the cumulative distributions of response times.
+// Both distributions are walked once, in increasing order of response times.
+func distributionsDiffer(baseline, current *Stats) bool {
+	if baseline.CallCount == 0 || current.CallCount == 0 {
+		return false
+	}
+	baselineWalk, currentWalk := newCumulativeWalk(baseline), newCumulativeWalk(current)
+	maxDistance := 0.0
+	for !baselineWalk.done() || !currentWalk.done() {
+		value := math.Min(baselineWalk.nextValue(), currentWalk.nextValue())
+		value += valueTolerance(baseline, current, value)
+		baselineWalk.advanceTo(value)
+		currentWalk.advanceTo(value)
+		maxDistance = math.Max(maxDistance, math.Abs(baselineWalk.ratio()-currentWalk.ratio()))
+	}
+	n1, n2 := float64(baseline.CallCount), float64(current.CallCount)
+	return maxDistance > ksCriticalCoefficient*math.Sqrt((n1+n2)/(n1*n2))
+}
+
+// valueTolerance returns how much higher than the given response time, in milliseconds, the same response time may be
+// in the other distribution. Histograms of the same unit round response times the same way. Otherwise, response times
+// are truncated to the coarser unit, and histograms only keep 3 significant digits.
+func valueTolerance(baseline, current *Stats, value float64) float64 {
+	if baseline.Unit.OrDefault() == current.Unit.OrDefault() {
+		return 0
+	}
+	return math.Max(baseline.Milliseconds(1), current.Milliseconds(1)) + value*histogramPrecision
+}
+
+// histogramPrecision is the highest relative error of the response times of histograms with 3 significant digits
+const histogramPrecision = 0.001
+
+// cumulativeWalk walks the cumulative distribution of response times of some stats in increasing order of response
+// times, counting the calls that took at most the response times walked so far.
+type cumulativeWalk struct {
+	stats    *Stats
+	brackets []hdr.Bracket
+	next     int
+	count    int64
+}
+
+func newCumulativeWalk(stats *Stats) *cumulativeWalk {
+	brackets := stats.CumulativeDistribution
+	less := func(i, j int) bool {
+		return brackets[i].ValueAt < brackets[j].ValueAt ||
+			(brackets[i].ValueAt == brackets[j].ValueAt && brackets[i].Count < brackets[j].Count)
+	}
+	if !sort.SliceIsSorted(brackets, less) {
+		// Stats may come from anywhere, such as a saved report, so they are sorted without changing them
+		brackets = append([]hdr.Bracket(nil), brackets...)
+		sort.Slice(brackets, less)
+	}
+	return &cumulativeWalk{
+		stats:    stats,
+		brackets: brackets,
+	}
+}
+
+func (w *cumulativeWalk) done() bool {
+	return w.next >= len(w.brackets)
+}
+
+// nextValue returns the next response time of the distribution, in milliseconds, or +Inf if there is none.
+func (w *cumulativeWalk) nextValue() float64 {
+	if w.done() {
+		return math.Inf(1)
+	}
+	return w.stats.Milliseconds(float64(w.brackets[w.next].ValueAt))
+}
+
+// advanceTo walks the distribution up to the given response time, in milliseconds, included.
+func (w *cumulativeWalk) advanceTo(value float64) {
+	for ; !w.done() && w.nextValue() <= value; w.next++ {
+		if count := w.brackets[w.next].Count; count > w.count {
+			w.count = count
+		}
+	}
+}
+
+// ratio returns the ratio of calls that took at most the response times walked so far.
+func (w *cumulativeWalk) ratio() float64 {
+	return float64(w.count) / float64(w.stats.CallCount)
+}
@@ -0,0 +1,157 @@
+package reporting
+
+import (
+	"github.com/ofux/deluge/core/recording"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newComparedRun(t *testing.T, duration time.Duration, entries ...*recording.HTTPRecordEntry) ComparedRun {
+	t.Helper()
+	return newComparedRunWithConfig(t, duration, recording.DefaultHistogramConfig, entries...)
+}
+
+func newComparedRunWithConfig(t *testing.T, duration time.Duration, config recording.HistogramConfig, entries ...*recording.HTTPRecordEntry) ComparedRun {
+	t.Helper()
+	recorder := recording.NewHTTPRecorder(1, 1, config)
+	for _, entry := range entries {
+		recorder.Record(entry)
+	}
+	recorder.Close()
+	records, err := recorder.GetRecords()
+	require.NoError(t, err)
+	return ComparedRun{
+		Report:   (&HTTPReporter{}).Report(records).(*HTTPReport),
+		Duration: duration,
+	}
+}
+
+func repeatEntry(count int, name string, value int64, statusCode int) []*recording.HTTPRecordEntry {
+	entries := make([]*recording.HTTPRecordEntry, 0, count)
+	for i := 0; i < count; i++ {
+		entries = append(entries, &recording.HTTPRecordEntry{Name: name, Value: value, StatusCode: statusCode})
+	}
+	return entries
+}
+
+func TestCompareHTTPReports(t *testing.T) {
+
+	t.Run("Compare identical reports", func(t *testing.T) {
+		baseline := newComparedRun(t, time.Second, repeatEntry(100, "foo", 10, 200)...)
+		current := newComparedRun(t, time.Second, repeatEntry(100, "foo", 10, 200)...)
+
+		comparison := CompareHTTPReports(baseline, current, DefaultTolerances)
+		assert.False(t, comparison.HasRegression())
+		assert.False(t, comparison.Significant)
+		assert.Empty(t, comparison.Regressions)
+		assert.Equal(t, 0.0, comparison.Percentiles[95].Change)
+		assert.Equal(t, 0.0, *comparison.Percentiles[95].RelativeChange)
+		assert.Equal(t, 100.0, comparison.Throughput.Current)
+		require.Contains(t, comparison.PerRequests, "foo")
+		assert.Empty(t, comparison.PerRequests["foo"].Regressions)
+		assert.Empty(t, comparison.OnlyInBaseline)
+		assert.Empty(t, comparison.OnlyInCurrent)
+	})
+
+	t.Run("Detect regressions", func(t *testing.T) {
+		baseline := newComparedRun(t, time.Second, append(
+			repeatEntry(100, "foo", 10, 200),
+			repeatEntry(100, "bar", 10, 200)...,
+		)...)
+		current := newComparedRun(t, 2*time.Second, append(
+			repeatEntry(100, "foo", 20, 200),
+			append(repeatEntry(90, "bar", 10, 200), repeatEntry(10, "bar", 10, 500)...)...,
+		)...)
+
+		comparison := CompareHTTPReports(baseline, current, DefaultTolerances)
+		assert.True(t, comparison.HasRegression())
+
+		foo := comparison.PerRequests["foo"]
+		assert.True(t, foo.Significant)
+		assert.Equal(t, []string{"p50", "p75", "p90", "p95", "p99", MetricMean, MetricThroughput}, foo.Regressions)
+		assert.Equal(t, 10.0, foo.Percentiles[50].Change)
+		assert.Equal(t, 1.0, *foo.Percentiles[50].RelativeChange)
+		assert.Equal(t, -0.5, *foo.Throughput.RelativeChange)
+
+		bar := comparison.PerRequests["bar"]
+		assert.False(t, bar.Significant)
+		assert.Equal(t, []string{MetricThroughput, MetricErrorRate}, bar.Regressions)
+		assert.Equal(t, 0.1, bar.ErrorRate.Change)
+		assert.Nil(t, bar.ErrorRate.RelativeChange)
+
+		// Tolerances can be loosened
+		comparison = CompareHTTPReports(baseline, current, Tolerances{ResponseTime: 1, Throughput: 0.5, ErrorRate: 0.1})
+		assert.False(t, comparison.HasRegression())
+	})
+
+	t.Run("Compare reports with different requests", func(t *testing.T) {
+		baseline := newComparedRun(t, 0, append(
+			repeatEntry(10, "foo", 10, 200),
+			repeatEntry(10, "old", 10, 200)...,
+		)...)
+		current := newComparedRun(t, 0, append(
+			repeatEntry(10, "foo", 10, 200),
+			repeatEntry(10, "new", 10, 200)...,
+		)...)
+
+		comparison := CompareHTTPReports(baseline, current, DefaultTolerances)
+		assert.Len(t, comparison.PerRequests, 1)
+		assert.Equal(t, []string{"old"}, comparison.OnlyInBaseline)
+		assert.Equal(t, []string{"new"}, comparison.OnlyInCurrent)
+		assert.Nil(t, comparison.Throughput, "throughput is unknown without duration")
+	})
+
+	t.Run("Compare with an empty report", func(t *testing.T) {
+		current := newComparedRun(t, time.Second, repeatEntry(10, "foo", 10, 200)...)
+
+		comparison := CompareHTTPReports(ComparedRun{}, current, DefaultTolerances)
+		assert.Equal(t, []string{"foo"}, comparison.OnlyInCurrent)
+		assert.False(t, comparison.Significant)
+		assert.Nil(t, comparison.Percentiles[50].RelativeChange)
+		assert.False(t, comparison.HasRegression())
+	})
+
+	t.Run("Compare reports recorded in different units", func(t *testing.T) {
+		baseline := newComparedRun(t, time.Second, repeatEntry(100, "foo", 10, 200)...)
+		microseconds := recording.HistogramConfig{Unit: recording.Microsecond, MaxValue: time.Minute}
+		current := newComparedRunWithConfig(t, time.Second, microseconds, repeatEntry(100, "foo", 10000, 200)...)
+
+		comparison := CompareHTTPReports(baseline, current, DefaultTolerances)
+		assert.Equal(t, 10.0, comparison.Percentiles[50].Baseline)
+		// Values are rounded to 3 significant digits by histograms
+		assert.InDelta(t, 10.0, comparison.Percentiles[50].Current, 0.01)
+		assert.InDelta(t, 10.0, comparison.Mean.Current, 0.01)
+		assert.False(t, comparison.HasRegression())
+	})
+
+	t.Run("Equal distributions recorded in different units are not significantly different", func(t *testing.T) {
+		var baselineEntries, currentEntries []*recording.HTTPRecordEntry
+		for ms := int64(1); ms <= 5000; ms += 7 {
+			baselineEntries = append(baselineEntries, repeatEntry(3, "foo", ms, 200)...)
+			currentEntries = append(currentEntries, repeatEntry(3, "foo", ms*1000, 200)...)
+		}
+		baseline := newComparedRun(t, time.Second, baselineEntries...)
+		microseconds := recording.HistogramConfig{Unit: recording.Microsecond, MaxValue: time.Minute}
+		current := newComparedRunWithConfig(t, time.Second, microseconds, currentEntries...)
+
+		comparison := CompareHTTPReports(baseline, current, DefaultTolerances)
+		assert.False(t, comparison.Significant)
+		assert.False(t, comparison.HasRegression())
+	})
+
+	t.Run("Different distributions recorded in different units are significantly different", func(t *testing.T) {
+		var baselineEntries, currentEntries []*recording.HTTPRecordEntry
+		for ms := int64(1); ms <= 5000; ms += 7 {
+			baselineEntries = append(baselineEntries, repeatEntry(3, "foo", ms, 200)...)
+			currentEntries = append(currentEntries, repeatEntry(3, "foo", ms*2000, 200)...)
+		}
+		baseline := newComparedRun(t, time.Second, baselineEntries...)
+		microseconds := recording.HistogramConfig{Unit: recording.Microsecond, MaxValue: time.Minute}
+		current := newComparedRunWithConfig(t, time.Second, microseconds, currentEntries...)
+
+		comparison := CompareHTTPReports(baseline, current, DefaultTolerances)
+		assert.True(t, comparison.Significant)
+	})
+}
@@ -0,0 +1,96 @@
+package reporting
+
+import (
+	"github.com/ofux/deluge/dsl/object"
+	"sort"
+	"time"
+)
+
+// ErrorGroup is a set of identical errors (same message at the same location) that made iterations of the users of a
+// scenario fail.
+type ErrorGroup struct {
+	*object.Error
+	Count   uint64
+	FirstAt time.Time
+	LastAt  time.Time
+	// Requests are the names of the last requests called by the users before the error occurred, sorted. An error
+	// that occurred before any request of its iteration has no request.
+	Requests []string
+}
+
+// NewErrorGroup creates a group with a first occurrence of the given error.
+func NewErrorGroup(err *object.Error, request string, at time.Time) *ErrorGroup {
+	group := &ErrorGroup{Error: err}
+	group.Add(request, at)
+	return group
+}
+
+// Add counts another occurrence of the error of the group, during or after the given request (empty if none).
+func (g *ErrorGroup) Add(request string, at time.Time) {
+	g.Count++
+	if g.FirstAt.IsZero() || at.Before(g.FirstAt) {
+		g.FirstAt = at
+	}
+	if at.After(g.LastAt) {
+		g.LastAt = at
+	}
+	if request != "" {
+		g.Requests = addRequest(g.Requests, request)
+	}
+}
+
+// ErrorKey returns the key that identical errors have in common: their message and location.
+func ErrorKey(err *object.Error) string {
+	return err.Inspect()
+}
+
+// MergeErrorGroups merges the groups of identical errors, for instance the errors of a scenario on several workers.
+// The given groups are left unchanged. The merged groups are sorted by first occurrence.
+func MergeErrorGroups(groups ...[]*ErrorGroup) []*ErrorGroup {
+	merged := make([]*ErrorGroup, 0)
+	byKey := make(map[string]*ErrorGroup)
+	for _, someGroups := range groups {
+		for _, group := range someGroups {
+			key := ErrorKey(group.Error)
+			mergedGroup, ok := byKey[key]
+			if !ok {
+				mergedGroup = &ErrorGroup{
+					Error:    group.Error,
+					Count:    group.Count,
+					FirstAt:  group.FirstAt,
+					LastAt:   group.LastAt,
+					Requests: append([]string(nil), group.Requests...),
+				}
+				byKey[key] = mergedGroup
+				merged = append(merged, mergedGroup)
+				continue
+			}
+			mergedGroup.Count += group.Count
+			if group.FirstAt.Before(mergedGroup.FirstAt) {
+				mergedGroup.FirstAt = group.FirstAt
+			}
+			if group.LastAt.After(mergedGroup.LastAt) {
+				mergedGroup.LastAt = group.LastAt
+			}
+			for _, request := range group.Requests {
+				mergedGroup.Requests = addRequest(mergedGroup.Requests, request)
+			}
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].FirstAt.Before(merged[j].FirstAt)
+	})
+	return merged
+}
+
+// addRequest adds a request to the sorted requests, unless it is already there.
+func addRequest(requests []string, request string) []string {
+	i := sort.SearchStrings(requests, request)
+	if i < len(requests) && requests[i] == request {
+		return requests
+	}
+	requests = append(requests, "")
+	copy(requests[i+1:], requests[i:])
+	requests[i] = request
+	return requests
+}
@@ -0,0 +1,59 @@
+package reporting
+
+import (
+	"github.com/ofux/deluge/dsl/object"
+	"github.com/ofux/deluge/dsl/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestErrorGroup(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	assertErr := &object.Error{Message: "Assertion failed", StackToken: []token.Token{{Type: token.IDENT, Literal: "assert", Line: 3}}}
+
+	t.Run("Count occurrences", func(t *testing.T) {
+		group := NewErrorGroup(assertErr, "foo", start.Add(time.Second))
+		group.Add("", start)
+		group.Add("bar", start.Add(2*time.Second))
+		group.Add("foo", start.Add(time.Second))
+
+		assert.Equal(t, &ErrorGroup{
+			Error:    assertErr,
+			Count:    4,
+			FirstAt:  start,
+			LastAt:   start.Add(2 * time.Second),
+			Requests: []string{"bar", "foo"},
+		}, group)
+	})
+
+	t.Run("Merge groups", func(t *testing.T) {
+		otherErr := &object.Error{Message: "Other error"}
+		worker1 := []*ErrorGroup{
+			{Error: otherErr, Count: 1, FirstAt: start.Add(time.Second), LastAt: start.Add(time.Second)},
+			{Error: assertErr, Count: 2, FirstAt: start.Add(time.Second), LastAt: start.Add(3 * time.Second), Requests: []string{"foo"}},
+		}
+		worker2 := []*ErrorGroup{
+			{Error: &object.Error{Message: "Assertion failed", StackToken: []token.Token{{Type: token.IDENT, Literal: "assert", Line: 3}}}, Count: 3, FirstAt: start, LastAt: start.Add(2 * time.Second), Requests: []string{"bar", "foo"}},
+			// Same message, but somewhere else
+			{Error: &object.Error{Message: "Assertion failed", StackToken: []token.Token{{Type: token.IDENT, Literal: "assert", Line: 4}}}, Count: 1, FirstAt: start.Add(5 * time.Second), LastAt: start.Add(5 * time.Second)},
+		}
+
+		merged := MergeErrorGroups(worker1, worker2)
+		require.Len(t, merged, 3)
+		assert.Equal(t, &ErrorGroup{Error: assertErr, Count: 5, FirstAt: start, LastAt: start.Add(3 * time.Second), Requests: []string{"bar", "foo"}}, merged[0])
+		assert.Equal(t, otherErr, merged[1].Error)
+		assert.Equal(t, uint64(1), merged[1].Count)
+		assert.Equal(t, 4, merged[2].StackToken[0].Line)
+
+		// The given groups are unchanged
+		assert.Equal(t, uint64(2), worker1[1].Count)
+		assert.Equal(t, []string{"foo"}, worker1[1].Requests)
+	})
+
+	t.Run("Merge no group", func(t *testing.T) {
+		assert.Empty(t, MergeErrorGroups())
+		assert.Empty(t, MergeErrorGroups(nil, []*ErrorGroup{}))
+	})
+}
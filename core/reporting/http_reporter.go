@@ -15,53 +15,127 @@ type HTTPStatsOverTime struct {
 	PerIteration []*HTTPStats
 }
 
+// HTTPStatsSnapshot holds the statistics of the iterations that changed since the previous snapshot.
+type HTTPStatsSnapshot struct {
+	Global       *HTTPStats
+	PerIteration map[int]*HTTPStats
+}
+
 type HTTPStats struct {
 	HTTPRequestStats
 	PerRequests map[string]*HTTPRequestStats
+	// PerGroups holds the statistics of the groups of requests of the script, such as business flows
+	PerGroups map[string]*GroupStats `json:",omitempty"`
+	// Stage is the number (starting at 1) of the load stage of the iteration, or 0 if the scenario has no stages
+	Stage int `json:",omitempty"`
+	// Users is the number of users that were running during the iteration
+	Users int `json:",omitempty"`
 }
 
 type HTTPRequestStats struct {
 	Global    *Stats
 	PerStatus map[int]*Stats
 	PerOkKo   map[recording.OkKo]*Stats
+	// PerPhase holds the statistics of the phases of the requests: DNS lookup, TCP connect, TLS handshake, time to
+	// first byte and transfer of the response
+	PerPhase map[recording.HTTPPhase]*Stats `json:",omitempty"`
+	// PerError holds the statistics of the requests that got no response, per category of error
+	PerError map[recording.ErrorCategory]*Stats `json:",omitempty"`
+}
+
+// GroupStats are the statistics of the executions of a group of requests.
+type GroupStats struct {
+	// Global are the durations of the executions, pauses included
+	Global *Stats
+	// WithoutPauses are the durations of the executions, minus the time spent in pauses
+	WithoutPauses *Stats
+	PerOkKo       map[recording.OkKo]*Stats
 }
 
 func (r *HTTPReporter) Report(records *recording.HTTPRecordsOverTime) Report {
 	report := &HTTPReport{
 		Stats: &HTTPStatsOverTime{
-			Global:       newHTTPStats(records.Global),
+			Global:       newHTTPStats(records.Global, records.Unit.OrDefault()),
 			PerIteration: make([]*HTTPStats, 0, 16),
 		},
 	}
 	for _, v := range records.OverTime {
-		report.Stats.PerIteration = append(report.Stats.PerIteration, newHTTPStats(v))
+		report.Stats.PerIteration = append(report.Stats.PerIteration, newHTTPStats(v, records.Unit.OrDefault()))
 	}
 
 	return report
 }
 
-func newHTTPStats(rec *recording.HTTPRecord) *HTTPStats {
+// ReportSnapshot computes the statistics of a snapshot of records. Only the iterations of the snapshot are reported.
+func (r *HTTPReporter) ReportSnapshot(snapshot *recording.HTTPRecordsOverTimeSnapshot) *HTTPStatsSnapshot {
+	stats := &HTTPStatsSnapshot{
+		PerIteration: make(map[int]*HTTPStats, len(snapshot.OverTime)),
+	}
+	if snapshot.Global != nil {
+		stats.Global = newHTTPStats(snapshot.Global, snapshot.Unit.OrDefault())
+	}
+	for index, rec := range snapshot.OverTime {
+		if rec != nil {
+			stats.PerIteration[index] = newHTTPStats(rec, snapshot.Unit.OrDefault())
+		}
+	}
+	return stats
+}
+
+func newHTTPStats(rec *recording.HTTPRecord, unit recording.TimeUnit) *HTTPStats {
 	st := &HTTPStats{
-		HTTPRequestStats: *newHTTPRequestStats(&(rec.HTTPRequestRecord)),
+		HTTPRequestStats: *newHTTPRequestStats(&(rec.HTTPRequestRecord), unit),
 		PerRequests:      make(map[string]*HTTPRequestStats),
+		Stage:            rec.Stage,
+		Users:            rec.Users,
 	}
 	for k, v := range rec.PerRequests {
-		st.PerRequests[k] = newHTTPRequestStats(v)
+		st.PerRequests[k] = newHTTPRequestStats(v, unit)
+	}
+	if rec.PerGroups != nil {
+		st.PerGroups = make(map[string]*GroupStats, len(rec.PerGroups))
+		for k, v := range rec.PerGroups {
+			st.PerGroups[k] = newGroupStats(v, unit)
+		}
 	}
 	return st
 }
 
-func newHTTPRequestStats(rec *recording.HTTPRequestRecord) *HTTPRequestStats {
+func newGroupStats(rec *recording.GroupRecord, unit recording.TimeUnit) *GroupStats {
+	st := &GroupStats{
+		Global:        newStatsFromHistogram(rec.Global, unit),
+		WithoutPauses: newStatsFromHistogram(rec.WithoutPauses, unit),
+		PerOkKo:       make(map[recording.OkKo]*Stats),
+	}
+	for k, v := range rec.PerOkKo {
+		st.PerOkKo[k] = newStatsFromHistogram(v, unit)
+	}
+	return st
+}
+
+func newHTTPRequestStats(rec *recording.HTTPRequestRecord, unit recording.TimeUnit) *HTTPRequestStats {
 	st := &HTTPRequestStats{
-		Global:    newStatsFromHistogram(rec.Global),
+		Global:    newStatsFromHistogram(rec.Global, unit),
 		PerStatus: make(map[int]*Stats),
 		PerOkKo:   make(map[recording.OkKo]*Stats),
 	}
 	for k, v := range rec.PerStatus {
-		st.PerStatus[k] = newStatsFromHistogram(v)
+		st.PerStatus[k] = newStatsFromHistogram(v, unit)
 	}
 	for k, v := range rec.PerOkKo {
-		st.PerOkKo[k] = newStatsFromHistogram(v)
+		st.PerOkKo[k] = newStatsFromHistogram(v, unit)
+	}
+	if rec.PerPhase != nil {
+		st.PerPhase = make(map[recording.HTTPPhase]*Stats, len(rec.PerPhase))
+		for k, v := range rec.PerPhase {
+			st.PerPhase[k] = newStatsFromHistogram(v, unit)
+		}
+	}
+	if rec.PerError != nil {
+		st.PerError = make(map[recording.ErrorCategory]*Stats, len(rec.PerError))
+		for k, v := range rec.PerError {
+			st.PerError[k] = newStatsFromHistogram(v, unit)
+		}
 	}
 	return st
 }
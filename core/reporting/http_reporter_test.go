@@ -5,10 +5,11 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 func TestHTTPReporter_Report(t *testing.T) {
-	recorder := recording.NewHTTPRecorder(3, 1)
+	recorder := recording.NewHTTPRecorder(3, 1, recording.DefaultHistogramConfig)
 
 	for i := 0; i < 3; i++ {
 		recorder.Record(&recording.HTTPRecordEntry{
@@ -59,6 +60,7 @@ func TestHTTPReporter_Report(t *testing.T) {
 	report := reporter.Report(recs)
 	rep := report.(*HTTPReport)
 	assert.Equal(t, int64(18), rep.Stats.Global.Global.CallCount)
+	assert.Equal(t, recording.Millisecond, rep.Stats.Global.Global.Unit)
 	assert.Equal(t, int64(100), rep.Stats.Global.Global.MinTime)
 	assert.InDelta(t, int64(40000), rep.Stats.Global.Global.MaxTime, 100)
 
@@ -79,4 +81,103 @@ func TestHTTPReporter_Report(t *testing.T) {
 	assert.Len(t, rep.Stats.Global.PerRequests["bar"].PerStatus, 2)
 	assert.Equal(t, int64(3), rep.Stats.Global.PerRequests["bar"].PerStatus[201].CallCount)
 	assert.Equal(t, int64(3), rep.Stats.Global.PerRequests["bar"].PerStatus[500].CallCount)
+
+	// Phases were not recorded, and all the requests got a response
+	assert.Nil(t, rep.Stats.Global.PerPhase)
+	assert.Nil(t, rep.Stats.Global.PerError)
+
+	t.Run("Report the requests that got no response", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+		recorder.Record(&recording.HTTPRecordEntry{Name: "foo", Value: 1000, Error: recording.ErrorTimeout})
+		recorder.Record(&recording.HTTPRecordEntry{Name: "foo", Value: 3, Error: recording.ErrorDNS})
+		recorder.Close()
+		recs, err := recorder.GetRecords()
+		require.NoError(t, err)
+
+		rep := reporter.Report(recs).(*HTTPReport)
+		require.Len(t, rep.Stats.Global.PerError, 2)
+		assert.Equal(t, int64(1), rep.Stats.Global.PerError[recording.ErrorTimeout].CallCount)
+		assert.Equal(t, int64(2), rep.Stats.Global.PerOkKo[recording.Ko].CallCount)
+		assert.Empty(t, rep.Stats.Global.PerStatus)
+		assert.Equal(t, int64(3), rep.Stats.Global.PerRequests["foo"].PerError[recording.ErrorDNS].MaxTime)
+	})
+
+	t.Run("Report the phases of requests", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+		recorder.Record(&recording.HTTPRecordEntry{
+			Name:       "foo",
+			Value:      50,
+			StatusCode: 200,
+			Phases:     map[recording.HTTPPhase]int64{recording.PhaseTTFB: 30, recording.PhaseTransfer: 5},
+		})
+		recorder.Close()
+		recs, err := recorder.GetRecords()
+		require.NoError(t, err)
+
+		rep := reporter.Report(recs).(*HTTPReport)
+		require.Len(t, rep.Stats.Global.PerPhase, 2)
+		assert.Equal(t, int64(30), rep.Stats.Global.PerPhase[recording.PhaseTTFB].MaxTime)
+		assert.Equal(t, recording.Millisecond, rep.Stats.Global.PerPhase[recording.PhaseTTFB].Unit)
+		assert.Equal(t, int64(5), rep.Stats.Global.PerRequests["foo"].PerPhase[recording.PhaseTransfer].MaxTime)
+	})
+
+	t.Run("Report groups", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+		recorder.Record(&recording.GroupRecordEntry{Name: "checkout", Value: 1500, ValueWithoutPauses: 500})
+		recorder.Record(&recording.GroupRecordEntry{Name: "checkout", Value: 700, ValueWithoutPauses: 700, Result: recording.Ko})
+		recorder.Close()
+		recs, err := recorder.GetRecords()
+		require.NoError(t, err)
+
+		rep := reporter.Report(recs).(*HTTPReport)
+		require.Len(t, rep.Stats.Global.PerGroups, 1)
+		checkout := rep.Stats.Global.PerGroups["checkout"]
+		assert.Equal(t, int64(2), checkout.Global.CallCount)
+		assert.Equal(t, int64(1500), checkout.Global.MaxTime)
+		assert.Equal(t, int64(700), checkout.WithoutPauses.MaxTime)
+		assert.Equal(t, int64(1), checkout.PerOkKo[recording.Ko].CallCount)
+		assert.Len(t, rep.Stats.PerIteration[0].PerGroups, 1)
+		// Groups are not requests
+		assert.Equal(t, int64(0), rep.Stats.Global.Global.CallCount)
+	})
+}
+
+func TestHTTPReporter_ReportSnapshot(t *testing.T) {
+	recorder := recording.NewHTTPRecorder(3, 1, recording.DefaultHistogramConfig)
+	defer recorder.Close()
+
+	recordAndWait := func(iteration int) {
+		recorder.Record(&recording.HTTPRecordEntry{
+			Iteration:  iteration,
+			Name:       "foo",
+			Value:      1000,
+			StatusCode: 200,
+		})
+		// Records are processed asynchronously
+		time.Sleep(20 * time.Millisecond)
+	}
+	getSnapshot := func() *recording.HTTPRecordsOverTimeSnapshot {
+		snapChan, err := recorder.GetRecordsSnapshot()
+		require.NoError(t, err)
+		snap := <-snapChan
+		require.NoError(t, snap.Err)
+		return snap.HTTPRecordsOverTimeSnapshot
+	}
+
+	reporter := &HTTPReporter{}
+
+	recordAndWait(0)
+	recordAndWait(1)
+	stats := reporter.ReportSnapshot(getSnapshot())
+	assert.Equal(t, int64(2), stats.Global.Global.CallCount)
+	assert.Equal(t, recording.Millisecond, stats.Global.Global.Unit)
+	assert.Len(t, stats.PerIteration, 2)
+	assert.Equal(t, int64(1), stats.PerIteration[0].Global.CallCount)
+	assert.Equal(t, int64(1), stats.PerIteration[1].PerRequests["foo"].Global.CallCount)
+
+	recordAndWait(1)
+	stats = reporter.ReportSnapshot(getSnapshot())
+	assert.Equal(t, int64(3), stats.Global.Global.CallCount)
+	assert.Len(t, stats.PerIteration, 1)
+	assert.Equal(t, int64(2), stats.PerIteration[1].Global.CallCount)
 }
@@ -12,6 +12,9 @@ type Reporter interface {
 }
 
 type Stats struct {
+	// Unit is the unit of the response times below. Stats reported before the unit was introduced have no unit, and
+	// are in milliseconds.
+	Unit                   recording.TimeUnit
 	CallCount              int64
 	MinTime                int64
 	MaxTime                int64
@@ -20,8 +23,9 @@ type Stats struct {
 	CumulativeDistribution []hdr.Bracket
 }
 
-func newStatsFromHistogram(histo *hdr.Histogram) *Stats {
+func newStatsFromHistogram(histo *hdr.Histogram, unit recording.TimeUnit) *Stats {
 	stats := &Stats{
+		Unit:                   unit,
 		CallCount:              histo.TotalCount(),
 		MinTime:                histo.Min(),
 		MaxTime:                histo.Max(),
@@ -36,3 +40,8 @@ func newStatsFromHistogram(histo *hdr.Histogram) *Stats {
 	stats.ValueAtQuantiles[99] = histo.ValueAtQuantile(99)
 	return stats
 }
+
+// Milliseconds converts a response time of the stats to milliseconds.
+func (s *Stats) Milliseconds(value float64) float64 {
+	return s.Unit.ToMilliseconds(value)
+}
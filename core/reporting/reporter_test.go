@@ -1,6 +1,7 @@
 package reporting
 
 import (
+	"github.com/ofux/deluge/core/recording"
 	hdr "github.com/ofux/hdrhistogram"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -15,9 +16,10 @@ func TestNewStatsFromHistogram(t *testing.T) {
 		histo.RecordValue(50)
 		histo.RecordValue(100)
 
-		stats := newStatsFromHistogram(histo)
+		stats := newStatsFromHistogram(histo, recording.Millisecond)
 
 		assert.Equal(t, &Stats{
+			Unit:      recording.Millisecond,
 			CallCount: 4,
 			MinTime:   1,
 			MaxTime:   100,
@@ -62,9 +64,10 @@ func TestNewStatsFromHistogram(t *testing.T) {
 	t.Run("Get stats from empty histogram", func(t *testing.T) {
 		histo := hdr.New(0, 100, 2)
 
-		stats := newStatsFromHistogram(histo)
+		stats := newStatsFromHistogram(histo, recording.Millisecond)
 
 		assert.Equal(t, &Stats{
+			Unit:      recording.Millisecond,
 			CallCount: 0,
 			MinTime:   0,
 			MaxTime:   0,
@@ -3,31 +3,70 @@ package core
 import (
 	"errors"
 	"fmt"
+	"github.com/ofux/deluge/core/eventlog"
 	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/reporting"
 	"github.com/ofux/deluge/core/status"
 	"github.com/ofux/deluge/dsl/object"
 	log "github.com/sirupsen/logrus"
+	"math"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+var (
+	// ErrUnknownScenario is returned when a scenario is not part of a deluge.
+	ErrUnknownScenario = errors.New("unknown scenario")
+	// ErrScenarioNotRunning is returned when trying to change the concurrency of a scenario that is not running.
+	ErrScenarioNotRunning = errors.New("scenario is not running")
+	// ErrConcurrencyNotAdjustable is returned when trying to change the concurrency of a scenario that does not run
+	// a fixed number of concurrent users.
+	ErrConcurrencyNotAdjustable = errors.New("concurrency can only be changed on scenarios with a fixed number of concurrent users")
+)
+
 type RunnableScenario struct {
-	compiledScenario  *CompiledScenario
-	simUsers          []*simUser
+	compiledScenario *CompiledScenario
+	simUsers         []*simUser
+	// concurrent is the number of users that should be running (closed model without stages only)
+	concurrent int
+	// activeUserCount is the number of users that are currently running
+	activeUserCount int64
+	arrivalRate     int
+	maxUsers        int
+	// profile is the load profile of the scenario, if it has stages. It is nil otherwise.
+	profile           *loadProfile
 	scriptArgs        *object.Hash
 	IterationDuration time.Duration
 	globalDuration    time.Duration
 	httpRecorder      *recording.HTTPRecorder
-	log               *log.Entry
+	// httpClient configures the HTTP client of each user. It is nil for the default client.
+	httpClient *httpClientConfig
+	// eventLog is where every HTTP request of the users is written, if set
+	eventLog *eventlog.Writer
+	log      *log.Entry
+	// onError is called the first time each distinct error occurs, if set
+	onError func(err *object.Error)
+	// errorPolicy is what the scenario does when an iteration of a user fails because of an error
+	errorPolicy errorPolicy
+	errors      errorAggregator
+	// abortDeluge aborts the deluge the scenario is part of, if any. It is used by the abortDeluge error policy.
+	abortDeluge func(err *object.Error)
+	// halted is closed when all the users of the scenario must stop after their current iteration
+	halted   chan struct{}
+	haltOnce *sync.Once
 
-	Status             status.ScenarioStatus
-	Errors             []*object.Error
+	Status status.ScenarioStatus
+	// Errors are the groups of identical errors that made iterations fail. It is set once the scenario has ended.
+	Errors             []*reporting.ErrorGroup
 	Records            *recording.HTTPRecordsOverTime
 	EffectiveUserCount uint64
 	EffectiveExecCount uint64
-	Mutex              *sync.Mutex
+	// DroppedExecCount is the number of iterations that could not be started on time because all the users
+	// were busy (open model only)
+	DroppedExecCount uint64
+	Mutex            *sync.Mutex
 }
 
 func newRunnableScenario(
@@ -36,32 +75,86 @@ func newRunnableScenario(
 	globalDuration time.Duration,
 	iterationDuration time.Duration,
 	scriptArgs *object.Hash,
+	histogram recording.HistogramConfig,
+	httpClient *httpClientConfig,
+	logEntry *log.Entry,
+) *RunnableScenario {
+	s := newRunnableScenarioBase(compiledScenario, concurrent, concurrent, globalDuration, iterationDuration, scriptArgs, histogram, httpClient, logEntry)
+	s.concurrent = concurrent
+	for i := 0; i < concurrent; i++ {
+		s.simUsers[i] = newSimUser(strconv.Itoa(i), s)
+	}
+
+	return s
+}
+
+// maxOpenRecorderBufferSize bounds the number of entries an open model scenario can record before they are processed,
+// whatever its arrival rate.
+const maxOpenRecorderBufferSize = 10000
+
+// newRunnableOpenScenario creates a scenario that starts arrivalRate new iterations per second (open model),
+// however long the previous iterations take. Iterations are run by users taken from a pool that grows on demand,
+// up to maxUsers users (0 means no limit).
+func newRunnableOpenScenario(
+	compiledScenario *CompiledScenario,
+	arrivalRate int,
+	maxUsers int,
+	globalDuration time.Duration,
+	scriptArgs *object.Hash,
+	histogram recording.HistogramConfig,
+	httpClient *httpClientConfig,
+	logEntry *log.Entry,
+) *RunnableScenario {
+	if arrivalRate <= 0 {
+		// Nothing will ever be scheduled
+		return newRunnableScenario(compiledScenario, 0, globalDuration, globalDuration, scriptArgs, histogram, httpClient, logEntry)
+	}
+	bufferSize := arrivalRate
+	if maxUsers > 0 {
+		bufferSize = maxUsers
+	}
+	if bufferSize > maxOpenRecorderBufferSize {
+		bufferSize = maxOpenRecorderBufferSize
+	}
+	s := newRunnableScenarioBase(compiledScenario, 0, bufferSize, globalDuration, time.Second/time.Duration(arrivalRate), scriptArgs, histogram, httpClient, logEntry)
+	s.arrivalRate = arrivalRate
+	s.maxUsers = maxUsers
+	return s
+}
+
+func newRunnableScenarioBase(
+	compiledScenario *CompiledScenario,
+	userCount int,
+	recorderBufferSize int,
+	globalDuration time.Duration,
+	iterationDuration time.Duration,
+	scriptArgs *object.Hash,
+	histogram recording.HistogramConfig,
+	httpClient *httpClientConfig,
 	logEntry *log.Entry,
 ) *RunnableScenario {
 	iterationCount := globalDuration.Nanoseconds() / iterationDuration.Nanoseconds()
-	s := &RunnableScenario{
+	return &RunnableScenario{
 		compiledScenario:  compiledScenario,
 		scriptArgs:        scriptArgs,
 		IterationDuration: iterationDuration,
 		globalDuration:    globalDuration,
-		simUsers:          make([]*simUser, concurrent),
+		simUsers:          make([]*simUser, userCount),
 
-		httpRecorder: recording.NewHTTPRecorder(int(iterationCount), concurrent),
+		httpRecorder: recording.NewHTTPRecorder(int(iterationCount), recorderBufferSize, histogram),
+		httpClient:   httpClient,
 		log: logEntry.WithFields(log.Fields{
 			"scenario": compiledScenario.scenario.ID,
 		}),
 
+		halted:   make(chan struct{}),
+		haltOnce: &sync.Once{},
+
 		Status: status.ScenarioVirgin,
-		Errors: make([]*object.Error, 0),
+		Errors: make([]*reporting.ErrorGroup, 0),
 
 		Mutex: &sync.Mutex{},
 	}
-
-	for i := 0; i < concurrent; i++ {
-		s.simUsers[i] = newSimUser(strconv.Itoa(i), s)
-	}
-
-	return s
 }
 
 // GetScenarioDefinition returns a copy of the scenario definition
@@ -78,9 +171,51 @@ func (sc *RunnableScenario) GetRecordsSnapshot() (*recording.RecordSnapshot, err
 	return &rec, nil
 }
 
-func (sc *RunnableScenario) run(interrupt chan struct{}) {
-	var waitg sync.WaitGroup
+// GetGlobalStats returns the statistics of all the records of the scenario so far, or of all its records once it ended.
+func (sc *RunnableScenario) GetGlobalStats() (*reporting.HTTPStats, error) {
+	if records, err := sc.httpRecorder.GetRecords(); err == nil {
+		return (&reporting.HTTPReporter{}).ReportSnapshot(&recording.HTTPRecordsOverTimeSnapshot{Unit: records.Unit, Global: records.Global}).Global, nil
+	}
+	return sc.getGlobalStats()
+}
+
+// GetActiveUserCount returns the number of users that are currently running.
+func (sc *RunnableScenario) GetActiveUserCount() int64 {
+	return atomic.LoadInt64(&sc.activeUserCount)
+}
+
+// SetEventLog makes the scenario write every HTTP request of its users to the given event log.
+// It must be called before the scenario runs.
+func (sc *RunnableScenario) SetEventLog(eventLog *eventlog.Writer) {
+	sc.eventLog = eventLog
+}
 
+// GetRecordingUnit returns the unit of the response times recorded by the scenario.
+func (sc *RunnableScenario) GetRecordingUnit() recording.TimeUnit {
+	return sc.httpRecorder.Unit()
+}
+
+// AddRecordSink adds a sink that will receive every request recorded by the scenario.
+// It must be called before the scenario runs.
+func (sc *RunnableScenario) AddRecordSink(sink recording.Sink) {
+	sc.httpRecorder.AddSink(sink)
+}
+
+// getGlobalStats returns the statistics of all the records of the scenario so far. Unlike GetRecordsSnapshot, it
+// leaves the next snapshot unchanged.
+func (sc *RunnableScenario) getGlobalStats() (*reporting.HTTPStats, error) {
+	snap, err := sc.httpRecorder.GetGlobalRecordsSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	rec := <-snap
+	if rec.Err != nil {
+		return nil, rec.Err
+	}
+	return (&reporting.HTTPReporter{}).ReportSnapshot(rec.HTTPRecordsOverTimeSnapshot).Global, nil
+}
+
+func (sc *RunnableScenario) run(interrupt chan struct{}) {
 	start := time.Now()
 	endTime := start.Add(sc.globalDuration)
 
@@ -91,25 +226,29 @@ func (sc *RunnableScenario) run(interrupt chan struct{}) {
 	sc.Status = status.ScenarioInProgress
 	sc.Mutex.Unlock()
 
-	for _, su := range sc.simUsers {
-		waitg.Add(1)
-		go func(su *simUser) {
-			defer waitg.Done()
-			sc.runSimUser(su, endTime, interrupt)
-		}(su)
+	if sc.arrivalRate > 0 {
+		sc.runScheduledIterations(start, endTime, interrupt)
+	} else {
+		sc.runSimUsers(start, endTime, interrupt)
 	}
-	waitg.Wait()
 
 	sc.Mutex.Lock()
 	sc.end()
 	sc.Mutex.Unlock()
 
 	sc.log.Infof("Scenario executed in %s simulating %d users for %d executions", time.Now().Sub(start).String(), sc.EffectiveUserCount, sc.EffectiveExecCount)
+	if sc.DroppedExecCount > 0 {
+		sc.log.Warnf("%d executions were dropped because all %d users were busy", sc.DroppedExecCount, sc.maxUsers)
+	}
 }
 
-func (sc *RunnableScenario) runSimUser(su *simUser, endTime time.Time, interrupt chan struct{}) {
+func (sc *RunnableScenario) runSimUser(su *simUser, start, endTime time.Time, interrupt chan struct{}) {
+	atomic.AddInt64(&sc.activeUserCount, 1)
 	defer func() {
+		atomic.AddInt64(&sc.activeUserCount, -1)
 		atomic.AddUint64(&sc.EffectiveUserCount, 1)
+		// Connections reused across iterations are kept until the user stops
+		su.client.CloseIdleConnections()
 	}()
 
 	i := 0
@@ -119,15 +258,24 @@ func (sc *RunnableScenario) runSimUser(su *simUser, endTime time.Time, interrupt
 			su.status = UserInterrupted
 			sc.log.Debugf("Terminate user simulation %s because of interrupt signal.", su.name)
 			return
+		case <-su.stop:
+			sc.log.Debugf("Terminate user simulation %s because the load profile decreased.", su.name)
+			return
+		case <-sc.halted:
+			sc.log.Debugf("Terminate user simulation %s because the scenario stopped on error.", su.name)
+			return
 		default:
 			iterationEndTime := time.Now().Add(sc.IterationDuration)
 
 			sc.log.Debugf("Running user simulation %s", su.name)
-			su.run(i)
+			if sc.profile != nil {
+				_, su.stage = sc.profile.valueAt(time.Since(start))
+			}
+			su.run(su.firstIteration + i)
 			i++
 			atomic.AddUint64(&sc.EffectiveExecCount, 1)
 
-			if su.status == UserDoneError {
+			if su.status == UserDoneError && sc.errorPolicy != continueOnError {
 				sc.log.Debugf("Terminate user simulation %s because an error occurred.", su.name)
 				return
 			}
@@ -136,7 +284,18 @@ func (sc *RunnableScenario) runSimUser(su *simUser, endTime time.Time, interrupt
 			if iterationEndTime.Before(endTime) {
 				// Wait till the end of iteration as defined in scenario configuration
 				if time.Now().Before(iterationEndTime) {
-					time.Sleep(time.Until(iterationEndTime))
+					timer := time.NewTimer(time.Until(iterationEndTime))
+					select {
+					case <-timer.C:
+					case <-su.stop:
+						timer.Stop()
+						sc.log.Debugf("Terminate user simulation %s because the load profile decreased.", su.name)
+						return
+					case <-sc.halted:
+						timer.Stop()
+						sc.log.Debugf("Terminate user simulation %s because the scenario stopped on error.", su.name)
+						return
+					}
 				}
 			} else {
 				sc.log.Debugf("Terminate user simulation %s.", su.name)
@@ -146,6 +305,65 @@ func (sc *RunnableScenario) runSimUser(su *simUser, endTime time.Time, interrupt
 	}
 }
 
+// handleError records an error that made an iteration of a user fail, during or after the given request (empty if
+// none), and applies the error policy of the scenario.
+func (sc *RunnableScenario) handleError(err *object.Error, request string) {
+	if sc.errors.add(err, request, time.Now()) && sc.onError != nil {
+		sc.onError(err)
+	}
+
+	switch sc.errorPolicy {
+	case stopScenarioOnError:
+		sc.halt()
+	case abortDelugeOnError:
+		if sc.abortDeluge != nil {
+			sc.abortDeluge(err)
+		} else {
+			sc.halt()
+		}
+	}
+}
+
+// halt makes all the users of the scenario stop after their current iteration, and no new iteration start.
+func (sc *RunnableScenario) halt() {
+	sc.haltOnce.Do(func() {
+		sc.log.Warn("Stopping scenario because of an error")
+		close(sc.halted)
+	})
+}
+
+// SetConcurrency changes the number of users of a running scenario. Missing users are started right away, and
+// surplus users stop after their current iteration.
+func (sc *RunnableScenario) SetConcurrency(concurrent int) error {
+	if concurrent < 0 {
+		return fmt.Errorf("concurrency should be a positive integer but was %d", concurrent)
+	}
+	if sc.arrivalRate > 0 || sc.profile != nil {
+		return ErrConcurrencyNotAdjustable
+	}
+
+	sc.Mutex.Lock()
+	defer sc.Mutex.Unlock()
+	if sc.Status != status.ScenarioInProgress {
+		return ErrScenarioNotRunning
+	}
+	sc.log.Infof("Changing concurrency from %d to %d users", sc.concurrent, concurrent)
+	sc.concurrent = concurrent
+	return nil
+}
+
+// getTargetUserCount returns the number of users that should be running at the given time since the beginning of
+// the scenario (closed model only).
+func (sc *RunnableScenario) getTargetUserCount(elapsed time.Duration) int {
+	if sc.profile != nil {
+		target, _ := sc.profile.valueAt(elapsed)
+		return int(math.Round(target))
+	}
+	sc.Mutex.Lock()
+	defer sc.Mutex.Unlock()
+	return sc.concurrent
+}
+
 func (sc *RunnableScenario) end() {
 
 	sc.httpRecorder.Close()
@@ -156,13 +374,20 @@ func (sc *RunnableScenario) end() {
 		sc.log.Error(err)
 	}
 
+	var droppedErrors uint64
+	sc.Errors, droppedErrors = sc.errors.get()
+	if droppedErrors > 0 {
+		sc.log.Warnf("%d errors were not kept because the scenario already had %d distinct errors", droppedErrors, maxScenarioErrors)
+	}
+
 	sc.Status = status.ScenarioDoneSuccess
-	for _, su := range sc.simUsers {
-		if su.status == UserDoneError {
-			sc.Status = status.ScenarioDoneError
-			sc.Errors = append(sc.Errors, su.execError)
-		} else if su.status == UserInterrupted && sc.Status != status.ScenarioDoneError {
-			sc.Status = status.ScenarioInterrupted
+	if len(sc.Errors) > 0 {
+		sc.Status = status.ScenarioDoneError
+	} else {
+		for _, su := range sc.simUsers {
+			if su.status == UserInterrupted {
+				sc.Status = status.ScenarioInterrupted
+			}
 		}
 	}
 
@@ -9,7 +9,10 @@ import (
 	"github.com/ofux/docilemonkey/docilemonkey"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -37,7 +40,7 @@ scenario("sc1", "Some scenario", function () {
 });
 		`)
 
-		scenario := newRunnableScenario(compiledScenario, 50, 200*time.Millisecond, 50*time.Millisecond, nil, logTest)
+		scenario := newRunnableScenario(compiledScenario, 50, 200*time.Millisecond, 50*time.Millisecond, nil, recording.DefaultHistogramConfig, nil, logTest)
 		scenario.run(nil)
 
 		records, err := scenario.httpRecorder.GetRecords()
@@ -80,7 +83,7 @@ scenario("sc1", "Some scenario", function (args, session) {
 });
 		`)
 
-		scenario := newRunnableScenario(compiledScenario, 5, 20000*time.Millisecond, 10*time.Millisecond, nil, logTest)
+		scenario := newRunnableScenario(compiledScenario, 5, 20000*time.Millisecond, 10*time.Millisecond, nil, recording.DefaultHistogramConfig, nil, logTest)
 		scenario.run(nil)
 
 		assert.Equal(t, uint64(5), scenario.EffectiveUserCount)
@@ -99,7 +102,7 @@ scenario("sc1", "Some scenario", function () {
 });
 		`)
 
-		scenario := newRunnableScenario(compiledScenario, 50, 200*time.Millisecond, 1*time.Millisecond, nil, logTest)
+		scenario := newRunnableScenario(compiledScenario, 50, 200*time.Millisecond, 1*time.Millisecond, nil, recording.DefaultHistogramConfig, nil, logTest)
 		scenario.run(nil)
 
 		assert.Equal(t, uint64(50), scenario.EffectiveUserCount)
@@ -132,7 +135,7 @@ scenario("sc1", "Some scenario", function (args) {
 			IsImmutable: true,
 		}
 
-		scenario := newRunnableScenario(compiledScenario, 50, 200*time.Millisecond, 50*time.Millisecond, scriptArgs, logTest)
+		scenario := newRunnableScenario(compiledScenario, 50, 200*time.Millisecond, 50*time.Millisecond, scriptArgs, recording.DefaultHistogramConfig, nil, logTest)
 		scenario.run(nil)
 
 		records, err := scenario.httpRecorder.GetRecords()
@@ -170,12 +173,13 @@ scenario("sc1", "Some scenario", function (args) {
 			IsImmutable: true,
 		}
 
-		scenario := newRunnableScenario(compiledScenario, 50, 200*time.Millisecond, 50*time.Millisecond, scriptArgs, logTest)
+		scenario := newRunnableScenario(compiledScenario, 50, 200*time.Millisecond, 50*time.Millisecond, scriptArgs, recording.DefaultHistogramConfig, nil, logTest)
 		scenario.run(nil)
 
 		assert.Equal(t, status.ScenarioDoneError, scenario.Status)
-		assert.Len(t, scenario.Errors, 50)
+		require.Len(t, scenario.Errors, 1)
 		assert.Equal(t, "hash is immutable, you cannot modify it", scenario.Errors[0].Message)
+		assert.Equal(t, uint64(50), scenario.Errors[0].Count)
 	})
 
 	t.Run("Run scenario with error", func(t *testing.T) {
@@ -190,21 +194,339 @@ scenario("sc1", "Some scenario", function () {
 });
 		`)
 
-		scenario := newRunnableScenario(compiledScenario, 50, 200*time.Millisecond, 1*time.Millisecond, nil, logTest)
+		scenario := newRunnableScenario(compiledScenario, 50, 200*time.Millisecond, 1*time.Millisecond, nil, recording.DefaultHistogramConfig, nil, logTest)
 		scenario.run(nil)
 
-		if len(scenario.Errors) != 50 {
-			t.Fatalf("Expected to have %d errors, got %d", 50, len(scenario.Errors))
+		if len(scenario.Errors) != 1 {
+			t.Fatalf("Expected to have %d error, got %d", 1, len(scenario.Errors))
 		}
-		for _, err := range scenario.Errors {
-			if err.Message != "identifier not found: doesntexists" {
-				t.Errorf("Wrong error message. Got '%s'", err.Message)
-			}
+		if scenario.Errors[0].Message != "identifier not found: doesntexists" {
+			t.Errorf("Wrong error message. Got '%s'", scenario.Errors[0].Message)
+		}
+		if scenario.Errors[0].Count != 50 {
+			t.Errorf("Expected the error to occur %d times, got %d", 50, scenario.Errors[0].Count)
 		}
 
 		assert.Equal(t, uint64(50), scenario.EffectiveUserCount)
 		assert.Equal(t, uint64(50), scenario.EffectiveExecCount)
 	})
+
+	t.Run("Group errors with the requests called before them", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer ts.Close()
+		clearRepo()
+
+		compiledScenario := compileScenario(t, `
+scenario("sc1", "Some scenario", function (args, session) {
+		if (session["count"] == null) {
+			session["count"] = 0;
+			assert(false);
+		}
+		session["count"]++;
+		http("foo", {"url": "`+ts.URL+`"});
+		if (session["count"] == 1) {
+			http("bar", {"url": "`+ts.URL+`"});
+		}
+		assert(false);
+});
+		`)
+
+		before := time.Now()
+		scenario := newRunnableScenario(compiledScenario, 1, 100*time.Millisecond, 10*time.Millisecond, nil, recording.DefaultHistogramConfig, nil, logTest)
+		scenario.errorPolicy = continueOnError
+		scenario.run(nil)
+
+		require.Len(t, scenario.Errors, 2)
+		assert.Equal(t, uint64(1), scenario.Errors[0].Count)
+		assert.Empty(t, scenario.Errors[0].Requests, "the first error occurred before any request")
+		assert.Equal(t, scenario.EffectiveExecCount-1, scenario.Errors[1].Count)
+		assert.Equal(t, []string{"bar", "foo"}, scenario.Errors[1].Requests)
+		assert.False(t, scenario.Errors[0].FirstAt.Before(before))
+		assert.True(t, scenario.Errors[1].FirstAt.After(scenario.Errors[0].FirstAt))
+		assert.True(t, scenario.Errors[1].LastAt.After(scenario.Errors[1].FirstAt))
+	})
+}
+
+func TestScenario_RunArrivalRate(t *testing.T) {
+
+	// discard DSL logs for testing
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	logTest := logger.WithField("test", true)
+
+	t.Run("Start iterations at a constant rate whatever their duration", func(t *testing.T) {
+		srv := docilemonkey.NewTestServer()
+		defer srv.Close()
+		clearRepo()
+
+		const reqName = "My request"
+		compiledScenario := compileScenario(t, `
+scenario("sc1", "Some scenario", function () {
+		http("`+reqName+`", {
+			"url": "`+srv.URL+`/hello/toto?s=201&t=100ms"
+		});
+});
+		`)
+
+		// 20 iterations per second during 500ms, each iteration taking at least 100ms
+		scenario := newRunnableOpenScenario(compiledScenario, 20, 0, 500*time.Millisecond, nil, recording.DefaultHistogramConfig, nil, logTest)
+		assert.Equal(t, 50*time.Millisecond, scenario.IterationDuration)
+		scenario.run(nil)
+
+		assert.Equal(t, status.ScenarioDoneSuccess, scenario.Status)
+		assert.Equal(t, uint64(10), scenario.EffectiveExecCount)
+		assert.Equal(t, uint64(0), scenario.DroppedExecCount)
+		// Iterations overlap, so the pool had to grow
+		assert.True(t, scenario.EffectiveUserCount > 1, "Expected more than 1 user, got %d", scenario.EffectiveUserCount)
+		assert.True(t, scenario.EffectiveUserCount < 10, "Expected users to be reused, got %d", scenario.EffectiveUserCount)
+
+		records, err := scenario.httpRecorder.GetRecords()
+		assert.NoError(t, err)
+		recordingtest.CheckHTTPRecord(t, records.Global, reqName, 10, 201, recording.Ok)
+		assert.Len(t, records.OverTime, 10)
+	})
+
+	t.Run("Drop iterations when all users are busy", func(t *testing.T) {
+		clearRepo()
+
+		compiledScenario := compileScenario(t, `
+scenario("sc1", "Some scenario", function () {
+		pause("120ms");
+});
+		`)
+
+		scenario := newRunnableOpenScenario(compiledScenario, 20, 2, 500*time.Millisecond, nil, recording.DefaultHistogramConfig, nil, logTest)
+		scenario.run(nil)
+
+		assert.Equal(t, status.ScenarioDoneSuccess, scenario.Status)
+		assert.Equal(t, uint64(2), scenario.EffectiveUserCount)
+		assert.True(t, scenario.DroppedExecCount > 0, "Expected some dropped executions")
+		assert.Equal(t, uint64(10), scenario.EffectiveExecCount+scenario.DroppedExecCount)
+	})
+
+	t.Run("Run scenario with error", func(t *testing.T) {
+		clearRepo()
+
+		compiledScenario := compileScenario(t, `
+scenario("sc1", "Some scenario", function () {
+		doesntexists();
+});
+		`)
+
+		scenario := newRunnableOpenScenario(compiledScenario, 50, 0, 100*time.Millisecond, nil, recording.DefaultHistogramConfig, nil, logTest)
+		scenario.run(nil)
+
+		assert.Equal(t, status.ScenarioDoneError, scenario.Status)
+		assert.Equal(t, uint64(5), scenario.EffectiveExecCount)
+		// Users stop at their first error, so every iteration needed a new user
+		assert.Equal(t, uint64(5), scenario.EffectiveUserCount)
+		require.Len(t, scenario.Errors, 1)
+		assert.Equal(t, uint64(5), scenario.Errors[0].Count)
+	})
+
+	t.Run("Run scenario with error and continue", func(t *testing.T) {
+		clearRepo()
+
+		compiledScenario := compileScenario(t, `
+scenario("sc1", "Some scenario", function () {
+		doesntexists();
+});
+		`)
+
+		scenario := newRunnableOpenScenario(compiledScenario, 50, 0, 100*time.Millisecond, nil, recording.DefaultHistogramConfig, nil, logTest)
+		scenario.errorPolicy = continueOnError
+		scenario.run(nil)
+
+		assert.Equal(t, status.ScenarioDoneError, scenario.Status)
+		assert.Equal(t, uint64(5), scenario.EffectiveExecCount)
+		// Users are given back to the pool after an error
+		assert.Equal(t, uint64(1), scenario.EffectiveUserCount)
+		require.Len(t, scenario.Errors, 1)
+		assert.Equal(t, uint64(5), scenario.Errors[0].Count)
+	})
+
+	t.Run("Interrupt scenario", func(t *testing.T) {
+		clearRepo()
+
+		compiledScenario := compileScenario(t, `
+scenario("sc1", "Some scenario", function () {
+});
+		`)
+
+		scenario := newRunnableOpenScenario(compiledScenario, 50, 0, 10*time.Second, nil, recording.DefaultHistogramConfig, nil, logTest)
+		interrupt := make(chan struct{})
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			close(interrupt)
+		}()
+		start := time.Now()
+		scenario.run(interrupt)
+
+		assert.True(t, time.Since(start) < 1*time.Second)
+		assert.Equal(t, status.ScenarioInterrupted, scenario.Status)
+	})
+}
+
+func TestScenario_RunStages(t *testing.T) {
+
+	// discard DSL logs for testing
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	logTest := logger.WithField("test", true)
+
+	t.Run("Ramp users up and down", func(t *testing.T) {
+		srv := docilemonkey.NewTestServer()
+		defer srv.Close()
+		clearRepo()
+
+		const reqName = "My request"
+		compiledScenario := compileScenario(t, `
+scenario("sc1", "Some scenario", function () {
+		http("`+reqName+`", {
+			"url": "`+srv.URL+`/hello/toto"
+		});
+});
+		`)
+
+		scenario := newRunnableScenario(compiledScenario, 0, 600*time.Millisecond, 50*time.Millisecond, nil, recording.DefaultHistogramConfig, nil, logTest)
+		scenario.profile = &loadProfile{
+			start: 0,
+			stages: []stage{
+				{duration: 200 * time.Millisecond, target: 10},
+				{duration: 200 * time.Millisecond, target: 10},
+				{duration: 200 * time.Millisecond, target: 0},
+			},
+		}
+		scenario.run(nil)
+
+		assert.Equal(t, status.ScenarioDoneSuccess, scenario.Status)
+		assert.Equal(t, uint64(10), scenario.EffectiveUserCount)
+		// A full load would have been 10 users * 12 iterations, but the users were ramped up and down
+		assert.True(t, scenario.EffectiveExecCount > 40, "Expected more than 40 executions, got %d", scenario.EffectiveExecCount)
+		assert.True(t, scenario.EffectiveExecCount < 100, "Expected less than 100 executions, got %d", scenario.EffectiveExecCount)
+
+		records, err := scenario.httpRecorder.GetRecords()
+		assert.NoError(t, err)
+		assert.Len(t, records.OverTime, 12)
+		assert.Equal(t, 1, records.OverTime[0].Stage)
+		assert.Equal(t, 2, records.OverTime[5].Stage)
+		assert.Equal(t, 3, records.OverTime[9].Stage)
+
+		// The plateau is the stage with the highest load
+		plateau := records.OverTime[5].Global.TotalCount()
+		assert.True(t, records.OverTime[0].Global.TotalCount() < plateau)
+		assert.True(t, records.OverTime[11].Global.TotalCount() < plateau)
+	})
+
+	t.Run("Step arrival rate", func(t *testing.T) {
+		clearRepo()
+
+		compiledScenario := compileScenario(t, `
+scenario("sc1", "Some scenario", function () {
+});
+		`)
+
+		scenario := newRunnableOpenScenario(compiledScenario, 40, 0, 500*time.Millisecond, nil, recording.DefaultHistogramConfig, nil, logTest)
+		scenario.profile = &loadProfile{
+			start: 0,
+			stages: []stage{
+				{duration: 250 * time.Millisecond, target: 20, step: true},
+				{duration: 250 * time.Millisecond, target: 40, step: true},
+			},
+		}
+		scenario.run(nil)
+
+		assert.Equal(t, status.ScenarioDoneSuccess, scenario.Status)
+		// 20/s during 250ms then 40/s during 250ms
+		assert.Equal(t, uint64(15), scenario.EffectiveExecCount)
+	})
+
+	t.Run("Interrupt scenario", func(t *testing.T) {
+		clearRepo()
+
+		compiledScenario := compileScenario(t, `
+scenario("sc1", "Some scenario", function () {
+});
+		`)
+
+		scenario := newRunnableScenario(compiledScenario, 0, 10*time.Second, 10*time.Millisecond, nil, recording.DefaultHistogramConfig, nil, logTest)
+		scenario.profile = &loadProfile{
+			start:  5,
+			stages: []stage{{duration: 10 * time.Second, target: 100}},
+		}
+		interrupt := make(chan struct{})
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			close(interrupt)
+		}()
+		start := time.Now()
+		scenario.run(interrupt)
+
+		assert.True(t, time.Since(start) < 1*time.Second)
+		assert.Equal(t, status.ScenarioInterrupted, scenario.Status)
+	})
+}
+
+func TestScenario_SetConcurrency(t *testing.T) {
+
+	// discard DSL logs for testing
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	logTest := logger.WithField("test", true)
+
+	t.Run("Add and remove users while running", func(t *testing.T) {
+		srv := docilemonkey.NewTestServer()
+		defer srv.Close()
+		clearRepo()
+
+		const reqName = "My request"
+		compiledScenario := compileScenario(t, `
+scenario("sc1", "Some scenario", function () {
+		http("`+reqName+`", {
+			"url": "`+srv.URL+`/hello/toto"
+		});
+});
+		`)
+
+		scenario := newRunnableScenario(compiledScenario, 2, 600*time.Millisecond, 20*time.Millisecond, nil, recording.DefaultHistogramConfig, nil, logTest)
+		assert.Equal(t, ErrScenarioNotRunning, scenario.SetConcurrency(6))
+
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			assert.NoError(t, scenario.SetConcurrency(6))
+			time.Sleep(200 * time.Millisecond)
+			assert.NoError(t, scenario.SetConcurrency(1))
+		}()
+		scenario.run(nil)
+
+		assert.Equal(t, status.ScenarioDoneSuccess, scenario.Status)
+		assert.Equal(t, uint64(6), scenario.EffectiveUserCount)
+
+		// The number of running users is recorded over time
+		records, err := scenario.httpRecorder.GetRecords()
+		assert.NoError(t, err)
+		assert.Equal(t, 2, records.OverTime[5].Users)
+		assert.Equal(t, 6, records.OverTime[15].Users)
+		assert.Equal(t, 1, records.OverTime[25].Users)
+	})
+
+	t.Run("Change concurrency of scenarios without a fixed number of users", func(t *testing.T) {
+		clearRepo()
+
+		compiledScenario := compileScenario(t, `
+scenario("sc1", "Some scenario", function () {
+});
+		`)
+
+		scenario := newRunnableOpenScenario(compiledScenario, 10, 0, 100*time.Millisecond, nil, recording.DefaultHistogramConfig, nil, logTest)
+		assert.Equal(t, ErrConcurrencyNotAdjustable, scenario.SetConcurrency(6))
+
+		scenario = newRunnableScenario(compiledScenario, 0, 100*time.Millisecond, 10*time.Millisecond, nil, recording.DefaultHistogramConfig, nil, logTest)
+		scenario.profile = &loadProfile{stages: []stage{{duration: time.Second, target: 10}}}
+		assert.Equal(t, ErrConcurrencyNotAdjustable, scenario.SetConcurrency(6))
+
+		assert.Error(t, scenario.SetConcurrency(-1))
+	})
 }
 
 func compileScenario(t testing.TB, script string) *CompiledScenario {
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// userCountUpdateFrequency is the frequency at which the number of users of a closed model scenario is updated
+const userCountUpdateFrequency = 10 * time.Millisecond
+
+// runScheduledIterations is the open model alternative to runSimUser. The i-th iteration is started at
+// start + i*IterationDuration (or when the load profile says so, if the scenario has stages), whatever the time
+// taken by the previous iterations, so that a slow target cannot slow down the load (no coordinated omission).
+// An iteration that cannot get a user from the pool is dropped.
+func (sc *RunnableScenario) runScheduledIterations(start, endTime time.Time, interrupt chan struct{}) {
+	pool := newSimUserPool(sc, sc.maxUsers)
+	var waitg sync.WaitGroup
+	interrupted := false
+
+scheduling:
+	for i := 0; ; i++ {
+		offset := time.Duration(i) * sc.IterationDuration
+		stageNumber := 0
+		if sc.profile != nil {
+			var ok bool
+			if offset, ok = sc.profile.arrivalTime(i); !ok {
+				break
+			}
+			_, stageNumber = sc.profile.valueAt(offset)
+		}
+		scheduledTime := start.Add(offset)
+		if !scheduledTime.Before(endTime) {
+			break
+		}
+
+		timer := time.NewTimer(time.Until(scheduledTime))
+		select {
+		case <-interrupt:
+			timer.Stop()
+			interrupted = true
+			sc.log.Debug("Stop scheduling iterations because of interrupt signal.")
+			break scheduling
+		case <-sc.halted:
+			timer.Stop()
+			sc.log.Debug("Stop scheduling iterations because the scenario stopped on error.")
+			break scheduling
+		case <-timer.C:
+		}
+
+		su, ok := pool.acquire()
+		if !ok {
+			atomic.AddUint64(&sc.DroppedExecCount, 1)
+			continue
+		}
+
+		waitg.Add(1)
+		go func(su *simUser, iteration, stageNumber int) {
+			defer waitg.Done()
+
+			sc.log.Debugf("Running user simulation %s for iteration %d", su.name, iteration)
+			su.stage = stageNumber
+			atomic.AddInt64(&sc.activeUserCount, 1)
+			su.run(iteration)
+			atomic.AddInt64(&sc.activeUserCount, -1)
+			atomic.AddUint64(&sc.EffectiveExecCount, 1)
+
+			if su.status == UserDoneError && sc.errorPolicy != continueOnError {
+				// Like in the closed model, a user stops at its first error. It is not given back to the pool.
+				sc.log.Debugf("Terminate user simulation %s because an error occurred.", su.name)
+				return
+			}
+			pool.release(su)
+		}(su, int(offset/sc.IterationDuration), stageNumber)
+	}
+	waitg.Wait()
+
+	if interrupted {
+		for _, su := range sc.simUsers {
+			if su.status != UserDoneError {
+				su.status = UserInterrupted
+			}
+		}
+	}
+	atomic.StoreUint64(&sc.EffectiveUserCount, uint64(len(sc.simUsers)))
+}
+
+// runSimUsers runs the closed model. It starts the users of the scenario, then regularly starts or stops users so
+// that the number of running users follows the load profile, if any, or the concurrency set with SetConcurrency.
+func (sc *RunnableScenario) runSimUsers(start, endTime time.Time, interrupt chan struct{}) {
+	var waitg sync.WaitGroup
+	var running int64
+	startSimUser := func(su *simUser) {
+		su.stop = make(chan struct{})
+		waitg.Add(1)
+		atomic.AddInt64(&running, 1)
+		go func() {
+			defer waitg.Done()
+			defer atomic.AddInt64(&running, -1)
+			sc.runSimUser(su, start, endTime, interrupt)
+		}()
+	}
+
+	active := make([]*simUser, len(sc.simUsers))
+	copy(active, sc.simUsers)
+	for _, su := range active {
+		startSimUser(su)
+	}
+
+	ticker := time.NewTicker(userCountUpdateFrequency)
+	defer ticker.Stop()
+
+updating:
+	for time.Now().Before(endTime) {
+		if sc.profile == nil && len(active) > 0 && atomic.LoadInt64(&running) == 0 {
+			// All the users stopped because of an error
+			break
+		}
+		elapsed := time.Since(start)
+		userCount := sc.getTargetUserCount(elapsed)
+
+		for len(active) < userCount {
+			su := newSimUser(strconv.Itoa(len(sc.simUsers)), sc)
+			su.firstIteration = int(elapsed / sc.IterationDuration)
+			sc.simUsers = append(sc.simUsers, su)
+			active = append(active, su)
+			startSimUser(su)
+		}
+		for len(active) > userCount {
+			// The last started users are the first to stop
+			close(active[len(active)-1].stop)
+			active = active[:len(active)-1]
+		}
+
+		select {
+		case <-interrupt:
+			break updating
+		case <-sc.halted:
+			break updating
+		case <-ticker.C:
+		}
+	}
+	waitg.Wait()
+}
+
+// simUserPool holds the users that are waiting for an iteration to run. New users are created when no user is
+// waiting, up to maxUsers (0 means no limit).
+type simUserPool struct {
+	scenario *RunnableScenario
+	maxUsers int
+	idle     []*simUser
+	mut      *sync.Mutex
+}
+
+func newSimUserPool(scenario *RunnableScenario, maxUsers int) *simUserPool {
+	return &simUserPool{
+		scenario: scenario,
+		maxUsers: maxUsers,
+		mut:      &sync.Mutex{},
+	}
+}
+
+// acquire returns a waiting user, or a new one. It returns false if all the users are busy and no new user can be
+// created.
+func (p *simUserPool) acquire() (*simUser, bool) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if n := len(p.idle); n > 0 {
+		su := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		return su, true
+	}
+	if p.maxUsers > 0 && len(p.scenario.simUsers) >= p.maxUsers {
+		return nil, false
+	}
+	su := newSimUser(strconv.Itoa(len(p.scenario.simUsers)), p.scenario)
+	p.scenario.simUsers = append(p.scenario.simUsers, su)
+	return su, true
+}
+
+// release puts back a user in the pool once it has finished its iteration.
+func (p *simUserPool) release(su *simUser) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.idle = append(p.idle, su)
+}
@@ -0,0 +1,34 @@
+package core
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Share identifies the part of a deluge that is run by a single worker when a job is spread across several workers.
+type Share struct {
+	Index int `json:"index"`
+	Count int `json:"count"`
+}
+
+// FullShare is the share of a worker that runs the whole deluge on its own.
+var FullShare = Share{Index: 0, Count: 1}
+
+func (s Share) Validate() error {
+	if s.Count < 1 {
+		return errors.Errorf("invalid share: count should be greater than 0 but was %d", s.Count)
+	}
+	if s.Index < 0 || s.Index >= s.Count {
+		return errors.Errorf("invalid share: index should be in [0, %d] but was %d", s.Count-1, s.Index)
+	}
+	return nil
+}
+
+// Split returns the part of total that falls to this share.
+// The remainder of the division is given to the first shares, so that the sum of all shares is always equal to total.
+func (s Share) Split(total int) int {
+	part := total / s.Count
+	if s.Index < total%s.Count {
+		part++
+	}
+	return part
+}
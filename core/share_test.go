@@ -0,0 +1,44 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestShare_Split(t *testing.T) {
+	t.Run("Full share", func(t *testing.T) {
+		assert.Equal(t, 10, FullShare.Split(10))
+		assert.Equal(t, 0, FullShare.Split(0))
+	})
+
+	t.Run("Even split", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, 2, Share{Index: i, Count: 5}.Split(10))
+		}
+	})
+
+	t.Run("Remainder goes to the first shares", func(t *testing.T) {
+		total := 0
+		expected := []int{3, 3, 2, 2}
+		for i := 0; i < 4; i++ {
+			part := Share{Index: i, Count: 4}.Split(10)
+			assert.Equal(t, expected[i], part)
+			total += part
+		}
+		assert.Equal(t, 10, total)
+	})
+
+	t.Run("Less than one per share", func(t *testing.T) {
+		assert.Equal(t, 1, Share{Index: 0, Count: 3}.Split(1))
+		assert.Equal(t, 0, Share{Index: 1, Count: 3}.Split(1))
+		assert.Equal(t, 0, Share{Index: 2, Count: 3}.Split(1))
+	})
+}
+
+func TestShare_Validate(t *testing.T) {
+	assert.NoError(t, FullShare.Validate())
+	assert.NoError(t, Share{Index: 2, Count: 3}.Validate())
+	assert.Error(t, Share{Index: 0, Count: 0}.Validate())
+	assert.Error(t, Share{Index: 3, Count: 3}.Validate())
+	assert.Error(t, Share{Index: -1, Count: 3}.Validate())
+}
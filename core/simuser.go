@@ -1,7 +1,6 @@
 package core
 
 import (
-	"github.com/ofux/deluge/cleanhttp"
 	"github.com/ofux/deluge/core/recording"
 	"github.com/ofux/deluge/dsl/ast"
 	"github.com/ofux/deluge/dsl/evaluator"
@@ -30,7 +29,20 @@ type simUser struct {
 	httpRecorder  *recording.HTTPRecorder
 	log           *log.Entry
 	iteration     int
-	session       *object.Hash
+	stage         int
+	// firstIteration is the iteration during which the user was started. It is not 0 for users that are started
+	// while the scenario is running.
+	firstIteration int
+	// stop is closed when the user must stop after its current iteration. It is nil if the user never has to stop
+	// before the end of the scenario.
+	stop    chan struct{}
+	session *object.Hash
+	// lastRequest is the name of the last request called during the current iteration
+	lastRequest string
+	// koRequestCount is the number of KO requests of the user, and pauseDuration the time it spent in pauses. Groups
+	// compare them before and after their execution.
+	koRequestCount uint64
+	pauseDuration  time.Duration
 
 	status    simUserStatus
 	execError *object.Error
@@ -42,7 +54,7 @@ func newSimUser(name string, scenario *RunnableScenario) *simUser {
 		status:    UserVirgin,
 		scenario:  scenario,
 		evaluator: evaluator.NewEvaluator(),
-		client:    cleanhttp.DefaultClient(),
+		client:    scenario.httpClient.newClient(),
 		session: &object.Hash{
 			Pairs: make(map[object.HashKey]object.Object),
 		},
@@ -56,6 +68,12 @@ func newSimUser(name string, scenario *RunnableScenario) *simUser {
 	if err := su.evaluator.AddBuiltin("http", su.execHTTPRequest); err != nil {
 		log.Fatal(err.Error())
 	}
+	if err := su.evaluator.AddBuiltin("group", su.execGroup); err != nil {
+		log.Fatal(err.Error())
+	}
+	if err := su.evaluator.AddBuiltin("pause", su.execPause); err != nil {
+		log.Fatal(err.Error())
+	}
 
 	return su
 }
@@ -66,16 +84,20 @@ func (su *simUser) getRootAstNode() ast.Node {
 
 func (su *simUser) run(iteration int) {
 	su.iteration = iteration
+	su.lastRequest = ""
 	su.status = UserInProgress
 	env := su.createEnvironment()
 	evaluated := su.evaluator.Eval(su.getRootAstNode(), env)
 
-	su.client.Transport.(*http.Transport).CloseIdleConnections()
+	if su.scenario.httpClient.closesIdleConnections() {
+		su.client.CloseIdleConnections()
+	}
 
 	if evaluated != nil && evaluated.Type() == object.ERROR_OBJ {
 		su.status = UserDoneError
 		su.execError = evaluated.(*object.Error)
 		su.log.Errorln(evaluated.Inspect())
+		su.scenario.handleError(su.execError, su.lastRequest)
 		return
 	}
 
@@ -34,7 +34,7 @@ func NewSimUserTest(t testing.TB, js string) *simUser {
 			},
 			script: script,
 		},
-		httpRecorder: recording.NewHTTPRecorder(1, 1),
+		httpRecorder: recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig),
 		log: logger.WithFields(log.Fields{
 			"scenario": "Test scenario",
 		}),
@@ -0,0 +1,106 @@
+package sinks
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxInfluxDBBatchSize is the number of lines InfluxDB recommends to write per request
+const maxInfluxDBBatchSize = 5000
+
+// influxDBBatcher writes each entry as a line of the InfluxDB line protocol, posted in batches over HTTP.
+type influxDBBatcher struct {
+	poster *httpPoster
+	lines  *bytes.Buffer
+	count  int
+}
+
+func newInfluxDBSink(config Config) Sink {
+	return newBufferedSink(TypeInfluxDB, &influxDBBatcher{
+		poster: newHTTPPoster(config, "text/plain; charset=utf-8"),
+		lines:  &bytes.Buffer{},
+	})
+}
+
+func (b *influxDBBatcher) add(entry *Entry) {
+	b.lines.WriteString("deluge_request")
+	b.lines.WriteString(",job_id=" + influxDBTag(entry.JobID))
+	b.lines.WriteString(",worker_id=" + influxDBTag(entry.WorkerID))
+	b.lines.WriteString(",scenario=" + influxDBTag(entry.ScenarioID))
+	b.lines.WriteString(",request=" + influxDBTag(entry.Request))
+	b.lines.WriteString(",status_code=" + strconv.Itoa(entry.StatusCode))
+	b.lines.WriteString(",result=" + result(entry))
+	b.lines.WriteString(" duration_ms=" + strconv.FormatFloat(entry.Duration, 'f', -1, 64))
+	b.lines.WriteString(" " + strconv.FormatInt(entry.Time.UnixNano(), 10) + "\n")
+	b.count++
+}
+
+func (b *influxDBBatcher) full() bool {
+	return b.count >= maxInfluxDBBatchSize
+}
+
+func (b *influxDBBatcher) flush() error {
+	if b.count == 0 {
+		return nil
+	}
+	defer func() {
+		b.lines.Reset()
+		b.count = 0
+	}()
+	return b.poster.post(b.lines.Bytes())
+}
+
+var influxDBTagReplacer = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+
+// influxDBTag escapes a tag value. Empty values are not allowed by the line protocol.
+func influxDBTag(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return influxDBTagReplacer.Replace(value)
+}
+
+// httpPoster posts batches of metrics to a URL.
+type httpPoster struct {
+	client      *http.Client
+	url         string
+	contentType string
+	headers     map[string]string
+}
+
+func newHTTPPoster(config Config, contentType string) *httpPoster {
+	return &httpPoster{
+		client:      &http.Client{Timeout: 10 * time.Second},
+		url:         config.Address,
+		contentType: contentType,
+		headers:     config.Headers,
+	}
+}
+
+func (p *httpPoster) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", p.contentType)
+	for key, value := range p.headers {
+		req.Header.Set(key, value)
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		resBody, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return errors.Errorf("POST %s: unexpected status code %d: %s", p.url, res.StatusCode, resBody)
+	}
+	_, err = io.Copy(ioutil.Discard, res.Body)
+	return err
+}
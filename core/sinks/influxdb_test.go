@@ -0,0 +1,59 @@
+package sinks
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInfluxDBSink(t *testing.T) {
+	t.Run("Post entries as line protocol", func(t *testing.T) {
+		bodies := make(chan string, 10)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/write", r.URL.Path)
+			assert.Equal(t, "deluge", r.URL.Query().Get("db"))
+			assert.Equal(t, "Token secret", r.Header.Get("Authorization"))
+			body, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			bodies <- string(body)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer srv.Close()
+
+		sink, err := New(Config{
+			Type:    TypeInfluxDB,
+			Address: srv.URL + "/write?db=deluge",
+			Headers: map[string]string{"Authorization": "Token secret"},
+		})
+		require.NoError(t, err)
+		at := time.Unix(1500000000, 123)
+		sink.Send(&Entry{Time: at, JobID: "job1", WorkerID: "worker1", ScenarioID: "sc1", Request: "get home, page=1", Duration: 12, StatusCode: 200, Ok: true})
+		sink.Send(&Entry{Time: at, JobID: "job1", WorkerID: "worker1", ScenarioID: "sc1", Request: "foo", Duration: 30, StatusCode: 500})
+		require.NoError(t, sink.Close())
+
+		require.Len(t, bodies, 1)
+		assert.Equal(t,
+			`deluge_request,job_id=job1,worker_id=worker1,scenario=sc1,request=get\ home\,\ page\=1,status_code=200,result=ok duration_ms=12 1500000000000000123`+"\n"+
+				`deluge_request,job_id=job1,worker_id=worker1,scenario=sc1,request=foo,status_code=500,result=ko duration_ms=30 1500000000000000123`+"\n",
+			<-bodies)
+	})
+
+	t.Run("Keep running when the server fails", func(t *testing.T) {
+		calls := make(chan struct{}, 10)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls <- struct{}{}
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer srv.Close()
+
+		sink, err := New(Config{Type: TypeInfluxDB, Address: srv.URL + "/write?db=deluge"})
+		require.NoError(t, err)
+		sink.Send(&Entry{Time: time.Now(), Request: "foo", Duration: 12, StatusCode: 200, Ok: true})
+		require.NoError(t, sink.Close())
+		assert.Len(t, calls, 1)
+	})
+}
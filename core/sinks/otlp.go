@@ -0,0 +1,214 @@
+package sinks
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// otlpDurationBounds are the upper bounds, in milliseconds, of the buckets of the response time histograms
+var otlpDurationBounds = []float64{5, 10, 25, 50, 75, 100, 250, 500, 750, 1000, 2500, 5000, 7500, 10000}
+
+// otlpAggregationTemporalityDelta means that each data point only covers the entries since the previous one
+const otlpAggregationTemporalityDelta = 1
+
+// otlpBatcher aggregates the entries of each flush interval into response time histograms, sent as OTLP metrics
+// encoded in JSON over HTTP. The count of the histograms is the number of requests.
+type otlpBatcher struct {
+	poster *httpPoster
+	start  time.Time
+	points map[otlpAttributes]*otlpHistogram
+}
+
+// otlpAttributes identify a histogram
+type otlpAttributes struct {
+	jobID      string
+	workerID   string
+	scenarioID string
+	request    string
+	statusCode int
+	result     string
+}
+
+type otlpHistogram struct {
+	count        uint64
+	sum          float64
+	min          float64
+	max          float64
+	bucketCounts []uint64
+}
+
+func newOTLPSink(config Config) Sink {
+	return newBufferedSink(TypeOTLP, &otlpBatcher{
+		poster: newHTTPPoster(config, "application/json"),
+		start:  time.Now(),
+		points: make(map[otlpAttributes]*otlpHistogram),
+	})
+}
+
+func (b *otlpBatcher) add(entry *Entry) {
+	attributes := otlpAttributes{
+		jobID:      entry.JobID,
+		workerID:   entry.WorkerID,
+		scenarioID: entry.ScenarioID,
+		request:    entry.Request,
+		statusCode: entry.StatusCode,
+		result:     result(entry),
+	}
+	value := entry.Duration
+	histogram, ok := b.points[attributes]
+	if !ok {
+		histogram = &otlpHistogram{
+			min:          value,
+			max:          value,
+			bucketCounts: make([]uint64, len(otlpDurationBounds)+1),
+		}
+		b.points[attributes] = histogram
+	}
+	histogram.count++
+	histogram.sum += value
+	if value < histogram.min {
+		histogram.min = value
+	}
+	if value > histogram.max {
+		histogram.max = value
+	}
+	histogram.bucketCounts[sort.SearchFloat64s(otlpDurationBounds, value)]++
+}
+
+func (b *otlpBatcher) full() bool {
+	return false
+}
+
+func (b *otlpBatcher) flush() error {
+	end := time.Now()
+	if len(b.points) == 0 {
+		b.start = end
+		return nil
+	}
+	body, err := json.Marshal(b.newRequest(end))
+	b.start = end
+	b.points = make(map[otlpAttributes]*otlpHistogram)
+	if err != nil {
+		return err
+	}
+	return b.poster.post(body)
+}
+
+// newRequest creates an ExportMetricsServiceRequest, following the JSON mapping of the OTLP protobuf messages
+func (b *otlpBatcher) newRequest(end time.Time) *otlpRequest {
+	points := make([]*otlpDataPoint, 0, len(b.points))
+	for attributes, histogram := range b.points {
+		bucketCounts := make([]string, len(histogram.bucketCounts))
+		for i, count := range histogram.bucketCounts {
+			bucketCounts[i] = strconv.FormatUint(count, 10)
+		}
+		points = append(points, &otlpDataPoint{
+			Attributes: []*otlpAttribute{
+				newOTLPAttribute("job_id", attributes.jobID),
+				newOTLPAttribute("worker_id", attributes.workerID),
+				newOTLPAttribute("scenario", attributes.scenarioID),
+				newOTLPAttribute("request", attributes.request),
+				newOTLPAttribute("status_code", strconv.Itoa(attributes.statusCode)),
+				newOTLPAttribute("result", attributes.result),
+			},
+			StartTimeUnixNano: strconv.FormatInt(b.start.UnixNano(), 10),
+			TimeUnixNano:      strconv.FormatInt(end.UnixNano(), 10),
+			Count:             strconv.FormatUint(histogram.count, 10),
+			Sum:               histogram.sum,
+			Min:               histogram.min,
+			Max:               histogram.max,
+			BucketCounts:      bucketCounts,
+			ExplicitBounds:    otlpDurationBounds,
+		})
+	}
+	// Sorted for readability and tests
+	sort.Slice(points, func(i, j int) bool {
+		for k := range points[i].Attributes {
+			if vi, vj := points[i].Attributes[k].Value.StringValue, points[j].Attributes[k].Value.StringValue; vi != vj {
+				return vi < vj
+			}
+		}
+		return false
+	})
+
+	return &otlpRequest{
+		ResourceMetrics: []*otlpResourceMetrics{{
+			Resource: &otlpResource{
+				Attributes: []*otlpAttribute{newOTLPAttribute("service.name", "deluge")},
+			},
+			ScopeMetrics: []*otlpScopeMetrics{{
+				Scope: &otlpScope{Name: "deluge"},
+				Metrics: []*otlpMetric{{
+					Name:        "deluge.request.duration",
+					Description: "Response times of the HTTP requests of simulated users",
+					Unit:        "ms",
+					Histogram: &otlpHistogramData{
+						AggregationTemporality: otlpAggregationTemporalityDelta,
+						DataPoints:             points,
+					},
+				}},
+			}},
+		}},
+	}
+}
+
+type otlpRequest struct {
+	ResourceMetrics []*otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     *otlpResource       `json:"resource"`
+	ScopeMetrics []*otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []*otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   *otlpScope    `json:"scope"`
+	Metrics []*otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Unit        string             `json:"unit"`
+	Histogram   *otlpHistogramData `json:"histogram"`
+}
+
+type otlpHistogramData struct {
+	AggregationTemporality int              `json:"aggregationTemporality"`
+	DataPoints             []*otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []*otlpAttribute `json:"attributes"`
+	StartTimeUnixNano string           `json:"startTimeUnixNano"`
+	TimeUnixNano      string           `json:"timeUnixNano"`
+	Count             string           `json:"count"`
+	Sum               float64          `json:"sum"`
+	Min               float64          `json:"min"`
+	Max               float64          `json:"max"`
+	BucketCounts      []string         `json:"bucketCounts"`
+	ExplicitBounds    []float64        `json:"explicitBounds"`
+}
+
+type otlpAttribute struct {
+	Key   string              `json:"key"`
+	Value *otlpAttributeValue `json:"value"`
+}
+
+type otlpAttributeValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func newOTLPAttribute(key, value string) *otlpAttribute {
+	return &otlpAttribute{Key: key, Value: &otlpAttributeValue{StringValue: value}}
+}
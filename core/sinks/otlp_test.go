@@ -0,0 +1,81 @@
+package sinks
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOTLPSink(t *testing.T) {
+	t.Run("Post histograms of entries as OTLP metrics", func(t *testing.T) {
+		bodies := make(chan []byte, 10)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/v1/metrics", r.URL.Path)
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			body, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			bodies <- body
+		}))
+		defer srv.Close()
+
+		sink, err := New(Config{Type: TypeOTLP, Address: srv.URL + "/v1/metrics"})
+		require.NoError(t, err)
+		sink.Send(&Entry{Time: time.Now(), JobID: "job1", WorkerID: "worker1", ScenarioID: "sc1", Request: "foo", Duration: 3, StatusCode: 200, Ok: true})
+		sink.Send(&Entry{Time: time.Now(), JobID: "job1", WorkerID: "worker1", ScenarioID: "sc1", Request: "foo", Duration: 10, StatusCode: 200, Ok: true})
+		sink.Send(&Entry{Time: time.Now(), JobID: "job1", WorkerID: "worker1", ScenarioID: "sc1", Request: "foo", Duration: 20000, StatusCode: 200, Ok: true})
+		sink.Send(&Entry{Time: time.Now(), JobID: "job1", WorkerID: "worker1", ScenarioID: "sc1", Request: "foo", Duration: 30, StatusCode: 500})
+		require.NoError(t, sink.Close())
+
+		require.Len(t, bodies, 1)
+		request := &otlpRequest{}
+		require.NoError(t, json.Unmarshal(<-bodies, request))
+		require.Len(t, request.ResourceMetrics, 1)
+		assert.Equal(t, []*otlpAttribute{newOTLPAttribute("service.name", "deluge")}, request.ResourceMetrics[0].Resource.Attributes)
+		require.Len(t, request.ResourceMetrics[0].ScopeMetrics, 1)
+		require.Len(t, request.ResourceMetrics[0].ScopeMetrics[0].Metrics, 1)
+		metric := request.ResourceMetrics[0].ScopeMetrics[0].Metrics[0]
+		assert.Equal(t, "deluge.request.duration", metric.Name)
+		assert.Equal(t, "ms", metric.Unit)
+		assert.Equal(t, otlpAggregationTemporalityDelta, metric.Histogram.AggregationTemporality)
+		require.Len(t, metric.Histogram.DataPoints, 2)
+
+		ok := metric.Histogram.DataPoints[0]
+		assert.Equal(t, []*otlpAttribute{
+			newOTLPAttribute("job_id", "job1"),
+			newOTLPAttribute("worker_id", "worker1"),
+			newOTLPAttribute("scenario", "sc1"),
+			newOTLPAttribute("request", "foo"),
+			newOTLPAttribute("status_code", "200"),
+			newOTLPAttribute("result", "ok"),
+		}, ok.Attributes)
+		assert.Equal(t, "3", ok.Count)
+		assert.Equal(t, 20013.0, ok.Sum)
+		assert.Equal(t, 3.0, ok.Min)
+		assert.Equal(t, 20000.0, ok.Max)
+		assert.Equal(t, otlpDurationBounds, ok.ExplicitBounds)
+		assert.Equal(t, []string{"1", "1", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "1"}, ok.BucketCounts)
+		assert.True(t, ok.StartTimeUnixNano <= ok.TimeUnixNano)
+
+		ko := metric.Histogram.DataPoints[1]
+		assert.Equal(t, newOTLPAttribute("status_code", "500"), ko.Attributes[4])
+		assert.Equal(t, "1", ko.Count)
+	})
+
+	t.Run("Post nothing without any entry", func(t *testing.T) {
+		calls := make(chan struct{}, 10)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls <- struct{}{}
+		}))
+		defer srv.Close()
+
+		sink, err := New(Config{Type: TypeOTLP, Address: srv.URL + "/v1/metrics"})
+		require.NoError(t, err)
+		require.NoError(t, sink.Close())
+		assert.Len(t, calls, 0)
+	})
+}
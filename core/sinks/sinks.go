@@ -0,0 +1,248 @@
+// Package sinks forwards the requests recorded while a job runs to external time-series systems, so that the metrics
+// of the load generator can be seen next to the metrics of the servers under test.
+package sinks
+
+import (
+	"github.com/ofux/deluge/core/recording"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	TypeStatsD   = "statsd"
+	TypeInfluxDB = "influxdb"
+	TypeOTLP     = "otlp"
+)
+
+var (
+	// flushInterval is how often buffered entries are sent
+	flushInterval = time.Second
+	// queueSize is the number of entries a sink buffers before it starts dropping them
+	queueSize = 10000
+)
+
+// Config configures a sink of a job.
+type Config struct {
+	// Type is one of statsd, influxdb or otlp
+	Type string `json:"type"`
+	// Address is the host:port of a StatsD server, the write URL of an InfluxDB server (such as
+	// http://localhost:8086/write?db=deluge) or the OTLP/HTTP metrics URL of a collector (such as
+	// http://localhost:4318/v1/metrics).
+	Address string `json:"address"`
+	// Headers are added to the requests of the InfluxDB and OTLP sinks, for instance to authenticate.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Validate tells whether the sink can be created.
+func (c Config) Validate() error {
+	if c.Address == "" {
+		return errors.Errorf("the address of the %s sink is missing", c.Type)
+	}
+	switch c.Type {
+	case TypeStatsD:
+		return nil
+	case TypeInfluxDB, TypeOTLP:
+		u, err := url.ParseRequestURI(c.Address)
+		if err != nil {
+			return errors.Wrapf(err, "invalid address of the %s sink", c.Type)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return errors.Errorf("invalid address of the %s sink: expected an http or https URL but got %s", c.Type, c.Address)
+		}
+		return nil
+	}
+	return errors.Errorf("unknown sink type '%s', expected one of %s, %s or %s", c.Type, TypeStatsD, TypeInfluxDB, TypeOTLP)
+}
+
+// Entry is a request sent by a simulated user.
+type Entry struct {
+	Time       time.Time
+	JobID      string
+	WorkerID   string
+	ScenarioID string
+	Request    string
+	// Duration is the response time of the request, in milliseconds
+	Duration   float64
+	StatusCode int
+	Ok         bool
+}
+
+// Sink sends entries to an external system.
+type Sink interface {
+	// Send queues the entry to be sent. It never blocks: entries are dropped when the queue is full.
+	Send(entry *Entry)
+	// Close sends the queued entries and releases the resources of the sink. Entries sent afterwards are ignored.
+	Close() error
+}
+
+// New creates the sink of the given configuration.
+func New(config Config) (Sink, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	switch config.Type {
+	case TypeStatsD:
+		return newStatsDSink(config)
+	case TypeInfluxDB:
+		return newInfluxDBSink(config), nil
+	default:
+		return newOTLPSink(config), nil
+	}
+}
+
+// NewAll creates the sinks of the given configurations. If one of them cannot be created, the ones already created
+// are closed.
+func NewAll(configs []Config) ([]Sink, error) {
+	sinks := make([]Sink, 0, len(configs))
+	for _, config := range configs {
+		sink, err := New(config)
+		if err != nil {
+			CloseAll(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// CloseAll closes the sinks and logs their errors.
+func CloseAll(sinks []Sink) {
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			log.WithError(err).Error("Failed to close metrics sink")
+		}
+	}
+}
+
+// recordSink sends the HTTP entries recorded for a scenario to a sink.
+type recordSink struct {
+	sink       Sink
+	jobID      string
+	workerID   string
+	scenarioID string
+	unit       recording.TimeUnit
+}
+
+// NewRecordSink creates a sink of recorder that sends the HTTP entries recorded for the given scenario to the sink.
+// unit is the unit of the values recorded for the scenario.
+func NewRecordSink(sink Sink, jobID, workerID, scenarioID string, unit recording.TimeUnit) recording.Sink {
+	return &recordSink{
+		sink:       sink,
+		jobID:      jobID,
+		workerID:   workerID,
+		scenarioID: scenarioID,
+		unit:       unit,
+	}
+}
+
+func (s *recordSink) Send(rec recording.RecordEntry) {
+	httpRec, ok := rec.(*recording.HTTPRecordEntry)
+	if !ok {
+		return
+	}
+	s.sink.Send(&Entry{
+		Time:       time.Now(),
+		JobID:      s.jobID,
+		WorkerID:   s.workerID,
+		ScenarioID: s.scenarioID,
+		Request:    httpRec.Name,
+		Duration:   s.unit.ToMilliseconds(float64(httpRec.Value)),
+		StatusCode: httpRec.StatusCode,
+		Ok:         httpRec.OkKo() == recording.Ok,
+	})
+}
+
+// batcher accumulates entries and sends them to an external system.
+type batcher interface {
+	add(entry *Entry)
+	// full tells whether the accumulated entries should be sent without waiting for the next flush
+	full() bool
+	flush() error
+}
+
+// bufferedSink queues entries and hands them to a batcher from a single goroutine, which flushes them regularly.
+type bufferedSink struct {
+	name    string
+	batcher batcher
+	queue   chan *Entry
+	done    chan struct{}
+	closed  bool
+	mut     *sync.RWMutex
+	dropped uint64
+	logger  *log.Entry
+}
+
+func newBufferedSink(name string, b batcher) *bufferedSink {
+	s := &bufferedSink{
+		name:    name,
+		batcher: b,
+		queue:   make(chan *Entry, queueSize),
+		done:    make(chan struct{}),
+		mut:     &sync.RWMutex{},
+		logger:  log.WithField("sink", name),
+	}
+	go s.run()
+	return s
+}
+
+func (s *bufferedSink) Send(entry *Entry) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- entry:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+func (s *bufferedSink) Close() error {
+	s.mut.Lock()
+	if s.closed {
+		s.mut.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	s.mut.Unlock()
+
+	<-s.done
+	if dropped := atomic.LoadUint64(&s.dropped); dropped > 0 {
+		return errors.Errorf("%s sink dropped %d entries because it could not keep up", s.name, dropped)
+	}
+	return nil
+}
+
+func (s *bufferedSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case entry, ok := <-s.queue:
+			if !ok {
+				s.flush()
+				return
+			}
+			s.batcher.add(entry)
+			if s.batcher.full() {
+				s.flush()
+			}
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+}
+
+func (s *bufferedSink) flush() {
+	if err := s.batcher.flush(); err != nil {
+		s.logger.WithError(err).Warn("Failed to send metrics")
+	}
+}
@@ -0,0 +1,181 @@
+package sinks
+
+import (
+	"github.com/ofux/deluge/core/recording"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	t.Run("Valid sinks", func(t *testing.T) {
+		assert.NoError(t, Config{Type: TypeStatsD, Address: "localhost:8125"}.Validate())
+		assert.NoError(t, Config{Type: TypeInfluxDB, Address: "http://localhost:8086/write?db=deluge"}.Validate())
+		assert.NoError(t, Config{Type: TypeOTLP, Address: "https://localhost:4318/v1/metrics"}.Validate())
+	})
+
+	t.Run("Invalid sinks", func(t *testing.T) {
+		assert.EqualError(t, Config{Type: "graphite", Address: "localhost:2003"}.Validate(), "unknown sink type 'graphite', expected one of statsd, influxdb or otlp")
+		assert.EqualError(t, Config{Type: TypeStatsD}.Validate(), "the address of the statsd sink is missing")
+		assert.EqualError(t, Config{Type: TypeInfluxDB, Address: "localhost:8086"}.Validate(), "invalid address of the influxdb sink: expected an http or https URL but got localhost:8086")
+		assert.Error(t, Config{Type: TypeOTLP, Address: "not a url"}.Validate())
+	})
+}
+
+func TestNewAll(t *testing.T) {
+	t.Run("Create sinks", func(t *testing.T) {
+		sinks, err := NewAll([]Config{
+			{Type: TypeStatsD, Address: "localhost:8125"},
+			{Type: TypeInfluxDB, Address: "http://localhost:8086/write?db=deluge"},
+			{Type: TypeOTLP, Address: "http://localhost:4318/v1/metrics"},
+		})
+		require.NoError(t, err)
+		assert.Len(t, sinks, 3)
+		CloseAll(sinks)
+	})
+
+	t.Run("Fail to create an invalid sink", func(t *testing.T) {
+		_, err := NewAll([]Config{
+			{Type: TypeStatsD, Address: "localhost:8125"},
+			{Type: "graphite", Address: "localhost:2003"},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestRecordSink(t *testing.T) {
+	t.Run("Send recorded HTTP entries", func(t *testing.T) {
+		sink := &sinkMock{}
+		recordSink := NewRecordSink(sink, "job1", "worker1", "sc1", recording.Millisecond)
+
+		recordSink.Send(&recording.HTTPRecordEntry{Name: "foo", Value: 12, StatusCode: 503})
+		recordSink.Send("not an HTTP entry")
+
+		require.Len(t, sink.entries, 1)
+		entry := sink.entries[0]
+		assert.WithinDuration(t, time.Now(), entry.Time, time.Second)
+		assert.Equal(t, &Entry{
+			Time:       entry.Time,
+			JobID:      "job1",
+			WorkerID:   "worker1",
+			ScenarioID: "sc1",
+			Request:    "foo",
+			Duration:   12,
+			StatusCode: 503,
+			Ok:         false,
+		}, entry)
+	})
+
+	t.Run("Send durations in milliseconds", func(t *testing.T) {
+		sink := &sinkMock{}
+		recordSink := NewRecordSink(sink, "job1", "worker1", "sc1", recording.Microsecond)
+
+		recordSink.Send(&recording.HTTPRecordEntry{Name: "foo", Value: 250, StatusCode: 200})
+
+		require.Len(t, sink.entries, 1)
+		assert.Equal(t, 0.25, sink.entries[0].Duration)
+	})
+}
+
+func TestBufferedSink(t *testing.T) {
+	t.Run("Flush entries regularly and when closed", func(t *testing.T) {
+		defer setFlushInterval(50 * time.Millisecond)()
+		b := newBatcherMock()
+		sink := newBufferedSink("mock", b)
+
+		sink.Send(&Entry{Request: "foo"})
+		select {
+		case flushed := <-b.flushed:
+			assert.Equal(t, []string{"foo"}, flushed)
+		case <-time.After(time.Second):
+			t.Fatal("Entries were not flushed in time")
+		}
+
+		sink.Send(&Entry{Request: "bar"})
+		require.NoError(t, sink.Close())
+		assert.Equal(t, []string{"bar"}, <-b.flushed)
+
+		// Entries sent once the sink is closed are ignored
+		sink.Send(&Entry{Request: "baz"})
+		assert.NoError(t, sink.Close())
+	})
+
+	t.Run("Drop entries when the queue is full", func(t *testing.T) {
+		defer setQueueSize(1)()
+		b := newBatcherMock()
+		b.blockFlush = make(chan struct{})
+		sink := newBufferedSink("mock", b)
+
+		// The first entry blocks the batcher, the second one fills the queue and the third one is dropped
+		b.fullAfter = 1
+		sink.Send(&Entry{Request: "foo"})
+		for len(sink.queue) > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		sink.Send(&Entry{Request: "bar"})
+		sink.Send(&Entry{Request: "baz"})
+		close(b.blockFlush)
+
+		assert.EqualError(t, sink.Close(), "mock sink dropped 1 entries because it could not keep up")
+	})
+}
+
+func setFlushInterval(interval time.Duration) func() {
+	previous := flushInterval
+	flushInterval = interval
+	return func() {
+		flushInterval = previous
+	}
+}
+
+func setQueueSize(size int) func() {
+	previous := queueSize
+	queueSize = size
+	return func() {
+		queueSize = previous
+	}
+}
+
+type sinkMock struct {
+	entries []*Entry
+}
+
+func (s *sinkMock) Send(entry *Entry) {
+	s.entries = append(s.entries, entry)
+}
+
+func (s *sinkMock) Close() error {
+	return nil
+}
+
+type batcherMock struct {
+	requests   []string
+	fullAfter  int
+	blockFlush chan struct{}
+	flushed    chan []string
+}
+
+func newBatcherMock() *batcherMock {
+	return &batcherMock{flushed: make(chan []string, 10)}
+}
+
+func (b *batcherMock) add(entry *Entry) {
+	b.requests = append(b.requests, entry.Request)
+}
+
+func (b *batcherMock) full() bool {
+	return b.fullAfter > 0 && len(b.requests) >= b.fullAfter
+}
+
+func (b *batcherMock) flush() error {
+	if len(b.requests) == 0 {
+		return nil
+	}
+	if b.blockFlush != nil {
+		<-b.blockFlush
+	}
+	b.flushed <- b.requests
+	b.requests = nil
+	return nil
+}
@@ -0,0 +1,95 @@
+package sinks
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// maxStatsDPacketSize keeps packets below the usual MTU, so that they are not fragmented
+const maxStatsDPacketSize = 1432
+
+// statsDBatcher sends each entry as a timing and a counter over UDP. Tags are sent with the DogStatsD extension
+// (|#key:value), which is also understood by Telegraf and the StatsD exporter of Prometheus.
+type statsDBatcher struct {
+	conn net.Conn
+	// packets are the lines to send, several lines per packet
+	packets []*bytes.Buffer
+}
+
+func newStatsDSink(config Config) (Sink, error) {
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s sink", TypeStatsD)
+	}
+	return &closingSink{
+		bufferedSink: newBufferedSink(TypeStatsD, &statsDBatcher{conn: conn}),
+		close:        conn.Close,
+	}, nil
+}
+
+func (b *statsDBatcher) add(entry *Entry) {
+	tags := "|#job_id:" + statsDTag(entry.JobID) +
+		",worker_id:" + statsDTag(entry.WorkerID) +
+		",scenario:" + statsDTag(entry.ScenarioID) +
+		",request:" + statsDTag(entry.Request) +
+		",status_code:" + strconv.Itoa(entry.StatusCode) +
+		",result:" + result(entry)
+	lines := "deluge.request.duration:" + strconv.FormatFloat(entry.Duration, 'f', -1, 64) + "|ms" + tags + "\n" +
+		"deluge.request.count:1|c" + tags
+
+	if len(b.packets) == 0 || b.packets[len(b.packets)-1].Len()+1+len(lines) > maxStatsDPacketSize {
+		b.packets = append(b.packets, &bytes.Buffer{})
+	}
+	packet := b.packets[len(b.packets)-1]
+	if packet.Len() > 0 {
+		packet.WriteByte('\n')
+	}
+	packet.WriteString(lines)
+}
+
+// full tells whether a packet is ready to be sent
+func (b *statsDBatcher) full() bool {
+	return len(b.packets) > 1
+}
+
+func (b *statsDBatcher) flush() error {
+	defer func() {
+		b.packets = b.packets[:0]
+	}()
+	for _, packet := range b.packets {
+		if _, err := b.conn.Write(packet.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var statsDTagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", ":", "_", "\n", "_")
+
+func statsDTag(value string) string {
+	return statsDTagReplacer.Replace(value)
+}
+
+func result(entry *Entry) string {
+	if entry.Ok {
+		return "ok"
+	}
+	return "ko"
+}
+
+// closingSink releases a resource once its buffered sink is closed.
+type closingSink struct {
+	*bufferedSink
+	close func() error
+}
+
+func (s *closingSink) Close() error {
+	err := s.bufferedSink.Close()
+	if closeErr := s.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
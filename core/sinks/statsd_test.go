@@ -0,0 +1,63 @@
+package sinks
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsDSink(t *testing.T) {
+	t.Run("Send entries over UDP", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+
+		sink, err := New(Config{Type: TypeStatsD, Address: conn.LocalAddr().String()})
+		require.NoError(t, err)
+		sink.Send(&Entry{JobID: "job1", WorkerID: "worker1", ScenarioID: "sc1", Request: "get, then: put", Duration: 12, StatusCode: 200, Ok: true})
+		sink.Send(&Entry{JobID: "job1", WorkerID: "worker1", ScenarioID: "sc1", Request: "foo", Duration: 30.5, StatusCode: 500})
+		require.NoError(t, sink.Close())
+
+		packet := readPacket(t, conn)
+		assert.Equal(t, strings.Join([]string{
+			"deluge.request.duration:12|ms|#job_id:job1,worker_id:worker1,scenario:sc1,request:get_ then_ put,status_code:200,result:ok",
+			"deluge.request.count:1|c|#job_id:job1,worker_id:worker1,scenario:sc1,request:get_ then_ put,status_code:200,result:ok",
+			"deluge.request.duration:30.5|ms|#job_id:job1,worker_id:worker1,scenario:sc1,request:foo,status_code:500,result:ko",
+			"deluge.request.count:1|c|#job_id:job1,worker_id:worker1,scenario:sc1,request:foo,status_code:500,result:ko",
+		}, "\n"), packet)
+	})
+
+	t.Run("Split entries into several packets", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+
+		sink, err := New(Config{Type: TypeStatsD, Address: conn.LocalAddr().String()})
+		require.NoError(t, err)
+		const entryCount = 20
+		for i := 0; i < entryCount; i++ {
+			sink.Send(&Entry{JobID: "job1", WorkerID: "worker1", ScenarioID: "sc1", Request: "foo", Duration: 12, StatusCode: 200, Ok: true})
+		}
+		require.NoError(t, sink.Close())
+
+		lineCount := 0
+		for lineCount < 2*entryCount {
+			packet := readPacket(t, conn)
+			assert.True(t, len(packet) <= maxStatsDPacketSize, "packet is too big: %d bytes", len(packet))
+			lineCount += len(strings.Split(packet, "\n"))
+		}
+		assert.Equal(t, 2*entryCount, lineCount)
+	})
+}
+
+func readPacket(t *testing.T, conn net.PacketConn) string {
+	t.Helper()
+	buf := make([]byte, 65536)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
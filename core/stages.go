@@ -0,0 +1,113 @@
+package core
+
+import (
+	"math"
+	"time"
+)
+
+// stage is a part of a load profile. During a stage, the number of users (or the arrival rate) goes linearly from
+// the target of the previous stage to the target of this stage. A step stage reaches its target immediately.
+type stage struct {
+	duration time.Duration
+	target   int
+	step     bool
+}
+
+// loadProfile describes how the number of users (or the arrival rate) of a scenario evolves over time.
+// Once all the stages are over, the target of the last stage is kept.
+type loadProfile struct {
+	start  int
+	stages []stage
+}
+
+// split returns the profile of the given share of the users (or of the arrival rate).
+func (p *loadProfile) split(share Share) *loadProfile {
+	splitProfile := &loadProfile{
+		start:  share.Split(p.start),
+		stages: make([]stage, len(p.stages)),
+	}
+	for i, s := range p.stages {
+		splitProfile.stages[i] = stage{
+			duration: s.duration,
+			target:   share.Split(s.target),
+			step:     s.step,
+		}
+	}
+	return splitProfile
+}
+
+// max returns the highest value reached by the profile.
+func (p *loadProfile) max() int {
+	max := p.start
+	for _, s := range p.stages {
+		if s.target > max {
+			max = s.target
+		}
+	}
+	return max
+}
+
+// valueAt returns the number of users (or the arrival rate) at the given time since the beginning of the scenario,
+// and the number (starting at 1) of the stage running at that time.
+func (p *loadProfile) valueAt(elapsed time.Duration) (float64, int) {
+	from := float64(p.start)
+	for i, s := range p.stages {
+		to := float64(s.target)
+		if elapsed < s.duration {
+			if s.step {
+				return to, i + 1
+			}
+			return from + (to-from)*float64(elapsed)/float64(s.duration), i + 1
+		}
+		elapsed -= s.duration
+		from = to
+	}
+	return from, len(p.stages)
+}
+
+// arrivalTime returns the time since the beginning of the scenario at which the n-th iteration (starting at 0) must
+// start so that the arrival rate follows the profile. It returns false if the n-th iteration never starts because
+// the arrival rate drops to 0.
+func (p *loadProfile) arrivalTime(n int) (time.Duration, bool) {
+	// The n-th iteration starts when the count of iterations (the integral of the rate) reaches n, so that the first
+	// one starts right away like without stages
+	remaining := float64(n)
+	from := float64(p.start)
+	var offset time.Duration
+	for _, s := range p.stages {
+		to := float64(s.target)
+		seconds := s.duration.Seconds()
+		if s.step {
+			from = to
+		}
+		count := (from + to) / 2 * seconds
+		if count > 0 && remaining <= count {
+			return offset + secondsToDuration(solveLinearRamp(from, to, seconds, remaining)), true
+		}
+		remaining -= count
+		offset += s.duration
+		from = to
+	}
+	if from <= 0 {
+		return 0, false
+	}
+	return offset + secondsToDuration(remaining/from), true
+}
+
+// solveLinearRamp returns the time t at which the integral of a rate going linearly from 'from' to 'to' in
+// 'duration' seconds reaches 'count', i.e. the solution of from*t + (to-from)/duration*t²/2 = count.
+func solveLinearRamp(from, to, duration, count float64) float64 {
+	a := (to - from) / duration
+	if a == 0 {
+		return count / from
+	}
+	delta := from*from + 2*a*count
+	if delta < 0 {
+		delta = 0
+	}
+	return (-from + math.Sqrt(delta)) / a
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLoadProfile_ValueAt(t *testing.T) {
+	profile := &loadProfile{
+		start: 10,
+		stages: []stage{
+			{duration: 10 * time.Second, target: 20},
+			{duration: 10 * time.Second, target: 20},
+			{duration: 10 * time.Second, target: 50, step: true},
+			{duration: 10 * time.Second, target: 0},
+		},
+	}
+
+	tests := []struct {
+		elapsed       time.Duration
+		expectedValue float64
+		expectedStage int
+	}{
+		{0, 10, 1},
+		{5 * time.Second, 15, 1},
+		{10 * time.Second, 20, 2},
+		{19 * time.Second, 20, 2},
+		{20 * time.Second, 50, 3},
+		{29 * time.Second, 50, 3},
+		{35 * time.Second, 25, 4},
+		{40 * time.Second, 0, 4},
+		{time.Hour, 0, 4},
+	}
+	for _, tt := range tests {
+		value, stageNumber := profile.valueAt(tt.elapsed)
+		assert.InDelta(t, tt.expectedValue, value, 0.0001, "at %s", tt.elapsed)
+		assert.Equal(t, tt.expectedStage, stageNumber, "at %s", tt.elapsed)
+	}
+}
+
+func TestLoadProfile_ArrivalTime(t *testing.T) {
+	t.Run("Constant rate", func(t *testing.T) {
+		profile := &loadProfile{
+			start:  10,
+			stages: []stage{{duration: 10 * time.Second, target: 10}},
+		}
+		arrival, ok := profile.arrivalTime(0)
+		assert.True(t, ok)
+		assert.Equal(t, time.Duration(0), arrival)
+		arrival, ok = profile.arrivalTime(1)
+		assert.True(t, ok)
+		assert.Equal(t, 100*time.Millisecond, arrival)
+		arrival, ok = profile.arrivalTime(100)
+		assert.True(t, ok)
+		assert.Equal(t, 10*time.Second, arrival)
+		// The last rate is kept after the last stage
+		arrival, ok = profile.arrivalTime(110)
+		assert.True(t, ok)
+		assert.Equal(t, 11*time.Second, arrival)
+	})
+
+	t.Run("Linear ramp-up from 0", func(t *testing.T) {
+		profile := &loadProfile{
+			start:  0,
+			stages: []stage{{duration: 10 * time.Second, target: 20}},
+		}
+		arrival, ok := profile.arrivalTime(0)
+		assert.True(t, ok)
+		assert.Equal(t, time.Duration(0), arrival)
+		// 100 iterations are started during the ramp-up
+		arrival, ok = profile.arrivalTime(100)
+		assert.True(t, ok)
+		assert.InDelta(t, float64(10*time.Second), float64(arrival), float64(time.Millisecond))
+		// 25 iterations are started during the first half of the ramp-up
+		arrival, ok = profile.arrivalTime(25)
+		assert.True(t, ok)
+		assert.InDelta(t, float64(5*time.Second), float64(arrival), float64(time.Millisecond))
+	})
+
+	t.Run("Step", func(t *testing.T) {
+		profile := &loadProfile{
+			start:  0,
+			stages: []stage{{duration: 10 * time.Second, target: 10, step: true}},
+		}
+		arrival, ok := profile.arrivalTime(1)
+		assert.True(t, ok)
+		assert.InDelta(t, float64(100*time.Millisecond), float64(arrival), float64(time.Microsecond))
+	})
+
+	t.Run("Ramp-down to 0", func(t *testing.T) {
+		profile := &loadProfile{
+			start:  20,
+			stages: []stage{{duration: 10 * time.Second, target: 0}},
+		}
+		arrival, ok := profile.arrivalTime(100)
+		assert.True(t, ok)
+		assert.InDelta(t, float64(10*time.Second), float64(arrival), float64(time.Millisecond))
+		_, ok = profile.arrivalTime(101)
+		assert.False(t, ok)
+	})
+}
+
+func TestLoadProfile_Split(t *testing.T) {
+	profile := &loadProfile{
+		start: 10,
+		stages: []stage{
+			{duration: 10 * time.Second, target: 100},
+			{duration: 10 * time.Second, target: 5, step: true},
+		},
+	}
+	assert.Equal(t, 100, profile.max())
+
+	split := profile.split(Share{Index: 0, Count: 2})
+	assert.Equal(t, &loadProfile{
+		start: 5,
+		stages: []stage{
+			{duration: 10 * time.Second, target: 50},
+			{duration: 10 * time.Second, target: 3, step: true},
+		},
+	}, split)
+}
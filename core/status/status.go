@@ -15,7 +15,11 @@ const (
 	DelugeInProgress
 	DelugeDoneSuccess
 	DelugeInterrupted
+	// DelugeWorkerLost is set by an orchestrator when a worker stopped sending heartbeats before the end of its job
+	DelugeWorkerLost
 	DelugeDoneError
+	// DelugeAborted is set when a threshold with 'abortOnFail' failed while the deluge was running
+	DelugeAborted
 )
 
 func (s DelugeStatus) String() string {
@@ -28,15 +32,19 @@ func (s DelugeStatus) String() string {
 		return "doneSuccess"
 	case DelugeInterrupted:
 		return "interrupted"
+	case DelugeWorkerLost:
+		return "workerLost"
 	case DelugeDoneError:
 		return "doneError"
+	case DelugeAborted:
+		return "aborted"
 	default:
 		return "unknown"
 	}
 }
 
 func (s DelugeStatus) IsEnd() bool {
-	return s == DelugeDoneSuccess || s == DelugeInterrupted || s == DelugeDoneError
+	return s == DelugeDoneSuccess || s == DelugeInterrupted || s == DelugeWorkerLost || s == DelugeDoneError || s == DelugeAborted
 }
 
 func (s DelugeStatus) MarshalJSON() ([]byte, error) {
@@ -55,8 +63,12 @@ func (s *DelugeStatus) UnmarshalJSON(data []byte) error {
 		*s = DelugeDoneSuccess
 	case DelugeInterrupted.String():
 		*s = DelugeInterrupted
+	case DelugeWorkerLost.String():
+		*s = DelugeWorkerLost
 	case DelugeDoneError.String():
 		*s = DelugeDoneError
+	case DelugeAborted.String():
+		*s = DelugeAborted
 	default:
 		return errors.Errorf("invalid status '%s'", payload)
 	}
@@ -70,6 +82,21 @@ func MergeDelugeStatuses(s1, s2 DelugeStatus) DelugeStatus {
 	return s2
 }
 
+// MergeJobStatuses merges the statuses of all the workers of a job. The job is still in progress as long as some of
+// its workers have not ended, even if others are already done.
+func MergeJobStatuses(statuses ...DelugeStatus) DelugeStatus {
+	merged := DelugeVirgin
+	allEnded := true
+	for _, s := range statuses {
+		merged = MergeDelugeStatuses(merged, s)
+		allEnded = allEnded && s.IsEnd()
+	}
+	if !allEnded && merged.IsEnd() {
+		merged = DelugeInProgress
+	}
+	return merged
+}
+
 // ScenarioStatus represents the status of a running scenario
 type ScenarioStatus int
 
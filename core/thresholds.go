@@ -0,0 +1,231 @@
+package core
+
+import (
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/ofux/deluge/dsl/ast"
+	"github.com/ofux/deluge/dsl/evaluator"
+	"github.com/ofux/deluge/dsl/object"
+	"sort"
+	"time"
+)
+
+// AllRequests is the request name of the thresholds that apply to all the requests of a scenario.
+const AllRequests = "*"
+
+// ThresholdMetric is what a threshold compares to its limit.
+type ThresholdMetric string
+
+const (
+	ThresholdP50  ThresholdMetric = "p50"
+	ThresholdP75  ThresholdMetric = "p75"
+	ThresholdP90  ThresholdMetric = "p90"
+	ThresholdP95  ThresholdMetric = "p95"
+	ThresholdP99  ThresholdMetric = "p99"
+	ThresholdMean ThresholdMetric = "mean"
+	ThresholdMax  ThresholdMetric = "max"
+	// ThresholdErrorRate is the ratio of KO calls, between 0 and 1
+	ThresholdErrorRate ThresholdMetric = "errorRate"
+)
+
+var thresholdQuantiles = map[ThresholdMetric]int{
+	ThresholdP50: 50,
+	ThresholdP75: 75,
+	ThresholdP90: 90,
+	ThresholdP95: 95,
+	ThresholdP99: 99,
+}
+
+// Threshold is a pass/fail criterion of a scenario, evaluated against its report at the end of a job.
+// It passes if the value of the metric does not exceed the limit.
+type Threshold struct {
+	// Request is the name of the request the threshold applies to, or AllRequests
+	Request string
+	Metric  ThresholdMetric
+	// Limit is in milliseconds for response times, and a ratio for the error rate
+	Limit float64
+	// AbortOnFail makes the threshold evaluated while the deluge runs too. The deluge is aborted as soon as it fails.
+	AbortOnFail bool
+	// DelayAbortEval is the time since the beginning of the deluge during which the threshold is not evaluated
+	// while the deluge runs, so that a few slow requests at startup do not abort it.
+	DelayAbortEval time.Duration
+}
+
+// ThresholdResult is the outcome of a threshold.
+type ThresholdResult struct {
+	*Threshold
+	// Value is the value of the metric, or nil if no call of the request was recorded
+	Value  *float64
+	Passed bool
+}
+
+// GetThresholds returns the thresholds of each scenario of the deluge.
+func (c *CompiledDeluge) GetThresholds() map[string][]*Threshold {
+	thresholds := make(map[string][]*Threshold)
+	for scenarioID, conf := range c.scenarioConfigs {
+		if len(conf.thresholds) > 0 {
+			thresholds[scenarioID] = conf.thresholds
+		}
+	}
+	return thresholds
+}
+
+// EvaluateThresholds evaluates the thresholds against the report of a scenario. A threshold on a request that has
+// no recorded call fails, as it is most likely a mistake in the request name.
+func EvaluateThresholds(thresholds []*Threshold, report *reporting.HTTPReport) []*ThresholdResult {
+	var global *reporting.HTTPStats
+	if report != nil && report.Stats != nil {
+		global = report.Stats.Global
+	}
+	results := make([]*ThresholdResult, 0, len(thresholds))
+	for _, threshold := range thresholds {
+		results = append(results, evaluateThreshold(threshold, global))
+	}
+	return results
+}
+
+func evaluateThreshold(threshold *Threshold, global *reporting.HTTPStats) *ThresholdResult {
+	result := &ThresholdResult{Threshold: threshold}
+	if stats := thresholdRequestStats(threshold.Request, global); stats != nil {
+		value := threshold.Metric.valueOf(stats)
+		result.Value = &value
+		result.Passed = value <= threshold.Limit
+	}
+	return result
+}
+
+func thresholdRequestStats(request string, global *reporting.HTTPStats) *reporting.HTTPRequestStats {
+	if global == nil {
+		return nil
+	}
+	stats := &global.HTTPRequestStats
+	if request != AllRequests {
+		stats = global.PerRequests[request]
+	}
+	if stats == nil || stats.Global == nil || stats.Global.CallCount == 0 {
+		return nil
+	}
+	return stats
+}
+
+func (m ThresholdMetric) valueOf(stats *reporting.HTTPRequestStats) float64 {
+	switch m {
+	case ThresholdMean:
+		return stats.Global.Milliseconds(stats.Global.MeanTime)
+	case ThresholdMax:
+		return stats.Global.Milliseconds(float64(stats.Global.MaxTime))
+	case ThresholdErrorRate:
+		koStats := stats.PerOkKo[recording.Ko]
+		if koStats == nil {
+			return 0
+		}
+		return float64(koStats.CallCount) / float64(stats.Global.CallCount)
+	default:
+		return stats.Global.Milliseconds(float64(stats.Global.ValueAtQuantiles[thresholdQuantiles[m]]))
+	}
+}
+
+func (m ThresholdMetric) isValid() bool {
+	_, isQuantile := thresholdQuantiles[m]
+	return isQuantile || m == ThresholdMean || m == ThresholdMax || m == ThresholdErrorRate
+}
+
+// parseThresholds reads the 'thresholds' object of a scenario configuration. Its keys are request names (or
+// AllRequests) and its values are objects of metrics and their limits, such as {"p95": "300ms", "errorRate": 0.01}.
+// These objects may also have 'abortOnFail' and 'delayAbortEval', which apply to all their thresholds.
+func parseThresholds(node ast.Node, thresholdsHashValue object.Object) ([]*Threshold, *object.Error) {
+	thresholdsHash, ok := thresholdsHashValue.(*object.Hash)
+	if !ok {
+		return nil, evaluator.NewError(node, "Expected 'thresholds' value to be an object in configuration at %s\n", ast.PrintLocation(node))
+	}
+
+	thresholds := make([]*Threshold, 0)
+	for request, rulesValue := range thresholdsHash.Pairs {
+		rules, ok := rulesValue.(*object.Hash)
+		if !ok {
+			return nil, evaluator.NewError(node, "Expected thresholds of '%s' to be an object in configuration at %s\n", request, ast.PrintLocation(node))
+		}
+		abortOnFail, delayAbortEval, errObj := parseAbortOptions(node, string(request), rules)
+		if errObj != nil {
+			return nil, errObj
+		}
+		for metric, limitValue := range rules.Pairs {
+			if metric == "abortOnFail" || metric == "delayAbortEval" {
+				continue
+			}
+			threshold := &Threshold{
+				Request:        string(request),
+				Metric:         ThresholdMetric(metric),
+				AbortOnFail:    abortOnFail,
+				DelayAbortEval: delayAbortEval,
+			}
+			if !threshold.Metric.isValid() {
+				return nil, evaluator.NewError(node, "Unknown threshold '%s' of '%s' in configuration at %s\n", metric, request, ast.PrintLocation(node))
+			}
+			limit, ok := parseThresholdLimit(threshold.Metric, limitValue)
+			if !ok {
+				if threshold.Metric == ThresholdErrorRate {
+					return nil, evaluator.NewError(node, "Expected threshold '%s' of '%s' to be a number between 0 and 1 in configuration at %s\n", metric, request, ast.PrintLocation(node))
+				}
+				return nil, evaluator.NewError(node, "Expected threshold '%s' of '%s' to be a valid duration in configuration at %s\n", metric, request, ast.PrintLocation(node))
+			}
+			threshold.Limit = limit
+			thresholds = append(thresholds, threshold)
+		}
+	}
+
+	// Hash pairs have no order
+	sort.Slice(thresholds, func(i, j int) bool {
+		if thresholds[i].Request != thresholds[j].Request {
+			return thresholds[i].Request < thresholds[j].Request
+		}
+		return thresholds[i].Metric < thresholds[j].Metric
+	})
+	return thresholds, nil
+}
+
+func parseAbortOptions(node ast.Node, request string, rules *object.Hash) (bool, time.Duration, *object.Error) {
+	abortOnFail := false
+	if abortOnFailValue, ok, err := rules.GetAsBool("abortOnFail"); ok {
+		if err != nil {
+			return false, 0, evaluator.NewError(node, "Expected 'abortOnFail' of '%s' to be a boolean in configuration at %s\n", request, ast.PrintLocation(node))
+		}
+		abortOnFail = abortOnFailValue.Value
+	}
+
+	var delayAbortEval time.Duration
+	if delayAbortEvalValue, ok, err := rules.GetAsString("delayAbortEval"); ok {
+		if err == nil {
+			delayAbortEval, err = time.ParseDuration(delayAbortEvalValue.Value)
+		}
+		if err != nil || delayAbortEval < 0 {
+			return false, 0, evaluator.NewError(node, "Expected 'delayAbortEval' of '%s' to be a valid duration in configuration at %s\n", request, ast.PrintLocation(node))
+		}
+	}
+	return abortOnFail, delayAbortEval, nil
+}
+
+func parseThresholdLimit(metric ThresholdMetric, limitValue object.Object) (float64, bool) {
+	if metric == ThresholdErrorRate {
+		var rate float64
+		switch limit := limitValue.(type) {
+		case *object.Float:
+			rate = limit.Value
+		case *object.Integer:
+			rate = float64(limit.Value)
+		default:
+			return 0, false
+		}
+		return rate, rate >= 0 && rate <= 1
+	}
+
+	limitStr, ok := limitValue.(*object.String)
+	if !ok {
+		return 0, false
+	}
+	duration, err := time.ParseDuration(limitStr.Value)
+	if err != nil || duration < 0 {
+		return 0, false
+	}
+	return float64(duration) / float64(time.Millisecond), true
+}
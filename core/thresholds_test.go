@@ -0,0 +1,68 @@
+package core
+
+import (
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestEvaluateThresholds(t *testing.T) {
+	recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+	for _, value := range []int64{10, 20, 30, 40} {
+		recorder.Record(&recording.HTTPRecordEntry{Iteration: 0, Name: "foo", Value: value, StatusCode: 200})
+	}
+	recorder.Record(&recording.HTTPRecordEntry{Iteration: 0, Name: "bar", Value: 100, StatusCode: 500})
+	recorder.Close()
+	records, err := recorder.GetRecords()
+	require.NoError(t, err)
+	report := (&reporting.HTTPReporter{}).Report(records).(*reporting.HTTPReport)
+
+	value := func(v float64) *float64 {
+		return &v
+	}
+
+	t.Run("Evaluate thresholds against a report", func(t *testing.T) {
+		thresholds := []*Threshold{
+			{Request: AllRequests, Metric: ThresholdErrorRate, Limit: 0.2},
+			{Request: AllRequests, Metric: ThresholdMax, Limit: 99},
+			{Request: "foo", Metric: ThresholdP50, Limit: 20},
+			{Request: "foo", Metric: ThresholdMean, Limit: 20},
+			{Request: "foo", Metric: ThresholdErrorRate, Limit: 0},
+			{Request: "bar", Metric: ThresholdP99, Limit: 100},
+			{Request: "unknown", Metric: ThresholdP99, Limit: 100},
+		}
+		assert.Equal(t, []*ThresholdResult{
+			{Threshold: thresholds[0], Value: value(0.2), Passed: true},
+			{Threshold: thresholds[1], Value: value(100), Passed: false},
+			{Threshold: thresholds[2], Value: value(20), Passed: true},
+			{Threshold: thresholds[3], Value: value(25), Passed: false},
+			{Threshold: thresholds[4], Value: value(0), Passed: true},
+			{Threshold: thresholds[5], Value: value(100), Passed: true},
+			{Threshold: thresholds[6], Value: nil, Passed: false},
+		}, EvaluateThresholds(thresholds, report))
+	})
+
+	t.Run("Evaluate thresholds in milliseconds against a report in microseconds", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(1, 1, recording.HistogramConfig{Unit: recording.Microsecond, MaxValue: time.Minute})
+		recorder.Record(&recording.HTTPRecordEntry{Iteration: 0, Name: "foo", Value: 500, StatusCode: 200})
+		recorder.Close()
+		records, err := recorder.GetRecords()
+		require.NoError(t, err)
+		report := (&reporting.HTTPReporter{}).Report(records).(*reporting.HTTPReport)
+
+		thresholds := []*Threshold{{Request: "foo", Metric: ThresholdP99, Limit: 0.4}}
+		assert.Equal(t, []*ThresholdResult{
+			{Threshold: thresholds[0], Value: value(0.5), Passed: false},
+		}, EvaluateThresholds(thresholds, report))
+	})
+
+	t.Run("Evaluate thresholds without report", func(t *testing.T) {
+		thresholds := []*Threshold{{Request: AllRequests, Metric: ThresholdErrorRate, Limit: 1}}
+		assert.Equal(t, []*ThresholdResult{
+			{Threshold: thresholds[0], Value: nil, Passed: false},
+		}, EvaluateThresholds(thresholds, nil))
+	})
+}
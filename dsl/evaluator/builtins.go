@@ -23,6 +23,13 @@ func AddGlobalBuiltin(name string, fn object.BuiltinFunction) error {
 	return nil
 }
 
+// GlobalBuiltin returns the global built-in function of the given name. It lets an evaluator wrap a global built-in
+// function with its own.
+func GlobalBuiltin(name string) (*object.Builtin, bool) {
+	builtin, ok := globalBuiltins[name]
+	return builtin, ok
+}
+
 func AssertArgCount(node ast.Node, args []object.Object, count int) *object.Error {
 	if len(args) != count {
 		return NewError(node, "wrong number of arguments. got=%d, want=%d",
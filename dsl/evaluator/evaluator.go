@@ -871,6 +871,12 @@ func (e *Evaluator) evalExpressions(
 	return result
 }
 
+// ApplyFunction calls a function of the script, or a built-in function, with the given arguments. It lets built-in
+// functions call back the script.
+func (e *Evaluator) ApplyFunction(node ast.Node, fn object.Object, args ...object.Object) object.Object {
+	return e.applyFunction(node, fn, args)
+}
+
 func (e *Evaluator) applyFunction(node ast.Node, fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 
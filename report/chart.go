@@ -0,0 +1,134 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"math"
+	"strconv"
+)
+
+const (
+	chartWidth        = 720
+	chartHeight       = 280
+	chartMarginLeft   = 60
+	chartMarginRight  = 120
+	chartMarginTop    = 30
+	chartMarginBottom = 40
+	chartTickCount    = 5
+)
+
+// chartColors are used in turn by the series of a chart
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#d62728", "#2ca02c", "#9467bd", "#8c564b"}
+
+// lineChart is rendered as inline SVG so that reports work offline.
+type lineChart struct {
+	Title  string
+	XLabel string
+	YLabel string
+	Series []*chartSeries
+}
+
+type chartSeries struct {
+	Name   string
+	Points []chartPoint
+}
+
+type chartPoint struct {
+	X, Y float64
+}
+
+func (c *lineChart) isEmpty() bool {
+	for _, series := range c.Series {
+		if len(series.Points) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// SVG renders the chart. Both axes start at 0.
+func (c *lineChart) SVG() template.HTML {
+	maxX, maxY := 0.0, 0.0
+	for _, series := range c.Series {
+		for _, p := range series.Points {
+			maxX = math.Max(maxX, p.X)
+			maxY = math.Max(maxY, p.Y)
+		}
+	}
+	xTicks := niceTicks(maxX)
+	yTicks := niceTicks(maxY)
+	maxX, maxY = xTicks[len(xTicks)-1], yTicks[len(yTicks)-1]
+
+	plotWidth := float64(chartWidth - chartMarginLeft - chartMarginRight)
+	plotHeight := float64(chartHeight - chartMarginTop - chartMarginBottom)
+	xPos := func(x float64) float64 { return chartMarginLeft + x/maxX*plotWidth }
+	yPos := func(y float64) float64 { return chartMarginTop + plotHeight - y/maxY*plotHeight }
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `<svg class="chart" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg">`, chartWidth, chartHeight)
+	fmt.Fprintf(buf, `<text class="title" x="%d" y="18">%s</text>`, chartMarginLeft, template.HTMLEscapeString(c.Title))
+
+	for _, tick := range yTicks {
+		y := yPos(tick)
+		fmt.Fprintf(buf, `<line class="grid" x1="%d" y1="%.1f" x2="%.1f" y2="%.1f"/>`, chartMarginLeft, y, xPos(maxX), y)
+		fmt.Fprintf(buf, `<text class="tick" x="%d" y="%.1f" text-anchor="end">%s</text>`, chartMarginLeft-6, y+4, formatTick(tick))
+	}
+	for _, tick := range xTicks {
+		x := xPos(tick)
+		fmt.Fprintf(buf, `<line class="axis" x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f"/>`, x, yPos(0), x, yPos(0)+4)
+		fmt.Fprintf(buf, `<text class="tick" x="%.1f" y="%.1f" text-anchor="middle">%s</text>`, x, yPos(0)+16, formatTick(tick))
+	}
+	fmt.Fprintf(buf, `<line class="axis" x1="%d" y1="%.1f" x2="%.1f" y2="%.1f"/>`, chartMarginLeft, yPos(0), xPos(maxX), yPos(0))
+	fmt.Fprintf(buf, `<line class="axis" x1="%d" y1="%d" x2="%d" y2="%.1f"/>`, chartMarginLeft, chartMarginTop, chartMarginLeft, yPos(0))
+	fmt.Fprintf(buf, `<text class="label" x="%.1f" y="%d" text-anchor="middle">%s</text>`,
+		chartMarginLeft+plotWidth/2, chartHeight-6, template.HTMLEscapeString(c.XLabel))
+	fmt.Fprintf(buf, `<text class="label" x="14" y="%.1f" text-anchor="middle" transform="rotate(-90 14 %.1f)">%s</text>`,
+		chartMarginTop+plotHeight/2, chartMarginTop+plotHeight/2, template.HTMLEscapeString(c.YLabel))
+
+	for i, series := range c.Series {
+		color := chartColors[i%len(chartColors)]
+		points := &bytes.Buffer{}
+		for _, p := range series.Points {
+			fmt.Fprintf(points, "%.1f,%.1f ", xPos(p.X), yPos(p.Y))
+		}
+		if len(series.Points) == 1 {
+			p := series.Points[0]
+			fmt.Fprintf(buf, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"/>`, xPos(p.X), yPos(p.Y), color)
+		} else {
+			fmt.Fprintf(buf, `<polyline fill="none" stroke="%s" stroke-width="2" points="%s"/>`, color, points.String())
+		}
+		legendY := chartMarginTop + 16*i
+		fmt.Fprintf(buf, `<rect x="%d" y="%d" width="12" height="12" fill="%s"/>`, chartWidth-chartMarginRight+16, legendY, color)
+		fmt.Fprintf(buf, `<text class="legend" x="%d" y="%d">%s</text>`, chartWidth-chartMarginRight+32, legendY+10,
+			template.HTMLEscapeString(series.Name))
+	}
+	buf.WriteString(`</svg>`)
+	return template.HTML(buf.String())
+}
+
+// niceTicks returns evenly spaced round values from 0 to at least max.
+func niceTicks(max float64) []float64 {
+	if max <= 0 {
+		return []float64{0, 1}
+	}
+	rawStep := max / chartTickCount
+	magnitude := math.Pow(10, math.Floor(math.Log10(rawStep)))
+	step := magnitude
+	for _, multiple := range []float64{1, 2, 5, 10} {
+		step = multiple * magnitude
+		if step >= rawStep {
+			break
+		}
+	}
+	ticks := []float64{0}
+	for i := 1; ticks[len(ticks)-1] < max; i++ {
+		ticks = append(ticks, float64(i)*step)
+	}
+	return ticks
+}
+
+func formatTick(value float64) string {
+	// Rounded to hide floating point errors of small steps
+	return strconv.FormatFloat(math.Round(value*1000)/1000, 'f', -1, 64)
+}
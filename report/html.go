@@ -0,0 +1,355 @@
+package report
+
+import (
+	"fmt"
+	"github.com/ofux/deluge/api"
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/reporting"
+	"html/template"
+	"io"
+	"sort"
+	"time"
+)
+
+// allRequests is the name of the row that sums up all the requests of a scenario
+const allRequests = "All requests"
+
+type htmlReport struct {
+	Job       *api.Job
+	Generated time.Time
+	// Thresholds is "passed" or "failed" if the job has thresholds
+	Thresholds string
+	Scenarios  []*scenarioView
+}
+
+type scenarioView struct {
+	ID                string
+	Name              string
+	Status            string
+	IterationDuration time.Duration
+	Requests          []*requestRow
+	Groups            []*groupRow
+	Timings           []*timingRow
+	Statuses          []*statusRow
+	Failures          []*failureRow
+	Charts            []template.HTML
+	Errors            []*api.JobError
+	ErrorCount        uint64
+	Thresholds        []*api.ThresholdResult
+}
+
+// requestRow sums up the calls of a request. Response times are in milliseconds.
+type requestRow struct {
+	Name  string
+	Calls int64
+	OK    int64
+	KO    int64
+	Min   float64
+	Mean  float64
+	P50   float64
+	P90   float64
+	P95   float64
+	P99   float64
+	Max   float64
+}
+
+// groupRow sums up the executions of a group of requests. Durations are in milliseconds, pauses included unless
+// stated otherwise.
+type groupRow struct {
+	Name              string
+	Calls             int64
+	OK                int64
+	KO                int64
+	Mean              float64
+	P50               float64
+	P95               float64
+	P99               float64
+	Max               float64
+	MeanWithoutPauses float64
+	P95WithoutPauses  float64
+}
+
+// timingRow sums up the phases of the calls of a request, in the order of recording.HTTPPhases. Durations are in
+// milliseconds.
+type timingRow struct {
+	Name   string
+	Phases []phaseCell
+}
+
+type phaseCell struct {
+	Mean float64
+	P95  float64
+}
+
+type statusRow struct {
+	Status int
+	Calls  int64
+	P50    float64
+	P95    float64
+	P99    float64
+}
+
+// failureRow sums up the requests that failed without response with the same category of error. Durations are the
+// time until the failure, in milliseconds.
+type failureRow struct {
+	Category recording.ErrorCategory
+	Calls    int64
+	P50      float64
+	P95      float64
+	P99      float64
+}
+
+// WriteHTML writes a self-contained HTML report of the job. Charts are inline SVG, so the report can be read offline.
+func WriteHTML(w io.Writer, job *api.Job) error {
+	report := &htmlReport{
+		Job:       job,
+		Generated: time.Now(),
+	}
+	if job.ThresholdsPassed != nil {
+		report.Thresholds = "failed"
+		if *job.ThresholdsPassed {
+			report.Thresholds = "passed"
+		}
+	}
+	for _, scenarioID := range sortedScenarioIDs(job) {
+		report.Scenarios = append(report.Scenarios, newScenarioView(scenarioID, job.Scenarios[scenarioID]))
+	}
+	return htmlTemplate.Execute(w, report)
+}
+
+func sortedScenarioIDs(job *api.Job) []string {
+	ids := make([]string, 0, len(job.Scenarios))
+	for id := range job.Scenarios {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func newScenarioView(scenarioID string, scenario *api.JobScenario) *scenarioView {
+	view := &scenarioView{
+		ID:                scenarioID,
+		Name:              scenario.Name,
+		Status:            scenario.Status.String(),
+		IterationDuration: scenario.IterationDuration,
+		Errors:            sortErrors(scenario.Errors),
+		ErrorCount:        scenario.ErrorCount,
+		Thresholds:        scenario.Thresholds,
+	}
+
+	httpReport, ok := scenario.Report.(*reporting.HTTPReport)
+	if !ok || httpReport.Stats == nil || httpReport.Stats.Global == nil {
+		return view
+	}
+	global := httpReport.Stats.Global
+
+	view.Requests = append(view.Requests, newRequestRow(allRequests, &global.HTTPRequestStats))
+	for _, name := range sortedRequestNames(global) {
+		view.Requests = append(view.Requests, newRequestRow(name, global.PerRequests[name]))
+	}
+	for _, name := range sortedGroupNames(global) {
+		view.Groups = append(view.Groups, newGroupRow(name, global.PerGroups[name]))
+	}
+	if row := newTimingRow(allRequests, &global.HTTPRequestStats); row != nil {
+		view.Timings = append(view.Timings, row)
+		for _, name := range sortedRequestNames(global) {
+			if row := newTimingRow(name, global.PerRequests[name]); row != nil {
+				view.Timings = append(view.Timings, row)
+			}
+		}
+	}
+	view.Statuses = newStatusRows(&global.HTTPRequestStats)
+	view.Failures = newFailureRows(&global.HTTPRequestStats)
+
+	for _, chart := range []*lineChart{
+		latencyOverTimeChart(httpReport.Stats.PerIteration),
+		callsOverTimeChart(httpReport.Stats.PerIteration),
+		distributionChart(global),
+	} {
+		if !chart.isEmpty() {
+			view.Charts = append(view.Charts, chart.SVG())
+		}
+	}
+	return view
+}
+
+func sortedRequestNames(stats *reporting.HTTPStats) []string {
+	names := make([]string, 0, len(stats.PerRequests))
+	for name := range stats.PerRequests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newRequestRow(name string, stats *reporting.HTTPRequestStats) *requestRow {
+	row := &requestRow{Name: name}
+	if stats.Global != nil {
+		row.Calls = stats.Global.CallCount
+		row.Min = milliseconds(stats.Global, stats.Global.MinTime)
+		row.Mean = stats.Global.Milliseconds(stats.Global.MeanTime)
+		row.Max = milliseconds(stats.Global, stats.Global.MaxTime)
+		row.P50 = milliseconds(stats.Global, stats.Global.ValueAtQuantiles[50])
+		row.P90 = milliseconds(stats.Global, stats.Global.ValueAtQuantiles[90])
+		row.P95 = milliseconds(stats.Global, stats.Global.ValueAtQuantiles[95])
+		row.P99 = milliseconds(stats.Global, stats.Global.ValueAtQuantiles[99])
+	}
+	if okStats := stats.PerOkKo[recording.Ok]; okStats != nil {
+		row.OK = okStats.CallCount
+	}
+	if koStats := stats.PerOkKo[recording.Ko]; koStats != nil {
+		row.KO = koStats.CallCount
+	}
+	return row
+}
+
+func sortedGroupNames(stats *reporting.HTTPStats) []string {
+	names := make([]string, 0, len(stats.PerGroups))
+	for name := range stats.PerGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newGroupRow(name string, stats *reporting.GroupStats) *groupRow {
+	row := &groupRow{Name: name}
+	if stats.Global != nil {
+		row.Calls = stats.Global.CallCount
+		row.Mean = stats.Global.Milliseconds(stats.Global.MeanTime)
+		row.Max = milliseconds(stats.Global, stats.Global.MaxTime)
+		row.P50 = milliseconds(stats.Global, stats.Global.ValueAtQuantiles[50])
+		row.P95 = milliseconds(stats.Global, stats.Global.ValueAtQuantiles[95])
+		row.P99 = milliseconds(stats.Global, stats.Global.ValueAtQuantiles[99])
+	}
+	if stats.WithoutPauses != nil {
+		row.MeanWithoutPauses = stats.WithoutPauses.Milliseconds(stats.WithoutPauses.MeanTime)
+		row.P95WithoutPauses = milliseconds(stats.WithoutPauses, stats.WithoutPauses.ValueAtQuantiles[95])
+	}
+	if okStats := stats.PerOkKo[recording.Ok]; okStats != nil {
+		row.OK = okStats.CallCount
+	}
+	if koStats := stats.PerOkKo[recording.Ko]; koStats != nil {
+		row.KO = koStats.CallCount
+	}
+	return row
+}
+
+// newTimingRow returns nil if the phases of the request were not recorded.
+func newTimingRow(name string, stats *reporting.HTTPRequestStats) *timingRow {
+	if len(stats.PerPhase) == 0 {
+		return nil
+	}
+	row := &timingRow{Name: name, Phases: make([]phaseCell, 0, len(recording.HTTPPhases))}
+	for _, phase := range recording.HTTPPhases {
+		var cell phaseCell
+		if phaseStats := stats.PerPhase[phase]; phaseStats != nil {
+			cell.Mean = phaseStats.Milliseconds(phaseStats.MeanTime)
+			cell.P95 = milliseconds(phaseStats, phaseStats.ValueAtQuantiles[95])
+		}
+		row.Phases = append(row.Phases, cell)
+	}
+	return row
+}
+
+func newStatusRows(stats *reporting.HTTPRequestStats) []*statusRow {
+	rows := make([]*statusRow, 0, len(stats.PerStatus))
+	for status, statusStats := range stats.PerStatus {
+		rows = append(rows, &statusRow{
+			Status: status,
+			Calls:  statusStats.CallCount,
+			P50:    milliseconds(statusStats, statusStats.ValueAtQuantiles[50]),
+			P95:    milliseconds(statusStats, statusStats.ValueAtQuantiles[95]),
+			P99:    milliseconds(statusStats, statusStats.ValueAtQuantiles[99]),
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Status < rows[j].Status
+	})
+	return rows
+}
+
+func newFailureRows(stats *reporting.HTTPRequestStats) []*failureRow {
+	rows := make([]*failureRow, 0, len(stats.PerError))
+	for category, errorStats := range stats.PerError {
+		rows = append(rows, &failureRow{
+			Category: category,
+			Calls:    errorStats.CallCount,
+			P50:      milliseconds(errorStats, errorStats.ValueAtQuantiles[50]),
+			P95:      milliseconds(errorStats, errorStats.ValueAtQuantiles[95]),
+			P99:      milliseconds(errorStats, errorStats.ValueAtQuantiles[99]),
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Category < rows[j].Category
+	})
+	return rows
+}
+
+func latencyOverTimeChart(perIteration []*reporting.HTTPStats) *lineChart {
+	chart := &lineChart{Title: "Response time over time", XLabel: "Iteration", YLabel: "Response time (ms)"}
+	for _, quantile := range []int{50, 95, 99} {
+		series := &chartSeries{Name: fmt.Sprintf("p%d", quantile)}
+		for iteration, stats := range perIteration {
+			if stats == nil || stats.Global == nil || stats.Global.CallCount == 0 {
+				continue
+			}
+			series.Points = append(series.Points, chartPoint{X: float64(iteration), Y: milliseconds(stats.Global, stats.Global.ValueAtQuantiles[quantile])})
+		}
+		chart.Series = append(chart.Series, series)
+	}
+	return chart
+}
+
+func callsOverTimeChart(perIteration []*reporting.HTTPStats) *lineChart {
+	chart := &lineChart{Title: "Requests over time", XLabel: "Iteration", YLabel: "Requests"}
+	all := &chartSeries{Name: "All"}
+	ok := &chartSeries{Name: "OK"}
+	ko := &chartSeries{Name: "KO"}
+	for iteration, stats := range perIteration {
+		if stats == nil {
+			continue
+		}
+		row := newRequestRow("", &stats.HTTPRequestStats)
+		all.Points = append(all.Points, chartPoint{X: float64(iteration), Y: float64(row.Calls)})
+		ok.Points = append(ok.Points, chartPoint{X: float64(iteration), Y: float64(row.OK)})
+		ko.Points = append(ko.Points, chartPoint{X: float64(iteration), Y: float64(row.KO)})
+	}
+	chart.Series = append(chart.Series, all, ok, ko)
+	return chart
+}
+
+// distributionChart shows the response time at each percentile, for all requests and for each request.
+func distributionChart(global *reporting.HTTPStats) *lineChart {
+	chart := &lineChart{Title: "Response time distribution", XLabel: "Percentile", YLabel: "Response time (ms)"}
+	chart.Series = append(chart.Series, distributionSeries(allRequests, &global.HTTPRequestStats))
+	for _, name := range sortedRequestNames(global) {
+		chart.Series = append(chart.Series, distributionSeries(name, global.PerRequests[name]))
+	}
+	return chart
+}
+
+func distributionSeries(name string, stats *reporting.HTTPRequestStats) *chartSeries {
+	series := &chartSeries{Name: name}
+	if stats.Global == nil || stats.Global.CallCount == 0 {
+		return series
+	}
+	for _, bracket := range stats.Global.CumulativeDistribution {
+		series.Points = append(series.Points, chartPoint{X: bracket.Quantile, Y: milliseconds(stats.Global, bracket.ValueAt)})
+	}
+	return series
+}
+
+func milliseconds(stats *reporting.Stats, value int64) float64 {
+	return stats.Milliseconds(float64(value))
+}
+
+// sortErrors returns the groups of errors of a scenario, the most frequent first.
+func sortErrors(errs []*api.JobError) []*api.JobError {
+	sorted := make([]*api.JobError, len(errs))
+	copy(sorted, errs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Count > sorted[j].Count
+	})
+	return sorted
+}
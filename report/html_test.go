@@ -0,0 +1,264 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ofux/deluge/api"
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/ofux/deluge/core/status"
+	"github.com/ofux/deluge/dsl/object"
+	"github.com/ofux/deluge/dsl/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestJob(t *testing.T) *api.Job {
+	recorder := recording.NewHTTPRecorder(3, 1, recording.DefaultHistogramConfig)
+	for i := 0; i < 3; i++ {
+		recorder.Record(&recording.HTTPRecordEntry{Iteration: i, Name: "foo", Value: 10, StatusCode: 200})
+		recorder.Record(&recording.HTTPRecordEntry{Iteration: i, Name: "foo", Value: 30, StatusCode: 200})
+		recorder.Record(&recording.HTTPRecordEntry{Iteration: i, Name: "<bar>", Value: 200, StatusCode: 500})
+	}
+	recorder.Close()
+	records, err := recorder.GetRecords()
+	require.NoError(t, err)
+
+	p95, errorRate := 30.0, 1.0/3
+	thresholdsPassed := false
+	assertErr := &object.Error{Message: "Assertion failed", StackToken: []token.Token{{Literal: "assert", Line: 3, Column: 4}}}
+	errorTime := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	return &api.Job{
+		ID:               "job1",
+		DelugeID:         "deluge1",
+		DelugeName:       "My deluge",
+		Status:           status.DelugeDoneError,
+		GlobalDuration:   3 * time.Second,
+		ThresholdsPassed: &thresholdsPassed,
+		Scenarios: map[string]*api.JobScenario{
+			"sc1": {
+				ID:                "sc1",
+				Name:              "My scenario",
+				IterationDuration: time.Second,
+				Status:            status.ScenarioDoneError,
+				Errors: []*api.JobError{
+					{Error: &object.Error{Message: "Other error"}, Count: 1},
+					{Error: assertErr, Count: 2, FirstAt: errorTime, LastAt: errorTime.Add(time.Minute), Requests: []string{"bar", "foo"}},
+				},
+				ErrorCount: 3,
+				Report:     (&reporting.HTTPReporter{}).Report(records),
+				Thresholds: []*api.ThresholdResult{
+					{Request: "foo", Metric: "p95", Limit: 20, Value: &p95, Passed: false},
+					{Request: "*", Metric: "errorRate", Limit: 0.5, Value: &errorRate, Passed: true},
+					{Request: "unknown", Metric: "max", Limit: 100, Passed: false},
+				},
+			},
+			"sc2": {
+				ID:     "sc2",
+				Status: status.ScenarioDoneSuccess,
+			},
+		},
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	t.Run("Write report of a job", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, WriteHTML(buf, newTestJob(t)))
+		html := buf.String()
+
+		assert.Contains(t, html, "<title>Deluge report - My deluge</title>")
+		assert.Contains(t, html, `Job job1 of deluge deluge1 - <span class="status doneError">doneError</span>`)
+		assert.Contains(t, html, "<h2>Scenario sc1 - My scenario</h2>")
+		assert.Contains(t, html, "<h2>Scenario sc2</h2>")
+		assert.Contains(t, html, `<span class="status doneError">thresholds failed</span>`)
+		assert.Contains(t, html, `<tr><td>foo</td><td>p95</td><td>20 ms</td><td>30 ms</td><td class="ko">failed</td></tr>`)
+		assert.Contains(t, html, `<tr><td>*</td><td>errorRate</td><td>0.5</td><td>0.3333333333333333</td><td>passed</td></tr>`)
+		assert.Contains(t, html, `<tr><td>unknown</td><td>max</td><td>100 ms</td><td>no call recorded</td><td class="ko">failed</td></tr>`)
+		assert.Regexp(t, `<tr class="total"><td>All requests</td><td>9</td><td>6</td><td class="ko">3</td><td>33.33%</td>`, html)
+		assert.Contains(t, html, "<tr><td>&lt;bar&gt;</td><td>3</td><td>0</td><td class=\"ko\">3</td><td>100.00%</td>")
+		assert.Contains(t, html, "<tr><td>foo</td><td>6</td><td>6</td><td>0</td><td>0.00%</td><td>10 ms</td>")
+		// Phases were not recorded, and all the requests got a response
+		assert.NotContains(t, html, "<h3>Timings")
+		assert.NotContains(t, html, "<h3>Failures without response")
+		assert.NotContains(t, html, "<h3>Groups")
+		assert.Contains(t, html, "<tr><td>200</td><td>6</td>")
+		assert.Contains(t, html, "<tr><td>500</td><td>3</td>")
+
+		// The most frequent error comes first
+		assert.Contains(t, html, "<h3>Errors (3)</h3>")
+		assert.Regexp(t, regexp.MustCompile(`(?s)Assertion failed.*at assert \(line 3, col 4\)</pre></td><td>2</td><td>bar, foo</td><td>Wed, 01 Jan 2020 10:00:00 UTC</td><td>Wed, 01 Jan 2020 10:01:00 UTC</td>.*Other error</pre></td><td>1</td><td></td><td></td><td></td>`), html)
+
+		assert.Equal(t, 3, strings.Count(html, "<svg"))
+		assert.Contains(t, html, "Response time over time")
+		assert.Contains(t, html, "Requests over time")
+		assert.Contains(t, html, "Response time distribution")
+		assert.NotContains(t, html, "<script")
+		assert.NotRegexp(t, `(src|href)="http`, html, "the report must work offline")
+	})
+
+	t.Run("Write report of an aborted job", func(t *testing.T) {
+		job := newTestJob(t)
+		job.Status = status.DelugeAborted
+		job.AbortedBy = &api.JobAbortCause{ScenarioID: "sc1", Request: "foo", Metric: "p95", Limit: 20, Value: 30}
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, WriteHTML(buf, job))
+		html := buf.String()
+		assert.Contains(t, html, `<span class="status aborted">aborted</span>`)
+		assert.Contains(t, html, "Aborted by threshold p95 of request foo of scenario sc1: 30 ms (limit: 20 ms)")
+	})
+
+	t.Run("Write report of a job aborted by an error", func(t *testing.T) {
+		job := newTestJob(t)
+		job.Status = status.DelugeAborted
+		job.AbortedBy = &api.JobAbortCause{ScenarioID: "sc1", Error: &object.Error{Message: "Assertion failed"}}
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, WriteHTML(buf, job))
+		html := buf.String()
+		assert.Contains(t, html, "Aborted by an error of scenario sc1:")
+		assert.Contains(t, html, "<pre>RUNTIME ERROR: Assertion failed</pre>")
+		assert.NotContains(t, html, "Aborted by threshold")
+	})
+
+	t.Run("Write report of a job read from JSON", func(t *testing.T) {
+		content, err := json.Marshal(newTestJob(t))
+		require.NoError(t, err)
+		job := &api.Job{}
+		require.NoError(t, json.Unmarshal(content, job))
+		require.IsType(t, &reporting.HTTPReport{}, job.Scenarios["sc1"].Report)
+		assert.Nil(t, job.Scenarios["sc2"].Report)
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, WriteHTML(buf, job))
+		assert.Regexp(t, `<tr class="total"><td>All requests</td><td>9</td>`, buf.String())
+	})
+
+	t.Run("Write report of a job recorded in microseconds", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(1, 1, recording.HistogramConfig{Unit: recording.Microsecond, MaxValue: time.Minute})
+		recorder.Record(&recording.HTTPRecordEntry{Name: "foo", Value: 250, StatusCode: 200})
+		recorder.Close()
+		records, err := recorder.GetRecords()
+		require.NoError(t, err)
+		job := &api.Job{
+			ID: "job1",
+			Scenarios: map[string]*api.JobScenario{
+				"sc1": {ID: "sc1", Report: (&reporting.HTTPReporter{}).Report(records)},
+			},
+		}
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, WriteHTML(buf, job))
+		assert.Contains(t, buf.String(), "<tr><td>foo</td><td>1</td><td>1</td><td>0</td><td>0.00%</td><td>0.25 ms</td>")
+	})
+
+	t.Run("Write report of a job with timings", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+		recorder.Record(&recording.HTTPRecordEntry{Name: "foo", Value: 50, StatusCode: 200, Phases: map[recording.HTTPPhase]int64{
+			recording.PhaseDNS:      2,
+			recording.PhaseConnect:  3,
+			recording.PhaseTLS:      10,
+			recording.PhaseTTFB:     30,
+			recording.PhaseTransfer: 5,
+		}})
+		recorder.Close()
+		records, err := recorder.GetRecords()
+		require.NoError(t, err)
+		job := &api.Job{
+			ID: "job1",
+			Scenarios: map[string]*api.JobScenario{
+				"sc1": {ID: "sc1", Report: (&reporting.HTTPReporter{}).Report(records)},
+			},
+		}
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, WriteHTML(buf, job))
+		html := buf.String()
+		assert.Contains(t, html, "<h3>Timings (mean / p95)</h3>")
+		assert.Contains(t, html, `<tr class="total"><td>All requests</td><td>2 ms / 2 ms</td><td>3 ms / 3 ms</td><td>10 ms / 10 ms</td><td>30 ms / 30 ms</td><td>5 ms / 5 ms</td></tr>`)
+		assert.Contains(t, html, "<tr><td>foo</td><td>2 ms / 2 ms</td>")
+	})
+
+	t.Run("Write report of a job with failures without response", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+		recorder.Record(&recording.HTTPRecordEntry{Name: "foo", Value: 20, StatusCode: 200})
+		recorder.Record(&recording.HTTPRecordEntry{Name: "foo", Value: 1000, Error: recording.ErrorTimeout})
+		recorder.Record(&recording.HTTPRecordEntry{Name: "foo", Value: 2, Error: recording.ErrorRefused})
+		recorder.Close()
+		records, err := recorder.GetRecords()
+		require.NoError(t, err)
+		job := &api.Job{
+			ID: "job1",
+			Scenarios: map[string]*api.JobScenario{
+				"sc1": {ID: "sc1", Report: (&reporting.HTTPReporter{}).Report(records)},
+			},
+		}
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, WriteHTML(buf, job))
+		html := buf.String()
+		assert.Contains(t, html, "<tr><td>foo</td><td>3</td><td>1</td><td class=\"ko\">2</td>")
+		assert.Contains(t, html, "<h3>Failures without response</h3>")
+		assert.Regexp(t, `(?s)<td class="ko">refused</td><td>1</td><td>2 ms</td>.*<td class="ko">timeout</td><td>1</td><td>1000 ms</td>`, html)
+	})
+
+	t.Run("Write report of a job with groups", func(t *testing.T) {
+		recorder := recording.NewHTTPRecorder(1, 1, recording.DefaultHistogramConfig)
+		recorder.Record(&recording.HTTPRecordEntry{Name: "cart", Value: 20, StatusCode: 200})
+		recorder.Record(&recording.GroupRecordEntry{Name: "checkout", Value: 1020, ValueWithoutPauses: 20})
+		recorder.Record(&recording.GroupRecordEntry{Name: "checkout", Value: 1040, ValueWithoutPauses: 40, Result: recording.Ko})
+		recorder.Close()
+		records, err := recorder.GetRecords()
+		require.NoError(t, err)
+		job := &api.Job{
+			ID: "job1",
+			Scenarios: map[string]*api.JobScenario{
+				"sc1": {ID: "sc1", Report: (&reporting.HTTPReporter{}).Report(records)},
+			},
+		}
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, WriteHTML(buf, job))
+		html := buf.String()
+		assert.Contains(t, html, "<h3>Groups</h3>")
+		assert.Contains(t, html, `<tr><td>checkout</td><td>2</td><td>1</td><td class="ko">1</td><td>50.00%</td><td>1030 ms</td><td>1020 ms</td><td>1040 ms</td><td>1040 ms</td><td>1040 ms</td><td>30 ms</td><td>40 ms</td></tr>`)
+		// Groups are not requests
+		assert.Contains(t, html, `<tr class="total"><td>All requests</td><td>1</td>`)
+	})
+
+	t.Run("Write report of a job without scenario", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, WriteHTML(buf, &api.Job{ID: "job1"}))
+		assert.Contains(t, buf.String(), "This job has no scenario.")
+	})
+}
+
+func TestLineChart(t *testing.T) {
+	t.Run("Scale the chart to round values", func(t *testing.T) {
+		assert.Equal(t, []float64{0, 2, 4, 6, 8, 10}, niceTicks(9))
+		assert.Equal(t, []float64{0, 20, 40, 60, 80, 100}, niceTicks(100))
+		assert.Equal(t, []float64{0, 1}, niceTicks(0))
+		assert.Equal(t, "0.3", formatTick(niceTicks(0.5)[3]))
+	})
+
+	t.Run("Render series", func(t *testing.T) {
+		chart := &lineChart{
+			Title: "<Title>",
+			Series: []*chartSeries{
+				{Name: "s1", Points: []chartPoint{{X: 0, Y: 0}, {X: 10, Y: 100}}},
+				{Name: "s2", Points: []chartPoint{{X: 5, Y: 50}}},
+			},
+		}
+		assert.False(t, chart.isEmpty())
+		svg := string(chart.SVG())
+		assert.Contains(t, svg, "&lt;Title&gt;")
+		assert.Contains(t, svg, `points="60.0,240.0 600.0,30.0 "`)
+		assert.Contains(t, svg, `<circle cx="330.0" cy="135.0"`)
+		assert.True(t, (&lineChart{Series: []*chartSeries{{Name: "empty"}}}).isEmpty())
+	})
+}
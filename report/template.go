@@ -0,0 +1,143 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"strconv"
+	"time"
+)
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	// ms formats milliseconds with up to 3 decimals, which is the resolution of reports in microseconds
+	"ms": func(value float64) string {
+		return strconv.FormatFloat(math.Round(value*1000)/1000, 'f', -1, 64) + " ms"
+	},
+	"percent": func(part, total int64) string {
+		if total == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f%%", float64(part)*100/float64(total))
+	},
+	"datetime": func(t time.Time) string {
+		return t.Format(time.RFC1123)
+	},
+	"thresholdValue": func(metric string, value interface{}) string {
+		var v float64
+		switch typedValue := value.(type) {
+		case float64:
+			v = typedValue
+		case *float64:
+			if typedValue == nil {
+				return "no call recorded"
+			}
+			v = *typedValue
+		}
+		if metric == "errorRate" {
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64) + " ms"
+	},
+}).Parse(htmlTemplateText))
+
+const htmlTemplateText = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Deluge report - {{.Job.DelugeName}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0 auto; max-width: 1100px; padding: 1em 2em; color: #222; }
+h1 { margin-bottom: 0.2em; }
+h2 { border-bottom: 2px solid #1f77b4; padding-bottom: 0.2em; margin-top: 2em; }
+.meta { color: #666; }
+.status { display: inline-block; padding: 0.1em 0.6em; border-radius: 0.3em; color: #fff; background: #888; }
+.status.doneSuccess { background: #2ca02c; }
+.status.doneError, .status.workerLost, .status.aborted { background: #d62728; }
+.status.interrupted { background: #ff7f0e; }
+table { border-collapse: collapse; margin: 1em 0; width: 100%; }
+th, td { border: 1px solid #ddd; padding: 0.3em 0.6em; text-align: right; }
+th { background: #f4f4f4; }
+th:first-child, td:first-child { text-align: left; }
+tr.total td { font-weight: bold; }
+td.ko, .abort-cause { color: #d62728; }
+.chart { width: 100%; max-width: 720px; display: block; margin: 1em 0; }
+.chart .title { font-size: 14px; font-weight: bold; }
+.chart .tick, .chart .legend { font-size: 11px; fill: #444; }
+.chart .label { font-size: 12px; fill: #444; }
+.chart .axis { stroke: #444; }
+.chart .grid { stroke: #e4e4e4; }
+pre { background: #f8f8f8; border: 1px solid #ddd; padding: 0.6em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>{{.Job.DelugeName}}</h1>
+<p class="meta">
+Job {{.Job.ID}} of deluge {{.Job.DelugeID}} - <span class="status {{.Job.Status}}">{{.Job.Status}}</span>
+{{if eq .Thresholds "passed"}}<span class="status doneSuccess">thresholds passed</span>{{else if eq .Thresholds "failed"}}<span class="status doneError">thresholds failed</span>{{end}}<br>
+Duration: {{.Job.GlobalDuration}} - Report generated on {{datetime .Generated}}
+</p>
+{{with .Job.AbortedBy}}{{if .Error}}<p class="abort-cause">Aborted by an error of scenario {{.ScenarioID}}:</p>
+<pre>{{.Error.Inspect}}</pre>{{else}}<p class="abort-cause">Aborted by threshold {{.Metric}} of request {{.Request}} of scenario {{.ScenarioID}}: {{thresholdValue .Metric .Value}} (limit: {{thresholdValue .Metric .Limit}})</p>{{end}}{{end}}
+{{range .Scenarios}}
+<h2>Scenario {{.ID}}{{if .Name}} - {{.Name}}{{end}}</h2>
+<p class="meta"><span class="status {{.Status}}">{{.Status}}</span> Iteration duration: {{.IterationDuration}}</p>
+{{if .Thresholds}}
+<h3>Thresholds</h3>
+<table>
+<tr><th>Request</th><th>Metric</th><th>Limit</th><th>Value</th><th>Result</th></tr>
+{{range .Thresholds}}<tr><td>{{.Request}}</td><td>{{.Metric}}</td><td>{{thresholdValue .Metric .Limit}}</td><td>{{thresholdValue .Metric .Value}}</td><td{{if not .Passed}} class="ko"{{end}}>{{if .Passed}}passed{{else}}failed{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Requests}}
+<h3>Requests</h3>
+<table>
+<tr><th>Request</th><th>Calls</th><th>OK</th><th>KO</th><th>KO rate</th><th>Min</th><th>Mean</th><th>p50</th><th>p90</th><th>p95</th><th>p99</th><th>Max</th></tr>
+{{range $i, $row := .Requests}}<tr{{if eq $i 0}} class="total"{{end}}><td>{{.Name}}</td><td>{{.Calls}}</td><td>{{.OK}}</td><td{{if .KO}} class="ko"{{end}}>{{.KO}}</td><td>{{percent .KO .Calls}}</td><td>{{ms .Min}}</td><td>{{ms .Mean}}</td><td>{{ms .P50}}</td><td>{{ms .P90}}</td><td>{{ms .P95}}</td><td>{{ms .P99}}</td><td>{{ms .Max}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Groups}}
+<h3>Groups</h3>
+<table>
+<tr><th>Group</th><th>Calls</th><th>OK</th><th>KO</th><th>KO rate</th><th>Mean</th><th>p50</th><th>p95</th><th>p99</th><th>Max</th><th>Mean without pauses</th><th>p95 without pauses</th></tr>
+{{range .Groups}}<tr><td>{{.Name}}</td><td>{{.Calls}}</td><td>{{.OK}}</td><td{{if .KO}} class="ko"{{end}}>{{.KO}}</td><td>{{percent .KO .Calls}}</td><td>{{ms .Mean}}</td><td>{{ms .P50}}</td><td>{{ms .P95}}</td><td>{{ms .P99}}</td><td>{{ms .Max}}</td><td>{{ms .MeanWithoutPauses}}</td><td>{{ms .P95WithoutPauses}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Timings}}
+<h3>Timings (mean / p95)</h3>
+<table>
+<tr><th>Request</th><th>DNS</th><th>Connect</th><th>TLS</th><th>TTFB</th><th>Transfer</th></tr>
+{{range $i, $row := .Timings}}<tr{{if eq $i 0}} class="total"{{end}}><td>{{.Name}}</td>{{range .Phases}}<td>{{ms .Mean}} / {{ms .P95}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+{{if .Statuses}}
+<h3>Status codes</h3>
+<table>
+<tr><th>Status</th><th>Calls</th><th>p50</th><th>p95</th><th>p99</th></tr>
+{{range .Statuses}}<tr><td>{{.Status}}</td><td>{{.Calls}}</td><td>{{ms .P50}}</td><td>{{ms .P95}}</td><td>{{ms .P99}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Failures}}
+<h3>Failures without response</h3>
+<table>
+<tr><th>Error</th><th>Calls</th><th>p50</th><th>p95</th><th>p99</th></tr>
+{{range .Failures}}<tr><td class="ko">{{.Category}}</td><td>{{.Calls}}</td><td>{{ms .P50}}</td><td>{{ms .P95}}</td><td>{{ms .P99}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Charts}}
+<h3>Charts</h3>
+{{range .Charts}}{{.}}
+{{end}}
+{{end}}
+{{if .Errors}}
+<h3>Errors ({{.ErrorCount}})</h3>
+<table>
+<tr><th>Error</th><th>Count</th><th>Requests</th><th>First</th><th>Last</th></tr>
+{{range .Errors}}<tr><td><pre>{{.Error.Inspect}}</pre></td><td>{{.Count}}</td><td>{{range $i, $request := .Requests}}{{if $i}}, {{end}}{{$request}}{{end}}</td><td>{{if not .FirstAt.IsZero}}{{datetime .FirstAt}}{{end}}</td><td>{{if not .LastAt.IsZero}}{{datetime .LastAt}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+{{else}}
+<p>This job has no scenario.</p>
+{{end}}
+</body>
+</html>
+`
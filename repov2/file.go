@@ -0,0 +1,360 @@
+package repov2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+const (
+	fileDeluges           = "deluges"
+	fileScenarios         = "scenarios"
+	fileJobShells         = "jobs"
+	fileWorkerReports     = "reports"
+	fileWebhookDeliveries = "webhooks"
+
+	jsonFileExt   = ".json"
+	ndjsonFileExt = ".ndjson"
+)
+
+// FileRepository is a Repository that stores everything as JSON files in a directory, so that deluges, scenarios,
+// jobs and their reports survive restarts. The directory is organized as follows:
+//
+//	deluges/<delugeId>.json
+//	scenarios/<scenarioId>.json
+//	jobs/<jobId>.json
+//	reports/<jobId>/<workerId>.json
+//	webhooks/<jobId>.ndjson (one delivery per line)
+//
+// Files are written to a temporary file first, synced to disk and then renamed, so that a crash never leaves a
+// half-written file.
+type FileRepository struct {
+	dir string
+	mut *sync.RWMutex
+}
+
+// NewFileRepository creates a FileRepository that reads and writes files in the given directory. The directory is
+// created if it does not exist yet.
+func NewFileRepository(dir string) (*FileRepository, error) {
+	for _, sub := range []string{fileDeluges, fileScenarios, fileJobShells, fileWorkerReports, fileWebhookDeliveries} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, errors.Wrapf(err, "failed to create data directory %s", dir)
+		}
+	}
+	return &FileRepository{
+		dir: dir,
+		mut: &sync.RWMutex{},
+	}, nil
+}
+
+func (r *FileRepository) SaveDeluge(deluge *PersistedDeluge) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.writeJSON(r.path(fileDeluges, deluge.ID+jsonFileExt), deluge)
+}
+
+func (r *FileRepository) GetDeluge(id string) (*PersistedDeluge, bool) {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	deluge := &PersistedDeluge{}
+	if !r.readJSON(r.path(fileDeluges, id+jsonFileExt), deluge) {
+		return nil, false
+	}
+	return deluge, true
+}
+
+func (r *FileRepository) GetAllDeluges() []*PersistedDeluge {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	all := make([]*PersistedDeluge, 0)
+	r.readAllJSON(r.path(fileDeluges), func() interface{} {
+		return &PersistedDeluge{}
+	}, func(v interface{}) {
+		all = append(all, v.(*PersistedDeluge))
+	})
+	return all
+}
+
+func (r *FileRepository) DeleteDeluge(id string) bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.remove(r.path(fileDeluges, id+jsonFileExt))
+}
+
+// ======
+
+func (r *FileRepository) SaveScenario(scenario *PersistedScenario) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.writeJSON(r.path(fileScenarios, scenario.ID+jsonFileExt), scenario)
+}
+
+func (r *FileRepository) GetScenario(id string) (*PersistedScenario, bool) {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	scenario := &PersistedScenario{}
+	if !r.readJSON(r.path(fileScenarios, id+jsonFileExt), scenario) {
+		return nil, false
+	}
+	return scenario, true
+}
+
+func (r *FileRepository) GetDelugeScenarios(ids []string) map[string]*PersistedScenario {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	delugeScenarios := make(map[string]*PersistedScenario)
+	for _, id := range ids {
+		scenario := &PersistedScenario{}
+		if r.readJSON(r.path(fileScenarios, id+jsonFileExt), scenario) {
+			delugeScenarios[id] = scenario
+		}
+	}
+	return delugeScenarios
+}
+
+func (r *FileRepository) GetAllScenarios() []*PersistedScenario {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	all := make([]*PersistedScenario, 0)
+	r.readAllJSON(r.path(fileScenarios), func() interface{} {
+		return &PersistedScenario{}
+	}, func(v interface{}) {
+		all = append(all, v.(*PersistedScenario))
+	})
+	return all
+}
+
+func (r *FileRepository) DeleteScenario(id string) bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.remove(r.path(fileScenarios, id+jsonFileExt))
+}
+
+// =======
+
+func (r *FileRepository) SaveJobShell(jobShell *PersistedJobShell) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.writeJSON(r.path(fileJobShells, jobShell.ID+jsonFileExt), jobShell)
+}
+
+func (r *FileRepository) GetJobShell(id string) (*PersistedJobShell, bool) {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	jobShell := &PersistedJobShell{}
+	if !r.readJSON(r.path(fileJobShells, id+jsonFileExt), jobShell) {
+		return nil, false
+	}
+	return jobShell, true
+}
+
+func (r *FileRepository) GetAllJobShell() []*PersistedJobShell {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	all := make([]*PersistedJobShell, 0)
+	r.readAllJSON(r.path(fileJobShells), func() interface{} {
+		return &PersistedJobShell{}
+	}, func(v interface{}) {
+		all = append(all, v.(*PersistedJobShell))
+	})
+	return all
+}
+
+// WorkerReports
+
+func (r *FileRepository) SaveWorkerReport(workerReport *PersistedWorkerReport) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	jobDir := r.path(fileWorkerReports, workerReport.JobID)
+	if err := os.MkdirAll(jobDir, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create directory of reports of job %s", workerReport.JobID)
+	}
+	return r.writeJSON(filepath.Join(jobDir, encodeFileName(workerReport.WorkerID+jsonFileExt)), workerReport)
+}
+
+func (r *FileRepository) GetJobWorkerReports(jobID string) []*PersistedWorkerReport {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	var reports []*PersistedWorkerReport
+	r.readAllJSON(r.path(fileWorkerReports, jobID), func() interface{} {
+		return &PersistedWorkerReport{}
+	}, func(v interface{}) {
+		reports = append(reports, v.(*PersistedWorkerReport))
+	})
+	return reports
+}
+
+// WebhookDeliveries
+
+func (r *FileRepository) SaveWebhookDelivery(delivery *PersistedWebhookDelivery) error {
+	line, err := json.Marshal(delivery)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize webhook delivery of job %s", delivery.JobID)
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	f, err := os.OpenFile(r.path(fileWebhookDeliveries, delivery.JobID+ndjsonFileExt), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open webhook deliveries of job %s", delivery.JobID)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "failed to save webhook delivery of job %s", delivery.JobID)
+	}
+	return f.Close()
+}
+
+func (r *FileRepository) GetJobWebhookDeliveries(jobID string) []*PersistedWebhookDelivery {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	deliveries := make([]*PersistedWebhookDelivery, 0)
+	data, err := ioutil.ReadFile(r.path(fileWebhookDeliveries, jobID+ndjsonFileExt))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).WithField("jobId", jobID).Error("Failed to read webhook deliveries")
+		}
+		return deliveries
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		delivery := &PersistedWebhookDelivery{}
+		if err := json.Unmarshal(scanner.Bytes(), delivery); err != nil {
+			log.WithError(err).WithField("jobId", jobID).Error("Failed to read webhook delivery")
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries
+}
+
+// path returns the path of a file or directory of the repository. Each element is encoded so that any ID can be
+// used as a file name.
+func (r *FileRepository) path(elements ...string) string {
+	encoded := make([]string, len(elements)+1)
+	encoded[0] = r.dir
+	for i, e := range elements {
+		encoded[i+1] = encodeFileName(e)
+	}
+	return filepath.Join(encoded...)
+}
+
+// writeJSON atomically replaces the content of the given file with v. The file and its directory are synced, so that
+// the new content survives a crash once writeJSON returns.
+func (r *FileRepository) writeJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize %s", path)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+	return errors.Wrapf(syncDir(filepath.Dir(path)), "failed to write %s", path)
+}
+
+// syncDir syncs the given directory, so that the files renamed into it survive a crash. Directories cannot be synced
+// on Windows, where it does nothing.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// readJSON reads the given file into v. It returns false if the file does not exist or cannot be read.
+func (r *FileRepository) readJSON(path string, v interface{}) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).WithField("path", path).Error("Failed to read file of repository")
+		}
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		log.WithError(err).WithField("path", path).Error("Failed to deserialize file of repository")
+		return false
+	}
+	return true
+}
+
+// readAllJSON reads every JSON file of the given directory into a value returned by newValue, and gives it to add.
+// Files that cannot be read or deserialized are skipped.
+func (r *FileRepository) readAllJSON(dir string, newValue func() interface{}, add func(v interface{})) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).WithField("path", dir).Error("Failed to read directory of repository")
+		}
+		return
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), jsonFileExt) || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			log.WithError(err).WithField("path", f.Name()).Error("Failed to read file of repository")
+			continue
+		}
+		v := newValue()
+		if err := json.Unmarshal(data, v); err != nil {
+			log.WithError(err).WithField("path", f.Name()).Error("Failed to deserialize file of repository")
+			continue
+		}
+		add(v)
+	}
+}
+
+func (r *FileRepository) remove(path string) bool {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.WithError(err).WithField("path", path).Error("Failed to delete file of repository")
+	}
+	return err == nil
+}
+
+// encodeFileName escapes a name so that it can safely be used as a file name, whatever the characters it contains.
+func encodeFileName(name string) string {
+	encoded := url.PathEscape(name)
+	if strings.HasPrefix(encoded, ".") {
+		// Prevents "." and "..", and hides nothing
+		encoded = "%2E" + encoded[1:]
+	}
+	return encoded
+}
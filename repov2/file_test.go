@@ -0,0 +1,271 @@
+package repov2
+
+import (
+	"github.com/ofux/deluge/core/status"
+	"github.com/ofux/deluge/dsl/object"
+	"github.com/ofux/deluge/dsl/token"
+	hdr "github.com/ofux/hdrhistogram"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestFileRepository(t *testing.T) (*FileRepository, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "deluge-repo")
+	require.NoError(t, err)
+	repo, err := NewFileRepository(dir)
+	require.NoError(t, err)
+	return repo, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFileRepository_Deluges(t *testing.T) {
+	t.Run("Save, get, list and delete deluges", func(t *testing.T) {
+		testedRepo, cleanup := newTestFileRepository(t)
+		defer cleanup()
+		deluge1 := &PersistedDeluge{ID: "givenID1", Name: "Deluge 1", Script: "some script", GlobalDuration: time.Minute, ScenarioIDs: []string{"sc1"}}
+		deluge2 := &PersistedDeluge{ID: "givenID2", Name: "Deluge 2"}
+
+		assert.NoError(t, testedRepo.SaveDeluge(deluge1))
+		assert.NoError(t, testedRepo.SaveDeluge(deluge2))
+
+		retrieved, ok := testedRepo.GetDeluge("givenID1")
+		assert.True(t, ok)
+		assert.Equal(t, deluge1, retrieved)
+		assert.ElementsMatch(t, []*PersistedDeluge{deluge1, deluge2}, testedRepo.GetAllDeluges())
+
+		assert.True(t, testedRepo.DeleteDeluge("givenID1"))
+		assert.False(t, testedRepo.DeleteDeluge("givenID1"))
+		_, ok = testedRepo.GetDeluge("givenID1")
+		assert.False(t, ok)
+		assert.Equal(t, []*PersistedDeluge{deluge2}, testedRepo.GetAllDeluges())
+	})
+
+	t.Run("Save 2 deluges with the same ID", func(t *testing.T) {
+		testedRepo, cleanup := newTestFileRepository(t)
+		defer cleanup()
+		deluge1 := &PersistedDeluge{ID: "givenID", Name: "Deluge 1"}
+		deluge2 := &PersistedDeluge{ID: "givenID", Name: "Deluge 2"}
+
+		assert.NoError(t, testedRepo.SaveDeluge(deluge1))
+		assert.NoError(t, testedRepo.SaveDeluge(deluge2))
+
+		retrieved, ok := testedRepo.GetDeluge("givenID")
+		assert.True(t, ok)
+		assert.Equal(t, deluge2, retrieved)
+		assert.Len(t, testedRepo.GetAllDeluges(), 1)
+	})
+
+	t.Run("Get all deluges of an empty repo", func(t *testing.T) {
+		testedRepo, cleanup := newTestFileRepository(t)
+		defer cleanup()
+
+		assert.Len(t, testedRepo.GetAllDeluges(), 0)
+	})
+}
+
+func TestFileRepository_Scenarios(t *testing.T) {
+	testedRepo, cleanup := newTestFileRepository(t)
+	defer cleanup()
+	scenario1 := &PersistedScenario{ID: "givenID1", Name: "Scenario 1", Script: "some script"}
+	scenario2 := &PersistedScenario{ID: "givenID2", Name: "Scenario 2"}
+	scenario3 := &PersistedScenario{ID: "givenID3", Name: "Scenario 3"}
+
+	assert.NoError(t, testedRepo.SaveScenario(scenario1))
+	assert.NoError(t, testedRepo.SaveScenario(scenario2))
+	assert.NoError(t, testedRepo.SaveScenario(scenario3))
+
+	retrieved, ok := testedRepo.GetScenario("givenID1")
+	assert.True(t, ok)
+	assert.Equal(t, scenario1, retrieved)
+	_, ok = testedRepo.GetScenario("doesNotExist")
+	assert.False(t, ok)
+
+	assert.Equal(t, map[string]*PersistedScenario{
+		"givenID1": scenario1,
+		"givenID2": scenario2,
+	}, testedRepo.GetDelugeScenarios([]string{"givenID1", "givenID2", "doesNotExist"}))
+	assert.ElementsMatch(t, []*PersistedScenario{scenario1, scenario2, scenario3}, testedRepo.GetAllScenarios())
+
+	assert.True(t, testedRepo.DeleteScenario("givenID2"))
+	assert.Len(t, testedRepo.GetAllScenarios(), 2)
+}
+
+func TestFileRepository_Jobs(t *testing.T) {
+	testedRepo, cleanup := newTestFileRepository(t)
+	defer cleanup()
+	job1 := &PersistedJobShell{ID: "givenID1", DelugeID: "deluge1", Webhook: "http://localhost"}
+	job2 := &PersistedJobShell{ID: "givenID2", DelugeID: "deluge1"}
+
+	assert.NoError(t, testedRepo.SaveJobShell(job1))
+	assert.NoError(t, testedRepo.SaveJobShell(job2))
+
+	retrieved, ok := testedRepo.GetJobShell("givenID1")
+	assert.True(t, ok)
+	assert.Equal(t, job1, retrieved)
+	_, ok = testedRepo.GetJobShell("doesNotExist")
+	assert.False(t, ok)
+	assert.ElementsMatch(t, []*PersistedJobShell{job1, job2}, testedRepo.GetAllJobShell())
+}
+
+func TestFileRepository_WorkerReports(t *testing.T) {
+	t.Run("Save worker-reports with histograms and get them back", func(t *testing.T) {
+		testedRepo, cleanup := newTestFileRepository(t)
+		defer cleanup()
+
+		histogram := hdr.New(0, 1000, 1)
+		require.NoError(t, histogram.RecordValue(42))
+		require.NoError(t, histogram.RecordValue(100))
+		snapshot, err := histogram.Export()
+		require.NoError(t, err)
+
+		record := &PersistedHTTPRecord{
+			PersistedHTTPRequestRecord: PersistedHTTPRequestRecord{
+				Global:    snapshot,
+				PerStatus: map[int]*hdr.Snapshot{200: snapshot},
+				PerOkKo:   map[OkKo]*hdr.Snapshot{Ok: snapshot},
+			},
+			PerRequests: map[string]*PersistedHTTPRequestRecord{
+				"req1": {
+					Global:    snapshot,
+					PerStatus: map[int]*hdr.Snapshot{200: snapshot},
+					PerOkKo:   map[OkKo]*hdr.Snapshot{Ok: snapshot},
+				},
+			},
+			Stage: 2,
+			Users: 10,
+		}
+		wr1 := &PersistedWorkerReport{
+			WorkerID: "worker1",
+			JobID:    "job1",
+			Status:   status.DelugeDoneError,
+			Scenarios: map[string]*PersistedWorkerScenarioReport{
+				"sc1": {
+					Status: status.ScenarioDoneError,
+					Errors: []*PersistedScenarioError{
+						{Error: &object.Error{Message: "some error", StackToken: []token.Token{{Type: token.IDENT, Line: 1, Column: 2, Literal: "foo"}}}, Count: 3},
+					},
+					IterationDuration: time.Second,
+					Records: &PersistedHTTPRecordsOverTime{
+						Global:   record,
+						OverTime: []*PersistedHTTPRecord{record, record},
+					},
+				},
+			},
+		}
+		wr2 := &PersistedWorkerReport{WorkerID: "worker2#1", JobID: "job1", Status: status.DelugeInProgress}
+		wr3 := &PersistedWorkerReport{WorkerID: "worker1", JobID: "job2", Status: status.DelugeDoneSuccess}
+
+		assert.NoError(t, testedRepo.SaveWorkerReport(wr1))
+		assert.NoError(t, testedRepo.SaveWorkerReport(wr2))
+		assert.NoError(t, testedRepo.SaveWorkerReport(wr3))
+
+		retrievedReports := testedRepo.GetJobWorkerReports("job1")
+		assert.ElementsMatch(t, []*PersistedWorkerReport{wr1, wr2}, retrievedReports)
+
+		// Histograms can be restored from the persisted snapshots
+		for _, report := range retrievedReports {
+			if report.WorkerID == "worker1" {
+				restored, err := hdr.Import(report.Scenarios["sc1"].Records.OverTime[1].Global)
+				require.NoError(t, err)
+				assert.Equal(t, int64(2), restored.TotalCount())
+				assert.Equal(t, int64(42), restored.Min())
+			}
+		}
+
+		// A new report of the same worker replaces the previous one
+		wr2.Status = status.DelugeDoneSuccess
+		assert.NoError(t, testedRepo.SaveWorkerReport(wr2))
+		assert.Len(t, testedRepo.GetJobWorkerReports("job1"), 2)
+	})
+
+	t.Run("Skip corrupt worker-reports", func(t *testing.T) {
+		testedRepo, cleanup := newTestFileRepository(t)
+		defer cleanup()
+		report := &PersistedWorkerReport{WorkerID: "worker1", JobID: "job1", Status: status.DelugeDoneSuccess}
+		assert.NoError(t, testedRepo.SaveWorkerReport(report))
+		assert.NoError(t, testedRepo.SaveWorkerReport(&PersistedWorkerReport{WorkerID: "worker2", JobID: "job1"}))
+
+		// A file truncated by a crash
+		path := filepath.Join(testedRepo.path(fileWorkerReports, "job1"), "worker2"+jsonFileExt)
+		require.NoError(t, ioutil.WriteFile(path, []byte(`{"WorkerID":"wor`), 0644))
+
+		assert.Equal(t, []*PersistedWorkerReport{report}, testedRepo.GetJobWorkerReports("job1"))
+	})
+
+	t.Run("Get worker-reports of a job that does not exist", func(t *testing.T) {
+		testedRepo, cleanup := newTestFileRepository(t)
+		defer cleanup()
+
+		assert.Len(t, testedRepo.GetJobWorkerReports("doesNotExist"), 0)
+	})
+}
+
+func TestFileRepository_WebhookDeliveries(t *testing.T) {
+	testedRepo, cleanup := newTestFileRepository(t)
+	defer cleanup()
+	d1 := &PersistedWebhookDelivery{JobID: "job1", Attempt: 1, Time: time.Unix(1000, 0).UTC(), Duration: time.Second, StatusCode: 500}
+	d2 := &PersistedWebhookDelivery{JobID: "job2", Attempt: 1, StatusCode: 200}
+	d3 := &PersistedWebhookDelivery{JobID: "job1", Attempt: 2, Error: "connection refused"}
+
+	assert.NoError(t, testedRepo.SaveWebhookDelivery(d1))
+	assert.NoError(t, testedRepo.SaveWebhookDelivery(d2))
+	assert.NoError(t, testedRepo.SaveWebhookDelivery(d3))
+
+	assert.Equal(t, []*PersistedWebhookDelivery{d1, d3}, testedRepo.GetJobWebhookDeliveries("job1"))
+	assert.Equal(t, []*PersistedWebhookDelivery{d2}, testedRepo.GetJobWebhookDeliveries("job2"))
+	assert.Len(t, testedRepo.GetJobWebhookDeliveries("doesNotExist"), 0)
+}
+
+func TestFileRepository_Persistence(t *testing.T) {
+	t.Run("Reopen a repository", func(t *testing.T) {
+		testedRepo, cleanup := newTestFileRepository(t)
+		defer cleanup()
+		deluge := &PersistedDeluge{ID: "givenID", Name: "Deluge"}
+		job := &PersistedJobShell{ID: "givenID", DelugeID: "givenID"}
+		report := &PersistedWorkerReport{WorkerID: "worker1", JobID: "givenID", Status: status.DelugeDoneSuccess}
+		assert.NoError(t, testedRepo.SaveDeluge(deluge))
+		assert.NoError(t, testedRepo.SaveJobShell(job))
+		assert.NoError(t, testedRepo.SaveWorkerReport(report))
+
+		reopenedRepo, err := NewFileRepository(testedRepo.dir)
+		require.NoError(t, err)
+		retrievedDeluge, ok := reopenedRepo.GetDeluge("givenID")
+		assert.True(t, ok)
+		assert.Equal(t, deluge, retrievedDeluge)
+		retrievedJob, ok := reopenedRepo.GetJobShell("givenID")
+		assert.True(t, ok)
+		assert.Equal(t, job, retrievedJob)
+		assert.Equal(t, []*PersistedWorkerReport{report}, reopenedRepo.GetJobWorkerReports("givenID"))
+	})
+
+	t.Run("Use IDs that are not valid file names", func(t *testing.T) {
+		testedRepo, cleanup := newTestFileRepository(t)
+		defer cleanup()
+
+		for _, id := range []string{"..", ".", "../../foo", "a/b", "a\\b", "a b?c#d"} {
+			job := &PersistedJobShell{ID: id, DelugeID: "deluge"}
+			assert.NoError(t, testedRepo.SaveJobShell(job))
+			retrieved, ok := testedRepo.GetJobShell(id)
+			assert.True(t, ok, "job %q was not found", id)
+			assert.Equal(t, job, retrieved)
+
+			assert.NoError(t, testedRepo.SaveWorkerReport(&PersistedWorkerReport{WorkerID: id, JobID: id}))
+			assert.Len(t, testedRepo.GetJobWorkerReports(id), 1, "reports of job %q were not found", id)
+		}
+		assert.Len(t, testedRepo.GetAllJobShell(), 6)
+
+		// Nothing was written outside of the repository
+		files, err := ioutil.ReadDir(testedRepo.dir)
+		require.NoError(t, err)
+		assert.Len(t, files, 5)
+		_, err = os.Stat(filepath.Join(filepath.Dir(testedRepo.dir), "foo.json"))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
@@ -16,18 +16,23 @@ type InMemoryRepository struct {
 
 	workerReports    map[string]*PersistedWorkerReport
 	mutWorkerReports *sync.Mutex
+
+	webhookDeliveries    map[string][]*PersistedWebhookDelivery
+	mutWebhookDeliveries *sync.Mutex
 }
 
 func NewInMemoryRepository() *InMemoryRepository {
 	return &InMemoryRepository{
-		delugeDefinitions:   make(map[string]*PersistedDeluge),
-		mutDeluges:          &sync.Mutex{},
-		scenarioDefinitions: make(map[string]*PersistedScenario),
-		mutScenarios:        &sync.Mutex{},
-		jobShells:           make(map[string]*PersistedJobShell),
-		mutJobShells:        &sync.Mutex{},
-		workerReports:       make(map[string]*PersistedWorkerReport),
-		mutWorkerReports:    &sync.Mutex{},
+		delugeDefinitions:    make(map[string]*PersistedDeluge),
+		mutDeluges:           &sync.Mutex{},
+		scenarioDefinitions:  make(map[string]*PersistedScenario),
+		mutScenarios:         &sync.Mutex{},
+		jobShells:            make(map[string]*PersistedJobShell),
+		mutJobShells:         &sync.Mutex{},
+		workerReports:        make(map[string]*PersistedWorkerReport),
+		mutWorkerReports:     &sync.Mutex{},
+		webhookDeliveries:    make(map[string][]*PersistedWebhookDelivery),
+		mutWebhookDeliveries: &sync.Mutex{},
 	}
 }
 
@@ -159,3 +164,20 @@ func (r *InMemoryRepository) GetJobWorkerReports(jobID string) []*PersistedWorke
 	}
 	return reports
 }
+
+// WebhookDeliveries
+
+func (r *InMemoryRepository) SaveWebhookDelivery(delivery *PersistedWebhookDelivery) error {
+	r.mutWebhookDeliveries.Lock()
+	defer r.mutWebhookDeliveries.Unlock()
+	r.webhookDeliveries[delivery.JobID] = append(r.webhookDeliveries[delivery.JobID], delivery)
+	return nil
+}
+
+func (r *InMemoryRepository) GetJobWebhookDeliveries(jobID string) []*PersistedWebhookDelivery {
+	r.mutWebhookDeliveries.Lock()
+	defer r.mutWebhookDeliveries.Unlock()
+	deliveries := make([]*PersistedWebhookDelivery, len(r.webhookDeliveries[jobID]))
+	copy(deliveries, r.webhookDeliveries[jobID])
+	return deliveries
+}
@@ -709,3 +709,27 @@ func TestInMemoryRepository_RaceWorkerReport(t *testing.T) {
 		testedRepo.GetJobWorkerReports(givenID1)
 	}()
 }
+
+// WEBHOOK DELIVERIES
+
+func TestInMemoryRepository_WebhookDeliveries(t *testing.T) {
+	t.Run("Save deliveries of 2 jobs and get them back in order", func(t *testing.T) {
+		testedRepo := NewInMemoryRepository()
+		d1 := &PersistedWebhookDelivery{JobID: "job1", Attempt: 1, StatusCode: 500}
+		d2 := &PersistedWebhookDelivery{JobID: "job2", Attempt: 1, StatusCode: 200}
+		d3 := &PersistedWebhookDelivery{JobID: "job1", Attempt: 2, StatusCode: 200}
+
+		assert.NoError(t, testedRepo.SaveWebhookDelivery(d1))
+		assert.NoError(t, testedRepo.SaveWebhookDelivery(d2))
+		assert.NoError(t, testedRepo.SaveWebhookDelivery(d3))
+
+		assert.Equal(t, []*PersistedWebhookDelivery{d1, d3}, testedRepo.GetJobWebhookDeliveries("job1"))
+		assert.Equal(t, []*PersistedWebhookDelivery{d2}, testedRepo.GetJobWebhookDeliveries("job2"))
+	})
+
+	t.Run("Get deliveries of a job that has none", func(t *testing.T) {
+		testedRepo := NewInMemoryRepository()
+
+		assert.Len(t, testedRepo.GetJobWebhookDeliveries("doesNotExist"), 0)
+	})
+}
@@ -27,6 +27,9 @@ type Repository interface {
 
 	SaveWorkerReport(workerReport *PersistedWorkerReport) error
 	GetJobWorkerReports(jobID string) []*PersistedWorkerReport
+
+	SaveWebhookDelivery(delivery *PersistedWebhookDelivery) error
+	GetJobWebhookDeliveries(jobID string) []*PersistedWebhookDelivery
 }
 
 type PersistedDeluge struct {
@@ -47,6 +50,36 @@ type PersistedJobShell struct {
 	ID       string
 	DelugeID string
 	Webhook  string
+	// EventLog is the format of the event log of the job, or empty if it has no event log
+	EventLog string
+	// Thresholds are the results of the thresholds of the job, saved right before the job ends. They are nil until
+	// then, and if the deluge of the job has no threshold.
+	Thresholds *PersistedThresholdResults `json:",omitempty"`
+}
+
+// PersistedThresholdResults are the results of the thresholds of the scenarios of a job
+type PersistedThresholdResults struct {
+	Passed      bool
+	PerScenario map[string][]*PersistedThresholdResult `json:",omitempty"`
+}
+
+// PersistedThresholdResult is the outcome of a threshold. Value is nil if the request of the threshold was never made.
+type PersistedThresholdResult struct {
+	Request string
+	Metric  string
+	Limit   float64
+	Value   *float64
+	Passed  bool
+}
+
+// PersistedWebhookDelivery is an attempt to call the webhook of a job
+type PersistedWebhookDelivery struct {
+	JobID      string
+	Attempt    int
+	Time       time.Time
+	Duration   time.Duration
+	StatusCode int
+	Error      string
 }
 
 type PersistedWorkerReport struct {
@@ -54,6 +87,20 @@ type PersistedWorkerReport struct {
 	JobID     string
 	Status    status.DelugeStatus
 	Scenarios map[string]*PersistedWorkerScenarioReport
+	// AbortedBy is the threshold that aborted the deluge, if its status is DelugeAborted
+	AbortedBy *PersistedAbortCause
+}
+
+// PersistedAbortCause is a threshold that failed while a deluge was running, or the error of a scenario configured
+// to abort the deluge on error
+type PersistedAbortCause struct {
+	ScenarioID string
+	Request    string
+	Metric     string
+	Limit      float64
+	Value      float64
+	// Error is set instead of the threshold if the deluge was aborted by an error
+	Error *object.Error `json:",omitempty"`
 }
 
 func (wr *PersistedWorkerReport) GetID() string {
@@ -62,12 +109,24 @@ func (wr *PersistedWorkerReport) GetID() string {
 
 type PersistedWorkerScenarioReport struct {
 	Status            status.ScenarioStatus
-	Errors            []*object.Error
+	Errors            []*PersistedScenarioError
 	IterationDuration time.Duration
 	Records           *PersistedHTTPRecordsOverTime
 }
 
+// PersistedScenarioError is an error that made iterations of the users of a scenario fail, with the number of times
+// it occurred. Errors persisted without count occurred once.
+type PersistedScenarioError struct {
+	*object.Error
+	Count    uint64    `json:",omitempty"`
+	FirstAt  time.Time `json:",omitempty"`
+	LastAt   time.Time `json:",omitempty"`
+	Requests []string  `json:",omitempty"`
+}
+
 type PersistedHTTPRecordsOverTime struct {
+	// Unit is the unit of the values of the histograms: ms, us or ns. Records persisted without unit are in ms.
+	Unit     string `json:",omitempty"`
 	Global   *PersistedHTTPRecord
 	OverTime []*PersistedHTTPRecord
 }
@@ -75,12 +134,27 @@ type PersistedHTTPRecordsOverTime struct {
 type PersistedHTTPRecord struct {
 	PersistedHTTPRequestRecord
 	PerRequests map[string]*PersistedHTTPRequestRecord
+	// PerGroups holds the durations of the groups of requests of the script, if any
+	PerGroups map[string]*PersistedGroupRecord `json:",omitempty"`
+	Stage     int
+	Users     int
+}
+
+type PersistedGroupRecord struct {
+	Global        *hdr.Snapshot
+	WithoutPauses *hdr.Snapshot
+	PerOkKo       map[OkKo]*hdr.Snapshot
 }
 
 type PersistedHTTPRequestRecord struct {
 	Global    *hdr.Snapshot
 	PerStatus map[int]*hdr.Snapshot
 	PerOkKo   map[OkKo]*hdr.Snapshot
+	// PerPhase holds the durations of the phases of the requests (dns, connect, tls, ttfb and transfer), if recorded
+	PerPhase map[string]*hdr.Snapshot `json:",omitempty"`
+	// PerError holds the durations of the requests that got no response, per category of error (timeout, refused,
+	// reset, dns, tls or other)
+	PerError map[string]*hdr.Snapshot `json:",omitempty"`
 }
 
 type OkKo string
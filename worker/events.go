@@ -0,0 +1,196 @@
+package worker
+
+import (
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/ofux/deluge/core/status"
+	"github.com/ofux/deluge/dsl/object"
+	"sync"
+)
+
+// JobEventType is the kind of a JobEvent
+type JobEventType string
+
+const (
+	// JobEventStatus is published when the status of a job changes
+	JobEventStatus JobEventType = "status"
+	// JobEventRecords is published when a worker has new records for some iterations of a scenario
+	JobEventRecords JobEventType = "records"
+	// JobEventError is published when users of a scenario stop because of errors
+	JobEventError JobEventType = "error"
+)
+
+const (
+	// jobEventBufferSize is the number of events a subscriber can be late before being dropped.
+	jobEventBufferSize = 64
+	// jobErrorHistorySize is the number of recent error events of a running job that are sent to new subscribers.
+	jobErrorHistorySize = 10
+)
+
+// JobEvent is something that happened to a running job.
+type JobEvent struct {
+	Type JobEventType
+	// Status is the merged status of all the workers of the job. Only set for JobEventStatus.
+	Status status.DelugeStatus
+	// WorkerID is the worker that sent the records or the errors.
+	WorkerID   string
+	ScenarioID string
+	// Records contains the statistics of the iterations that changed since the previous JobEventRecords of the same
+	// worker. Only set for JobEventRecords.
+	Records *reporting.HTTPStatsSnapshot
+	// Errors are the errors users of the scenario stopped with. Only set for JobEventError.
+	Errors []*object.Error
+}
+
+// JobEventBroker dispatches the events of running jobs to the clients that follow them.
+type JobEventBroker struct {
+	subscriptions map[string]map[*JobSubscription]struct{}
+	// statuses are the last statuses of each worker of a running job
+	statuses map[string]map[string]status.DelugeStatus
+	// jobStatuses are the last merged statuses published for running jobs
+	jobStatuses map[string]status.DelugeStatus
+	// jobErrors are the recent error events of running jobs
+	jobErrors map[string][]*JobEvent
+	mut       *sync.Mutex
+}
+
+// JobSubscription receives the events of a job.
+type JobSubscription struct {
+	jobID  string
+	events chan *JobEvent
+	closed bool
+	broker *JobEventBroker
+}
+
+var EventsInstance = NewJobEventBroker()
+
+func NewJobEventBroker() *JobEventBroker {
+	return &JobEventBroker{
+		subscriptions: make(map[string]map[*JobSubscription]struct{}),
+		statuses:      make(map[string]map[string]status.DelugeStatus),
+		jobStatuses:   make(map[string]status.DelugeStatus),
+		jobErrors:     make(map[string][]*JobEvent),
+		mut:           &sync.Mutex{},
+	}
+}
+
+// Subscribe starts following the events of a job. If the job is running, its current status is the first event,
+// followed by its recent errors. The subscription must be closed once the events are not needed anymore.
+func (b *JobEventBroker) Subscribe(jobID string) *JobSubscription {
+	sub := &JobSubscription{
+		jobID:  jobID,
+		events: make(chan *JobEvent, jobEventBufferSize),
+		broker: b,
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if _, ok := b.subscriptions[jobID]; !ok {
+		b.subscriptions[jobID] = make(map[*JobSubscription]struct{})
+	}
+	b.subscriptions[jobID][sub] = struct{}{}
+	if jobStatus, ok := b.jobStatuses[jobID]; ok {
+		sub.events <- &JobEvent{Type: JobEventStatus, Status: jobStatus}
+	}
+	for _, event := range b.jobErrors[jobID] {
+		sub.events <- event
+	}
+	return sub
+}
+
+// HasSubscribers tells whether someone follows the events of the job, so that events that are expensive to build
+// are only built when needed.
+func (b *JobEventBroker) HasSubscribers(jobID string) bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return len(b.subscriptions[jobID]) > 0
+}
+
+// PublishStatus takes the new status of a worker of the job into account. An event is published only if the merged
+// status of the job changes.
+func (b *JobEventBroker) PublishStatus(jobID, workerID string, workerStatus status.DelugeStatus) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if _, ok := b.statuses[jobID]; !ok {
+		b.statuses[jobID] = make(map[string]status.DelugeStatus)
+	}
+	b.statuses[jobID][workerID] = workerStatus
+
+	workersStatuses := make([]status.DelugeStatus, 0, len(b.statuses[jobID]))
+	for _, s := range b.statuses[jobID] {
+		workersStatuses = append(workersStatuses, s)
+	}
+	jobStatus := status.MergeJobStatuses(workersStatuses...)
+	if previous, ok := b.jobStatuses[jobID]; ok && previous == jobStatus {
+		return
+	}
+
+	if jobStatus.IsEnd() {
+		// Nothing else is expected from this job
+		delete(b.statuses, jobID)
+		delete(b.jobStatuses, jobID)
+		delete(b.jobErrors, jobID)
+	} else {
+		b.jobStatuses[jobID] = jobStatus
+	}
+	b.publish(jobID, &JobEvent{Type: JobEventStatus, Status: jobStatus})
+}
+
+// PublishRecords publishes the statistics of the iterations of a scenario that changed since the previous records
+// of the worker.
+func (b *JobEventBroker) PublishRecords(jobID, workerID, scenarioID string, records *reporting.HTTPStatsSnapshot) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.publish(jobID, &JobEvent{Type: JobEventRecords, WorkerID: workerID, ScenarioID: scenarioID, Records: records})
+}
+
+// PublishErrors publishes the errors users of a scenario of the job stopped with.
+func (b *JobEventBroker) PublishErrors(jobID, workerID, scenarioID string, errs []*object.Error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	event := &JobEvent{Type: JobEventError, WorkerID: workerID, ScenarioID: scenarioID, Errors: errs}
+	// Kept until the job ends
+	history := append(b.jobErrors[jobID], event)
+	if len(history) > jobErrorHistorySize {
+		history = history[len(history)-jobErrorHistorySize:]
+	}
+	b.jobErrors[jobID] = history
+	b.publish(jobID, event)
+}
+
+// publish sends the event to the subscribers of the job. Subscribers that are too late to receive it are dropped,
+// because missing some records would make them show wrong results.
+func (b *JobEventBroker) publish(jobID string, event *JobEvent) {
+	for sub := range b.subscriptions[jobID] {
+		select {
+		case sub.events <- event:
+		default:
+			b.unsubscribe(sub)
+		}
+	}
+}
+
+func (b *JobEventBroker) unsubscribe(sub *JobSubscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.events)
+	delete(b.subscriptions[sub.jobID], sub)
+	if len(b.subscriptions[sub.jobID]) == 0 {
+		delete(b.subscriptions, sub.jobID)
+	}
+}
+
+// Events returns the channel of the events of the job. It is closed when the subscription is closed, or when the
+// subscriber was too slow to receive the events.
+func (s *JobSubscription) Events() <-chan *JobEvent {
+	return s.events
+}
+
+// Close stops following the events of the job.
+func (s *JobSubscription) Close() {
+	s.broker.mut.Lock()
+	defer s.broker.mut.Unlock()
+	s.broker.unsubscribe(s)
+}
@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/ofux/deluge/core/status"
+	"github.com/ofux/deluge/dsl/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestJobEventBroker(t *testing.T) {
+	t.Run("Publish events to the subscribers of a job", func(t *testing.T) {
+		broker := NewJobEventBroker()
+		sub1 := broker.Subscribe("job1")
+		defer sub1.Close()
+		sub2 := broker.Subscribe("job2")
+		defer sub2.Close()
+		assert.True(t, broker.HasSubscribers("job1"))
+		assert.False(t, broker.HasSubscribers("job3"))
+
+		records := &reporting.HTTPStatsSnapshot{PerIteration: map[int]*reporting.HTTPStats{}}
+		errs := []*object.Error{{Message: "some error"}}
+		broker.PublishRecords("job1", "worker1", "sc1", records)
+		broker.PublishErrors("job1", "worker1", "sc1", errs)
+
+		assert.Equal(t, &JobEvent{Type: JobEventRecords, WorkerID: "worker1", ScenarioID: "sc1", Records: records}, <-sub1.Events())
+		assert.Equal(t, &JobEvent{Type: JobEventError, WorkerID: "worker1", ScenarioID: "sc1", Errors: errs}, <-sub1.Events())
+		assert.Len(t, sub2.Events(), 0)
+	})
+
+	t.Run("Publish the merged status of the workers of a job", func(t *testing.T) {
+		broker := NewJobEventBroker()
+		broker.PublishStatus("job1", "worker1", status.DelugeVirgin)
+		broker.PublishStatus("job1", "worker1", status.DelugeInProgress)
+
+		sub := broker.Subscribe("job1")
+		defer sub.Close()
+		// Subscribers of a running job first receive its current status
+		assert.Equal(t, &JobEvent{Type: JobEventStatus, Status: status.DelugeInProgress}, <-sub.Events())
+
+		broker.PublishStatus("job1", "worker2", status.DelugeInProgress)
+		broker.PublishStatus("job1", "worker1", status.DelugeDoneSuccess)
+		assert.Len(t, sub.Events(), 0, "the job is still running on worker2")
+
+		broker.PublishStatus("job1", "worker2", status.DelugeDoneError)
+		assert.Equal(t, &JobEvent{Type: JobEventStatus, Status: status.DelugeDoneError}, <-sub.Events())
+
+		// The ended job is forgotten
+		sub2 := broker.Subscribe("job1")
+		defer sub2.Close()
+		assert.Len(t, sub2.Events(), 0)
+	})
+
+	t.Run("Send the recent errors of a running job to new subscribers", func(t *testing.T) {
+		broker := NewJobEventBroker()
+		broker.PublishStatus("job1", "worker1", status.DelugeInProgress)
+		for i := 0; i <= jobErrorHistorySize; i++ {
+			broker.PublishErrors("job1", "worker1", "sc1", []*object.Error{{Message: fmt.Sprintf("error %d", i)}})
+		}
+
+		sub := broker.Subscribe("job1")
+		defer sub.Close()
+		assert.Equal(t, &JobEvent{Type: JobEventStatus, Status: status.DelugeInProgress}, <-sub.Events())
+		require.Len(t, sub.Events(), jobErrorHistorySize)
+		assert.Equal(t, "error 1", (<-sub.Events()).Errors[0].Message, "the oldest error is forgotten")
+
+		// The errors of an ended job are forgotten
+		broker.PublishStatus("job1", "worker1", status.DelugeDoneError)
+		sub2 := broker.Subscribe("job1")
+		defer sub2.Close()
+		assert.Len(t, sub2.Events(), 0)
+	})
+
+	t.Run("Close a subscription", func(t *testing.T) {
+		broker := NewJobEventBroker()
+		sub := broker.Subscribe("job1")
+		sub.Close()
+		sub.Close()
+
+		assert.False(t, broker.HasSubscribers("job1"))
+		_, ok := <-sub.Events()
+		assert.False(t, ok)
+	})
+
+	t.Run("Drop a subscriber that is too slow", func(t *testing.T) {
+		broker := NewJobEventBroker()
+		slowSub := broker.Subscribe("job1")
+		defer slowSub.Close()
+
+		for i := 0; i <= jobEventBufferSize; i++ {
+			broker.PublishErrors("job1", "worker1", "sc1", nil)
+		}
+
+		assert.False(t, broker.HasSubscribers("job1"))
+		received := 0
+		for range slowSub.Events() {
+			received++
+		}
+		require.Equal(t, jobEventBufferSize, received)
+	})
+}
@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ofux/deluge/cleanhttp"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	contentTypeJSON = "application/json; charset=UTF-8"
+	contentTypeText = "text/plain"
+)
+
+func newHTTPClient() *http.Client {
+	client := cleanhttp.DefaultClient()
+	client.Timeout = 30 * time.Second
+	return client
+}
+
+// sendRequest sends an HTTP request and returns the status code and the body of the response.
+func sendRequest(client *http.Client, method, url, contentType string, body []byte) (int, []byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return 0, nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return res.StatusCode, nil, err
+	}
+	return res.StatusCode, resBody, nil
+}
+
+// sendJSON marshals the given value and sends it. It fails if the response status code is not the expected one.
+func sendJSON(client *http.Client, method, url string, v interface{}, expectedCode int) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	code, resBody, err := sendRequest(client, method, url, contentTypeJSON, body)
+	if err != nil {
+		return err
+	}
+	if code != expectedCode {
+		return errors.Errorf("%s %s: expected code %d but got %d: %s", method, url, expectedCode, code, resBody)
+	}
+	return nil
+}
+
+// isUnreachable tells whether the request failed because the remote host could not be reached or did not answer,
+// rather than because it rejected the request.
+func isUnreachable(err error) bool {
+	_, ok := errors.Cause(err).(*url.Error)
+	return ok
+}
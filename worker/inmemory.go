@@ -1,30 +1,99 @@
 package worker
 
 import (
+	"github.com/ofux/deluge/core/eventlog"
 	"github.com/ofux/deluge/repov2"
 	"github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
+	"io"
+	"sync"
 )
 
 type inMemoryManager struct {
-	workers []*worker
+	workerCount int
+	workers     map[string][]*worker
+	// running is the number of workers of each job that have not saved their final report yet
+	running  map[string]int
+	onJobEnd JobEndHandler
+	mut      *sync.Mutex
+	// endMut makes the workers save their final report one at a time
+	endMut *sync.Mutex
 }
 
 func NewInMemoryManager(workerCount int) Manager {
 	return &inMemoryManager{
-		workers: make([]*worker, workerCount),
+		workerCount: workerCount,
+		workers:     make(map[string][]*worker),
+		running:     make(map[string]int),
+		mut:         &sync.Mutex{},
+		endMut:      &sync.Mutex{},
 	}
 }
 
 func (m *inMemoryManager) CreateAll(jobShell *JobShell) error {
-	for i := range m.workers {
-		m.workers[i] = newWorker(uuid.NewV4().String(), jobShell, repov2.Instance)
+	workers := make([]*worker, m.workerCount)
+	for i := range workers {
+		w := newWorker(uuid.NewV4().String(), jobShell, repov2.Instance)
+		if jobShell.Share != nil {
+			// The job has been given by an orchestrator, so it must be kept informed
+			w.orchestrator = OrchestratorInstance
+		}
+		w.saveFinalReport = func(report *repov2.PersistedWorkerReport) {
+			m.saveFinalReport(w, report)
+		}
+		workers[i] = w
 	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.workers[jobShell.ID] = workers
+	m.running[jobShell.ID] = len(workers)
 	return nil
 }
 
+// OnJobEnd sets the function called once all the workers of a job have saved their final report.
+func (m *inMemoryManager) OnJobEnd(handler JobEndHandler) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.onJobEnd = handler
+}
+
+// saveFinalReport saves the final report of a worker. Final reports are saved one at a time, so that the results of the
+// thresholds of the job can be saved right before the last one, with the records of all the workers: the job is never
+// seen ended without them. The end of the job is handled once the last report is saved.
+func (m *inMemoryManager) saveFinalReport(w *worker, report *repov2.PersistedWorkerReport) {
+	jobShellID := w.jobShell.ID
+	m.endMut.Lock()
+	m.mut.Lock()
+	m.running[jobShellID]--
+	last := m.running[jobShellID] == 0
+	if last {
+		delete(m.running, jobShellID)
+	}
+	handler := m.onJobEnd
+	m.mut.Unlock()
+
+	if last {
+		// Thresholds are those of the deluge that was run, even if it has been changed since
+		reports := replaceReports(w.repository.GetJobWorkerReports(jobShellID), report)
+		if err := saveThresholdResults(w.repository, jobShellID, w.getRunningDeluge().GetThresholds(), reports); err != nil {
+			w.logger.WithError(err).Error("Failed to save results of thresholds")
+		}
+	}
+	w.saveWorkerReportWithRetry(report)
+	m.endMut.Unlock()
+
+	if last && handler != nil {
+		handler(jobShellID)
+	}
+}
+
 func (m *inMemoryManager) StartAll(jobShell *JobShell) error {
-	for _, w := range m.workers {
+	workers, ok := m.getWorkers(jobShell.ID)
+	if !ok {
+		return errors.Errorf("no worker was created for job %s", jobShell.ID)
+	}
+	for _, w := range workers {
 		if err := w.start(); err != nil {
 			return errors.Wrapf(err, "failed to start worker %s", w.ID)
 		}
@@ -33,8 +102,36 @@ func (m *inMemoryManager) StartAll(jobShell *JobShell) error {
 }
 
 func (m *inMemoryManager) InterruptAll(jobShellID string) error {
-	for _, w := range m.workers {
+	workers, _ := m.getWorkers(jobShellID)
+	for _, w := range workers {
 		w.interrupt()
 	}
 	return nil
 }
+
+// SetConcurrency changes the concurrency on every worker of the job. Like when the job was created, each of them
+// runs the whole share it was given.
+func (m *inMemoryManager) SetConcurrency(jobShellID, scenarioID string, concurrent int) error {
+	workers, ok := m.getWorkers(jobShellID)
+	if !ok {
+		return ErrUnknownJob
+	}
+	for _, w := range workers {
+		if err := w.setConcurrency(scenarioID, concurrent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenEventLogs opens the event logs written on this host, which outlive the workers.
+func (m *inMemoryManager) OpenEventLogs(jobShellID, format string) ([]io.ReadCloser, error) {
+	return eventlog.OpenAll(jobShellID, format)
+}
+
+func (m *inMemoryManager) getWorkers(jobShellID string) ([]*worker, bool) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	workers, ok := m.workers[jobShellID]
+	return workers, ok
+}
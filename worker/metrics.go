@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/ofux/deluge/core/status"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// metricsRetention is how long the metrics of a job are still collected after it ended, so that they can be scraped
+// one last time.
+var metricsRetention = 5 * time.Minute
+
+// MetricsCollector is implemented by the managers that run jobs themselves, as opposed to an orchestrator.
+type MetricsCollector interface {
+	// CollectMetrics returns the metrics of the running jobs and of the jobs that ended recently.
+	CollectMetrics() []*JobMetrics
+}
+
+// JobMetrics are the live statistics of the share of a job run by a worker.
+type JobMetrics struct {
+	JobID     string
+	DelugeID  string
+	WorkerID  string
+	Status    status.DelugeStatus
+	Scenarios map[string]*ScenarioMetrics
+}
+
+// ScenarioMetrics are the live statistics of a scenario of a job.
+type ScenarioMetrics struct {
+	ActiveUsers int64
+	Iterations  uint64
+	// Stats is nil if the records of the scenario could not be read
+	Stats *reporting.HTTPStats
+}
+
+func (m *inMemoryManager) CollectMetrics() []*JobMetrics {
+	m.mut.Lock()
+	workers := make([]*worker, 0, len(m.workers))
+	for _, jobWorkers := range m.workers {
+		workers = append(workers, jobWorkers...)
+	}
+	m.mut.Unlock()
+
+	metrics := make([]*JobMetrics, 0, len(workers))
+	for _, w := range workers {
+		if jobMetrics := w.collectMetrics(); jobMetrics != nil {
+			metrics = append(metrics, jobMetrics)
+		}
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].JobID != metrics[j].JobID {
+			return metrics[i].JobID < metrics[j].JobID
+		}
+		return metrics[i].WorkerID < metrics[j].WorkerID
+	})
+	return metrics
+}
+
+// collectMetrics returns nil if the worker has not started its job yet, or if the job ended a while ago.
+func (w *worker) collectMetrics() *JobMetrics {
+	dlg := w.getRunningDeluge()
+	if dlg == nil {
+		return nil
+	}
+	if endedAt := w.getEndedAt(); !endedAt.IsZero() && time.Since(endedAt) > metricsRetention {
+		return nil
+	}
+
+	metrics := &JobMetrics{
+		JobID:     w.jobShell.ID,
+		DelugeID:  w.jobShell.DelugeID,
+		WorkerID:  w.ID,
+		Status:    dlg.GetStatus(),
+		Scenarios: make(map[string]*ScenarioMetrics),
+	}
+	for scenarioID, scenario := range dlg.Scenarios {
+		scenarioMetrics := &ScenarioMetrics{
+			ActiveUsers: scenario.GetActiveUserCount(),
+			Iterations:  atomic.LoadUint64(&scenario.EffectiveExecCount),
+		}
+		if stats, err := scenario.GetGlobalStats(); err == nil {
+			scenarioMetrics.Stats = stats
+		} else {
+			w.logger.WithError(err).WithField("scenarioId", scenarioID).Debug("Failed to collect metrics of scenario")
+		}
+		metrics.Scenarios[scenarioID] = scenarioMetrics
+	}
+	return metrics
+}
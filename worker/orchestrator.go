@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"encoding/json"
+	"github.com/ofux/deluge/core/status"
+	"github.com/ofux/deluge/repov2"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OrchestratorInstance is the orchestrator this worker has joined. It is nil when the worker runs on its own.
+var OrchestratorInstance *OrchestratorClient
+
+// Registration is sent by a worker to an orchestrator in order to join it.
+type Registration struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// OrchestratorClient is used by a worker to talk to the orchestrator it has joined.
+type OrchestratorClient struct {
+	url          string
+	registration Registration
+	client       *http.Client
+	logger       *logrus.Entry
+}
+
+func NewOrchestratorClient(orchestratorURL string, registration Registration) *OrchestratorClient {
+	return &OrchestratorClient{
+		url:          strings.TrimSuffix(orchestratorURL, "/"),
+		registration: registration,
+		client:       newHTTPClient(),
+		logger:       logrus.WithField("orchestrator", orchestratorURL).WithField("workerId", registration.ID),
+	}
+}
+
+func (c *OrchestratorClient) GetWorkerID() string {
+	return c.registration.ID
+}
+
+// Register makes the worker join the orchestrator.
+func (c *OrchestratorClient) Register() error {
+	return sendJSON(c.client, http.MethodPost, c.url+"/v1/workers", c.registration, http.StatusCreated)
+}
+
+// Join registers the worker, retrying until the orchestrator accepts it.
+func (c *OrchestratorClient) Join(retryDelay time.Duration) {
+	for {
+		err := c.Register()
+		if err == nil {
+			c.logger.Info("Joined orchestrator")
+			return
+		}
+		c.logger.WithError(err).Errorf("Failed to join orchestrator. Retrying in %s", retryDelay)
+		time.Sleep(retryDelay)
+	}
+}
+
+// PushReport sends a worker report to the orchestrator.
+func (c *OrchestratorClient) PushReport(report *repov2.PersistedWorkerReport) error {
+	return sendJSON(c.client, http.MethodPost, c.url+"/v1/workers/"+c.registration.ID+"/reports", report, http.StatusOK)
+}
+
+// SendHeartbeat tells the orchestrator that the worker is still alive and running the given job.
+// It returns ErrLostAssignment if the orchestrator already considered the worker lost for this job, in which case
+// the job must not go on.
+func (c *OrchestratorClient) SendHeartbeat(jobID string, jobStatus status.DelugeStatus) error {
+	body, err := json.Marshal(&Heartbeat{JobID: jobID, Status: jobStatus})
+	if err != nil {
+		return err
+	}
+	code, resBody, err := sendRequest(c.client, http.MethodPost, c.url+"/v1/workers/"+c.registration.ID+"/heartbeats", contentTypeJSON, body)
+	if err != nil {
+		return err
+	}
+	switch code {
+	case http.StatusOK:
+		return nil
+	case http.StatusGone:
+		return ErrLostAssignment
+	case http.StatusNotFound:
+		// The orchestrator may have been restarted or may have dropped this worker, so it has to join again
+		c.logger.Warn("Orchestrator does not know this worker anymore. Joining it again")
+		if err := c.Register(); err != nil {
+			return errors.Wrap(err, "failed to join orchestrator again")
+		}
+		return nil
+	default:
+		return errors.Errorf("heartbeat: expected code %d but got %d: %s", http.StatusOK, code, resBody)
+	}
+}
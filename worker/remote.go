@@ -0,0 +1,810 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ofux/deluge/cleanhttp"
+	"github.com/ofux/deluge/core"
+	"github.com/ofux/deluge/core/eventlog"
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/ofux/deluge/core/sinks"
+	"github.com/ofux/deluge/core/status"
+	"github.com/ofux/deluge/dsl/object"
+	"github.com/ofux/deluge/repov2"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownAssignment is returned when a remote worker reports about a job it was not given by the orchestrator.
+	ErrUnknownAssignment = errors.New("unknown assignment")
+	// ErrLostAssignment is returned when a remote worker reports about a job it was considered lost for.
+	ErrLostAssignment = errors.New("assignment was considered lost")
+	// ErrUnknownWorker is returned when a remote worker that is not registered sends a heartbeat.
+	ErrUnknownWorker = errors.New("unknown worker")
+	// ErrConcurrencyRejected is returned when a remote worker refuses to change the concurrency of a scenario,
+	// because the scenario is not running or does not run a fixed number of concurrent users.
+	ErrConcurrencyRejected = errors.New("concurrency change rejected by worker")
+)
+
+// RemoteWorker is a worker that has joined the orchestrator.
+type RemoteWorker struct {
+	ID           string    `json:"id"`
+	URL          string    `json:"url"`
+	RegisteredAt time.Time `json:"registeredAt"`
+	LastSeen     time.Time `json:"lastSeen"`
+}
+
+// Heartbeat is regularly sent by a remote worker to the orchestrator while it runs a job.
+type Heartbeat struct {
+	JobID  string              `json:"jobId"`
+	Status status.DelugeStatus `json:"status"`
+}
+
+// assignment is the share of a job given to a remote worker.
+type assignment struct {
+	// ID is the ID of the job on the remote worker
+	ID       string
+	JobID    string
+	WorkerID string
+	Share    core.Share
+	// reportID is the ID under which the reports of this assignment are saved by the orchestrator
+	reportID string
+	// replaced are the lost assignments whose share this assignment took over. Their reports are kept in progress
+	// until this assignment sends its first report, so that the job does not look ended in the meantime.
+	replaced []*assignment
+
+	status   status.DelugeStatus
+	lastSeen time.Time
+	lost     bool
+	// reassigning is true while the share of the lost assignment is being restarted on another worker
+	reassigning bool
+}
+
+// isRunning tells whether the orchestrator still waits for the remote worker to finish this assignment.
+func (a *assignment) isRunning() bool {
+	return !a.lost && !a.status.IsEnd()
+}
+
+func (a *assignment) reportWorkerID() string {
+	return a.reportID
+}
+
+// remoteJobCreation is the body sent to the jobs resource of a remote worker.
+type remoteJobCreation struct {
+	ID       string         `json:"id"`
+	DelugeID string         `json:"delugeId"`
+	Share    *core.Share    `json:"share"`
+	Sinks    []sinks.Config `json:"sinks,omitempty"`
+	EventLog string         `json:"eventLog,omitempty"`
+}
+
+// remoteConcurrencyChange is the body sent to the concurrency resource of a remote worker.
+type remoteConcurrencyChange struct {
+	Concurrent int `json:"concurrent"`
+}
+
+// RemoteManager is a Manager that spreads the concurrent users of each job across all the remote workers
+// that have joined it, and that talks to them over HTTP.
+//
+// While a job is running, remote workers must send heartbeats. A remote worker that stays silent for longer than
+// the heartbeat timeout is considered lost: its report gets the status DelugeWorkerLost, it is removed from the
+// registered workers and, if rebalancing is enabled, its share of the job is restarted on a healthy worker.
+type RemoteManager struct {
+	workers        map[string]*RemoteWorker
+	assignments    map[string]*assignment
+	jobAssignments map[string][]*assignment
+	// jobThresholds are the thresholds of the deluge run by each job, until the job ends
+	jobThresholds map[string]map[string][]*core.Threshold
+	// endedJobs are the jobs whose end has been handled
+	endedJobs map[string]struct{}
+	onJobEnd  JobEndHandler
+	mut       *sync.Mutex
+	// endMut makes the reports that may end a job saved one batch at a time
+	endMut *sync.Mutex
+	client *http.Client
+	// downloadClient has no timeout, as event logs can be large
+	downloadClient *http.Client
+	events         *JobEventBroker
+	logger         *logrus.Entry
+
+	heartbeatTimeout        time.Duration
+	heartbeatCheckFrequency time.Duration
+	rebalance               bool
+}
+
+func NewRemoteManager(heartbeatTimeout time.Duration, rebalance bool) *RemoteManager {
+	return &RemoteManager{
+		workers:        make(map[string]*RemoteWorker),
+		assignments:    make(map[string]*assignment),
+		jobAssignments: make(map[string][]*assignment),
+		jobThresholds:  make(map[string]map[string][]*core.Threshold),
+		endedJobs:      make(map[string]struct{}),
+		mut:            &sync.Mutex{},
+		endMut:         &sync.Mutex{},
+		client:         newHTTPClient(),
+		downloadClient: cleanhttp.DefaultClient(),
+		events:         EventsInstance,
+		logger:         logrus.WithField("component", "orchestrator"),
+
+		heartbeatTimeout:        heartbeatTimeout,
+		heartbeatCheckFrequency: heartbeatTimeout / 4,
+		rebalance:               rebalance,
+	}
+}
+
+// Register adds a remote worker to the ones that will run the next jobs.
+func (m *RemoteManager) Register(registration Registration) *RemoteWorker {
+	now := time.Now()
+	rw := &RemoteWorker{
+		ID:           registration.ID,
+		URL:          strings.TrimSuffix(registration.URL, "/"),
+		RegisteredAt: now,
+		LastSeen:     now,
+	}
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.workers[rw.ID] = rw
+	m.logger.WithField("workerId", rw.ID).Infof("Worker %s joined", rw.URL)
+	return rw
+}
+
+// GetWorkers returns all registered remote workers, sorted by ID.
+func (m *RemoteManager) GetWorkers() []*RemoteWorker {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	all := make([]*RemoteWorker, 0, len(m.workers))
+	for _, rw := range m.workers {
+		all = append(all, rw)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].ID < all[j].ID
+	})
+	return all
+}
+
+func (m *RemoteManager) getWorker(id string) (*RemoteWorker, bool) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	rw, ok := m.workers[id]
+	return rw, ok
+}
+
+// evict forgets the remote worker, which must register again to be given new jobs.
+func (m *RemoteManager) evict(id string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	delete(m.workers, id)
+}
+
+func (m *RemoteManager) getJobAssignments(jobID string) []*assignment {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.jobAssignments[jobID]
+}
+
+// CreateAll gives a share of the job to every registered worker and uploads the deluge and its scenarios to them.
+func (m *RemoteManager) CreateAll(jobShell *JobShell) error {
+	deluge, ok := repov2.Instance.GetDeluge(jobShell.DelugeID)
+	if !ok {
+		return errors.Errorf("deluge with ID '%s' does not exist", jobShell.DelugeID)
+	}
+	scenarios := repov2.Instance.GetDelugeScenarios(deluge.ScenarioIDs)
+	for _, id := range deluge.ScenarioIDs {
+		if _, ok := scenarios[id]; !ok {
+			return errors.Errorf("scenario '%s' is configured but not defined", id)
+		}
+	}
+	compiledDeluge, err := core.CompileDeluge(deluge.Script)
+	if err != nil {
+		return errors.Wrapf(err, "failed to compile deluge %s", deluge.ID)
+	}
+
+	workers := m.GetWorkers()
+	if len(workers) == 0 {
+		return errors.New("no worker has joined the orchestrator")
+	}
+
+	// Idle workers do not send heartbeats, so the ones that died since they joined are only noticed here
+	reachable := make([]*RemoteWorker, 0, len(workers))
+	for _, rw := range workers {
+		if err := m.uploadDeluge(rw, deluge, scenarios); err != nil {
+			if !isUnreachable(err) {
+				return errors.Wrapf(err, "failed to upload deluge %s to worker %s", deluge.ID, rw.ID)
+			}
+			m.logger.WithError(err).WithField("workerId", rw.ID).Error("Worker could not be reached. It is not given new jobs until it joins again.")
+			m.evict(rw.ID)
+			continue
+		}
+		reachable = append(reachable, rw)
+	}
+	if len(reachable) == 0 {
+		return errors.New("none of the workers that joined the orchestrator could be reached")
+	}
+
+	assignments := make([]*assignment, 0, len(reachable))
+	for i, rw := range reachable {
+		assignments = append(assignments, newAssignment(jobShell.ID, rw.ID, core.Share{Index: i, Count: len(reachable)}))
+	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	for _, a := range assignments {
+		m.assignments[a.ID] = a
+	}
+	m.jobAssignments[jobShell.ID] = assignments
+	m.jobThresholds[jobShell.ID] = compiledDeluge.GetThresholds()
+	return nil
+}
+
+// StartAll starts the job on all the workers it was assigned to. If one of them fails to start, the others are
+// interrupted and the job is not assigned anymore.
+func (m *RemoteManager) StartAll(jobShell *JobShell) error {
+	assignments := m.getJobAssignments(jobShell.ID)
+	if len(assignments) == 0 {
+		return errors.Errorf("job %s was not assigned to any worker", jobShell.ID)
+	}
+	for i, a := range assignments {
+		if err := m.startAssignment(a, jobShell); err != nil {
+			for _, started := range assignments[:i] {
+				if err := m.interruptAssignment(started); err != nil {
+					m.logger.WithError(err).WithField("workerId", started.WorkerID).Error("Failed to interrupt worker")
+				}
+			}
+			m.unassign(jobShell.ID)
+			return errors.Wrapf(err, "failed to start job %s on worker %s", jobShell.ID, a.WorkerID)
+		}
+	}
+	go m.watchHeartbeats(jobShell)
+	return nil
+}
+
+// unassign forgets the assignments of the job, so that their workers are not heard from anymore.
+func (m *RemoteManager) unassign(jobID string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	for _, a := range m.jobAssignments[jobID] {
+		delete(m.assignments, a.ID)
+	}
+	delete(m.jobAssignments, jobID)
+	delete(m.jobThresholds, jobID)
+}
+
+// InterruptAll interrupts the job on all the workers it was assigned to.
+func (m *RemoteManager) InterruptAll(jobShellID string) error {
+	var failures []string
+	for _, a := range m.getJobAssignments(jobShellID) {
+		m.mut.Lock()
+		lost := a.lost
+		m.mut.Unlock()
+		if lost {
+			continue
+		}
+		if err := m.interruptAssignment(a); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return errors.Errorf("failed to interrupt job %s: %s", jobShellID, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// SetConcurrency spreads the new number of users of the scenario across the workers that are running the job,
+// the same way the users were spread when the job was created.
+// A share that is restarted after its worker was lost gets the concurrency defined in the deluge again.
+func (m *RemoteManager) SetConcurrency(jobShellID, scenarioID string, concurrent int) error {
+	assignments := m.getJobAssignments(jobShellID)
+	if len(assignments) == 0 {
+		return ErrUnknownJob
+	}
+
+	var firstErr error
+	failures := 0
+	for _, a := range assignments {
+		m.mut.Lock()
+		running := a.isRunning()
+		m.mut.Unlock()
+		if !running {
+			continue
+		}
+		if err := m.setAssignmentConcurrency(a, scenarioID, a.Share.Split(concurrent)); err != nil {
+			m.logger.WithError(err).WithField("workerId", a.WorkerID).Error("Failed to change concurrency on worker")
+			if firstErr == nil {
+				firstErr = err
+			}
+			failures++
+		}
+	}
+	if firstErr != nil {
+		return errors.Wrapf(firstErr, "failed to change concurrency of job %s on %d worker(s)", jobShellID, failures)
+	}
+	return nil
+}
+
+// SaveReport saves the report sent by a remote worker as a report of the orchestrator's job. Reports sent once the
+// assignment has ended, such as a last regular report, are ignored so that they cannot replace the final one.
+func (m *RemoteManager) SaveReport(workerID string, report *repov2.PersistedWorkerReport) error {
+	a, ended, err := m.hearFrom(workerID, report.JobID, report.Status)
+	if err != nil {
+		return err
+	}
+	if ended {
+		return nil
+	}
+
+	report.JobID = a.JobID
+	report.WorkerID = a.reportWorkerID()
+	// The share of the lost workers is running again, so their reports can end
+	replaced := m.takeReplaced(a)
+	reports := append([]*repov2.PersistedWorkerReport{report}, m.lostReports(replaced, status.DelugeWorkerLost)...)
+	if err := m.saveReports(a.JobID, reports); err != nil {
+		m.mut.Lock()
+		a.replaced = append(replaced, a.replaced...)
+		m.mut.Unlock()
+		return err
+	}
+	m.publishReport(report)
+	for _, lost := range replaced {
+		m.events.PublishStatus(lost.JobID, lost.reportWorkerID(), status.DelugeWorkerLost)
+	}
+	if report.Status == status.DelugeAborted {
+		// The other shares of the job must not keep running once a threshold aborted one of them
+		go func() {
+			if err := m.InterruptAll(a.JobID); err != nil {
+				m.logger.WithError(err).WithField("jobId", a.JobID).Error("Failed to interrupt aborted job")
+			}
+		}()
+	}
+	return nil
+}
+
+// publishReport publishes the content of a report of a remote worker to the clients that follow the job.
+func (m *RemoteManager) publishReport(report *repov2.PersistedWorkerReport) {
+	if m.events.HasSubscribers(report.JobID) {
+		httpReporter := &reporting.HTTPReporter{}
+		for scenarioID, scenario := range report.Scenarios {
+			if scenario.Records != nil {
+				records, err := recording.MapPersistedHTTPRecords(scenario.Records)
+				if err != nil {
+					m.logger.WithError(err).WithField("jobId", report.JobID).WithField("scenarioId", scenarioID).Error("Failed to map records of remote worker")
+				} else {
+					snapshot := recording.NewHTTPRecordsOverTimeSnapshot(records)
+					m.events.PublishRecords(report.JobID, report.WorkerID, scenarioID, httpReporter.ReportSnapshot(snapshot))
+				}
+			}
+			if len(scenario.Errors) > 0 {
+				errs := make([]*object.Error, 0, len(scenario.Errors))
+				for _, err := range scenario.Errors {
+					errs = append(errs, err.Error)
+				}
+				m.events.PublishErrors(report.JobID, report.WorkerID, scenarioID, errs)
+			}
+		}
+	}
+	m.events.PublishStatus(report.JobID, report.WorkerID, report.Status)
+}
+
+// Heartbeat takes into account a heartbeat sent by a remote worker.
+func (m *RemoteManager) Heartbeat(workerID string, heartbeat Heartbeat) error {
+	a, ended, err := m.hearFrom(workerID, heartbeat.JobID, heartbeat.Status)
+	if err == ErrUnknownAssignment {
+		if _, ok := m.getWorker(workerID); !ok {
+			return ErrUnknownWorker
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !ended {
+		m.events.PublishStatus(a.JobID, a.reportWorkerID(), heartbeat.Status)
+	}
+	return nil
+}
+
+// hearFrom updates the liveness and the status of the assignment the remote worker talks about. It tells whether the
+// assignment had already ended, in which case its status is left unchanged: regular reports and heartbeats carry
+// older statuses than the final report, and must not make the assignment run again.
+func (m *RemoteManager) hearFrom(workerID, assignmentID string, newStatus status.DelugeStatus) (*assignment, bool, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	a, ok := m.assignments[assignmentID]
+	if !ok || a.WorkerID != workerID {
+		return nil, false, ErrUnknownAssignment
+	}
+	if a.lost {
+		return nil, false, ErrLostAssignment
+	}
+	now := time.Now()
+	a.lastSeen = now
+	if rw, ok := m.workers[workerID]; ok {
+		rw.LastSeen = now
+	}
+	if a.status.IsEnd() {
+		return a, true, nil
+	}
+	a.status = status.MergeDelugeStatuses(a.status, newStatus)
+	return a, false, nil
+}
+
+// watchHeartbeats regularly looks for the remote workers of the job that stopped sending heartbeats,
+// until all of them are done.
+func (m *RemoteManager) watchHeartbeats(jobShell *JobShell) {
+	ticker := time.NewTicker(m.heartbeatCheckFrequency)
+	defer ticker.Stop()
+	for range ticker.C {
+		lost, running := m.findLostAssignments(jobShell.ID)
+		for _, a := range lost {
+			m.handleLostAssignment(jobShell, a)
+		}
+		if !running && len(lost) == 0 {
+			return
+		}
+	}
+}
+
+// findLostAssignments marks as lost the running assignments of the job whose worker has been silent for too long.
+// It also tells whether some assignments of the job are still running.
+func (m *RemoteManager) findLostAssignments(jobID string) (lost []*assignment, running bool) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	for _, a := range m.jobAssignments[jobID] {
+		if !a.isRunning() {
+			continue
+		}
+		if time.Since(a.lastSeen) > m.heartbeatTimeout {
+			a.lost = true
+			a.reassigning = m.rebalance
+			// The worker won't be given new jobs unless it registers again
+			delete(m.workers, a.WorkerID)
+			lost = append(lost, a)
+		} else {
+			running = true
+		}
+	}
+	return lost, running
+}
+
+// handleLostAssignment gives the report of a lost assignment the status DelugeWorkerLost. With rebalancing, the share
+// of the assignment is restarted first, and its report only ends once the new assignment reports.
+func (m *RemoteManager) handleLostAssignment(jobShell *JobShell, a *assignment) {
+	logger := m.logger.WithField("workerId", a.WorkerID).WithField("jobId", a.JobID)
+	logger.Errorf("Worker stopped sending heartbeats for more than %s. It is considered lost.", m.heartbeatTimeout)
+
+	// The lost assignment may itself have taken over the share of lost workers before it could report
+	pending := append([]*assignment{a}, m.takeReplaced(a)...)
+	if m.rebalance {
+		m.saveLostReport(a, status.DelugeInProgress)
+		err := m.reassign(jobShell, a, pending)
+		m.mut.Lock()
+		a.reassigning = false
+		m.mut.Unlock()
+		if err == nil {
+			return
+		}
+		logger.WithError(err).Error("Failed to restart the share of the lost worker")
+	}
+	if err := m.saveReports(a.JobID, m.lostReports(pending, status.DelugeWorkerLost)); err != nil {
+		logger.WithError(err).Error("Failed to save report of lost worker")
+	}
+	for _, lost := range pending {
+		m.events.PublishStatus(lost.JobID, lost.reportWorkerID(), status.DelugeWorkerLost)
+	}
+}
+
+// OnJobEnd sets the function called once all the workers of a job have saved their final report.
+func (m *RemoteManager) OnJobEnd(handler JobEndHandler) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.onJobEnd = handler
+}
+
+// saveReports saves reports of the assignments of the job, and stops at the first that fails. Reports that may end the
+// job are saved one batch at a time: if they end it, the results of the thresholds of the job are saved first, with the
+// records of all the reports, so that the job is never seen ended without them. The end of the job is handled once the
+// reports are saved.
+func (m *RemoteManager) saveReports(jobID string, reports []*repov2.PersistedWorkerReport) error {
+	if !hasEndedReport(reports) {
+		return saveWorkerReports(reports)
+	}
+
+	m.endMut.Lock()
+	all := replaceReports(repov2.Instance.GetJobWorkerReports(jobID), reports...)
+	thresholds, handler, ended := m.endJob(jobID, all)
+	if ended {
+		if err := saveThresholdResults(repov2.Instance, jobID, thresholds, all); err != nil {
+			m.logger.WithError(err).WithField("jobId", jobID).Error("Failed to save results of thresholds")
+		}
+	}
+	err := saveWorkerReports(reports)
+	m.endMut.Unlock()
+
+	if ended && err == nil && handler != nil {
+		handler(jobID)
+	}
+	return err
+}
+
+// endJob marks the job as ended if all its reports have ended and none of its assignments is running or being
+// reassigned anymore. If so, it returns the thresholds of the job and the job end handler.
+func (m *RemoteManager) endJob(jobID string, reports []*repov2.PersistedWorkerReport) (map[string][]*core.Threshold, JobEndHandler, bool) {
+	if !allReportsEnded(reports) {
+		return nil, nil, false
+	}
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	assignments := m.jobAssignments[jobID]
+	if _, ok := m.endedJobs[jobID]; ok || len(assignments) == 0 {
+		return nil, nil, false
+	}
+	for _, a := range assignments {
+		if a.isRunning() || a.reassigning {
+			return nil, nil, false
+		}
+	}
+	m.endedJobs[jobID] = struct{}{}
+	thresholds := m.jobThresholds[jobID]
+	delete(m.jobThresholds, jobID)
+	return thresholds, m.onJobEnd, true
+}
+
+func hasEndedReport(reports []*repov2.PersistedWorkerReport) bool {
+	for _, wr := range reports {
+		if wr.Status.IsEnd() {
+			return true
+		}
+	}
+	return false
+}
+
+func allReportsEnded(reports []*repov2.PersistedWorkerReport) bool {
+	for _, wr := range reports {
+		if !wr.Status.IsEnd() {
+			return false
+		}
+	}
+	return true
+}
+
+func saveWorkerReports(reports []*repov2.PersistedWorkerReport) error {
+	for _, wr := range reports {
+		if err := repov2.Instance.SaveWorkerReport(wr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lostReports returns the reports of lost assignments with the given status. The records the workers sent before they
+// were lost are kept, under the reportID of the assignments, so that they are merged with the records of the others.
+func (m *RemoteManager) lostReports(lost []*assignment, lostStatus status.DelugeStatus) []*repov2.PersistedWorkerReport {
+	if len(lost) == 0 {
+		return nil
+	}
+	saved := repov2.Instance.GetJobWorkerReports(lost[0].JobID)
+	reports := make([]*repov2.PersistedWorkerReport, 0, len(lost))
+	for _, a := range lost {
+		report := &repov2.PersistedWorkerReport{
+			WorkerID:  a.reportWorkerID(),
+			JobID:     a.JobID,
+			Status:    lostStatus,
+			Scenarios: make(map[string]*repov2.PersistedWorkerScenarioReport),
+		}
+		for _, wr := range saved {
+			if wr.WorkerID == report.WorkerID {
+				report.Scenarios = wr.Scenarios
+			}
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// saveLostReport saves the report of a lost assignment with the given status.
+func (m *RemoteManager) saveLostReport(a *assignment, lostStatus status.DelugeStatus) {
+	if err := m.saveReports(a.JobID, m.lostReports([]*assignment{a}, lostStatus)); err != nil {
+		m.logger.WithError(err).WithField("workerId", a.WorkerID).WithField("jobId", a.JobID).Error("Failed to save report of lost worker")
+	}
+	m.events.PublishStatus(a.JobID, a.reportWorkerID(), lostStatus)
+}
+
+// takeReplaced returns the lost assignments whose share the assignment took over, and forgets them.
+func (m *RemoteManager) takeReplaced(a *assignment) []*assignment {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	replaced := a.replaced
+	a.replaced = nil
+	return replaced
+}
+
+// reassign restarts the share of a lost assignment on the healthy worker that runs the fewest shares of the job.
+// The new assignment ends the reports of the given lost assignments once it reports.
+func (m *RemoteManager) reassign(jobShell *JobShell, lost *assignment, replaced []*assignment) error {
+	deluge, ok := repov2.Instance.GetDeluge(jobShell.DelugeID)
+	if !ok {
+		return errors.Errorf("deluge with ID '%s' does not exist", jobShell.DelugeID)
+	}
+	scenarios := repov2.Instance.GetDelugeScenarios(deluge.ScenarioIDs)
+
+	for _, rw := range m.getWorkersByJobLoad(lost.JobID) {
+		if err := m.uploadDeluge(rw, deluge, scenarios); err != nil {
+			m.logger.WithError(err).WithField("workerId", rw.ID).Warn("Failed to upload deluge to worker")
+			continue
+		}
+		a := newAssignment(lost.JobID, rw.ID, lost.Share)
+		a.replaced = replaced
+		m.mut.Lock()
+		m.assignments[a.ID] = a
+		m.jobAssignments[a.JobID] = append(m.jobAssignments[a.JobID], a)
+		m.mut.Unlock()
+		if err := m.startAssignment(a, jobShell); err != nil {
+			m.logger.WithError(err).WithField("workerId", rw.ID).Warn("Failed to start job on worker")
+			m.mut.Lock()
+			a.lost = true
+			a.replaced = nil
+			m.mut.Unlock()
+			continue
+		}
+		m.logger.WithField("workerId", rw.ID).WithField("jobId", a.JobID).Infof("Restarted share %d/%d of lost worker %s", lost.Share.Index+1, lost.Share.Count, lost.WorkerID)
+		return nil
+	}
+	return errors.New("no healthy worker could take over")
+}
+
+// getWorkersByJobLoad returns the registered workers sorted by the number of shares of the job they are running.
+func (m *RemoteManager) getWorkersByJobLoad(jobID string) []*RemoteWorker {
+	workers := m.GetWorkers()
+	m.mut.Lock()
+	load := make(map[string]int)
+	for _, a := range m.jobAssignments[jobID] {
+		if a.isRunning() {
+			load[a.WorkerID]++
+		}
+	}
+	m.mut.Unlock()
+	sort.SliceStable(workers, func(i, j int) bool {
+		return load[workers[i].ID] < load[workers[j].ID]
+	})
+	return workers
+}
+
+func newAssignment(jobID, workerID string, share core.Share) *assignment {
+	return &assignment{
+		ID:       uuid.NewV4().String(),
+		JobID:    jobID,
+		WorkerID: workerID,
+		Share:    share,
+		reportID: fmt.Sprintf("%s#%d", workerID, share.Index),
+		status:   status.DelugeVirgin,
+		lastSeen: time.Now(),
+	}
+}
+
+func (m *RemoteManager) startAssignment(a *assignment, jobShell *JobShell) error {
+	rw, ok := m.getWorker(a.WorkerID)
+	if !ok {
+		return errors.Errorf("worker %s is not registered", a.WorkerID)
+	}
+	share := a.Share
+	return sendJSON(m.client, http.MethodPost, rw.URL+"/v1/jobs", &remoteJobCreation{
+		ID:       a.ID,
+		DelugeID: jobShell.DelugeID,
+		Share:    &share,
+		Sinks:    jobShell.Sinks,
+		EventLog: jobShell.EventLog,
+	}, http.StatusAccepted)
+}
+
+// OpenEventLogs downloads the event logs of the job from the workers it was assigned to. The event logs of the workers
+// that are no longer registered are left out.
+func (m *RemoteManager) OpenEventLogs(jobShellID, format string) ([]io.ReadCloser, error) {
+	var readers []io.ReadCloser
+	for _, a := range m.getJobAssignments(jobShellID) {
+		rw, ok := m.getWorker(a.WorkerID)
+		if !ok {
+			m.logger.WithField("workerId", a.WorkerID).Warn("Event log of unregistered worker left out")
+			continue
+		}
+		url := rw.URL + "/v1/jobs/" + a.ID + "/events"
+		res, err := m.downloadClient.Get(url)
+		if err != nil {
+			eventlog.CloseAll(readers)
+			return nil, err
+		}
+		switch res.StatusCode {
+		case http.StatusOK:
+			readers = append(readers, res.Body)
+		case http.StatusNotFound:
+			// The worker has not started the job yet
+			res.Body.Close()
+		default:
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			eventlog.CloseAll(readers)
+			return nil, errors.Errorf("GET %s: unexpected status code %d: %s", url, res.StatusCode, body)
+		}
+	}
+	return readers, nil
+}
+
+func (m *RemoteManager) interruptAssignment(a *assignment) error {
+	rw, ok := m.getWorker(a.WorkerID)
+	if !ok {
+		return errors.Errorf("worker %s is not registered", a.WorkerID)
+	}
+	url := rw.URL + "/v1/jobs/interrupt/" + a.ID
+	code, body, err := sendRequest(m.client, http.MethodPut, url, "", nil)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusAccepted {
+		return errors.Errorf("PUT %s: expected code %d but got %d: %s", url, http.StatusAccepted, code, body)
+	}
+	return nil
+}
+
+func (m *RemoteManager) setAssignmentConcurrency(a *assignment, scenarioID string, concurrent int) error {
+	rw, ok := m.getWorker(a.WorkerID)
+	if !ok {
+		return errors.Errorf("worker %s is not registered", a.WorkerID)
+	}
+	body, err := json.Marshal(&remoteConcurrencyChange{Concurrent: concurrent})
+	if err != nil {
+		return err
+	}
+	url := rw.URL + "/v1/jobs/" + a.ID + "/scenarios/" + scenarioID + "/concurrency"
+	code, resBody, err := sendRequest(m.client, http.MethodPut, url, contentTypeJSON, body)
+	if err != nil {
+		return err
+	}
+	switch code {
+	case http.StatusAccepted:
+		return nil
+	case http.StatusNotFound:
+		return errors.Wrapf(core.ErrUnknownScenario, "PUT %s: %s", url, resBody)
+	case http.StatusConflict:
+		return errors.Wrapf(ErrConcurrencyRejected, "PUT %s: %s", url, resBody)
+	default:
+		return errors.Errorf("PUT %s: expected code %d but got %d: %s", url, http.StatusAccepted, code, resBody)
+	}
+}
+
+func (m *RemoteManager) uploadDeluge(rw *RemoteWorker, deluge *repov2.PersistedDeluge, scenarios map[string]*repov2.PersistedScenario) error {
+	for id, scenario := range scenarios {
+		if err := m.uploadScript(rw.URL+"/v1/scenarios", id, scenario.Script); err != nil {
+			return errors.Wrapf(err, "failed to upload scenario %s", id)
+		}
+	}
+	return m.uploadScript(rw.URL+"/v1/deluges", deluge.ID, deluge.Script)
+}
+
+// uploadScript creates the resource on the remote worker, or updates it if it already exists.
+func (m *RemoteManager) uploadScript(resourceURL, id, script string) error {
+	code, body, err := sendRequest(m.client, http.MethodPost, resourceURL, contentTypeText, []byte(script))
+	if err != nil {
+		return err
+	}
+	if code == http.StatusConflict {
+		code, body, err = sendRequest(m.client, http.MethodPut, resourceURL+"/"+id, contentTypeText, []byte(script))
+		if err != nil {
+			return err
+		}
+		if code != http.StatusOK {
+			return errors.Errorf("expected code %d but got %d: %s", http.StatusOK, code, body)
+		}
+		return nil
+	}
+	if code != http.StatusCreated {
+		return errors.Errorf("expected code %d but got %d: %s", http.StatusCreated, code, body)
+	}
+	return nil
+}
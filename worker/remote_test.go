@@ -0,0 +1,627 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ofux/deluge/core/eventlog"
+	"github.com/ofux/deluge/core/sinks"
+	"github.com/ofux/deluge/core/status"
+	"github.com/ofux/deluge/repov2"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRemoteWorker records the requests an orchestrator sends to a worker
+type fakeRemoteWorker struct {
+	*httptest.Server
+	mut         *sync.Mutex
+	scenarios   map[string]string
+	deluges     map[string]string
+	jobs        []*remoteJobCreation
+	interrupted []string
+	failJobs    bool
+	// concurrencies are the concurrencies received per URL path
+	concurrencies     map[string]int
+	rejectConcurrency bool
+	// events is the event log sent by the worker, or empty if it has none
+	events string
+}
+
+func newFakeRemoteWorker(t *testing.T) *fakeRemoteWorker {
+	fw := &fakeRemoteWorker{
+		mut:           &sync.Mutex{},
+		scenarios:     make(map[string]string),
+		deluges:       make(map[string]string),
+		concurrencies: make(map[string]int),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/scenarios", func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		fw.mut.Lock()
+		defer fw.mut.Unlock()
+		fw.scenarios[string(body)] = r.Method
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/v1/deluges", func(w http.ResponseWriter, r *http.Request) {
+		// Deluge always exists already so that the orchestrator has to update it
+		w.WriteHeader(http.StatusConflict)
+	})
+	mux.HandleFunc("/v1/deluges/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.MethodPut, r.Method)
+		fw.mut.Lock()
+		defer fw.mut.Unlock()
+		fw.deluges[r.URL.Path] = string(body)
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v1/jobs", func(w http.ResponseWriter, r *http.Request) {
+		if fw.failJobs {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		job := &remoteJobCreation{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(job))
+		fw.mut.Lock()
+		defer fw.mut.Unlock()
+		fw.jobs = append(fw.jobs, job)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/v1/jobs/interrupt/", func(w http.ResponseWriter, r *http.Request) {
+		fw.mut.Lock()
+		defer fw.mut.Unlock()
+		fw.interrupted = append(fw.interrupted, r.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/v1/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/events") {
+			if fw.events == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(fw.events))
+			return
+		}
+		assert.Equal(t, http.MethodPut, r.Method)
+		if fw.rejectConcurrency {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		change := &remoteConcurrencyChange{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(change))
+		fw.mut.Lock()
+		defer fw.mut.Unlock()
+		fw.concurrencies[r.URL.Path] = change.Concurrent
+		w.WriteHeader(http.StatusAccepted)
+	})
+	fw.Server = httptest.NewServer(mux)
+	return fw
+}
+
+// jobEndRecorder records the jobs whose end is handled by a manager.
+type jobEndRecorder struct {
+	mut  sync.Mutex
+	jobs []string
+}
+
+func (r *jobEndRecorder) handle(jobShellID string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.jobs = append(r.jobs, jobShellID)
+}
+
+func (r *jobEndRecorder) get() []string {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.jobs
+}
+
+func TestRemoteManager(t *testing.T) {
+	const delugeScript = `
+		deluge("deluge-id", "Some name", "2s", {
+			"scenario-id": {
+				"concurrent": 5,
+				"delay": "400ms"
+			}
+		});`
+	const scenarioScript = `
+		scenario("scenario-id", "My scenario", function () {
+		});`
+
+	t.Run("Spread job across workers", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		saveScenario(t, scenarioScript)
+		saveDeluge(t, delugeScript)
+
+		fw1 := newFakeRemoteWorker(t)
+		defer fw1.Close()
+		fw2 := newFakeRemoteWorker(t)
+		defer fw2.Close()
+
+		m := NewRemoteManager(30*time.Second, false)
+		m.Register(Registration{ID: "w1", URL: fw1.URL + "/"})
+		m.Register(Registration{ID: "w2", URL: fw2.URL})
+		require.Len(t, m.GetWorkers(), 2)
+
+		jobSinks := []sinks.Config{{Type: sinks.TypeStatsD, Address: "localhost:8125"}}
+		jobShell := &JobShell{ID: "job-id", DelugeID: "deluge-id", Sinks: jobSinks}
+		require.NoError(t, m.CreateAll(jobShell))
+		require.NoError(t, m.StartAll(jobShell))
+
+		for i, fw := range []*fakeRemoteWorker{fw1, fw2} {
+			assert.Equal(t, http.MethodPost, fw.scenarios[scenarioScript])
+			assert.Equal(t, delugeScript, fw.deluges["/v1/deluges/deluge-id"])
+			require.Len(t, fw.jobs, 1)
+			assert.Equal(t, "deluge-id", fw.jobs[0].DelugeID)
+			assert.Equal(t, jobSinks, fw.jobs[0].Sinks)
+			assert.NotEqual(t, "job-id", fw.jobs[0].ID)
+			require.NotNil(t, fw.jobs[0].Share)
+			assert.Equal(t, i, fw.jobs[0].Share.Index)
+			assert.Equal(t, 2, fw.jobs[0].Share.Count)
+		}
+		assert.NotEqual(t, fw1.jobs[0].ID, fw2.jobs[0].ID)
+
+		// Reports sent by workers are saved under the orchestrator's job
+		err := m.SaveReport("w2", &repov2.PersistedWorkerReport{
+			WorkerID: "local-worker-id",
+			JobID:    fw2.jobs[0].ID,
+			Status:   status.DelugeInProgress,
+		})
+		require.NoError(t, err)
+		reports := repov2.Instance.GetJobWorkerReports("job-id")
+		require.Len(t, reports, 1)
+		assert.Equal(t, "w2#1", reports[0].WorkerID)
+		assert.Equal(t, status.DelugeInProgress, reports[0].Status)
+
+		// Reports must come from the worker the job was assigned to
+		err = m.SaveReport("w1", &repov2.PersistedWorkerReport{
+			JobID: fw2.jobs[0].ID,
+		})
+		assert.Equal(t, ErrUnknownAssignment, err)
+		err = m.SaveReport("w1", &repov2.PersistedWorkerReport{
+			JobID: "unknown",
+		})
+		assert.Equal(t, ErrUnknownAssignment, err)
+
+		require.NoError(t, m.InterruptAll("job-id"))
+		assert.Equal(t, []string{"/v1/jobs/interrupt/" + fw1.jobs[0].ID}, fw1.interrupted)
+		assert.Equal(t, []string{"/v1/jobs/interrupt/" + fw2.jobs[0].ID}, fw2.interrupted)
+	})
+
+	t.Run("Detect lost worker and rebalance its share", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		saveScenario(t, scenarioScript)
+		saveDeluge(t, delugeScript)
+
+		fw1 := newFakeRemoteWorker(t)
+		defer fw1.Close()
+		fw2 := newFakeRemoteWorker(t)
+		defer fw2.Close()
+
+		m := NewRemoteManager(200*time.Millisecond, true)
+		m.Register(Registration{ID: "w1", URL: fw1.URL})
+		m.Register(Registration{ID: "w2", URL: fw2.URL})
+		endedJobs := &jobEndRecorder{}
+		m.OnJobEnd(endedJobs.handle)
+
+		jobShell := &JobShell{ID: "job-id", DelugeID: "deluge-id"}
+		require.NoError(t, m.CreateAll(jobShell))
+		require.NoError(t, m.StartAll(jobShell))
+		require.Len(t, fw2.jobs, 1)
+		lostJobID := fw2.jobs[0].ID
+		lostScenarios := map[string]*repov2.PersistedWorkerScenarioReport{"scenario-id": {Status: status.ScenarioInProgress}}
+		require.NoError(t, m.SaveReport("w2", &repov2.PersistedWorkerReport{
+			JobID:     lostJobID,
+			Status:    status.DelugeInProgress,
+			Scenarios: lostScenarios,
+		}))
+
+		// Only w1 sends heartbeats
+		for i := 0; i < 8; i++ {
+			time.Sleep(50 * time.Millisecond)
+			fw1.mut.Lock()
+			jobs := fw1.jobs
+			fw1.mut.Unlock()
+			for _, job := range jobs {
+				require.NoError(t, m.Heartbeat("w1", Heartbeat{JobID: job.ID, Status: status.DelugeInProgress}))
+			}
+		}
+
+		// w2 is not used anymore
+		workers := m.GetWorkers()
+		require.Len(t, workers, 1)
+		assert.Equal(t, "w1", workers[0].ID)
+		assert.Equal(t, ErrLostAssignment, m.Heartbeat("w2", Heartbeat{JobID: lostJobID, Status: status.DelugeInProgress}))
+		assert.Equal(t, ErrLostAssignment, m.SaveReport("w2", &repov2.PersistedWorkerReport{JobID: lostJobID}))
+		assert.Equal(t, ErrUnknownWorker, m.Heartbeat("w2", Heartbeat{JobID: "unknown"}))
+
+		// The report of w2 does not end the job until its share runs again
+		reports := repov2.Instance.GetJobWorkerReports("job-id")
+		require.Len(t, reports, 1)
+		assert.Equal(t, "w2#1", reports[0].WorkerID)
+		assert.Equal(t, status.DelugeInProgress, reports[0].Status)
+		assert.Equal(t, lostScenarios, reports[0].Scenarios)
+
+		// The share of w2 has been restarted on w1
+		fw1.mut.Lock()
+		require.Len(t, fw1.jobs, 2)
+		assert.Equal(t, 1, fw1.jobs[1].Share.Index)
+		assert.Equal(t, 2, fw1.jobs[1].Share.Count)
+		jobs := fw1.jobs
+		fw1.mut.Unlock()
+
+		// Reports of the new worker are saved next to the report of the lost one, which ends with its records
+		require.NoError(t, m.SaveReport("w1", &repov2.PersistedWorkerReport{
+			JobID:  jobs[1].ID,
+			Status: status.DelugeInProgress,
+		}))
+		reports = repov2.Instance.GetJobWorkerReports("job-id")
+		require.Len(t, reports, 2)
+		sort.Slice(reports, func(i, j int) bool {
+			return reports[i].WorkerID < reports[j].WorkerID
+		})
+		assert.Equal(t, "w1#1", reports[0].WorkerID)
+		assert.Equal(t, status.DelugeInProgress, reports[0].Status)
+		assert.Equal(t, "w2#1", reports[1].WorkerID)
+		assert.Equal(t, status.DelugeWorkerLost, reports[1].Status)
+		assert.Equal(t, lostScenarios, reports[1].Scenarios)
+
+		// Lost workers are not interrupted
+		require.NoError(t, m.InterruptAll("job-id"))
+		assert.Empty(t, fw2.interrupted)
+		assert.Len(t, fw1.interrupted, 2)
+
+		// The job ends with the final reports of the first worker and of the one that took over the lost share
+		for i, job := range jobs {
+			assert.Empty(t, endedJobs.get())
+			require.NoError(t, m.SaveReport("w1", &repov2.PersistedWorkerReport{
+				JobID:  job.ID,
+				Status: status.DelugeInterrupted,
+			}), "report %d", i)
+		}
+		assert.Equal(t, []string{"job-id"}, endedJobs.get())
+	})
+
+	t.Run("Detect lost worker without rebalancing", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		saveScenario(t, scenarioScript)
+		saveDeluge(t, delugeScript)
+
+		fw1 := newFakeRemoteWorker(t)
+		defer fw1.Close()
+
+		m := NewRemoteManager(100*time.Millisecond, false)
+		m.Register(Registration{ID: "w1", URL: fw1.URL})
+		endedJobs := &jobEndRecorder{}
+		m.OnJobEnd(endedJobs.handle)
+
+		jobShell := &JobShell{ID: "job-id", DelugeID: "deluge-id"}
+		require.NoError(t, m.CreateAll(jobShell))
+		require.NoError(t, m.StartAll(jobShell))
+
+		time.Sleep(300 * time.Millisecond)
+
+		assert.Empty(t, m.GetWorkers())
+		assert.Equal(t, []string{"job-id"}, endedJobs.get(), "the job ends with its last worker")
+		reports := repov2.Instance.GetJobWorkerReports("job-id")
+		require.Len(t, reports, 1)
+		assert.Equal(t, status.DelugeWorkerLost, reports[0].Status)
+		assert.Len(t, fw1.jobs, 1)
+	})
+
+	t.Run("Do not consider a worker that finished its job as lost", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		saveScenario(t, scenarioScript)
+		saveDeluge(t, delugeScript)
+
+		fw1 := newFakeRemoteWorker(t)
+		defer fw1.Close()
+
+		m := NewRemoteManager(100*time.Millisecond, false)
+		m.Register(Registration{ID: "w1", URL: fw1.URL})
+		endedJobs := &jobEndRecorder{}
+		m.OnJobEnd(endedJobs.handle)
+
+		jobShell := &JobShell{ID: "job-id", DelugeID: "deluge-id"}
+		require.NoError(t, m.CreateAll(jobShell))
+		require.NoError(t, m.StartAll(jobShell))
+		require.Len(t, fw1.jobs, 1)
+
+		require.NoError(t, m.SaveReport("w1", &repov2.PersistedWorkerReport{
+			JobID:  fw1.jobs[0].ID,
+			Status: status.DelugeDoneSuccess,
+		}))
+		assert.Equal(t, []string{"job-id"}, endedJobs.get())
+		// A last regular report, without status, is sent after the final one
+		require.NoError(t, m.SaveReport("w1", &repov2.PersistedWorkerReport{
+			JobID: fw1.jobs[0].ID,
+		}))
+
+		// The worker goes quiet for longer than the heartbeat timeout
+		time.Sleep(300 * time.Millisecond)
+
+		assert.Len(t, m.GetWorkers(), 1)
+		reports := repov2.Instance.GetJobWorkerReports("job-id")
+		require.Len(t, reports, 1)
+		assert.Equal(t, status.DelugeDoneSuccess, reports[0].Status)
+		assert.Equal(t, []string{"job-id"}, endedJobs.get(), "the end of the job must be handled once")
+	})
+
+	t.Run("Save results of thresholds right before the job ends", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		saveScenario(t, scenarioScript)
+		saveDeluge(t, `
+		deluge("deluge-id", "Some name", "2s", {
+			"scenario-id": {
+				"concurrent": 5,
+				"delay": "400ms",
+				"thresholds": {
+					"*": {"errorRate": 0.1}
+				}
+			}
+		});`)
+		require.NoError(t, repov2.Instance.SaveJobShell(&repov2.PersistedJobShell{ID: "job-id", DelugeID: "deluge-id"}))
+
+		fw1 := newFakeRemoteWorker(t)
+		defer fw1.Close()
+		fw2 := newFakeRemoteWorker(t)
+		defer fw2.Close()
+
+		m := NewRemoteManager(30*time.Second, false)
+		m.Register(Registration{ID: "w1", URL: fw1.URL})
+		m.Register(Registration{ID: "w2", URL: fw2.URL})
+		endedJobs := &jobEndRecorder{}
+		m.OnJobEnd(endedJobs.handle)
+
+		jobShell := &JobShell{ID: "job-id", DelugeID: "deluge-id"}
+		require.NoError(t, m.CreateAll(jobShell))
+		require.NoError(t, m.StartAll(jobShell))
+		// The thresholds of the deluge that was sent to the workers are evaluated
+		saveDeluge(t, delugeScript)
+
+		require.NoError(t, m.SaveReport("w1", &repov2.PersistedWorkerReport{
+			JobID:  fw1.jobs[0].ID,
+			Status: status.DelugeDoneSuccess,
+		}))
+		savedJobShell, ok := repov2.Instance.GetJobShell("job-id")
+		require.True(t, ok)
+		assert.Nil(t, savedJobShell.Thresholds, "thresholds must not be evaluated before the job ends")
+		assert.Empty(t, endedJobs.get())
+
+		require.NoError(t, m.SaveReport("w2", &repov2.PersistedWorkerReport{
+			JobID:  fw2.jobs[0].ID,
+			Status: status.DelugeDoneSuccess,
+		}))
+		savedJobShell, ok = repov2.Instance.GetJobShell("job-id")
+		require.True(t, ok)
+		require.NotNil(t, savedJobShell.Thresholds)
+		assert.False(t, savedJobShell.Thresholds.Passed, "thresholds of a scenario without records fail")
+		assert.Len(t, savedJobShell.Thresholds.PerScenario["scenario-id"], 1)
+		assert.Equal(t, []string{"job-id"}, endedJobs.get())
+	})
+
+	t.Run("Create job without any worker", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		saveScenario(t, scenarioScript)
+		saveDeluge(t, delugeScript)
+
+		m := NewRemoteManager(30*time.Second, false)
+		err := m.CreateAll(&JobShell{ID: "job-id", DelugeID: "deluge-id"})
+		assert.EqualError(t, err, "no worker has joined the orchestrator")
+	})
+
+	t.Run("Leave out unreachable workers when creating a job", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		saveScenario(t, scenarioScript)
+		saveDeluge(t, delugeScript)
+
+		fw1 := newFakeRemoteWorker(t)
+		defer fw1.Close()
+		// The second worker died while it was idle, so it never missed a heartbeat
+		fw2 := newFakeRemoteWorker(t)
+		fw2.Close()
+
+		m := NewRemoteManager(30*time.Second, false)
+		m.Register(Registration{ID: "w1", URL: fw1.URL})
+		m.Register(Registration{ID: "w2", URL: fw2.URL})
+
+		jobShell := &JobShell{ID: "job-id", DelugeID: "deluge-id"}
+		require.NoError(t, m.CreateAll(jobShell))
+		require.NoError(t, m.StartAll(jobShell))
+
+		require.Len(t, fw1.jobs, 1)
+		assert.Equal(t, 0, fw1.jobs[0].Share.Index)
+		assert.Equal(t, 1, fw1.jobs[0].Share.Count)
+		workers := m.GetWorkers()
+		require.Len(t, workers, 1)
+		assert.Equal(t, "w1", workers[0].ID)
+	})
+
+	t.Run("Create job with unreachable workers only", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		saveScenario(t, scenarioScript)
+		saveDeluge(t, delugeScript)
+
+		fw1 := newFakeRemoteWorker(t)
+		fw1.Close()
+
+		m := NewRemoteManager(30*time.Second, false)
+		m.Register(Registration{ID: "w1", URL: fw1.URL})
+
+		err := m.CreateAll(&JobShell{ID: "job-id", DelugeID: "deluge-id"})
+		assert.EqualError(t, err, "none of the workers that joined the orchestrator could be reached")
+		assert.Empty(t, m.GetWorkers())
+	})
+
+	t.Run("Keep workers that reject the deluge", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		saveScenario(t, scenarioScript)
+		saveDeluge(t, delugeScript)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer srv.Close()
+
+		m := NewRemoteManager(30*time.Second, false)
+		m.Register(Registration{ID: "w1", URL: srv.URL})
+
+		err := m.CreateAll(&JobShell{ID: "job-id", DelugeID: "deluge-id"})
+		assert.Error(t, err)
+		assert.Len(t, m.GetWorkers(), 1)
+	})
+
+	t.Run("Create job with unknown deluge", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+
+		m := NewRemoteManager(30*time.Second, false)
+		m.Register(Registration{ID: "w1", URL: "http://localhost"})
+		err := m.CreateAll(&JobShell{ID: "job-id", DelugeID: "deluge-id"})
+		assert.EqualError(t, err, "deluge with ID 'deluge-id' does not exist")
+	})
+
+	t.Run("Spread concurrency change across workers", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		saveScenario(t, scenarioScript)
+		saveDeluge(t, delugeScript)
+
+		fw1 := newFakeRemoteWorker(t)
+		defer fw1.Close()
+		fw2 := newFakeRemoteWorker(t)
+		defer fw2.Close()
+
+		m := NewRemoteManager(30*time.Second, false)
+		m.Register(Registration{ID: "w1", URL: fw1.URL})
+		m.Register(Registration{ID: "w2", URL: fw2.URL})
+
+		jobShell := &JobShell{ID: "job-id", DelugeID: "deluge-id"}
+		require.NoError(t, m.CreateAll(jobShell))
+		require.NoError(t, m.StartAll(jobShell))
+
+		require.NoError(t, m.SetConcurrency("job-id", "scenario-id", 7))
+		assert.Equal(t, map[string]int{"/v1/jobs/" + fw1.jobs[0].ID + "/scenarios/scenario-id/concurrency": 4}, fw1.concurrencies)
+		assert.Equal(t, map[string]int{"/v1/jobs/" + fw2.jobs[0].ID + "/scenarios/scenario-id/concurrency": 3}, fw2.concurrencies)
+
+		fw2.rejectConcurrency = true
+		err := m.SetConcurrency("job-id", "scenario-id", 10)
+		assert.Equal(t, ErrConcurrencyRejected, errors.Cause(err))
+
+		assert.Equal(t, ErrUnknownJob, m.SetConcurrency("unknown", "scenario-id", 10))
+	})
+
+	t.Run("Download event logs from workers", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		saveScenario(t, scenarioScript)
+		saveDeluge(t, delugeScript)
+
+		fw1 := newFakeRemoteWorker(t)
+		defer fw1.Close()
+		fw1.events = "{\"request\":\"foo\"}\n"
+		fw2 := newFakeRemoteWorker(t)
+		defer fw2.Close()
+		fw3 := newFakeRemoteWorker(t)
+		defer fw3.Close()
+		fw3.events = "{\"request\":\"bar\"}\n"
+
+		m := NewRemoteManager(30*time.Second, false)
+		m.Register(Registration{ID: "w1", URL: fw1.URL})
+		m.Register(Registration{ID: "w2", URL: fw2.URL})
+		m.Register(Registration{ID: "w3", URL: fw3.URL})
+
+		jobShell := &JobShell{ID: "job-id", DelugeID: "deluge-id", EventLog: eventlog.FormatNDJSON}
+		require.NoError(t, m.CreateAll(jobShell))
+		require.NoError(t, m.StartAll(jobShell))
+		for _, fw := range []*fakeRemoteWorker{fw1, fw2, fw3} {
+			require.Len(t, fw.jobs, 1)
+			assert.Equal(t, eventlog.FormatNDJSON, fw.jobs[0].EventLog)
+		}
+
+		readers, err := m.OpenEventLogs("job-id", eventlog.FormatNDJSON)
+		require.NoError(t, err)
+		defer eventlog.CloseAll(readers)
+		buf := &bytes.Buffer{}
+		require.NoError(t, eventlog.Concat(buf, eventlog.FormatNDJSON, readers))
+		// The second worker has no event log
+		assert.Equal(t, "{\"request\":\"foo\"}\n{\"request\":\"bar\"}\n", buf.String())
+
+		require.NoError(t, m.InterruptAll("job-id"))
+	})
+
+	t.Run("Interrupt started workers when one fails to start", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		saveScenario(t, scenarioScript)
+		saveDeluge(t, delugeScript)
+
+		fw1 := newFakeRemoteWorker(t)
+		defer fw1.Close()
+		fw2 := newFakeRemoteWorker(t)
+		defer fw2.Close()
+		fw2.failJobs = true
+
+		m := NewRemoteManager(30*time.Second, false)
+		m.Register(Registration{ID: "w1", URL: fw1.URL})
+		m.Register(Registration{ID: "w2", URL: fw2.URL})
+
+		jobShell := &JobShell{ID: "job-id", DelugeID: "deluge-id"}
+		require.NoError(t, m.CreateAll(jobShell))
+		assert.Error(t, m.StartAll(jobShell))
+		require.Len(t, fw1.jobs, 1)
+		assert.Equal(t, []string{"/v1/jobs/interrupt/" + fw1.jobs[0].ID}, fw1.interrupted)
+
+		// The job is not assigned anymore
+		assert.Empty(t, m.getJobAssignments("job-id"))
+		assert.Empty(t, m.assignments)
+		assert.Equal(t, ErrUnknownAssignment, m.Heartbeat("w1", Heartbeat{JobID: fw1.jobs[0].ID, Status: status.DelugeInterrupted}))
+	})
+
+	t.Run("Interrupt all workers when one is aborted by a threshold", func(t *testing.T) {
+		repov2.Instance = repov2.NewInMemoryRepository()
+		saveScenario(t, scenarioScript)
+		saveDeluge(t, delugeScript)
+
+		fw1 := newFakeRemoteWorker(t)
+		defer fw1.Close()
+		fw2 := newFakeRemoteWorker(t)
+		defer fw2.Close()
+
+		m := NewRemoteManager(30*time.Second, false)
+		m.Register(Registration{ID: "w1", URL: fw1.URL})
+		m.Register(Registration{ID: "w2", URL: fw2.URL})
+
+		jobShell := &JobShell{ID: "job-id", DelugeID: "deluge-id"}
+		require.NoError(t, m.CreateAll(jobShell))
+		require.NoError(t, m.StartAll(jobShell))
+
+		abortedBy := &repov2.PersistedAbortCause{ScenarioID: "scenario-id", Request: "*", Metric: "errorRate", Limit: 0.1, Value: 0.5}
+		require.NoError(t, m.SaveReport("w2", &repov2.PersistedWorkerReport{
+			JobID:     fw2.jobs[0].ID,
+			Status:    status.DelugeAborted,
+			AbortedBy: abortedBy,
+		}))
+		reports := repov2.Instance.GetJobWorkerReports("job-id")
+		require.Len(t, reports, 1)
+		assert.Equal(t, abortedBy, reports[0].AbortedBy)
+
+		// Other workers are interrupted asynchronously
+		var interrupted []string
+		for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+			fw1.mut.Lock()
+			interrupted = fw1.interrupted
+			fw1.mut.Unlock()
+			if len(interrupted) > 0 {
+				break
+			}
+		}
+		assert.Equal(t, []string{"/v1/jobs/interrupt/" + fw1.jobs[0].ID}, interrupted)
+	})
+}
@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"github.com/ofux/deluge/core"
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/ofux/deluge/repov2"
+	"github.com/pkg/errors"
+)
+
+// saveThresholdResults evaluates the thresholds of each scenario against the merged records of the given reports of
+// the workers of a job, and saves their results with the job. Nothing is saved if there is no threshold.
+func saveThresholdResults(repository repov2.Repository, jobShellID string, thresholds map[string][]*core.Threshold, reports []*repov2.PersistedWorkerReport) error {
+	if len(thresholds) == 0 {
+		return nil
+	}
+	jobShell, ok := repository.GetJobShell(jobShellID)
+	if !ok {
+		return errors.Errorf("job %s does not exist", jobShellID)
+	}
+	results, err := evaluateThresholds(thresholds, reports)
+	if err != nil {
+		return errors.Wrapf(err, "failed to evaluate thresholds of job %s", jobShellID)
+	}
+	// The job shell may be shared by the repository, so a copy is saved
+	evaluated := *jobShell
+	evaluated.Thresholds = results
+	return errors.Wrapf(repository.SaveJobShell(&evaluated), "failed to save thresholds of job %s", jobShellID)
+}
+
+// evaluateThresholds evaluates the thresholds of each scenario against the merged records of the reports. The
+// thresholds of a scenario without records fail.
+func evaluateThresholds(thresholds map[string][]*core.Threshold, reports []*repov2.PersistedWorkerReport) (*repov2.PersistedThresholdResults, error) {
+	scenariosRecords := make(map[string]*recording.HTTPRecordsOverTime)
+	for _, wr := range reports {
+		for scenarioID, scenario := range wr.Scenarios {
+			if _, ok := thresholds[scenarioID]; !ok {
+				continue
+			}
+			rec, err := recording.MapPersistedHTTPRecords(scenario.Records)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to map scenario %s of worker %s", scenarioID, wr.WorkerID)
+			}
+			scenariosRecords[scenarioID], err = recording.MergeHTTPRecordsOverTime(scenariosRecords[scenarioID], rec)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to merge scenario %s of worker %s", scenarioID, wr.WorkerID)
+			}
+		}
+	}
+
+	httpReporter := &reporting.HTTPReporter{}
+	results := &repov2.PersistedThresholdResults{
+		Passed:      true,
+		PerScenario: make(map[string][]*repov2.PersistedThresholdResult),
+	}
+	for scenarioID, scenarioThresholds := range thresholds {
+		var report *reporting.HTTPReport
+		if records := scenariosRecords[scenarioID]; records != nil {
+			report, _ = httpReporter.Report(records).(*reporting.HTTPReport)
+		}
+		for _, result := range core.EvaluateThresholds(scenarioThresholds, report) {
+			results.PerScenario[scenarioID] = append(results.PerScenario[scenarioID], &repov2.PersistedThresholdResult{
+				Request: result.Request,
+				Metric:  string(result.Metric),
+				Limit:   result.Limit,
+				Value:   result.Value,
+				Passed:  result.Passed,
+			})
+			results.Passed = results.Passed && result.Passed
+		}
+	}
+	return results, nil
+}
+
+// replaceReports returns the saved reports of the workers of a job, with the given reports in place of the saved ones
+// of the same workers.
+func replaceReports(saved []*repov2.PersistedWorkerReport, reports ...*repov2.PersistedWorkerReport) []*repov2.PersistedWorkerReport {
+	replaced := make([]*repov2.PersistedWorkerReport, 0, len(saved)+len(reports))
+	for _, wr := range saved {
+		if !containsReportOf(reports, wr.WorkerID) {
+			replaced = append(replaced, wr)
+		}
+	}
+	return append(replaced, reports...)
+}
+
+func containsReportOf(reports []*repov2.PersistedWorkerReport, workerID string) bool {
+	for _, wr := range reports {
+		if wr.WorkerID == workerID {
+			return true
+		}
+	}
+	return false
+}
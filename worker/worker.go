@@ -2,22 +2,48 @@ package worker
 
 import (
 	"github.com/ofux/deluge/core"
+	"github.com/ofux/deluge/core/eventlog"
 	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/reporting"
+	"github.com/ofux/deluge/core/sinks"
+	"github.com/ofux/deluge/dsl/object"
 	"github.com/ofux/deluge/repov2"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"io"
+	"sync"
 	"time"
 )
 
+// ErrUnknownJob is returned when a job was not created by the manager.
+var ErrUnknownJob = errors.New("unknown job")
+
 type Manager interface {
 	CreateAll(jobShell *JobShell) error
 	StartAll(jobShell *JobShell) error
 	InterruptAll(jobShellID string) error
+	// SetConcurrency changes the number of concurrent users of a scenario of a running job.
+	SetConcurrency(jobShellID, scenarioID string, concurrent int) error
+	// OpenEventLogs opens the event logs written by the workers of a job, in the given format. Readers must be closed.
+	OpenEventLogs(jobShellID, format string) ([]io.ReadCloser, error)
+	// OnJobEnd sets the function called once all the workers of a job have saved their final report. The results of
+	// the thresholds of the job are saved with the job by then.
+	OnJobEnd(handler JobEndHandler)
 }
 
+// JobEndHandler is called once all the workers of a job have saved their final report. It must not block.
+type JobEndHandler func(jobShellID string)
+
 type JobShell struct {
 	ID       string
 	DelugeID string
+	// Share is the part of the deluge to run when the job is spread across several workers by an orchestrator.
+	// A nil Share means that the whole deluge must be run.
+	Share *core.Share
+	// Sinks are the external systems every recorded request is sent to
+	Sinks []sinks.Config
+	// EventLog is the format of the event log every HTTP request is written to, or empty if there is no event log
+	EventLog string
 }
 
 var ManagerInstance Manager = NewInMemoryManager(1)
@@ -31,10 +57,24 @@ type worker struct {
 	jobShell      *JobShell
 	runningDeluge *core.RunnableDeluge
 	repository    repov2.Repository
+	orchestrator  *OrchestratorClient
+	events        *JobEventBroker
+	sinks         []sinks.Sink
+	eventLog      *eventlog.Writer
+	// saveFinalReport saves the final report of the worker in place of saveWorkerReportWithRetry, if set
+	saveFinalReport func(report *repov2.PersistedWorkerReport)
 
 	regularReportFrequency time.Duration
-	finalReportRetryCount  int
-	finalReportRetryDelay  time.Duration
+	// streamFrequency is how often records are published to the clients that follow the job
+	streamFrequency       time.Duration
+	heartbeatFrequency    time.Duration
+	finalReportRetryCount int
+	finalReportRetryDelay time.Duration
+
+	// mut protects runningDeluge and endedAt from the goroutines that collect metrics
+	mut *sync.Mutex
+	// endedAt is when the job ended, or zero if it has not ended
+	endedAt time.Time
 
 	logger *logrus.Entry
 }
@@ -44,25 +84,81 @@ func newWorker(ID string, jobShell *JobShell, repository repov2.Repository) *wor
 		ID:         ID,
 		jobShell:   jobShell,
 		repository: repository,
+		events:     EventsInstance,
 
 		regularReportFrequency: 20 * time.Second,
+		streamFrequency:        time.Second,
+		heartbeatFrequency:     5 * time.Second,
 		finalReportRetryCount:  3,
 		finalReportRetryDelay:  10 * time.Second,
 
+		mut: &sync.Mutex{},
+
 		logger: logrus.WithField("workerId", ID).WithField("jobId", jobShell.ID).WithField("delugeId", jobShell.DelugeID),
 	}
 }
 
+func (w *worker) getRunningDeluge() *core.RunnableDeluge {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.runningDeluge
+}
+
+func (w *worker) getEndedAt() time.Time {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.endedAt
+}
+
 func (w *worker) interrupt() {
-	w.runningDeluge.Interrupt()
+	if w.runningDeluge != nil {
+		w.runningDeluge.Interrupt()
+	}
+}
+
+func (w *worker) setConcurrency(scenarioID string, concurrent int) error {
+	if w.runningDeluge == nil {
+		return core.ErrScenarioNotRunning
+	}
+	return w.runningDeluge.SetConcurrency(scenarioID, concurrent)
 }
 
 func (w *worker) start() error {
-	dlg, err := core.NewRunnableDeluge(w.jobShell.DelugeID)
+	share := core.FullShare
+	if w.jobShell.Share != nil {
+		share = *w.jobShell.Share
+	}
+	dlg, err := core.NewRunnableDelugeShare(w.jobShell.DelugeID, share)
 	if err != nil {
 		return errors.Wrapf(err, "failed to create runnable deluge from jobShell %s (delugeId %s)", w.jobShell.ID, w.jobShell.DelugeID)
 	}
+	jobSinks, err := sinks.NewAll(w.jobShell.Sinks)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create sinks of jobShell %s", w.jobShell.ID)
+	}
+	w.sinks = jobSinks
+	if w.jobShell.EventLog != "" {
+		eventLog, err := eventlog.Create(w.jobShell.ID, w.ID, w.jobShell.EventLog)
+		if err != nil {
+			sinks.CloseAll(jobSinks)
+			return errors.Wrapf(err, "failed to create event log of jobShell %s", w.jobShell.ID)
+		}
+		w.eventLog = eventLog
+		for _, scenario := range dlg.Scenarios {
+			scenario.SetEventLog(eventLog)
+		}
+	}
+	for scenarioID, scenario := range dlg.Scenarios {
+		for _, sink := range jobSinks {
+			scenario.AddRecordSink(sinks.NewRecordSink(sink, w.jobShell.ID, w.ID, scenarioID, scenario.GetRecordingUnit()))
+		}
+	}
+	w.mut.Lock()
 	w.runningDeluge = dlg
+	w.mut.Unlock()
+	dlg.OnError(func(scenarioID string, err *object.Error) {
+		w.events.PublishErrors(w.jobShell.ID, w.ID, scenarioID, []*object.Error{err})
+	})
 
 	go func() {
 		w.listenToStatusChanges()
@@ -72,6 +168,12 @@ func (w *worker) start() error {
 		w.reportRecordsRegularly()
 	}()
 
+	if w.orchestrator != nil {
+		go func() {
+			w.sendHeartbeatsRegularly()
+		}()
+	}
+
 	dlg.Run()
 
 	return nil
@@ -88,6 +190,9 @@ func (w *worker) listenToStatusChanges() {
 			Scenarios: make(map[string]*repov2.PersistedWorkerScenarioReport),
 		}
 		if newStatus.IsEnd() {
+			w.mut.Lock()
+			w.endedAt = time.Now()
+			w.mut.Unlock()
 			for scenarioID, scenario := range w.runningDeluge.Scenarios {
 				logger := w.logger.WithField("scenarioId", scenarioID)
 				logger.Debug("Adding records of scenario")
@@ -105,22 +210,95 @@ func (w *worker) listenToStatusChanges() {
 
 				report.Scenarios[scenarioID] = &repov2.PersistedWorkerScenarioReport{
 					Status:            scenario.Status,
-					Errors:            scenario.Errors,
+					Errors:            mapScenarioErrors(scenario.Errors),
 					IterationDuration: scenario.IterationDuration,
 					Records:           records,
 				}
 				logger.Debug("Added records of scenario")
+
+				w.publishRecords(scenarioID, recording.NewHTTPRecordsOverTimeSnapshot(scenario.Records))
+			}
+			if cause := w.runningDeluge.GetAbortCause(); cause != nil {
+				report.AbortedBy = mapAbortCause(cause)
 			}
-			w.saveWorkerReportWithRetry(report)
+			// The event log is complete once the job is reported as ended
+			w.closeEventLog()
+			if w.saveFinalReport != nil {
+				w.saveFinalReport(report)
+			} else {
+				w.saveWorkerReportWithRetry(report)
+			}
+			// Scenarios have stopped recording, so the sinks can send what they still buffer
+			sinks.CloseAll(w.sinks)
 		} else {
 			w.saveWorkerReport(report)
 		}
-		//TODO: tell orchestrator that this worker is done
+		w.events.PublishStatus(w.jobShell.ID, w.ID, newStatus)
+	}
+}
+
+func (w *worker) closeEventLog() {
+	if w.eventLog == nil {
+		return
+	}
+	if err := w.eventLog.Close(); err != nil {
+		w.logger.WithError(err).Error("Failed to close event log")
 	}
 }
 
+// publishRecords publishes the statistics of the given records to the clients that follow the job, if any.
+func (w *worker) publishRecords(scenarioID string, snapshot *recording.HTTPRecordsOverTimeSnapshot) {
+	if snapshot == nil || !w.events.HasSubscribers(w.jobShell.ID) {
+		return
+	}
+	httpReporter := &reporting.HTTPReporter{}
+	w.events.PublishRecords(w.jobShell.ID, w.ID, scenarioID, httpReporter.ReportSnapshot(snapshot))
+}
+
+// mapScenarioErrors maps the groups of errors of a scenario to their persisted form.
+func mapScenarioErrors(errs []*reporting.ErrorGroup) []*repov2.PersistedScenarioError {
+	persisted := make([]*repov2.PersistedScenarioError, 0, len(errs))
+	for _, err := range errs {
+		persisted = append(persisted, &repov2.PersistedScenarioError{
+			Error:    err.Error,
+			Count:    err.Count,
+			FirstAt:  err.FirstAt,
+			LastAt:   err.LastAt,
+			Requests: err.Requests,
+		})
+	}
+	return persisted
+}
+
+func mapAbortCause(cause *core.AbortCause) *repov2.PersistedAbortCause {
+	if cause.ThresholdResult == nil {
+		return &repov2.PersistedAbortCause{
+			ScenarioID: cause.ScenarioID,
+			Error:      cause.Error,
+		}
+	}
+	return &repov2.PersistedAbortCause{
+		ScenarioID: cause.ScenarioID,
+		Request:    cause.Request,
+		Metric:     string(cause.Metric),
+		Limit:      cause.Limit,
+		Value:      *cause.Value,
+	}
+}
+
+// doSaveWorkerReport saves the report in the repository and pushes it to the orchestrator, if any.
+func (w *worker) doSaveWorkerReport(report *repov2.PersistedWorkerReport) error {
+	if err := w.repository.SaveWorkerReport(report); err != nil {
+		return err
+	}
+	if w.orchestrator != nil {
+		return errors.Wrap(w.orchestrator.PushReport(report), "failed to push report to orchestrator")
+	}
+	return nil
+}
+
 func (w *worker) saveWorkerReport(report *repov2.PersistedWorkerReport) {
-	err := w.repository.SaveWorkerReport(report)
+	err := w.doSaveWorkerReport(report)
 	if err != nil {
 		w.logger.WithError(err).Error("Failed to save records of worker")
 	} else {
@@ -129,7 +307,7 @@ func (w *worker) saveWorkerReport(report *repov2.PersistedWorkerReport) {
 }
 
 func (w *worker) saveWorkerReportWithRetry(report *repov2.PersistedWorkerReport) {
-	err := w.repository.SaveWorkerReport(report)
+	err := w.doSaveWorkerReport(report)
 
 	const retryDelayMultiply = 3
 
@@ -137,7 +315,7 @@ func (w *worker) saveWorkerReportWithRetry(report *repov2.PersistedWorkerReport)
 	for retry := 1; err != nil && retry <= w.finalReportRetryCount; retry++ {
 		w.logger.WithError(err).Errorf("Failed to save records of worker. Retrying in %s", delay)
 		time.Sleep(delay)
-		err = w.repository.SaveWorkerReport(report)
+		err = w.doSaveWorkerReport(report)
 		delay *= retryDelayMultiply
 	}
 
@@ -148,15 +326,48 @@ func (w *worker) saveWorkerReportWithRetry(report *repov2.PersistedWorkerReport)
 	}
 }
 
+// sendHeartbeatsRegularly lets the orchestrator know that the worker is alive until the deluge ends.
+// If the orchestrator considers that the worker was lost for this job, the deluge is interrupted because its
+// share is being run by another worker.
+func (w *worker) sendHeartbeatsRegularly() {
+	ticker := time.NewTicker(w.heartbeatFrequency)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		delugeStatus := w.runningDeluge.GetStatus()
+		if delugeStatus.IsEnd() {
+			return
+		}
+		err := w.orchestrator.SendHeartbeat(w.jobShell.ID, delugeStatus)
+		if err == ErrLostAssignment {
+			w.logger.Error("Orchestrator considered this worker lost for the job. Interrupting it")
+			w.interrupt()
+			return
+		}
+		if err != nil {
+			w.logger.WithError(err).Error("Failed to send heartbeat to orchestrator")
+		}
+	}
+}
+
+// reportRecordsRegularly saves the records of the worker every regularReportFrequency. In the meantime, the records
+// that changed are published every streamFrequency to the clients that follow the job.
 func (w *worker) reportRecordsRegularly() {
-	ticker := time.NewTicker(w.regularReportFrequency)
+	ticker := time.NewTicker(w.streamFrequency)
+	defer ticker.Stop()
+	lastReport := time.Now()
 
 	allRecords := make(map[string]*recording.HTTPRecordsOverTime)
 
 	for range ticker.C {
+		reportDue := time.Since(lastReport) >= w.regularReportFrequency
+		if !reportDue && !w.events.HasSubscribers(w.jobShell.ID) {
+			// Records that changed since the previous snapshot will be part of the next one
+			continue
+		}
+
 		snapshot, err := w.runningDeluge.GetRecordsSnapshot()
 		if err != nil {
-			ticker.Stop()
 			break
 		}
 		for scenarioID, scenarioSnapshot := range snapshot {
@@ -175,7 +386,13 @@ func (w *worker) reportRecordsRegularly() {
 				}
 				scenarioRecords.OverTime[overTimeIndex] = rec
 			}
+			w.publishRecords(scenarioID, scenarioSnapshot.HTTPRecordsOverTimeSnapshot)
+		}
+
+		if !reportDue {
+			continue
 		}
+		lastReport = time.Now()
 
 		report := &repov2.PersistedWorkerReport{
 			WorkerID:  w.ID,
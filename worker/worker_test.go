@@ -1,13 +1,24 @@
 package worker
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/ofux/deluge/core"
+	"github.com/ofux/deluge/core/eventlog"
+	"github.com/ofux/deluge/core/recording"
+	"github.com/ofux/deluge/core/sinks"
 	"github.com/ofux/deluge/core/status"
 	"github.com/ofux/deluge/repov2"
 	"github.com/ofux/docilemonkey/docilemonkey"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -165,6 +176,526 @@ func TestIntegration_worker_start(t *testing.T) {
 	})
 }
 
+func TestIntegration_worker_start_orchestrated(t *testing.T) {
+	rep := &repoMock{
+		InMemoryRepository: *repov2.NewInMemoryRepository(),
+	}
+	srv := docilemonkey.NewTestServer()
+	defer srv.Close()
+
+	var pushedReports []*repov2.PersistedWorkerReport
+	pushedReportsMut := &sync.Mutex{}
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/workers/remote-worker-id/reports", r.URL.Path)
+		report := &repov2.PersistedWorkerReport{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(report))
+		pushedReportsMut.Lock()
+		defer pushedReportsMut.Unlock()
+		pushedReports = append(pushedReports, report)
+	}))
+	defer orchestrator.Close()
+
+	saveScenario(t, `
+	scenario("scenario-id", "My scenario", function () {
+		http("My request", {
+			"url": "`+srv.URL+`/hello/toto"
+		});
+	});`)
+
+	saveDeluge(t, `
+	deluge("deluge-id", "Some name", "1s", {
+		"scenario-id": {
+			"concurrent": 5,
+			"delay": "400ms"
+		}
+	});`)
+
+	worker := newWorker("worker-id", &JobShell{
+		ID:       "job-id",
+		DelugeID: "deluge-id",
+		Share:    &core.Share{Index: 1, Count: 2},
+	}, rep)
+	worker.orchestrator = NewOrchestratorClient(orchestrator.URL, Registration{ID: "remote-worker-id"})
+
+	err := worker.start()
+	require.NoError(t, err)
+
+	// Wait for the deluge
+	time.Sleep(1 * time.Second)
+	for wait := 0 * time.Millisecond; wait < 5*time.Second && rep.GetSaveWorkerReportCall() < 3; wait += 100 * time.Millisecond {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	pushedReportsMut.Lock()
+	defer pushedReportsMut.Unlock()
+	require.Len(t, pushedReports, 3)
+	assert.Equal(t, status.DelugeVirgin, pushedReports[0].Status)
+	assert.Equal(t, status.DelugeInProgress, pushedReports[1].Status)
+	assert.Equal(t, status.DelugeDoneSuccess, pushedReports[2].Status)
+	assert.Equal(t, "job-id", pushedReports[2].JobID)
+	require.NotNil(t, pushedReports[2].Scenarios["scenario-id"])
+	records := pushedReports[2].Scenarios["scenario-id"].Records
+	require.NotNil(t, records)
+	assert.NotNil(t, records.Global.Global)
+}
+
+func TestIntegration_worker_lost_by_orchestrator(t *testing.T) {
+	rep := &repoMock{
+		InMemoryRepository: *repov2.NewInMemoryRepository(),
+	}
+
+	var heartbeats []*Heartbeat
+	heartbeatsMut := &sync.Mutex{}
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/workers/remote-worker-id/heartbeats" {
+			return
+		}
+		heartbeat := &Heartbeat{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(heartbeat))
+		heartbeatsMut.Lock()
+		defer heartbeatsMut.Unlock()
+		heartbeats = append(heartbeats, heartbeat)
+		if len(heartbeats) >= 2 {
+			// Orchestrator has given the share of this worker to another worker
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer orchestrator.Close()
+
+	saveScenario(t, `
+	scenario("scenario-id", "My scenario", function () {
+	});`)
+
+	saveDeluge(t, `
+	deluge("deluge-id", "Some name", "10s", {
+		"scenario-id": {
+			"concurrent": 2,
+			"delay": "100ms"
+		}
+	});`)
+
+	worker := newWorker("worker-id", &JobShell{
+		ID:       "job-id",
+		DelugeID: "deluge-id",
+		Share:    &core.Share{Index: 0, Count: 2},
+	}, rep)
+	worker.orchestrator = NewOrchestratorClient(orchestrator.URL, Registration{ID: "remote-worker-id"})
+	worker.heartbeatFrequency = 100 * time.Millisecond
+
+	err := worker.start()
+	require.NoError(t, err)
+
+	for wait := 0 * time.Millisecond; wait < 5*time.Second && !worker.runningDeluge.GetStatus().IsEnd(); wait += 100 * time.Millisecond {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, status.DelugeInterrupted, worker.runningDeluge.GetStatus())
+
+	heartbeatsMut.Lock()
+	defer heartbeatsMut.Unlock()
+	require.Len(t, heartbeats, 2)
+	assert.Equal(t, "job-id", heartbeats[0].JobID)
+	assert.Equal(t, status.DelugeInProgress, heartbeats[0].Status)
+}
+
+func TestIntegration_worker_stream(t *testing.T) {
+	rep := &repoMock{
+		InMemoryRepository: *repov2.NewInMemoryRepository(),
+	}
+	srv := docilemonkey.NewTestServer()
+	defer srv.Close()
+
+	saveScenario(t, `
+	scenario("scenario-id", "My scenario", function () {
+		http("My request", {
+			"url": "`+srv.URL+`/hello/toto"
+		});
+	});`)
+
+	saveDeluge(t, `
+	deluge("deluge-id", "Some name", "1s", {
+		"scenario-id": {
+			"concurrent": 5,
+			"delay": "100ms"
+		}
+	});`)
+
+	worker := newWorker("worker-id", &JobShell{
+		ID:       "job-id",
+		DelugeID: "deluge-id",
+	}, rep)
+	worker.events = NewJobEventBroker()
+	worker.streamFrequency = 100 * time.Millisecond
+
+	subscription := worker.events.Subscribe("job-id")
+	defer subscription.Close()
+
+	err := worker.start()
+	require.NoError(t, err)
+
+	var statuses []status.DelugeStatus
+	var records []*JobEvent
+	timeout := time.After(5 * time.Second)
+	for len(statuses) == 0 || !statuses[len(statuses)-1].IsEnd() {
+		select {
+		case event, ok := <-subscription.Events():
+			require.True(t, ok)
+			switch event.Type {
+			case JobEventStatus:
+				statuses = append(statuses, event.Status)
+			case JobEventRecords:
+				assert.Equal(t, "worker-id", event.WorkerID)
+				assert.Equal(t, "scenario-id", event.ScenarioID)
+				records = append(records, event)
+			default:
+				t.Errorf("Unexpected event %+v", event)
+			}
+		case <-timeout:
+			t.Fatal("Deluge did not end in time")
+		}
+	}
+
+	assert.Equal(t, []status.DelugeStatus{status.DelugeVirgin, status.DelugeInProgress, status.DelugeDoneSuccess}, statuses)
+	// Records are published while the deluge runs, and once more with all the records at the end
+	require.True(t, len(records) > 2, "expected several records events, got %d", len(records))
+	// How many iterations run in the duration of the deluge depends on timing, but the last event has all the
+	// records of the final report
+	finalRecords := records[len(records)-1].Records
+	savedRecords := waitForFinalRecords(t, rep, "job-id", "scenario-id")
+	assert.Equal(t, savedRecords.Global.Global.TotalCount(), finalRecords.Global.Global.CallCount)
+	assert.Len(t, finalRecords.PerIteration, len(savedRecords.OverTime))
+	assert.True(t, finalRecords.Global.Global.CallCount >= 5, "unexpected call count %d", finalRecords.Global.Global.CallCount)
+	for _, event := range records[:len(records)-1] {
+		assert.True(t, len(event.Records.PerIteration) <= 3, "only changed iterations are expected, got %d", len(event.Records.PerIteration))
+	}
+}
+
+func TestIntegration_worker_metrics(t *testing.T) {
+	rep := &repoMock{
+		InMemoryRepository: *repov2.NewInMemoryRepository(),
+	}
+	srv := docilemonkey.NewTestServer()
+	defer srv.Close()
+
+	saveScenario(t, `
+	scenario("scenario-id", "My scenario", function () {
+		http("My request", {
+			"url": "`+srv.URL+`/hello/toto"
+		});
+	});`)
+
+	saveDeluge(t, `
+	deluge("deluge-id", "Some name", "1s", {
+		"scenario-id": {
+			"concurrent": 5,
+			"delay": "100ms"
+		}
+	});`)
+
+	manager := NewInMemoryManager(1).(*inMemoryManager)
+	w := newWorker("worker-id", &JobShell{
+		ID:       "job-id",
+		DelugeID: "deluge-id",
+	}, rep)
+	manager.workers["job-id"] = []*worker{w}
+
+	assert.Empty(t, manager.CollectMetrics(), "no metric is expected before the job starts")
+
+	err := w.start()
+	require.NoError(t, err)
+
+	for wait := 0 * time.Millisecond; wait < 5*time.Second && !w.getRunningDeluge().GetStatus().IsEnd(); wait += 100 * time.Millisecond {
+		time.Sleep(100 * time.Millisecond)
+	}
+	for wait := 0 * time.Millisecond; wait < 5*time.Second && w.getEndedAt().IsZero(); wait += 10 * time.Millisecond {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	metrics := manager.CollectMetrics()
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "job-id", metrics[0].JobID)
+	assert.Equal(t, "deluge-id", metrics[0].DelugeID)
+	assert.Equal(t, "worker-id", metrics[0].WorkerID)
+	assert.Equal(t, status.DelugeDoneSuccess, metrics[0].Status)
+	require.NotNil(t, metrics[0].Scenarios["scenario-id"])
+	scenario := metrics[0].Scenarios["scenario-id"]
+	assert.Equal(t, int64(0), scenario.ActiveUsers)
+	assert.Equal(t, uint64(50), scenario.Iterations)
+	require.NotNil(t, scenario.Stats)
+	assert.Equal(t, int64(50), scenario.Stats.Global.CallCount)
+	assert.Equal(t, int64(50), scenario.Stats.PerRequests["My request"].PerStatus[200].CallCount)
+
+	w.mut.Lock()
+	w.endedAt = time.Now().Add(-metricsRetention - time.Second)
+	w.mut.Unlock()
+	assert.Empty(t, manager.CollectMetrics(), "no metric is expected once the job ended a while ago")
+}
+
+func TestIntegration_worker_job_end(t *testing.T) {
+	repov2.Instance = repov2.NewInMemoryRepository()
+	saveScenario(t, `
+	scenario("scenario-id", "My scenario", function () {
+	});`)
+	saveDeluge(t, `
+	deluge("deluge-id", "Some name", "200ms", {
+		"scenario-id": {
+			"concurrent": 5,
+			"delay": "100ms"
+		}
+	});`)
+
+	manager := NewInMemoryManager(2)
+	ended := make(chan string, 2)
+	manager.OnJobEnd(func(jobShellID string) {
+		// Every worker has saved its final report when the job ends
+		reports := repov2.Instance.GetJobWorkerReports(jobShellID)
+		assert.Len(t, reports, 2)
+		for _, report := range reports {
+			assert.Equal(t, status.DelugeDoneSuccess, report.Status)
+		}
+		ended <- jobShellID
+	})
+
+	jobShell := &JobShell{ID: "job-id", DelugeID: "deluge-id"}
+	require.NoError(t, manager.CreateAll(jobShell))
+	require.NoError(t, manager.StartAll(jobShell))
+
+	select {
+	case jobShellID := <-ended:
+		assert.Equal(t, "job-id", jobShellID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("end of job was not handled")
+	}
+	select {
+	case <-ended:
+		t.Fatal("end of job must be handled once")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestIntegration_worker_thresholds(t *testing.T) {
+	rep := &repoMock{
+		InMemoryRepository: *repov2.NewInMemoryRepository(),
+	}
+	rep.SaveWorkerReportImpl = func(workerReport *repov2.PersistedWorkerReport) error {
+		if workerReport.Status.IsEnd() {
+			ended := 0
+			for _, wr := range rep.GetJobWorkerReports(workerReport.JobID) {
+				if wr.Status.IsEnd() && wr.WorkerID != workerReport.WorkerID {
+					ended++
+				}
+			}
+			if ended == 1 {
+				jobShell, _ := rep.GetJobShell(workerReport.JobID)
+				assert.NotNil(t, jobShell.Thresholds, "the results of the thresholds must be saved before the job ends")
+			}
+		}
+		return rep.InMemoryRepository.SaveWorkerReport(workerReport)
+	}
+	repov2.Instance = rep
+	srv := docilemonkey.NewTestServer()
+	defer srv.Close()
+
+	saveScenario(t, `
+	scenario("scenario-id", "My scenario", function () {
+		http("My request", {
+			"url": "`+srv.URL+`/hello/toto"
+		});
+	});`)
+	saveDeluge(t, `
+	deluge("deluge-id", "Some name", "200ms", {
+		"scenario-id": {
+			"concurrent": 5,
+			"delay": "100ms",
+			"thresholds": {
+				"*": {"errorRate": 0.5},
+				"unknown": {"max": "1s"}
+			}
+		}
+	});`)
+	require.NoError(t, rep.SaveJobShell(&repov2.PersistedJobShell{ID: "job-id", DelugeID: "deluge-id"}))
+
+	manager := NewInMemoryManager(2)
+	ended := make(chan struct{})
+	manager.OnJobEnd(func(jobShellID string) {
+		close(ended)
+	})
+	jobShell := &JobShell{ID: "job-id", DelugeID: "deluge-id"}
+	require.NoError(t, manager.CreateAll(jobShell))
+	require.NoError(t, manager.StartAll(jobShell))
+
+	// The thresholds of the deluge that runs are evaluated, whatever happens to the saved deluge
+	require.True(t, rep.DeleteDeluge("deluge-id"))
+
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("end of job was not handled")
+	}
+
+	savedJobShell, ok := rep.GetJobShell("job-id")
+	require.True(t, ok)
+	require.NotNil(t, savedJobShell.Thresholds)
+	assert.False(t, savedJobShell.Thresholds.Passed)
+	results := savedJobShell.Thresholds.PerScenario["scenario-id"]
+	require.Len(t, results, 2)
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Request < results[j].Request
+	})
+	assert.Equal(t, "*", results[0].Request)
+	require.NotNil(t, results[0].Value)
+	assert.Equal(t, 0.0, *results[0].Value)
+	assert.True(t, results[0].Passed)
+	assert.Equal(t, "unknown", results[1].Request)
+	assert.Nil(t, results[1].Value)
+	assert.False(t, results[1].Passed)
+}
+
+func TestIntegration_worker_sinks(t *testing.T) {
+	rep := &repoMock{
+		InMemoryRepository: *repov2.NewInMemoryRepository(),
+	}
+	srv := docilemonkey.NewTestServer()
+	defer srv.Close()
+
+	var lineCount int64
+	influxDB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+			assert.True(t, strings.HasPrefix(line, "deluge_request,job_id=job-id,worker_id=worker-id,scenario=scenario-id,request=My\\ request,status_code=200,result=ok duration_ms="), line)
+			atomic.AddInt64(&lineCount, 1)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer influxDB.Close()
+
+	saveScenario(t, `
+	scenario("scenario-id", "My scenario", function () {
+		http("My request", {
+			"url": "`+srv.URL+`/hello/toto"
+		});
+	});`)
+
+	saveDeluge(t, `
+	deluge("deluge-id", "Some name", "1s", {
+		"scenario-id": {
+			"concurrent": 5,
+			"delay": "100ms"
+		}
+	});`)
+
+	w := newWorker("worker-id", &JobShell{
+		ID:       "job-id",
+		DelugeID: "deluge-id",
+		Sinks:    []sinks.Config{{Type: sinks.TypeInfluxDB, Address: influxDB.URL + "/write?db=deluge"}},
+	}, rep)
+
+	err := w.start()
+	require.NoError(t, err)
+
+	// How many iterations run in the duration of the deluge depends on timing, but every recorded call is sent
+	callCount := waitForFinalRecords(t, rep, "job-id", "scenario-id").Global.Global.TotalCount()
+	assert.True(t, callCount >= 5, "unexpected call count %d", callCount)
+	for wait := 0 * time.Millisecond; wait < 5*time.Second && atomic.LoadInt64(&lineCount) < callCount; wait += 100 * time.Millisecond {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, callCount, atomic.LoadInt64(&lineCount))
+}
+
+func TestIntegration_worker_event_log(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deluge-events")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	previousDir := eventlog.Dir
+	eventlog.Dir = dir
+	defer func() {
+		eventlog.Dir = previousDir
+	}()
+
+	rep := &repoMock{
+		InMemoryRepository: *repov2.NewInMemoryRepository(),
+	}
+	srv := docilemonkey.NewTestServer()
+	defer srv.Close()
+
+	saveScenario(t, `
+	scenario("scenario-id", "My scenario", function () {
+		http("My request", {
+			"url": "`+srv.URL+`/hello/toto?s=201",
+			"method": "POST",
+			"body": "hello"
+		});
+	});`)
+
+	saveDeluge(t, `
+	deluge("deluge-id", "Some name", "1s", {
+		"scenario-id": {
+			"concurrent": 5,
+			"delay": "100ms"
+		}
+	});`)
+
+	w := newWorker("worker-id", &JobShell{
+		ID:       "job-id",
+		DelugeID: "deluge-id",
+		EventLog: eventlog.FormatNDJSON,
+	}, rep)
+
+	err = w.start()
+	require.NoError(t, err)
+	// The event log is complete once the final report is saved
+	callCount := waitForFinalRecords(t, rep, "job-id", "scenario-id").Global.Global.TotalCount()
+
+	readers, err := NewInMemoryManager(1).OpenEventLogs("job-id", eventlog.FormatNDJSON)
+	require.NoError(t, err)
+	require.Len(t, readers, 1)
+	content, err := ioutil.ReadAll(readers[0])
+	require.NoError(t, err)
+	eventlog.CloseAll(readers)
+
+	// How many iterations run in the duration of the deluge depends on timing, but every recorded call is logged
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.True(t, callCount >= 5 && callCount <= 50, "unexpected call count %d", callCount)
+	require.Len(t, lines, int(callCount))
+	users := make(map[string]bool)
+	for _, line := range lines {
+		event := &eventlog.Event{}
+		require.NoError(t, json.Unmarshal([]byte(line), event))
+		assert.Equal(t, "scenario-id", event.Scenario)
+		assert.Equal(t, "My request", event.Request)
+		assert.Equal(t, http.MethodPost, event.Method)
+		assert.Equal(t, srv.URL+"/hello/toto?s=201", event.URL)
+		assert.Equal(t, http.StatusCreated, event.Status)
+		assert.Equal(t, int64(5), event.BytesSent)
+		assert.True(t, event.LatencyMs > 0)
+		assert.Empty(t, event.Error)
+		assert.False(t, event.Time.IsZero())
+		users[event.User] = true
+	}
+	assert.Len(t, users, 5)
+}
+
+// waitForFinalRecords waits for the worker of the given job to save its final report, and returns the records of the
+// given scenario in that report.
+func waitForFinalRecords(t *testing.T, rep repov2.Repository, jobID, scenarioID string) *recording.HTTPRecordsOverTime {
+	t.Helper()
+	var report *repov2.PersistedWorkerReport
+	for wait := 0 * time.Millisecond; wait < 5*time.Second && (report == nil || !report.Status.IsEnd()); wait += 100 * time.Millisecond {
+		if reports := rep.GetJobWorkerReports(jobID); len(reports) == 1 {
+			report = reports[0]
+		}
+		if report == nil || !report.Status.IsEnd() {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	require.NotNil(t, report)
+	require.True(t, report.Status.IsEnd())
+	require.Contains(t, report.Scenarios, scenarioID)
+	records, err := recording.MapPersistedHTTPRecords(report.Scenarios[scenarioID].Records)
+	require.NoError(t, err)
+	return records
+}
+
 func saveDeluge(t testing.TB, script string) *core.CompiledDeluge {
 	t.Helper()
 	compiled, err := core.CompileDeluge(script)